@@ -0,0 +1,250 @@
+/*
+Package client is a Go client for the Pacbot game server, for Go-based bots
+and tools: it connects to the server's websocket (speaking pacbot.v1), decodes
+each game state into a GameState, and sends commands such as moves.
+
+	c, err := client.Dial("ws://localhost:3002", &client.Options{Token: "..."})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer c.Close()
+	for {
+		state, err := c.Next()
+		if err != nil {
+			var serverErr *client.ServerError
+			if errors.As(err, &serverErr) {
+				continue // e.g. a rejected command
+			}
+			log.Fatal(err)
+		}
+		c.Move(client.Left)
+	}
+*/
+package client
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
+)
+
+// The websocket subprotocol of the protocol version that the client speaks
+const subprotocol = "pacbot.v1"
+
+// The protocol version, and the length of an envelope header
+const (
+	protocolVersion   = 1
+	envelopeHeaderLen = 6
+)
+
+// Message types within the envelopes (see webserver/protocol.go)
+const (
+	msgState      byte = 's' // Serialized game state (server -> client)
+	msgCommand    byte = 'c' // Game command (client -> server)
+	msgSeqCommand byte = 'q' // Sequenced game command (client -> server)
+	msgError      byte = 'e' // Error description (server -> client)
+	msgAck        byte = 'a' // Latest applied sequence number (server -> client)
+	msgSession    byte = 't' // Session token (server -> client)
+	msgRoundTrip  byte = 'r' // Timestamp to echo (both directions)
+)
+
+// Options for connecting to the server (all optional)
+type Options struct {
+	Token   string // Token to authenticate with, to send commands
+	Room    string // Room to join (the default room if empty)
+	Session string // Session token to resume a previous session with
+}
+
+/*
+An error message sent by the server (e.g. for a rejected command) - the
+connection stays open, so these errors aren't fatal
+*/
+type ServerError struct {
+	Message string
+}
+
+// Get the error message
+func (err *ServerError) Error() string {
+	return "server error: " + err.Message
+}
+
+// A client connected to the server
+type Client struct {
+	conn    *websocket.Conn
+	session atomic.Value  // Session token (string), for resuming the session
+	lastSeq uint32        // Last sequence number sent (under muWrite)
+	lastAck atomic.Uint32 // Last sequence number acknowledged by the server
+	muWrite sync.Mutex    // Mutex to serialize writes to the connection
+}
+
+// Connect to the server at a websocket address (e.g. "ws://localhost:3002")
+func Dial(addr string, opts *Options) (*Client, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	// Add the options to the address
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	query := u.Query()
+	if opts.Room != "" {
+		query.Set("room", opts.Room)
+	}
+	if opts.Session != "" {
+		query.Set("session", opts.Session)
+	}
+	u.RawQuery = query.Encode()
+	header := http.Header{}
+	if opts.Token != "" {
+		header.Set("Authorization", "Bearer "+opts.Token)
+	}
+
+	// Connect, checking that the server speaks the same protocol version
+	dialer := websocket.Dialer{Subprotocols: []string{subprotocol}}
+	conn, resp, err := dialer.Dial(u.String(), header)
+	if err != nil {
+		if resp != nil {
+			return nil, fmt.Errorf("%v (%s)", err, resp.Status)
+		}
+		return nil, err
+	}
+	if conn.Subprotocol() != subprotocol {
+		conn.Close()
+		return nil, fmt.Errorf("server does not speak %s", subprotocol)
+	}
+
+	c := Client{conn: conn}
+	c.session.Store(resp.Header.Get("Pacbot-Session"))
+	return &c, nil
+}
+
+// Close the connection
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+/*
+Get the session token, for resuming the session after reconnecting (empty if
+the client can't send commands, or sessions can't be resumed)
+*/
+func (c *Client) Session() string {
+	return c.session.Load().(string)
+}
+
+// Get the last sequence number acknowledged by the server (see SendSequenced)
+func (c *Client) LastAck() uint32 {
+	return c.lastAck.Load()
+}
+
+/*
+Wait for the next game state from the server - returns a *ServerError if the
+server sent an error message instead (the connection stays open), or another
+error if the connection closed. Only one go-routine should call Next at a time
+*/
+func (c *Client) Next() (*GameState, error) {
+	for {
+		msgType, payload, err := c.readMessage()
+		if err != nil {
+			return nil, err
+		}
+		switch msgType {
+		case msgState:
+			return DecodeState(payload)
+		case msgError:
+			return nil, &ServerError{Message: string(payload)}
+		case msgAck:
+			if len(payload) == 4 {
+				c.lastAck.Store(binary.BigEndian.Uint32(payload))
+			}
+		case msgSession:
+			c.session.Store(string(payload))
+		case msgRoundTrip:
+			// Echo timestamps back, so the server can measure our latency
+			c.muWrite.Lock()
+			c.writeMessage(msgRoundTrip, payload)
+			c.muWrite.Unlock()
+		}
+	}
+}
+
+// Read a message from the server, opening its envelope
+func (c *Client) readMessage() (byte, []byte, error) {
+	_, msg, err := c.conn.ReadMessage()
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(msg) < envelopeHeaderLen || msg[0] != protocolVersion {
+		return 0, nil, fmt.Errorf("invalid envelope from the server")
+	}
+	length := int(binary.BigEndian.Uint32(msg[2:envelopeHeaderLen]))
+	if length != len(msg)-envelopeHeaderLen {
+		return 0, nil, fmt.Errorf("invalid envelope length from the server")
+	}
+	return msg[1], msg[envelopeHeaderLen:], nil
+}
+
+// Write a message to the server, in an envelope
+func (c *Client) writeMessage(msgType byte, payload []byte) error {
+	msg := make([]byte, envelopeHeaderLen, envelopeHeaderLen+len(payload))
+	msg[0], msg[1] = protocolVersion, msgType
+	binary.BigEndian.PutUint32(msg[2:], uint32(len(payload)))
+	return c.conn.WriteMessage(websocket.BinaryMessage, append(msg, payload...))
+}
+
+// Send a raw byte command to the game engine (see game/commands.go)
+func (c *Client) Send(cmd []byte) error {
+	c.muWrite.Lock()
+	defer c.muWrite.Unlock()
+	return c.writeMessage(msgCommand, cmd)
+}
+
+/*
+Send a byte command with the next sequence number, which the server
+acknowledges once it has been applied (see LastAck) - returns the sequence
+number
+*/
+func (c *Client) SendSequenced(cmd []byte) (uint32, error) {
+	c.muWrite.Lock()
+	defer c.muWrite.Unlock()
+	c.lastSeq++
+	payload := binary.BigEndian.AppendUint32(nil, c.lastSeq)
+	return c.lastSeq, c.writeMessage(msgSeqCommand, append(payload, cmd...))
+}
+
+// Commands to move Pacman in each direction
+var moveCommands = [...]byte{'w', 'a', 's', 'd'}
+
+// Move Pacman one cell in a direction
+func (c *Client) Move(dir Direction) error {
+	if dir >= None {
+		return fmt.Errorf("invalid direction %v", dir)
+	}
+	return c.Send([]byte{moveCommands[dir]})
+}
+
+// Move Pacman to an absolute position (e.g. from tracking)
+func (c *Client) MoveTo(row, col int8) error {
+	return c.Send([]byte{'x', byte(row), byte(col)})
+}
+
+// Pause the game (referees only)
+func (c *Client) Pause() error {
+	return c.Send([]byte{'p'})
+}
+
+// Play (or resume) the game (referees only)
+func (c *Client) Play() error {
+	return c.Send([]byte{'P'})
+}
+
+// Restart the game (referees only)
+func (c *Client) Reset() error {
+	return c.Send([]byte{'r'})
+}
@@ -0,0 +1,567 @@
+package client
+
+import (
+	"fmt"
+	"math"
+)
+
+/*
+The game state, decoded from the compact binary serialization that the server
+broadcasts (see game/serialize.go) - all values are big-endian, in this order:
+
+	ticks (2), update period (1), mode (1), mode steps (1), mode duration (1),
+	level steps (2), score (2), level (1), lives (1), ghost combo (1),
+	ghosts (4 * 4: location (2), fright steps + flags (1),
+	        trapped steps + flag (1)),
+	pacman (2), fruit location (2), fruit steps (1), fruit duration (1),
+	pellets (31 * 4, one bit per column)
+
+followed by extensions, which older servers leave out:
+
+	lifecycle (1), maze name (1 + length), super pellets (1 + 2 * count),
+	clock rate (2), Pacman's pose (3 * 4: row, column, and heading, as
+	float32s), match clock (2, in ticks), extra ghosts (1 + 4 * count, for
+	mazes with more than four ghosts), gates (1 + 3 * count: row, column,
+	and 1 if open), maze size (2: rows and columns, followed by all of the
+	pellets as (columns + 31) / 32 uint32s per row, for mazes of more than
+	31 rows or 32 columns), fruit type (1) and points (2), power-ups (1 + 3 *
+	count: row, column, and type, followed by the steps left of the ghost
+	freeze, speed boost, and point multiplier, 1 byte each), teleporters
+	(1 + 5 * count: the row and column of both ends, and the steps left of
+	the cooldown), survival game (1: 1 if so, followed by the steps
+	survived (4) and the steps until the pellets respawn (2)), state hash (8,
+	if the server enables it)
+
+Each location is two bytes, for the row and the column, with a component of
+the direction in the top two bits of each (as a signed number). Pacman, the
+ghosts, and the fruit are at row and column 32 while out of play (63 on mazes
+of more than 32 rows or columns).
+*/
+
+// The dimensions of the classic maze (and of the pellets field)
+const (
+	MazeRows = 31
+	MazeCols = 28
+)
+
+// A direction that Pacman or a ghost is facing
+type Direction uint8
+
+const (
+	Up    Direction = 0
+	Left  Direction = 1
+	Down  Direction = 2
+	Right Direction = 3
+	None  Direction = 4
+)
+
+// Row and column components of each direction (up, left, down, right, none)
+var dRow = [...]int8{-1, 0, 1, 0, 0}
+var dCol = [...]int8{0, -1, 0, 1, 0}
+
+// Names of the directions
+var dirNames = [...]string{"up", "left", "down", "right", "none"}
+
+// Get the name of a direction
+func (dir Direction) String() string {
+	return nameOf(dirNames[:], uint8(dir))
+}
+
+// The game mode
+type Mode uint8
+
+const (
+	Paused  Mode = 0
+	Scatter Mode = 1
+	Chase   Mode = 2
+)
+
+// Names of the game modes
+var modeNames = [...]string{"paused", "scatter", "chase"}
+
+// Get the name of a game mode
+func (mode Mode) String() string {
+	return nameOf(modeNames[:], uint8(mode))
+}
+
+// The lifecycle state of the game
+type Lifecycle uint8
+
+const (
+	LifecycleLobby     Lifecycle = 0 // Set up, waiting for the game to start
+	LifecycleCountdown Lifecycle = 1 // Counting down to the start of the game
+	LifecycleRunning   Lifecycle = 2 // Game in progress
+	LifecyclePaused    Lifecycle = 3 // Game in progress, but paused
+	LifecycleGameOver  Lifecycle = 4 // Game finished
+)
+
+// Names of the lifecycle states
+var lifecycleNames = [...]string{"lobby", "countdown", "running", "paused",
+	"game over"}
+
+// Get the name of a lifecycle state
+func (lifecycle Lifecycle) String() string {
+	return nameOf(lifecycleNames[:], uint8(lifecycle))
+}
+
+// The colors of the ghosts, in the order they are serialized
+type Color uint8
+
+const (
+	Red    Color = 0
+	Pink   Color = 1
+	Cyan   Color = 2
+	Orange Color = 3
+	Purple Color = 4 // Colors 4-7 are only used by mazes with extra ghosts
+	Green  Color = 5
+	Blue   Color = 6
+	White  Color = 7
+)
+
+// The number of ghosts always sent (mazes can add more, up to NumColors)
+const NumGhosts = 4
+
+// The number of ghost colors
+const NumColors = 8
+
+// Names of the ghost colors
+var colorNames = [...]string{"red", "pink", "cyan", "orange",
+	"purple", "green", "blue", "white"}
+
+// Get the name of a ghost color
+func (color Color) String() string {
+	return nameOf(colorNames[:], uint8(color))
+}
+
+// The type of fruit on a level (as in the arcade game)
+type FruitType uint8
+
+const (
+	Cherry      FruitType = 0
+	Strawberry  FruitType = 1
+	OrangeFruit FruitType = 2 // (Orange is the ghost color)
+	Apple       FruitType = 3
+	Melon       FruitType = 4
+	Galaxian    FruitType = 5
+	Bell        FruitType = 6
+	Key         FruitType = 7
+)
+
+// Names of the fruit types
+var fruitNames = [...]string{"cherry", "strawberry", "orange", "apple",
+	"melon", "galaxian", "bell", "key"}
+
+// Get the name of a fruit type
+func (fruit FruitType) String() string {
+	return nameOf(fruitNames[:], uint8(fruit))
+}
+
+// The type of a power-up
+type PowerUpType uint8
+
+const (
+	Freeze     PowerUpType = 0 // Freezes the ghosts
+	Boost      PowerUpType = 1 // Speeds up Pacman
+	Multiplier PowerUpType = 2 // Multiplies the points scored
+)
+
+// Names of the power-up types
+var powerUpNames = [...]string{"freeze", "boost", "multiplier"}
+
+// Get the name of a power-up type
+func (kind PowerUpType) String() string {
+	return nameOf(powerUpNames[:], uint8(kind))
+}
+
+// A power-up left on the maze
+type PowerUp struct {
+	Row  int8
+	Col  int8
+	Type PowerUpType
+}
+
+// A teleporter of the maze, a pair of cells that lead to each other
+type Teleporter struct {
+	Ends          [2]Location // Both ends (with no direction)
+	CooldownSteps uint8       // Steps until it can be used again (0 = ready)
+}
+
+// A gate of the maze, a cell whose wall opens and closes during the game
+type Gate struct {
+	Row  int8
+	Col  int8
+	Open bool
+}
+
+// A location in the maze, with the direction being faced
+type Location struct {
+	Row int8
+	Col int8
+	Dir Direction
+}
+
+/*
+A continuous position in cell units (with the center of each cell at whole
+numbers), with a heading in radians (right = 0, down = pi/2)
+*/
+type Pose struct {
+	Row     float32
+	Col     float32
+	Heading float32
+}
+
+// A ghost
+type Ghost struct {
+	Color        Color
+	Location           // Location and direction of the ghost
+	FrightSteps  uint8 // Steps left until the ghost is no longer frightened
+	Flashing     bool  // Whether the fright is ending soon
+	Spawning     bool  // Whether the ghost is leaving the ghost house
+	TrappedSteps uint8 // Steps left until the ghost is released
+	Eaten        bool  // Whether the ghost was eaten (and is respawning)
+}
+
+// Whether a ghost is frightened (and can be eaten)
+func (g *Ghost) Frightened() bool {
+	return g.FrightSteps > 0
+}
+
+// The game state
+type GameState struct {
+	Ticks         uint16 // Ticks since the game started
+	UpdatePeriod  uint8  // Ticks per step
+	Mode          Mode
+	ModeSteps     uint8 // Steps until the mode changes
+	ModeDuration  uint8 // Steps that the mode lasts for
+	LevelSteps    uint16
+	Score         uint16
+	Level         uint8
+	Lives         uint8
+	GhostCombo    uint8
+	Ghosts        [NumGhosts]Ghost
+	Pacman        Location
+	Fruit         *Location // nil if there is no fruit
+	FruitSteps    uint8
+	FruitDuration uint8
+	Pellets       [MazeRows]uint32 // One bit per column, for each row
+
+	// Extensions (zero values if the server doesn't send them)
+	Lifecycle    Lifecycle
+	Maze         string
+	SuperPellets []Location
+	GameFPS      uint16
+	PacmanPose   Pose    // Pacman's continuous pose
+	MatchLeft    uint16  // Ticks left on the match clock (0 = none)
+	ExtraGhosts  []Ghost // Ghosts beyond the first four, if the maze has any
+	Gates        []Gate  // Cells whose wall opens and closes, if any
+	Rows         int8    // Size of the maze (MazeRows x MazeCols if not sent)
+	Cols         int8
+	WidePellets  [][]uint32 // All of the pellets, for mazes beyond Pellets
+	FruitType    FruitType  // Fruit of the current level
+	FruitPoints  uint16     // Points that the fruit is worth
+	PowerUps     []PowerUp  // Power-ups left on the maze, if any
+
+	// Steps left of each power-up's effect
+	FreezeSteps     uint8
+	BoostSteps      uint8
+	MultiplierSteps uint8
+
+	Teleporters []Teleporter // Pairs of cells that lead to each other, if any
+
+	// Survival games (played until Pacman is first caught)
+	Survival      bool
+	SurvivalSteps uint32 // Steps that Pacman has survived
+	RespawnSteps  uint16 // Steps until the pellets respawn (0 = never)
+
+	StateHash uint64 // Only sent if the server enables it
+}
+
+// Get all the ghosts, including any extra ghosts
+func (gs *GameState) AllGhosts() []Ghost {
+	return append(gs.Ghosts[:len(gs.Ghosts):len(gs.Ghosts)], gs.ExtraGhosts...)
+}
+
+// Whether there is a pellet (or super pellet) at a given cell
+func (gs *GameState) Pellet(row, col int8) bool {
+	if row < 0 || row >= gs.Rows || col < 0 || col >= gs.Cols {
+		return false
+	}
+	if gs.WidePellets != nil {
+		return (gs.WidePellets[row][col/32]>>(col%32))&1 == 1
+	}
+	return row < MazeRows && col < 32 && (gs.Pellets[row]>>col)&1 == 1
+}
+
+// Whether there is a closed gate (a wall, for now) at a given cell
+func (gs *GameState) GateClosed(row, col int8) bool {
+	for _, gate := range gs.Gates {
+		if gate.Row == row && gate.Col == col {
+			return !gate.Open
+		}
+	}
+	return false
+}
+
+// The number of pellets left in the maze
+func (gs *GameState) NumPellets() int {
+	count := 0
+	rows := [][]uint32{gs.Pellets[:]}
+	if gs.WidePellets != nil {
+		rows = gs.WidePellets
+	}
+	for _, words := range rows {
+		for _, bits := range words {
+			for ; bits != 0; bits &= bits - 1 {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+/*
+A reader over a serialized game state, which keeps track of any reads past the
+end of the buffer (so that the fields can be read without checking each one)
+*/
+type stateReader struct {
+	buf []byte
+	idx int
+	err error
+}
+
+// Read an individual byte
+func (r *stateReader) uint8() uint8 {
+	if r.idx+1 > len(r.buf) {
+		r.err = fmt.Errorf("serialized state truncated at byte %d", r.idx)
+		return 0
+	}
+	r.idx++
+	return r.buf[r.idx-1]
+}
+
+// Read a uint16 (MSB first)
+func (r *stateReader) uint16() uint16 {
+	return uint16(r.uint8())<<8 | uint16(r.uint8())
+}
+
+// Read a uint32 (MSB first)
+func (r *stateReader) uint32() uint32 {
+	return uint32(r.uint16())<<16 | uint32(r.uint16())
+}
+
+// Read a uint64 (MSB first)
+func (r *stateReader) uint64() uint64 {
+	return uint64(r.uint32())<<32 | uint64(r.uint32())
+}
+
+// Read a float32 (MSB first)
+func (r *stateReader) float32() float32 {
+	return math.Float32frombits(r.uint32())
+}
+
+// Whether there are more bytes to read (for optional extensions)
+func (r *stateReader) more() bool {
+	return r.err == nil && r.idx < len(r.buf)
+}
+
+// Read a location
+func (r *stateReader) location() Location {
+
+	// Each byte holds a direction component in its top 2 bits (as a signed
+	// number), and a coordinate in the rest
+	rowByte, colByte := r.uint8(), r.uint8()
+	dr, dc := int8(rowByte)>>6, int8(colByte)>>6
+
+	// Find the direction with these components
+	dir := None
+	for d := Up; d < None; d++ {
+		if dRow[d] == dr && dCol[d] == dc {
+			dir = d
+		}
+	}
+	return Location{Row: int8(rowByte & 0x3f), Col: int8(colByte & 0x3f),
+		Dir: dir}
+}
+
+// Read a ghost (with its flags in the top bits of the fright and trapped steps)
+func (r *stateReader) ghost(color Color) Ghost {
+	loc := r.location()
+	fright, trapped := r.uint8(), r.uint8()
+	return Ghost{
+		Color:        color,
+		Location:     loc,
+		FrightSteps:  fright & 0b00111111,
+		Flashing:     fright&0b01000000 != 0,
+		Spawning:     fright&0b10000000 != 0,
+		TrappedSteps: trapped & 0b01111111,
+		Eaten:        trapped&0b10000000 != 0,
+	}
+}
+
+// Look up a name by its index, falling back to the index itself
+func nameOf(names []string, idx uint8) string {
+	if int(idx) < len(names) {
+		return names[idx]
+	}
+	return fmt.Sprint(idx)
+}
+
+/*
+Get the row and column of the empty location (e.g. the fruit's location when
+there is no fruit), for a maze of a given size
+*/
+func emptyCoord(rows, cols int8) int8 {
+	if rows <= 32 && cols <= 32 {
+		return 32
+	}
+	return 63
+}
+
+// Decode a serialized game state, as broadcast by the server
+func DecodeState(buf []byte) (*GameState, error) {
+
+	// Read the fields in the same order that the server writes them
+	r := stateReader{buf: buf}
+	var gs GameState
+
+	// Packet header
+	gs.Ticks = r.uint16()
+	gs.UpdatePeriod = r.uint8()
+	gs.Mode = Mode(r.uint8())
+	gs.ModeSteps = r.uint8()
+	gs.ModeDuration = r.uint8()
+	gs.LevelSteps = r.uint16()
+
+	// General game state information
+	gs.Score = r.uint16()
+	gs.Level = r.uint8()
+	gs.Lives = r.uint8()
+	gs.GhostCombo = r.uint8()
+
+	// Ghosts
+	for color := range gs.Ghosts {
+		gs.Ghosts[color] = r.ghost(Color(color))
+	}
+
+	// Pacman
+	gs.Pacman = r.location()
+
+	// Fruit (checked against the empty location once the maze size is known)
+	fruit := r.location()
+	gs.FruitSteps = r.uint8()
+	gs.FruitDuration = r.uint8()
+
+	// Pellets
+	for row := range gs.Pellets {
+		gs.Pellets[row] = r.uint32()
+	}
+
+	// Extensions (left out by older servers)
+	if r.more() {
+		gs.Lifecycle = Lifecycle(r.uint8())
+	}
+	if r.more() {
+		name := make([]byte, r.uint8())
+		for i := range name {
+			name[i] = r.uint8()
+		}
+		gs.Maze = string(name)
+	}
+	if r.more() {
+		count := r.uint8()
+		for i := uint8(0); i < count; i++ {
+			row, col := int8(r.uint8()), int8(r.uint8())
+			gs.SuperPellets = append(gs.SuperPellets,
+				Location{Row: row, Col: col, Dir: None})
+		}
+	}
+	if r.more() {
+		gs.GameFPS = r.uint16()
+	}
+	if r.more() {
+		gs.PacmanPose = Pose{Row: r.float32(), Col: r.float32(),
+			Heading: r.float32()}
+	}
+	if r.more() {
+		gs.MatchLeft = r.uint16()
+	}
+	if r.more() {
+		count := r.uint8()
+		for i := uint8(0); i < count; i++ {
+			gs.ExtraGhosts = append(gs.ExtraGhosts,
+				r.ghost(Color(NumGhosts+i)))
+		}
+	}
+	if r.more() {
+		count := r.uint8()
+		for i := uint8(0); i < count; i++ {
+			row, col := int8(r.uint8()), int8(r.uint8())
+			gs.Gates = append(gs.Gates,
+				Gate{Row: row, Col: col, Open: r.uint8() != 0})
+		}
+	}
+	gs.Rows, gs.Cols = MazeRows, MazeCols
+	if r.more() {
+		gs.Rows, gs.Cols = int8(r.uint8()), int8(r.uint8())
+		if gs.Rows < 0 || gs.Cols < 0 {
+			return nil, fmt.Errorf("maze size %d x %d out of range",
+				uint8(gs.Rows), uint8(gs.Cols))
+		}
+		if gs.Rows > MazeRows || gs.Cols > 32 {
+			gs.WidePellets = make([][]uint32, gs.Rows)
+			for row := range gs.WidePellets {
+				gs.WidePellets[row] = make([]uint32, (int(gs.Cols)+31)/32)
+				for word := range gs.WidePellets[row] {
+					gs.WidePellets[row][word] = r.uint32()
+				}
+			}
+		}
+	}
+	if r.more() {
+		gs.FruitType = FruitType(r.uint8())
+		gs.FruitPoints = r.uint16()
+	}
+	if r.more() {
+		count := r.uint8()
+		for i := uint8(0); i < count; i++ {
+			row, col := int8(r.uint8()), int8(r.uint8())
+			gs.PowerUps = append(gs.PowerUps,
+				PowerUp{Row: row, Col: col, Type: PowerUpType(r.uint8())})
+		}
+		gs.FreezeSteps = r.uint8()
+		gs.BoostSteps = r.uint8()
+		gs.MultiplierSteps = r.uint8()
+	}
+	if r.more() {
+		count := r.uint8()
+		for i := uint8(0); i < count; i++ {
+			var t Teleporter
+			for end := range t.Ends {
+				t.Ends[end] = Location{Row: int8(r.uint8()),
+					Col: int8(r.uint8()), Dir: None}
+			}
+			t.CooldownSteps = r.uint8()
+			gs.Teleporters = append(gs.Teleporters, t)
+		}
+	}
+	if r.more() {
+		gs.Survival = r.uint8() != 0
+		gs.SurvivalSteps = r.uint32()
+		gs.RespawnSteps = r.uint16()
+	}
+	if r.more() {
+		gs.StateHash = r.uint64()
+	}
+
+	// Check that the whole state could be read
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	// Fruit (an empty location means there is no fruit)
+	empty := emptyCoord(gs.Rows, gs.Cols)
+	if fruit.Row != empty || fruit.Col != empty {
+		gs.Fruit = &fruit
+	}
+	return &gs, nil
+}
@@ -2,35 +2,260 @@ package main
 
 import (
 	"encoding/json"
-	"log"
+	"fmt"
+	"log/slog"
+	"net/url"
 	"os"
+	"pacbot_server/game"
+	"pacbot_server/webserver"
 )
 
 type Configuration struct {
-	ServerIP         string
-	TcpPort          int
-	WebSocketPort    int
-	OneClientPerIP   bool
-	GameFPS          int32
-	NumActiveGhosts  uint8
-	TrustedClientIPs []string
+	ServerIP          string
+	TcpPort           int
+	WebSocketPort     int
+	GrpcPort          int
+	TLSCertFile       string
+	TLSKeyFile        string
+	AutocertDomains   []string
+	AutocertCacheDir  string
+	SSERate           float64
+	SpectatorRate     float64
+	UdpTargets        []string
+	Compression       bool
+	CompressionLevel  int
+	OneClientPerIP    bool
+	GameFPS           int32
+	CountdownSeconds  uint8
+	MatchSeconds      uint16
+	NumActiveGhosts   uint8
+	DisabledGhosts    []string
+	Maze              string
+	MazeFile          string
+	BonusLifeScores   []uint16
+	GateSchedule      []game.GateChange
+	RandomSeed        int64
+	StateHash         bool
+	PoseFilter        string
+	PoseFilterWindow  int
+	PoseNoise         float64
+	PoseMaxSpeed      float64
+	CollisionRadius   float64
+	ReplayDir         string
+	SnapshotDir       string
+	RewindSeconds     float64
+	ResultsDir        string
+	EventLogFile      string
+	ScoreboardFile    string
+	LogFormat         string
+	LogFile           string
+	LogLevel          string
+	TrustedClientIPs  []string
+	AuthTokens        []webserver.ClientToken
+	AuthJWTSecret     string
+	CommandRateLimit  float64
+	CommandBurst      int
+	CommandRatePolicy string
+	PingInterval      float64
+	PongTimeout       float64
+	MaxControlLatency float64
+	TelemetryRate     float64
+	HumanGhosts       bool
+	SessionGrace      float64
+	DecisionDeadline  float64
+	DecisionPolicy    string
+	Webhooks          []webserver.Webhook
+	Arena             *webserver.ArenaCalibration
+	Chaos             webserver.ChaosConfig
+	Difficulty        string
+	Game              game.Config
+	Rooms             []RoomConfig
+
+	// The game constants given in the file, to apply over a room's own
+	// difficulty preset
+	gameOverrides json.RawMessage
+}
+
+/*
+Configuration of an extra room, hosting its own game alongside the default
+room - settings that are left out keep the values from the top level, and the
+game constants are layered as they are at the top level: the difficulty preset
+(the room's own, if it has one), then the top-level "Game" constants, then the
+room's "Game" constants
+*/
+type RoomConfig struct {
+	game.RoomSettings
+	Difficulty string
+	Game       json.RawMessage
+	Arena      *webserver.ArenaCalibration
+}
+
+/*
+Get the settings of a room's game engine, filling in the top-level values for
+the settings that the room leaves out
+*/
+func (c *Configuration) roomSettings(room RoomConfig) (game.RoomSettings, error) {
+	settings := room.RoomSettings
+	if settings.GameFPS == 0 {
+		settings.GameFPS = c.GameFPS
+	}
+
+	// Decode the room's game constants over a copy of the top-level ones, or
+	// over the room's difficulty preset with the top-level constants given in
+	// the file applied over it (difficulty.go)
+	if len(room.Game) > 0 || room.Difficulty != "" {
+		var conf game.Config
+		base, _ := json.Marshal(c.Game)
+		json.Unmarshal(base, &conf)
+		if room.Difficulty != "" {
+			preset, err := game.DifficultyPreset(room.Difficulty)
+			if err != nil {
+				return settings, fmt.Errorf("room %q: %v", room.Name, err)
+			}
+			conf = preset
+			if len(c.gameOverrides) > 0 {
+				if err := json.Unmarshal(c.gameOverrides, &conf); err != nil {
+					return settings, err
+				}
+			}
+		}
+		if len(room.Game) > 0 {
+			if err := json.Unmarshal(room.Game, &conf); err != nil {
+				return settings, fmt.Errorf("room %q: %v", room.Name, err)
+			}
+		}
+		settings.Game = &conf
+	}
+	return settings, nil
 }
 
 // Read from the config.json file in the base directory
 func GetConfig() Configuration {
 
 	// Look in the file "config.json" in the top directory
-	file, _ := os.Open("../config.json")
-	defer file.Close()
+	data, err := os.ReadFile("../config.json")
 
-	// Decode the JSON arguments
-	decoder := json.NewDecoder(file)
-	config := Configuration{}
-	err := decoder.Decode(&config)
+	// Decode the JSON arguments (game constants that are left out keep their
+	// values from the difficulty preset, or their defaults)
+	config := Configuration{Game: game.DefaultConfig(), SSERate: 10}
+	if err == nil {
+		err = config.decode(data)
+	}
 	if err != nil {
-		log.Println("JSON read error:", err)
+		slog.Error("JSON read error", "err", err)
+	} else {
+		err = config.validate()
+		if err != nil {
+			slog.Error("Invalid configuration", "err", err)
+		}
 	}
 
+	// The server carries on with an invalid configuration, but never with a
+	// clock rate that the game engine can't run at
+	config.fixGameFPS()
+
+	// Keep track of whether the configuration was valid (for health checks)
+	configErr = err
+
 	// Return the configuration when done
 	return config
 }
+
+/*
+Decode the configuration, starting the game constants from the difficulty
+preset that it chooses (an unknown preset is left to validate to report)
+*/
+func (c *Configuration) decode(data []byte) error {
+	var top struct {
+		Difficulty string
+		Game       json.RawMessage
+	}
+	if err := json.Unmarshal(data, &top); err != nil {
+		return err
+	}
+	if preset, err := game.DifficultyPreset(top.Difficulty); err == nil {
+		c.Game = preset
+	}
+	c.gameOverrides = top.Game
+	return json.Unmarshal(data, c)
+}
+
+// The error from reading the configuration (nil if it was valid)
+var configErr error = nil
+
+// The clock rate to fall back on if the configured one is out of range
+const defaultGameFPS = 24
+
+/*
+Replace any clock rate which is out of range (left out, or not between 1 and
+240) with the default, or with the top-level rate for rooms
+*/
+func (c *Configuration) fixGameFPS() {
+	if c.GameFPS <= 0 || c.GameFPS > 240 {
+		slog.Warn("GameFPS out of range, using the default",
+			"GameFPS", c.GameFPS, "default", defaultGameFPS)
+		c.GameFPS = defaultGameFPS
+	}
+	for i := range c.Rooms {
+		if room := &c.Rooms[i]; room.GameFPS < 0 || room.GameFPS > 240 {
+			slog.Warn("GameFPS out of range, using the top-level rate",
+				"room", room.Name, "GameFPS", room.GameFPS)
+			room.GameFPS = 0
+		}
+	}
+}
+
+// Check that the configuration values are usable
+func (c *Configuration) validate() error {
+	if c.TcpPort <= 0 || c.TcpPort > 65535 {
+		return fmt.Errorf("TcpPort must be between 1 and 65535")
+	}
+	if c.WebSocketPort <= 0 || c.WebSocketPort > 65535 {
+		return fmt.Errorf("WebSocketPort must be between 1 and 65535")
+	}
+	if c.GrpcPort < 0 || c.GrpcPort > 65535 {
+		return fmt.Errorf("GrpcPort must be between 0 and 65535")
+	}
+	switch c.CommandRatePolicy {
+	case "", "drop", "queue", "disconnect":
+	default:
+		return fmt.Errorf("CommandRatePolicy must be drop, queue, or disconnect")
+	}
+	if c.GameFPS <= 0 || c.GameFPS > 240 {
+		return fmt.Errorf("GameFPS must be between 1 and 240")
+	}
+	if _, err := game.DifficultyPreset(c.Difficulty); err != nil {
+		return err
+	}
+	if _, err := game.GhostSet(c.DisabledGhosts); err != nil {
+		return fmt.Errorf("DisabledGhosts: %v", err)
+	}
+	for _, hook := range c.Webhooks {
+		if u, err := url.Parse(hook.URL); err != nil ||
+			(u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("webhook URL \"%s\" must be an http(s) URL",
+				hook.URL)
+		}
+	}
+	rooms := make(map[string]struct{})
+	for _, room := range c.Rooms {
+		if room.Name == "" {
+			return fmt.Errorf("each room must have a Name")
+		}
+		if _, ok := rooms[room.Name]; ok {
+			return fmt.Errorf("room %q is configured twice", room.Name)
+		}
+		rooms[room.Name] = struct{}{}
+		if room.GameFPS < 0 || room.GameFPS > 240 {
+			return fmt.Errorf("room %q: GameFPS must be between 1 and 240",
+				room.Name)
+		}
+		if _, err := game.GhostSet(room.DisabledGhosts); err != nil {
+			return fmt.Errorf("room %q: DisabledGhosts: %v", room.Name, err)
+		}
+		if _, err := c.roomSettings(room); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+/*
+Package engine embeds the server's game rules in other programs, such as
+simulators, bots, and tests: a Game is played one update at a time with a
+Command for Pacman, as fast as the ghosts can be planned, with exactly the
+same rules as the server (the game package's, which the engine wraps), and
+without any clock, servers, or logging.
+
+	g, err := engine.New(engine.Config{Maze: "classic", Seed: 42})
+	if err != nil {
+		log.Fatal(err)
+	}
+	for g.Step(engine.Left) {
+		state := g.State()
+		fmt.Println(state.Score, state.Lives)
+	}
+
+When Pacman is caught, or a level is cleared, the game continues on the next
+step as if a referee had resumed it right away (see game/environment.go). The
+state is the same GameState that the client package decodes from the server's
+broadcasts, so bots can share their code between the two.
+*/
+package engine
+
+import (
+	"fmt"
+	"pacbot_server/client"
+	"pacbot_server/game"
+	"slices"
+)
+
+// The clock rate that games are timed with, unless configured otherwise
+const defaultGameFPS = 24
+
+// The state of a game (as decoded by the client package)
+type State = client.GameState
+
+// A command for Pacman, for one step of the game
+type Command uint8
+
+const (
+	Up    Command = 0 // Move one cell up
+	Left  Command = 1 // Move one cell left
+	Down  Command = 2 // Move one cell down
+	Right Command = 3 // Move one cell right
+	Stay  Command = 4 // Stay in place
+)
+
+// Names of the commands, by value
+var commandNames = [...]string{"up", "left", "down", "right", "stay"}
+
+// Get the name of a command
+func (cmd Command) String() string {
+	if int(cmd) < len(commandNames) {
+		return commandNames[cmd]
+	}
+	return fmt.Sprintf("Command(%d)", uint8(cmd))
+}
+
+/*
+How a game is set up - zero values keep the server's defaults (as configured
+in the game package, e.g. by ConfigGame)
+*/
+type Config struct {
+	Rules          *game.Config // Tunable game constants (see game.DefaultConfig)
+	Maze           string       // Built-in maze profile (see game.MazeNames)
+	Seed           int64        // Seed for the ghosts (0 for a new one per game)
+	GameFPS        int32        // Clock rate, which times the mode waves
+	NumGhosts      uint8        // Number of ghosts in play
+	DisabledGhosts []string     // Ghosts left out of play, by color
+}
+
+// A game, played one update at a time
+type Game struct {
+	env  *game.Environment
+	seed int64 // Seed of each new game (0 for a new one per game)
+	done bool  // Whether the game is over (or can't go any further)
+}
+
+// Set up a game with a configuration, ready for its first step
+func New(conf Config) (*Game, error) {
+
+	// Check the configuration
+	if conf.Maze != "" && !slices.Contains(game.MazeNames(), conf.Maze) {
+		return nil, fmt.Errorf("unknown maze \"%s\"", conf.Maze)
+	}
+	if _, err := game.GhostSet(conf.DisabledGhosts); err != nil {
+		return nil, err
+	}
+	if conf.GameFPS < 0 {
+		return nil, fmt.Errorf("invalid clock rate %d", conf.GameFPS)
+	}
+
+	// Set the game up like a room of the server
+	settings := game.RoomSettings{
+		GameFPS:        conf.GameFPS,
+		Maze:           conf.Maze,
+		DisabledGhosts: conf.DisabledGhosts,
+		Game:           conf.Rules,
+	}
+	if settings.GameFPS == 0 {
+		settings.GameFPS = defaultGameFPS
+	}
+	if conf.NumGhosts != 0 {
+		settings.NumActiveGhosts = &conf.NumGhosts
+	}
+	g := Game{env: game.NewEnvironment(settings), seed: conf.Seed}
+	g.Reset()
+	return &g, nil
+}
+
+// Start a new game (with the configured seed, if there is one)
+func (g *Game) Reset() {
+	g.env.Reset(g.seed)
+	g.done = false
+}
+
+/*
+Play the game forward by one update, with Pacman first carrying out a command -
+returns false once the game is over (stepping it further changes nothing)
+*/
+func (g *Game) Step(cmd Command) bool {
+	if cmd > Stay {
+		cmd = Stay
+	}
+	_, _, g.done = g.env.Step(uint8(cmd))
+	return !g.done
+}
+
+// Get the current state of the game
+func (g *Game) State() *State {
+	state, err := client.DecodeState(g.Serialized())
+	if err != nil {
+		panic(err) // The game's own serialization can always be decoded
+	}
+	return state
+}
+
+/*
+Get the current state of the game, serialized in the same format that the
+server broadcasts to clients
+*/
+func (g *Game) Serialized() []byte {
+	return g.env.Simulation().State()
+}
+
+// Check whether the game is over
+func (g *Game) Done() bool {
+	return g.done
+}
+
+// Get the state hash of the game (e.g. as a key for a transposition table)
+func (g *Game) Hash() uint64 {
+	return g.env.Simulation().Hash()
+}
+
+// Make an independent copy of the game (e.g. to try out commands)
+func (g *Game) Clone() *Game {
+	return &Game{env: g.env.Clone(), seed: g.seed, done: g.done}
+}
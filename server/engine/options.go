@@ -0,0 +1,119 @@
+package engine
+
+import (
+	"pacbot_server/game"
+)
+
+/*
+Games can also be set up with functional options, rather than by filling in a
+Config, so that each game only names what it changes from the defaults:
+
+	g, err := engine.NewGame(
+		engine.WithMaze("competition"),
+		engine.WithSeed(42),
+		engine.WithLives(1),
+		engine.WithGhostStrategy(engine.GhostStrategy{MazeDistance: true}),
+	)
+
+The options are applied in order, so an option that replaces all of the
+tunable constants (WithRules) should come before any that change some of them
+(WithLives, WithGhostStrategy)
+*/
+
+// An option for setting up a game, applied to its configuration
+type Option func(conf *Config)
+
+/*
+How the ghosts chase Pacman - the persona of each ghost is the ghost whose
+chase it copies ("red", "pink", "cyan", or "orange", in the order of the
+ghosts' colors, with any left out keeping their defaults), and the ghosts aim
+by maze distance rather than straight-line distance if MazeDistance is set
+*/
+type GhostStrategy struct {
+	Personas     []string
+	MazeDistance bool
+}
+
+// Set up a game with options, ready for its first step
+func NewGame(opts ...Option) (*Game, error) {
+	var conf Config
+	for _, opt := range opts {
+		opt(&conf)
+	}
+	return New(conf)
+}
+
+/*
+Get the tunable constants of a configuration to change, starting from the
+defaults if none were given
+*/
+func (conf *Config) rules() *game.Config {
+	if conf.Rules == nil {
+		rules := game.DefaultConfig()
+		conf.Rules = &rules
+	}
+	return conf.Rules
+}
+
+// Play every game with the same seed for the ghosts' random decisions
+func WithSeed(seed int64) Option {
+	return func(conf *Config) {
+		conf.Seed = seed
+	}
+}
+
+// Play on a built-in maze profile (see game.MazeNames)
+func WithMaze(name string) Option {
+	return func(conf *Config) {
+		conf.Maze = name
+	}
+}
+
+// Time the game with a clock rate, in ticks per second
+func WithTickRate(fps int32) Option {
+	return func(conf *Config) {
+		conf.GameFPS = fps
+	}
+}
+
+// Start Pacman with a number of lives
+func WithLives(lives uint8) Option {
+	return func(conf *Config) {
+		conf.rules().Lives = lives
+	}
+}
+
+// Set how the ghosts chase Pacman
+func WithGhostStrategy(strategy GhostStrategy) Option {
+	return func(conf *Config) {
+		rules := conf.rules()
+		if strategy.Personas != nil {
+			personas := append([]string{}, strategy.Personas...)
+			rules.GhostPersonas = append(personas,
+				rules.GhostPersonas[min(len(personas),
+					len(rules.GhostPersonas)):]...)
+		}
+		rules.GhostMazeDistance = strategy.MazeDistance
+	}
+}
+
+// Play with the given tunable constants (see game.DefaultConfig)
+func WithRules(rules game.Config) Option {
+	return func(conf *Config) {
+		conf.Rules = &rules
+	}
+}
+
+// Play with a number of ghosts
+func WithNumGhosts(num uint8) Option {
+	return func(conf *Config) {
+		conf.NumGhosts = num
+	}
+}
+
+// Leave some ghosts out of play, by color
+func WithDisabledGhosts(names ...string) Option {
+	return func(conf *Config) {
+		conf.DisabledGhosts = names
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"pacbot_server/game"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+/*
+Export mode (--export <file> with --replay <replay>) turns a recorded game into
+an animation, e.g. for a match report, then exits - the replay is played back
+as fast as the CPU allows and drawn at --export-fps frames per second (see
+game/replay_export.go). The output file's extension picks the format: a GIF is
+written directly, while anything else (e.g. .mp4) is encoded by ffmpeg, which
+must be installed, from the frames as PNG images
+*/
+
+// The highest frame rate that can be exported (GIF delays are in 1/100 s)
+const maxExportFPS = 50
+
+// Export a replay as an animation, exiting with an error if it fails
+func runExport(conf Configuration, replayPath string, outPath string,
+	fps float64) {
+
+	// Only log what went wrong, to keep the output clean
+	setupLogging(conf.LogFormat, conf.LogFile, "warn")
+
+	// The replay is played back with the current rules, as in headless mode
+	configGameRules(conf)
+
+	// Check the arguments
+	if replayPath == "" {
+		slog.Error("Export error", "err", "--export needs a --replay to export")
+		os.Exit(1)
+	}
+	if fps <= 0 || fps > maxExportFPS {
+		slog.Error("Export error", "err",
+			fmt.Sprintf("--export-fps must be above 0, and at most %d",
+				maxExportFPS))
+		os.Exit(1)
+	}
+
+	// Export as a GIF, or through ffmpeg
+	var frames int
+	var err error
+	if strings.EqualFold(filepath.Ext(outPath), ".gif") {
+		frames, err = exportGIF(replayPath, outPath, fps)
+	} else {
+		frames, err = exportVideo(replayPath, outPath, fps)
+	}
+	if err != nil {
+		slog.Error("Export error", "err", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Exported %d frames to %s\n", frames, outPath)
+}
+
+// Export a replay as an animated GIF
+func exportGIF(replayPath string, outPath string, fps float64) (int, error) {
+	file, err := os.Create(outPath)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	frames, err := game.ExportReplayGIF(replayPath, fps, file)
+	if err != nil {
+		return frames, err
+	}
+	return frames, file.Close()
+}
+
+// Export a replay as a video, by piping its frames into ffmpeg
+func exportVideo(replayPath string, outPath string, fps float64) (int, error) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return 0, fmt.Errorf("ffmpeg is needed to export videos (or export "+
+			"a .gif instead): %v", err)
+	}
+	rate := strconv.FormatFloat(fps, 'f', -1, 64)
+	cmd := exec.Command("ffmpeg", "-y", "-loglevel", "error",
+		"-f", "image2pipe", "-framerate", rate, "-i", "-",
+		"-pix_fmt", "yuv420p", outPath)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return 0, err
+	}
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+
+	// Feed the frames to ffmpeg, then wait for it to finish encoding
+	frames, err := game.ExportReplayPNG(replayPath, fps, stdin)
+	stdin.Close()
+	if waitErr := cmd.Wait(); err == nil && waitErr != nil {
+		err = fmt.Errorf("ffmpeg: %v", waitErr)
+	}
+	return frames, err
+}
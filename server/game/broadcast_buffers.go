@@ -0,0 +1,36 @@
+package game
+
+/*
+Every frame, the engine hands the web broker a copy of the serialized state
+(the output buffer is re-used for the next frame, see game_engine.go). Rather
+than allocating a new copy every frame, the copies come from a small free list:
+once the receiver is done with a copy, it can hand it back with RecycleBuffer,
+and the engine re-uses it for a later frame. Copies that are never handed back
+(e.g. passed on to somebody else) are simply left to the garbage collector, and
+replaced when the free list runs dry.
+*/
+
+// The number of broadcast buffers kept for re-use
+const numFreeBuffers = 4
+
+// Copy the serialized state into a buffer for broadcasting, re-using one if any
+func (ge *GameEngine) broadcastBuffer(state []byte) []byte {
+	var buf []byte = nil
+	select {
+	case buf = <-ge.freeBufs:
+	default:
+		buf = make([]byte, 0, len(state))
+	}
+	return append(buf[:0], state...)
+}
+
+/*
+Hand a broadcast buffer back to the game engine, once it is no longer needed,
+so that it can be re-used for a later frame (registered with the web broker)
+*/
+func (ge *GameEngine) RecycleBuffer(buf []byte) {
+	select {
+	case ge.freeBufs <- buf:
+	default:
+	}
+}
@@ -0,0 +1,50 @@
+package game
+
+import (
+	"math"
+)
+
+/*
+A physical robot clips the corners of cells as it turns, so collisions with
+exactly the same cell are harsh on it: in competition mode, Pacman can also
+collide with a ghost within a configurable distance of it, measured from
+Pacman's continuous pose (see pose.go) to the center of the ghost's cell. The
+distance is in cells - e.g. 0.75 catches a robot most of the way into a
+ghost's cell, and 1 also catches ghosts in the cells next to an untracked
+Pacman. A distance of 0 (the default) keeps collisions to the same cell, as
+outside of competition mode.
+*/
+
+// The distance within which Pacman collides with a ghost in competition mode
+var collisionRadius float64 = 0
+
+/*
+Configure the distance (in cells) within which Pacman collides with a ghost in
+competition mode (0 for the same cell only)
+*/
+func ConfigCollisionRadius(_collisionRadius float64) {
+	collisionRadius = max(_collisionRadius, 0)
+}
+
+// Check whether Pacman collides with a ghost at a given location
+func (gs *gameState) pacmanCollides(loc *locationState) bool {
+
+	// Nothing collides at the empty location (while out of play)
+	if gs.pacmanLoc.isEmpty(gs.maze) || loc.isEmpty(gs.maze) {
+		return false
+	}
+
+	// Pacman always collides with ghosts in the same cell
+	if gs.pacmanLoc.collidesWith(loc) {
+		return true
+	}
+
+	// In competition mode, also check the distance from Pacman's pose
+	if collisionRadius <= 0 || !gs.rules.isCompetition() {
+		return false
+	}
+	row, col := loc.getCoords()
+	poseRow, poseCol, _ := gs.getPacmanPose()
+	return math.Hypot(float64(poseRow)-float64(row),
+		float64(poseCol)-float64(col)) <= collisionRadius
+}
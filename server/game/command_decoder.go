@@ -0,0 +1,149 @@
+package game
+
+import (
+	"fmt"
+)
+
+/*
+Byte commands are checked against their formats before they are carried out,
+so that malformed traffic (e.g. from a buggy bot) is rejected with an error
+naming the command and what was wrong with it, rather than being ignored or
+half-applied. The web server checks commands as they arrive too (see
+ValidateCommand), so that it can reply to the client right away. Each command
+type takes a fixed number of argument bytes, and some arguments have ranges:
+
+	p, P, h, H, n, r, R, w, a, s, d, k - no arguments
+	g, u (non-zero), b                 - 1 byte
+	x, o (row, col), z, f (1-240)      - 2 bytes
+	G (ghost, direction 0-4)           - 2 bytes
+	l                                  - 8 or 12 bytes (localization.go)
+	T                                  - 8 bytes, plus 4 per encoder (telemetry.go)
+	e                                  - an action and its arguments (referee.go)
+	m, K                               - a name (at least 1 byte)
+
+Unknown command types are rejected as well. Whether a well-formed command can
+be carried out still depends on the game (e.g. a move into a wall, or a row
+and column outside of the maze), which only the game engine can tell (see
+errors.go).
+*/
+
+// The format of a byte command's arguments
+type commandFormat struct {
+	minArgs int    // Fewest argument bytes
+	maxArgs int    // Most argument bytes
+	want    string // Description of the arguments (for errors)
+
+	// Check the values of the arguments, returning why they are invalid (or
+	// "" if they aren't) - nil if any values are allowed
+	check func(args []byte) string
+}
+
+// The formats of the byte commands, by type
+var commandFormats = map[byte]commandFormat{
+	'p': {0, 0, "no arguments", nil},
+	'P': {0, 0, "no arguments", nil},
+	'h': {0, 0, "no arguments", nil},
+	'H': {0, 0, "no arguments", nil},
+	'n': {0, 0, "no arguments", nil},
+	'r': {0, 0, "no arguments", nil},
+	'R': {0, 0, "no arguments", nil},
+	'w': {0, 0, "no arguments", nil},
+	'a': {0, 0, "no arguments", nil},
+	's': {0, 0, "no arguments", nil},
+	'd': {0, 0, "no arguments", nil},
+	'k': {0, 0, "no arguments", nil},
+	'g': {1, 1, "1 byte", nil},
+	'b': {1, 1, "1 byte", nil},
+	'u': {1, 1, "a non-zero byte", checkUpdatePeriod},
+	'x': {2, 2, "2 bytes", nil},
+	'o': {2, 2, "2 bytes", nil},
+	'z': {2, 2, "2 bytes", nil},
+	'f': {2, 2, "2 bytes", checkClockRate},
+	'G': {2, 2, "a ghost and direction", checkGhostSteering},
+	'l': {8, 12, "8 or 12 bytes", checkLocalization},
+	'T': {8, 8 + 4*maxTelemetryEncoders, "8 bytes, plus 4 per encoder",
+		checkTelemetry},
+	'e': {1, 1 + maxRefereeNote*4, "an action", checkRefereeCommand},
+	'm': {1, 255, "a maze name", nil},
+	'K': {1, 255, "a snapshot name", nil},
+}
+
+// Check the update period of a 'u' command
+func checkUpdatePeriod(args []byte) string {
+	if args[0] == 0 {
+		return "expected a non-zero byte"
+	}
+	return ""
+}
+
+// Check the clock rate of an 'f' command
+func checkClockRate(args []byte) string {
+	fps := int32(args[0])<<8 | int32(args[1])
+	if fps == 0 || fps > maxGameFPS {
+		return fmt.Sprintf("clock rate %d out of range (1-%d)", fps, maxGameFPS)
+	}
+	return ""
+}
+
+// Check the ghost and direction of a 'G' command
+func checkGhostSteering(args []byte) string {
+	if args[0] >= numColors {
+		return fmt.Sprintf("ghost %d out of range (0-%d)", args[0], numColors-1)
+	}
+	if args[1] > none {
+		return fmt.Sprintf("direction %d out of range (0-%d)", args[1], none)
+	}
+	return ""
+}
+
+// Check the length of an 'l' command (which can't be 9 to 11 bytes)
+func checkLocalization(args []byte) string {
+	if len(args) != 8 && len(args) != 12 {
+		return "expected 8 or 12 bytes"
+	}
+	return ""
+}
+
+// Check the length of a 'T' command (a whole number of encoders)
+func checkTelemetry(args []byte) string {
+	if !validTelemetryLen(len(args)) {
+		return "unexpected length"
+	}
+	return ""
+}
+
+// Check the action of an 'e' command, and the length of its arguments
+func checkRefereeCommand(args []byte) string {
+	action := args[0]
+	if n, ok := refereeArgsLen[action]; (ok && len(args)-1 != n) ||
+		(!ok && action != refereeNote) {
+		return fmt.Sprintf("bad action '%c'", action)
+	}
+	return ""
+}
+
+/*
+Check a byte command against the format of its type - returns an error
+wrapping ErrInvalidCommand if it is malformed (exported for the web server,
+which checks commands as they arrive)
+*/
+func ValidateCommand(cmd []byte) error {
+	if len(cmd) == 0 {
+		return fmt.Errorf("%w: empty command", ErrInvalidCommand)
+	}
+	format, ok := commandFormats[cmd[0]]
+	if !ok {
+		return fmt.Errorf("%w: unknown command type 0x%02x", ErrInvalidCommand,
+			cmd[0])
+	}
+	args := cmd[1:]
+	if len(args) < format.minArgs || len(args) > format.maxArgs {
+		return invalidCommand(cmd[0], "expected "+format.want)
+	}
+	if format.check != nil {
+		if reason := format.check(args); reason != "" {
+			return invalidCommand(cmd[0], reason)
+		}
+	}
+	return nil
+}
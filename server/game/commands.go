@@ -1,20 +1,30 @@
 package game
 
 import (
-	"log"
+	"fmt"
+	"log/slog"
 )
 
 /***************************** Interpret Commands *****************************/
 
-// Convert byte messages from clients into commands to the game state
-func (gs *gameState) interpretCommand(msg []byte) bool {
+/*
+Convert byte messages from clients into commands to the game state - returns
+whether the game should restart, and an error if the command couldn't be
+carried out (see errors.go)
+*/
+func (gs *gameState) interpretCommand(msg []byte) (bool, error) {
+
+	// Check the command against its format (command_decoder.go)
+	if err := ValidateCommand(msg); err != nil {
+		return false, err
+	}
 
 	// Log the command if necessary
 	if getCommandLogEnable() {
 		if len(msg) > 1 {
-			log.Printf("\033[2m\033[35mCOMM: %c %v\033[0m", msg[0], msg[1:])
+			slog.Debug("Command", "type", string(msg[0]), "args", msg[1:])
 		} else {
-			log.Printf("\033[2m\033[35mCOMM: %c\033[0m", msg[0])
+			slog.Debug("Command", "type", string(msg[0]))
 		}
 	}
 
@@ -29,39 +39,88 @@ func (gs *gameState) interpretCommand(msg []byte) bool {
 	case 'P':
 		gs.play()
 
+	// Halt the engine loop (for debugging)
+	case 'h':
+		gs.halt()
+
+	// Unhalt the engine loop
+	case 'H':
+		gs.unhalt()
+
+	// Advance the halted engine loop by one update
+	case 'n':
+		return false, gs.requestStep()
+
 	// Restart command
 	case 'r':
-		return true
+		return true, nil
 
 	// Restart command
 	case 'R':
-		return true
+		return true, nil
+
+	// Select a maze profile by name (restarting the game to apply it)
+	case 'm':
+		if !gs.rules.selectMaze(string(msg[1:])) {
+			return false, invalidCommand('m',
+				fmt.Sprintf("unknown maze \"%s\"", msg[1:]))
+		}
+		return true, nil
+
+	// Select the ghosts in play (restarting the game to apply it, see
+	// ghost_selection.go)
+	case 'g':
+		gs.rules.selectGhosts(msg[1])
+		return true, nil
 
 	// Move up (decrease row index)
 	case 'w':
-		gs.movePacmanDir(up)
+		return false, gs.movePacmanDir(up)
 
 	// Move left (decrease column index)
 	case 'a':
-		gs.movePacmanDir(left)
+		return false, gs.movePacmanDir(left)
 
 	// Move down (increase row index)
 	case 's':
-		gs.movePacmanDir(down)
+		return false, gs.movePacmanDir(down)
 
 	// Move right (increase column index)
 	case 'd':
-		gs.movePacmanDir(right)
+		return false, gs.movePacmanDir(right)
 	
 	// Absolute position (from tracking)
 	case 'x':
-		if len(msg) != 3 {
-			log.Println("\033[35m\033[1mERR:  Invalid position update " +
-				"(message type 'x'). Ignoring...\033[0m")
-			return false
-		}
-		gs.movePacmanAbsolute(int8(msg[1]), int8(msg[2]))
+		return false, gs.movePacmanAbsolute(int8(msg[1]), int8(msg[2]))
+
+	// Report from the tracking system, of the robot's position (localization.go)
+	case 'l':
+		return false, gs.localizePacman(decodeLocalization(msg[1:]))
+
+	// Steer a ghost, for a human player (ghost_control.go)
+	case 'G':
+		gs.steerGhost(msg[1], msg[2])
+
+	// Telemetry from the robot, for the event log (telemetry.go)
+	case 'T':
+		gs.recordTelemetry(decodeTelemetry(msg[1:]))
+
+	// Change the update period (ticks per step), to slow down or speed up play
+	case 'u':
+		gs.setUpdatePeriod(msg[1])
+
+	// Change the game clock rate (ticks per second, as a 2-byte integer)
+	case 'f':
+		gs.rules.setFPS(int32(msg[1])<<8 | int32(msg[2]))
+
+	// Referee command, correcting the game (referee.go)
+	case 'e':
+		return false, gs.interpretRefereeCommand(msg[1:])
+
+	// Place a super pellet (for practice drills)
+	case 'o':
+		return false, gs.placeSuperPellet(int8(msg[1]), int8(msg[2]))
 	}
 
-	return false
+	return false, nil
 }
@@ -0,0 +1,183 @@
+package game
+
+import (
+	"log/slog"
+	"time"
+)
+
+/*
+In competition mode (turned on while a match is set up in the lobby), each
+step of a running game is a decision for the bot controlling Pacman: its move
+for the step must arrive within a deadline of the update that started the
+step. The first move to arrive in time is applied as usual, and any later moves
+in the same step are dropped. If no move arrives in time, Pacman gets a default
+move instead, depending on the policy: it continues straight (in its current
+direction), or it stops where it is - and moves arriving after the deadline are
+dropped until the next step. The outcome of every step is logged.
+
+Only direction moves are decisions (absolute positions from tracking are
+applied as usual), and deadlines are counted in ticks of the game clock
+(rounded up), so that enforcement is exactly as strict however busy the server
+is. Replays record the default moves like any other command, along with the
+competition mode commands, so they play back the same way.
+*/
+
+// Policies for the default move of a step whose move didn't arrive in time
+const (
+	decisionStraight = "straight" // Continue in Pacman's current direction
+	decisionStop     = "stop"     // Stay in place
+)
+
+// The deadline for each step's move (0 to never enforce one)
+var decisionDeadline time.Duration = 0
+
+// The policy for steps whose move didn't arrive in time
+var decisionPolicy string = decisionStraight
+
+/*
+Configure the deadline (in seconds) for each step's move in competition mode,
+and the policy for steps whose move didn't arrive in time ("straight" or
+"stop") - a deadline of 0 turns enforcement off
+*/
+func ConfigDecisionBudget(deadline float64, policy string) {
+	decisionDeadline = time.Duration(deadline * float64(time.Second))
+	switch policy {
+	case decisionStraight, decisionStop:
+		decisionPolicy = policy
+	default:
+		slog.Warn("Unknown decision policy, continuing straight instead",
+			"policy", policy)
+		decisionPolicy = decisionStraight
+	}
+}
+
+// The commands to move Pacman in each direction
+var dirCommands = [...]byte{'w', 'a', 's', 'd'}
+
+// States of the decision window of a step
+const (
+	windowNone   uint8 = iota // No decision is expected
+	windowOpen                // Waiting for the step's move
+	windowClosed              // The step's move arrived, or its deadline passed
+)
+
+// The decision window of the current step (only used by the engine loop)
+type decisionWindow struct {
+	state         uint8  // State of the window
+	startTick     uint16 // Tick of the update that started the step
+	deadlineTicks uint16 // Ticks until the deadline
+}
+
+// Interpret a command to turn competition mode on or off ('b', then 0 or 1)
+func (ge *GameEngine) interpretDecisionCommand(msg []byte) bool {
+
+	// Ignore any other commands
+	if len(msg) == 0 || msg[0] != 'b' {
+		return false
+	}
+	if len(msg) != 2 {
+		slog.Error("Invalid competition mode command. Ignoring...", "type", "b")
+		return true
+	}
+
+	// Turn competition mode on or off, closing any open window
+	ge.recorder.recordInput(msg)
+	ge.rules.setCompetition(msg[1] != 0)
+	ge.decision.state = windowNone
+	slog.Info("Competition mode changed", "room", ge.rules.room,
+		"on", ge.rules.isCompetition(),
+		"deadline", decisionDeadline, "policy", decisionPolicy)
+	return true
+}
+
+// Open the decision window of a new step, if a move is expected for it
+func (ge *GameEngine) openDecision() {
+
+	// Only running games in competition mode expect moves, if enforced (when
+	// playing back a replay, the default moves were recorded instead)
+	dw := &ge.decision
+	dw.state = windowNone
+	if !ge.rules.isCompetition() || decisionDeadline <= 0 ||
+		ge.player != nil || ge.state.isPaused() || ge.state.getPauseOnUpdate() {
+		return
+	}
+
+	// Count the deadline in ticks of the game clock (rounded up)
+	tickPeriod := tickTime(ge.rules.getFPS(), 1)
+	ticks := (decisionDeadline + tickPeriod - 1) / tickPeriod
+	dw.deadlineTicks = uint16(min(max(ticks, 1), 0xffff))
+	dw.startTick = ge.state.getCurrTicks()
+	dw.state = windowOpen
+}
+
+/*
+Decide whether a command from a client should be dropped, because it is a move
+that arrived after its step's deadline, or after its step's move - the first
+move to arrive in time is let through, as the step's decision
+*/
+func (ge *GameEngine) dropLateMove(msg []byte) bool {
+
+	// Only direction moves are decisions
+	dw := &ge.decision
+	if dw.state == windowNone || len(msg) == 0 {
+		return false
+	}
+	isMove := false
+	for _, cmd := range dirCommands {
+		isMove = isMove || msg[0] == cmd
+	}
+	if !isMove {
+		return false
+	}
+
+	// Let through the step's move, if it is in time
+	latency := ge.state.getCurrTicks() - dw.startTick
+	if dw.state == windowOpen {
+		dw.state = windowClosed
+		slog.Info("Decision on time", "room", ge.rules.room,
+			"tick", dw.startTick, "latency", latency)
+		return false
+	}
+
+	// Otherwise, drop it
+	slog.Warn("Decision dropped (late or extra move)", "room", ge.rules.room,
+		"tick", dw.startTick, "latency", latency, "move", string(msg[0]))
+	return true
+}
+
+/*
+Close the decision window once its deadline passes (or the next update is
+due), giving Pacman its default move if the step's move never arrived
+*/
+func (ge *GameEngine) expireDecision() {
+
+	// Only open windows can expire
+	dw := &ge.decision
+	if dw.state != windowOpen {
+		return
+	}
+
+	// If the game paused during the step, no move is expected any more
+	if ge.state.isPaused() || ge.state.getPauseOnUpdate() {
+		dw.state = windowNone
+		return
+	}
+
+	// Wait for the deadline, or for the next update
+	latency := ge.state.getCurrTicks() - dw.startTick
+	if latency < dw.deadlineTicks && !ge.state.updateReady() {
+		return
+	}
+	dw.state = windowClosed
+
+	// Give Pacman its default move (recorded like any other command)
+	dir := ge.state.pacmanLoc.getDir()
+	if decisionPolicy == decisionStraight && dir < numDirs {
+		msg := []byte{dirCommands[dir]}
+		ge.recorder.recordInput(msg)
+		ge.state.interpretCommand(msg)
+	}
+	slog.Warn("Decision missed", "room", ge.rules.room,
+		"tick", dw.startTick, "deadline", dw.deadlineTicks,
+		"policy", decisionPolicy)
+}
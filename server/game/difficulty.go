@@ -0,0 +1,71 @@
+package game
+
+import (
+	"fmt"
+	"slices"
+)
+
+/*
+Difficulty presets are named sets of game constants to start from, so that
+the game can be made easier or harder without tuning each constant by hand.
+The preset chosen by Difficulty in config.json (for the whole server, or for a
+room) replaces the defaults, and the constants given in the "Game" section are
+then applied over it. Presets that change how long ghosts stay frightened also
+scale how long they flash for at the end by as much, so that they flash for
+the same share of their fright as usual:
+
+	easy        - for new teams: ghosts stay frightened twice as long (capped
+	              at 63 steps) and move a third as often while frightened,
+	              take twice as long to be released from the ghost house,
+	              pink aims only 2 cells ahead of Pacman, and orange retreats
+	              within 12 cells
+	normal      - the defaults (see variables.go), following the arcade game
+	competition - for practicing against stronger ghosts: ghosts stay
+	              frightened half as long and aim by maze distance, and orange
+	              retreats only within 4 cells
+*/
+
+// The names of the difficulty presets
+var difficultyNames = []string{"easy", "normal", "competition"}
+
+/*
+Get the game constants of a difficulty preset ("" for the defaults) - returns
+an error if there is no preset with that name
+*/
+func DifficultyPreset(name string) (Config, error) {
+	conf := DefaultConfig()
+	switch name {
+	case "", "normal":
+	case "easy":
+		conf.FrightSteps = scaleSteps(conf.FrightSteps, 2)
+		conf.GhostFlashSteps = scaleSteps([]uint8{conf.GhostFlashSteps}, 2)[0]
+		conf.GhostFrightPeriod = 3
+		for i, timeout := range conf.GhostReleaseTimeouts {
+			conf.GhostReleaseTimeouts[i] = uint16(min(2*uint32(timeout), 0xffff))
+		}
+		conf.PinkLookahead = 2
+		conf.OrangeRetreatRadius = 12
+	case "competition":
+		conf.FrightSteps = scaleSteps(conf.FrightSteps, 0.5)
+		conf.GhostFlashSteps = scaleSteps([]uint8{conf.GhostFlashSteps}, 0.5)[0]
+		conf.GhostMazeDistance = true
+		conf.GhostTieBreak = "distance"
+		conf.OrangeRetreatRadius = 4
+	default:
+		return conf, fmt.Errorf("unknown difficulty \"%s\" (use %v)", name,
+			difficultyNames)
+	}
+	return conf, nil
+}
+
+/*
+Scale a table of fright step counts, rounding and capping them at the most
+that a ghost can have (see maxFrightSteps)
+*/
+func scaleSteps(steps []uint8, factor float64) []uint8 {
+	scaled := slices.Clone(steps)
+	for i, s := range scaled {
+		scaled[i] = uint8(min(float64(s)*factor+0.5, float64(maxFrightSteps)))
+	}
+	return scaled
+}
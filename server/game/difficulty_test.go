@@ -0,0 +1,56 @@
+package game
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestScaleSteps(t *testing.T) {
+	for _, tc := range []struct {
+		steps  []uint8
+		factor float64
+		want   []uint8
+	}{
+		{[]uint8{40, 34, 10, 0}, 2, []uint8{63, 63, 20, 0}},
+		{[]uint8{31, 32, 1}, 2, []uint8{62, 63, 2}},
+		{[]uint8{40, 34, 16, 10, 0}, 0.5, []uint8{20, 17, 8, 5, 0}},
+		{[]uint8{255}, 1, []uint8{63}},
+	} {
+		if got := scaleSteps(tc.steps, tc.factor); !slices.Equal(got, tc.want) {
+			t.Errorf("scaleSteps(%v, %g) = %v, want %v", tc.steps, tc.factor,
+				got, tc.want)
+		}
+	}
+}
+
+/*
+Every preset must pass validation unchanged (a value that validation replaces
+would silently undo the preset), and ghosts must only flash for their whole
+fright on the levels where they do by default
+*/
+func TestDifficultyPresetsAreValid(t *testing.T) {
+	def := DefaultConfig()
+	for _, name := range difficultyNames {
+		t.Run(name, func(t *testing.T) {
+			preset, err := DifficultyPreset(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := validateConfig(preset); !reflect.DeepEqual(got, preset) {
+				t.Errorf("validation changed the preset:\n got %+v\nwant %+v",
+					got, preset)
+			}
+			for level, steps := range preset.FrightSteps {
+				flashesAll := steps <= preset.GhostFlashSteps
+				defFlashesAll := def.FrightSteps[level] <= def.GhostFlashSteps
+				if flashesAll != defFlashesAll {
+					t.Errorf("level %d: %d fright steps, %d flashing (by "+
+						"default, %d and %d)", level+1, steps,
+						preset.GhostFlashSteps, def.FrightSteps[level],
+						def.GhostFlashSteps)
+				}
+			}
+		})
+	}
+}
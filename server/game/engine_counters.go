@@ -0,0 +1,53 @@
+package game
+
+import (
+	"sync/atomic"
+)
+
+/*
+The game state belongs to the engine's go-routine (see game_state.go), but a
+few of its scalars are wanted elsewhere on every request - e.g. by health
+checks and metrics, which shouldn't have to wait for a deep copy of the state.
+The engine publishes them once per frame, after serializing the state, to
+atomic counters that can be read from any go-routine without locking:
+
+	counters := ge.Counters()
+	fmt.Println(counters.Tick, counters.Score, counters.Pellets, counters.Mode)
+
+The counters are only ever as fresh as the last frame, and each is published
+on its own, so they may straddle two frames if read while being published.
+*/
+
+// Scalars of the game state, as last published by the engine loop
+type EngineCounters struct {
+	Tick    uint16 // Current ticks
+	Score   uint16 // Current score
+	Pellets uint16 // Pellets left
+	Mode    string // Game mode ("paused", "scatter", or "chase")
+}
+
+// Atomic counters that the engine loop publishes the game state's scalars to
+type engineCounters struct {
+	tick    atomic.Uint32
+	score   atomic.Uint32
+	pellets atomic.Uint32
+	mode    atomic.Uint32
+}
+
+// Publish the scalars of the game state (only called from the engine loop)
+func (ge *GameEngine) publishCounters() {
+	ge.counters.tick.Store(uint32(ge.state.getCurrTicks()))
+	ge.counters.score.Store(uint32(ge.state.getScore()))
+	ge.counters.pellets.Store(uint32(ge.state.getNumPellets()))
+	ge.counters.mode.Store(uint32(ge.state.getMode()))
+}
+
+// Get the scalars of the game state, as of the last frame of the engine loop
+func (ge *GameEngine) Counters() EngineCounters {
+	return EngineCounters{
+		Tick:    uint16(ge.counters.tick.Load()),
+		Score:   uint16(ge.counters.score.Load()),
+		Pellets: uint16(ge.counters.pellets.Load()),
+		Mode:    modeNames[ge.counters.mode.Load()%uint32(numModes)],
+	}
+}
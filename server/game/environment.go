@@ -0,0 +1,113 @@
+package game
+
+/*
+Environments let reinforcement learning teams train Pacman policies directly
+against the server's rules, in the style of a Gym environment: Reset() starts
+a new game (already running, with no countdown), and Step(action) plays it
+forward by one update with Pacman moving in a direction, returning the next
+observation, the reward, and whether the game is done. An environment's games
+are simulated (see simulation.go), so they run as fast as the ghosts can be
+planned, log nothing, and don't touch any room's high score.
+
+The observation is the serialized game state, in the same format that is
+broadcast to clients, and the reward is the score gained during the step.
+When Pacman is caught, or a level is cleared, the game continues on the next
+step as if a referee had resumed it right away (if Pacman hasn't respawned yet,
+it stays put during that step). A game is done once it is over, or once it can't tick any further.
+*/
+
+// A Gym-style environment, for training Pacman policies
+type Environment struct {
+	rules *gameRules // rules of the environment's games
+	sim   *Simulation
+	done  bool // whether the current game is done
+}
+
+/*
+Create a new environment, whose games are set up like those of a room (see
+RoomSettings) - call Reset before the first step
+*/
+func NewEnvironment(settings RoomSettings) *Environment {
+	return &Environment{rules: newGameRules(settings)}
+}
+
+/*
+Start a new game, with a given seed for the ghosts' random decisions (0 for
+the configured seed, or a new one if none is configured), and return the first
+observation
+*/
+func (env *Environment) Reset(seed int64) []byte {
+
+	// Create a simulated game
+	if seed == 0 {
+		seed = env.rules.newSeed()
+	}
+	gs := newGameStateWith(env.rules, env.rules.getMaze(), seed)
+	gs.simulated = true
+
+	// Prepare the first update, as the engine does, and start the game
+	gs.updateAllGhosts()
+	gs.handleStepEvents()
+	gs.planAllGhosts()
+	gs.resume()
+
+	env.sim = &Simulation{state: gs}
+	env.done = false
+	return env.sim.State()
+}
+
+/*
+Play the current game forward by one update, with Pacman first moving one cell
+in a direction (0 = up, 1 = left, 2 = down, 3 = right, 4 = stay in place), and
+return the next observation, the reward (the score gained), and whether the
+game is done - stepping a done game changes nothing
+*/
+func (env *Environment) Step(action uint8) ([]byte, float64, bool) {
+
+	// Without a game, or once the game is done, there is nothing to play
+	if env.sim == nil {
+		env.Reset(0)
+	}
+	if env.done {
+		return env.sim.State(), 0, true
+	}
+	gs := env.sim.state
+
+	// If the game paused (after Pacman was caught, or a level was cleared),
+	// resume it - or if it is about to pause, play through the pause instead,
+	// with Pacman still respawning
+	if gs.isPaused() {
+		gs.resume()
+	} else if gs.getPauseOnUpdate() {
+		gs.setPauseOnUpdate(false)
+		action = numDirs
+	}
+
+	// Play the update, and see what it was worth
+	scoreBefore := gs.getScore()
+	stepped := gs.simulateStep(action)
+	reward := float64(gs.getScore()) - float64(scoreBefore)
+	env.done = !stepped || gs.isGameOver()
+	return env.sim.State(), reward, env.done
+}
+
+/*
+Make an independent copy of the environment, with a copy of its current game
+(e.g. to try out moves before stepping the original)
+*/
+func (env *Environment) Clone() *Environment {
+	clone := Environment{rules: env.rules, done: env.done}
+	if env.sim != nil {
+		clone.sim = env.sim.Clone()
+	}
+	return &clone
+}
+
+/*
+Get the simulation of the current game, e.g. to read its score and lives, or
+to clone it for planning ahead - stepping it directly steps the environment's
+game too
+*/
+func (env *Environment) Simulation() *Simulation {
+	return env.sim
+}
@@ -0,0 +1,87 @@
+package game
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+)
+
+/*
+Commands that can't be carried out return typed errors, rather than being
+ignored silently, so that the command layer can tell the client what went wrong
+(the web server relays them as error messages, see
+webserver/command_errors.go). The errors can be told apart with errors.Is:
+
+	ErrInvalidCommand  - the command is malformed (see command_decoder.go)
+	ErrGameNotRunning  - the game is paused, so Pacman can't move
+	ErrWallCollision   - the move or position runs into a wall
+	ErrOutOfBounds     - the position is outside the maze
+
+The command is ignored either way, as before. Only malformed commands are
+logged as errors, since the others are an everyday part of play (e.g. bots that
+keep steering during a pause, or noisy tracking near a wall).
+*/
+
+// Errors returned for commands that can't be carried out
+var (
+	ErrInvalidCommand = errors.New("invalid command")
+	ErrGameNotRunning = errors.New("game is not running")
+	ErrWallCollision  = errors.New("wall collision")
+	ErrOutOfBounds    = errors.New("out of bounds")
+)
+
+// Create an error for a malformed command, of a given type
+func invalidCommand(cmdType byte, reason string) error {
+	return fmt.Errorf("%w '%c': %s", ErrInvalidCommand, cmdType, reason)
+}
+
+// Create an error for a cell that can't be moved to (a wall, or off the maze)
+func (gs *gameState) cellError(row int8, col int8) error {
+	if !gs.inBounds(row, col) {
+		return fmt.Errorf("%w: (%d, %d)", ErrOutOfBounds, row, col)
+	}
+	return fmt.Errorf("%w: (%d, %d)", ErrWallCollision, row, col)
+}
+
+/*
+A listener for commands that couldn't be carried out, given the room, the
+command, and the error (e.g. to relay the error to the client that sent it)
+*/
+var commandErrorListener func(room string, cmd []byte, err error) = nil
+
+// Mutex to protect the command error listener
+var muCommandErrors sync.RWMutex
+
+// Configure the listener for commands that couldn't be carried out
+func ConfigCommandErrorListener(listener func(room string, cmd []byte,
+	err error)) {
+	muCommandErrors.Lock()
+	{
+		commandErrorListener = listener
+	}
+	muCommandErrors.Unlock()
+}
+
+// Log a command that couldn't be carried out, and tell the listener about it
+func (ge *GameEngine) reportCommandError(cmd []byte, err error) {
+	if len(cmd) == 0 {
+		slog.Error("Command ignored", "room", ge.rules.room, "err", err)
+		return
+	}
+	if errors.Is(err, ErrGameNotRunning) || errors.Is(err, ErrWallCollision) ||
+		errors.Is(err, ErrOutOfBounds) {
+		slog.Debug("Command ignored", "room", ge.rules.room,
+			"type", string(cmd[0]), "err", err)
+	} else {
+		slog.Error("Command ignored", "room", ge.rules.room,
+			"type", string(cmd[0]), "err", err)
+	}
+
+	muCommandErrors.RLock()
+	listener := commandErrorListener
+	muCommandErrors.RUnlock()
+	if listener != nil {
+		listener(ge.rules.room, cmd, err)
+	}
+}
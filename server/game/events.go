@@ -0,0 +1,183 @@
+package game
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+/*
+The event log records notable game events (pellets eaten, ghosts eaten, Pacman
+being caught, etc.) as an append-only JSONL file - one JSON object per line,
+each with the event type and the tick it happened on, along with any details
+of the event:
+
+	{"event":"ghost_eaten","ghost":"pink","points":400,"tick":1234}
+*/
+
+// Enum-like declaration to hold the event types
+const (
+	eventGameStart      = "game_start"      // The game started (seed)
+	eventPellet         = "pellet"          // Pellet eaten (row, col)
+	eventSuperPellet    = "super_pellet"    // Super pellet eaten (row, col)
+	eventGhostEaten     = "ghost_eaten"     // Ghost eaten (ghost, points)
+	eventPacmanCaught   = "pacman_caught"   // Pacman caught (ghost, lives)
+	eventFruitSpawned   = "fruit_spawned"   // Fruit spawned (row, col, fruit)
+	eventFruitEaten     = "fruit_eaten"     // Fruit eaten (fruit, points)
+	eventFruitDespawned = "fruit_despawned" // Fruit timed out (row, col, fruit)
+	eventModeChange     = "mode_change"     // Mode changed (from, to)
+	eventLevelComplete  = "level_complete"  // Level completed (level, score)
+	eventGameOver       = "game_over"       // Game over (score, level, pellets)
+	eventHighScore      = "high_score"      // High score beaten (score, previous)
+	eventReferee        = "referee"         // Referee command (action, details)
+	eventTimeUp         = "time_up"         // Match clock ran out (score)
+	eventCountdown      = "countdown"       // Countdown second (count, 0 = go)
+	eventTelemetry      = "telemetry"       // Robot telemetry (battery, etc.)
+	eventGate           = "gate"            // Gate opened or closed (gate, open)
+	eventPowerUp        = "power_up"        // Power-up collected (powerUp)
+	eventTeleport       = "teleport"        // Teleporter used (who, from, to)
+	eventPelletRespawn  = "pellet_respawn"  // Pellets grew back (count)
+)
+
+// The file that game events are appended to (nil if disabled)
+var eventLogFile *os.File = nil
+
+// Mutex accompanying the above variable (also serializing writes)
+var muEventLog sync.Mutex
+
+/*
+A listener which is told about every game event (e.g. to send webhooks) - it is
+called from the game engine's go-routine, so it shouldn't block
+*/
+var eventListener func(details map[string]any) = nil
+
+// Configure a listener to be told about every game event (nil for none)
+func ConfigEventListener(listener func(details map[string]any)) {
+	muEventLog.Lock()
+	{
+		eventListener = listener
+	}
+	muEventLog.Unlock()
+}
+
+/*
+Configure the file to append game events to (empty to disable the event log)
+- the file is created if it doesn't exist yet
+*/
+func ConfigEventLogFile(path string) {
+
+	// Lock the event log while it is being replaced
+	muEventLog.Lock()
+	defer muEventLog.Unlock()
+
+	// Close any previous event log
+	if eventLogFile != nil {
+		eventLogFile.Close()
+		eventLogFile = nil
+	}
+
+	// If no file is given, the event log is disabled
+	if path == "" {
+		return
+	}
+
+	// Open the file for appending
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		slog.Error("Event log error", "err", err)
+		return
+	}
+	eventLogFile = file
+	slog.Info("Logging game events", "path", path)
+}
+
+/*
+Flush and close the event log, once no more games are being played (e.g. when
+the server shuts down)
+*/
+func CloseEventLog() {
+	muEventLog.Lock()
+	defer muEventLog.Unlock()
+	if eventLogFile == nil {
+		return
+	}
+	if err := eventLogFile.Sync(); err != nil {
+		slog.Error("Event log error", "err", err)
+	}
+	eventLogFile.Close()
+	eventLogFile = nil
+}
+
+// Append an event (with any details) to the event log, if it is enabled
+func (gs *gameState) logEvent(event string, details map[string]any) {
+
+	// Simulated games don't send any events
+	if gs.simulated {
+		return
+	}
+
+	// Tell the observers about the event (see observer.go)
+	gs.notifyObservers(event, details)
+
+	// Lock the event log, so that events are written one at a time
+	muEventLog.Lock()
+	defer muEventLog.Unlock()
+
+	// Record the event towards the game's result (see results.go)
+	gs.results.record(gs, event, details)
+
+	// If the event log is disabled and no one is listening, there's nothing
+	// to do
+	if eventLogFile == nil && eventListener == nil {
+		return
+	}
+
+	// Add the event type and tick to the details
+	if details == nil {
+		details = make(map[string]any)
+	}
+	details["event"] = event
+	details["tick"] = gs.getCurrTicks()
+	if gs.rules.room != "" {
+		details["room"] = gs.rules.room
+	}
+
+	// Tell the listener about the event
+	if eventListener != nil {
+		eventListener(details)
+	}
+	if eventLogFile == nil {
+		return
+	}
+
+	// Encode the event as a single line of JSON, and append it
+	line, err := json.Marshal(details)
+	if err != nil {
+		slog.Error("Event log error", "err", err)
+		return
+	}
+	if _, err := eventLogFile.Write(append(line, '\n')); err != nil {
+		slog.Error("Event log error", "err", err)
+	}
+}
+
+/*
+Log the end of the game, with its final score, the level reached, and the
+pellets eaten over the whole game (for the scoreboard, see
+webserver/scoreboard.go), along with the steps survived in survival games,
+then write its result files (see results.go)
+*/
+func (gs *gameState) logGameOver() {
+	details := map[string]any{
+		"score":   gs.getScore(),
+		"level":   gs.getLevel(),
+		"pellets": gs.getPelletsEaten(),
+	}
+	if gs.rules.Survival {
+		details["steps"] = gs.survivalSteps
+	}
+	gs.logEvent(eventGameOver, details)
+	gs.results.save(gs)
+	gs.results = nil
+}
@@ -0,0 +1,113 @@
+package game
+
+import (
+	"slices"
+)
+
+/*
+The fruit that appears on each level follows the arcade game's table: a
+cherry on level 1, a strawberry on level 2, oranges on levels 3 and 4, apples
+on 5 and 6, melons on 7 and 8, galaxians on 9 and 10, bells on 11 and 12, and
+a key on every level after that. Each fruit is worth the points given for its
+level in FruitPoints, and the fruit on each level can be changed with
+FruitTypes (by name), in the same way:
+
+	"FruitTypes": ["cherry", "strawberry", "orange", "orange", "apple", ...]
+
+where the last entry applies to all levels beyond the table.
+
+Once spawned, the fruit stays for FruitDuration steps, counted down by the
+fruit steps, and despawns if Pacman hasn't eaten it by then. Spawning, eating,
+and despawning are logged as events, which carry the fruit's name. Every state
+carries the fruit of the current level, and its points, after the maze size
+(see serFruitType), so that clients can draw and value the fruit without
+keeping their own table.
+*/
+
+// Enum-like declaration to hold the fruit types
+const (
+	fruitCherry     uint8 = 0
+	fruitStrawberry uint8 = 1
+	fruitOrange     uint8 = 2
+	fruitApple      uint8 = 3
+	fruitMelon      uint8 = 4
+	fruitGalaxian   uint8 = 5
+	fruitBell       uint8 = 6
+	fruitKey        uint8 = 7
+	numFruitTypes   uint8 = 8
+)
+
+// Names of the fruit types
+var fruitNames [numFruitTypes]string = [...]string{
+	"cherry",
+	"strawberry",
+	"orange",
+	"apple",
+	"melon",
+	"galaxian",
+	"bell",
+	"key",
+}
+
+/*
+The fruit on each level (following the arcade game) - the last entry applies
+to all levels beyond the table
+*/
+var fruitTypes = []uint8{
+	fruitCherry, fruitStrawberry, fruitOrange, fruitOrange, // levels 1-4
+	fruitApple, fruitApple, fruitMelon, fruitMelon, // levels 5-8
+	fruitGalaxian, fruitGalaxian, fruitBell, fruitBell, // levels 9-12
+	fruitKey, // levels 13+
+}
+
+// Get the type of a fruit by its name (-1 if there is no such fruit)
+func fruitType(name string) int {
+	return slices.Index(fruitNames[:], name)
+}
+
+// Helper function to get the type of the fruit on the current level
+func (gs *gameState) getFruitType() uint8 {
+
+	// Look up the fruit type corresponding to the current level
+	fruitTypes := gs.rules.fruitTypes
+	return fruitTypes[levelTableIdx(gs.getLevel(), len(fruitTypes))]
+}
+
+/*
+Count down the steps until the fruit despawns, logging the fruit's despawn if
+Pacman didn't eat it in time
+*/
+func (gs *gameState) updateFruit() {
+
+	// If there is no fruit, there is nothing to count down
+	if !gs.fruitExists() {
+		return
+	}
+
+	// Decrement the fruit steps
+	gs.decrementFruitSteps()
+
+	// Log the fruit despawning, once its time is up
+	if !gs.fruitExists() {
+		fruitRow, fruitCol := gs.fruitLoc.getCoords()
+		fruit := fruitNames[gs.getFruitType()]
+		gs.logger().Info("Fruit despawned", "fruit", fruit,
+			"tick", gs.getCurrTicks())
+		gs.logEvent(eventFruitDespawned, map[string]any{"row": fruitRow,
+			"col": fruitCol, "fruit": fruit})
+	}
+}
+
+/*
+Serialize the fruit of the current level, as its type (1 byte) followed by
+the points it is worth (2 bytes) - sent whether or not the fruit is out
+*/
+func (gs *gameState) serFruitType(outputBuf []byte, startIdx int) int {
+
+	// Serialize the fruit type, then its points
+	startIdx = serUint8(gs.getFruitType(), outputBuf, startIdx)
+	startIdx = serUint16(gs.getFruitPoints(), outputBuf, startIdx)
+
+	// Return the starting index of the next field
+	return startIdx
+}
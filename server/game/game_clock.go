@@ -0,0 +1,182 @@
+package game
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+/*
+The game clock paces the engine loop. Each frame is scheduled against a fixed
+anchor time (anchor + frames * period) rather than the end of the previous
+frame, so small delays don't accumulate over a long match - a late frame is
+followed by a shorter wait, keeping the game in step with wall-clock time.
+Along the way, the clock measures how far each frame strays from its target
+period (jitter), and counts overruns (frames whose work took longer than the
+period itself). If the loop falls too far behind, the clock resynchronizes to
+the current time instead of rushing through the missed frames.
+*/
+
+// The number of frames the loop may fall behind before the clock resyncs
+const maxFramesBehind = 3
+
+// The shortest time between overrun warnings (to avoid flooding the logs)
+const overrunLogPeriod = time.Second
+
+// Timing statistics of the game clock, exported for health checks
+type ClockStats struct {
+	TargetPeriod time.Duration // Target time between frames
+	LastInterval time.Duration // Actual time between the last two frames
+	MeanJitter   time.Duration // Mean deviation from the target period
+	MaxJitter    time.Duration // Largest deviation from the target period
+	Frames       uint64        // Number of frames measured
+	Overruns     uint64        // Number of frames that overran the period
+	Resyncs      uint64        // Number of times the clock resynchronized
+}
+
+/*
+A game clock object, which waits out the remainder of each frame (only used
+by the game engine's go-routine)
+*/
+type gameClock struct {
+	period      time.Duration // Target time between frames
+	anchor      time.Time     // Time that frame 0 was scheduled for
+	frames      int64         // Frames scheduled since the anchor
+	last        time.Time     // Time that the last frame started
+	timer       *time.Timer   // Timer for waiting out each frame
+	jitterSum   time.Duration // Total deviation from the period (for the mean)
+	lastWarning time.Time     // Time of the last overrun warning
+	overrunsLog uint64        // Overruns since the last overrun warning
+
+	// Timing statistics, exported for health checks
+	stats        ClockStats
+	muClockStats sync.RWMutex // Mutex accompanying the above variable
+}
+
+// Getter method for the timing statistics of the game clock
+func (gc *gameClock) getStats() ClockStats {
+	gc.muClockStats.RLock()
+	defer gc.muClockStats.RUnlock()
+	return gc.stats
+}
+
+// Create a new game clock, with a given time between frames
+func newGameClock(period time.Duration) *gameClock {
+
+	// Create a stopped timer, to be reset at every frame
+	timer := time.NewTimer(period)
+	if !timer.Stop() {
+		<-timer.C
+	}
+
+	// Start measuring from scratch
+	now := time.Now()
+	return &gameClock{
+		period: period,
+		anchor: now,
+		last:   now,
+		timer:  timer,
+		stats:  ClockStats{TargetPeriod: period},
+	}
+}
+
+/*
+Change the time between frames, re-anchoring the schedule at the current
+frame so that the new period applies from here on
+*/
+func (gc *gameClock) reset(period time.Duration) {
+	gc.period = period
+	gc.anchor = time.Now()
+	gc.frames = 0
+	gc.muClockStats.Lock()
+	{
+		gc.stats.TargetPeriod = period
+	}
+	gc.muClockStats.Unlock()
+}
+
+// Free up the game clock's timer
+func (gc *gameClock) stop() {
+	gc.timer.Stop()
+}
+
+/*
+Wait until the next frame is due - returns false if a quit signal was
+received instead
+*/
+func (gc *gameClock) wait(quitCh <-chan struct{}) bool {
+
+	// The time the next frame is due, on the anchored schedule
+	gc.frames++
+	deadline := gc.anchor.Add(time.Duration(gc.frames) * gc.period)
+	now := time.Now()
+
+	// If the frame finished early, wait out the rest of it
+	overrun, resync := false, false
+	if remaining := deadline.Sub(now); remaining > 0 {
+		gc.timer.Reset(remaining)
+		select {
+		case <-gc.timer.C:
+		case <-quitCh:
+			gc.timer.Stop()
+			return false
+		}
+	} else {
+		// Otherwise, the frame overran its deadline - if it fell too far
+		// behind, start a new schedule instead of rushing to catch up
+		overrun = true
+		if -remaining > maxFramesBehind*gc.period {
+			resync = true
+			gc.anchor = now
+			gc.frames = 0
+		}
+
+		// Still respond to quit signals while running behind
+		select {
+		case <-quitCh:
+			return false
+		default:
+		}
+	}
+
+	// Measure how far this frame strayed from the target period
+	now = time.Now()
+	interval := now.Sub(gc.last)
+	gc.last = now
+	jitter := interval - gc.period
+	if jitter < 0 {
+		jitter = -jitter
+	}
+	gc.jitterSum += jitter
+
+	// Update the exported statistics
+	gc.muClockStats.Lock()
+	{
+		gc.stats.LastInterval = interval
+		gc.stats.Frames++
+		gc.stats.MeanJitter = gc.jitterSum /
+			time.Duration(gc.stats.Frames)
+		gc.stats.MaxJitter = max(gc.stats.MaxJitter, jitter)
+		if overrun {
+			gc.stats.Overruns++
+		}
+		if resync {
+			gc.stats.Resyncs++
+		}
+	}
+	gc.muClockStats.Unlock()
+
+	// Warn about overruns (at most once per logging period)
+	if overrun {
+		gc.overrunsLog++
+		if now.Sub(gc.lastWarning) >= overrunLogPeriod {
+			slog.Warn("Game engine frame overran its period",
+				"interval", interval, "period", gc.period,
+				"overruns", gc.overrunsLog, "resync", resync)
+			gc.lastWarning = now
+			gc.overrunsLog = 0
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,351 @@
+package game
+
+import (
+	"log/slog"
+	"slices"
+)
+
+/*
+Tunable game constants, which can be overridden by the "Game" section of the
+configuration so that the game can be adjusted without recompiling - any
+fields left out of the configuration keep the defaults from variables.go
+*/
+type Config struct {
+	UpdatePeriod         uint8         // Initial update period (ticks)
+	Lives                uint8         // Lives that Pacman starts with
+	LevelDuration        uint16        // Steps before a level speeds up
+	LevelPenaltyDuration uint16        // Steps before it speeds up again
+	ModeWaves            [][]uint32    // Scatter/chase schedule (ms)
+	FrightSteps          []uint8       // Fright steps per level
+	FruitDuration        uint8         // Steps that a fruit stays for
+	FruitThresholds      [2]uint16     // Pellets eaten to spawn fruits
+	AngerThresholds      [2]uint16     // Pellets left to anger ghosts
+	PelletPoints         uint16        // Points for a pellet
+	SuperPelletPoints    uint16        // Points for a super pellet
+	FruitPoints          []uint16      // Points for a fruit per level
+	FruitTypes           []string      // Fruit on each level, by name
+	ComboMultiplier      uint16        // Points for the first ghost
+	GhostDotLimits       [][]uint8     // Pellets to leave the house
+	GhostReleaseTimeouts []uint16      // Ticks before a forced release
+	ScatterTargets       [][2]int8     // Scatter targets (row, col)
+	GhostGlobalDotLimits []uint8       // Pellets to leave after a death
+	GhostPersonas        []string      // Ghost whose chase each copies
+	GhostFlashSteps      uint8         // Fright steps left to flash at
+	GhostFrightPeriod    uint8         // Steps per frightened move
+	GhostMazeDistance    bool          // Aim by maze distance
+	GhostTieBreak        string        // Breaks maze distance ties
+	Speeds               []LevelSpeeds // Movement speeds per level
+	PinkLookahead        uint8         // Cells ahead pink aims for
+	CyanPivotAhead       uint8         // Cells ahead cyan pivots on
+	OrangeRetreatRadius  uint8         // Cells within which orange retreats
+	FreezeSteps          uint8         // Steps that a ghost freeze lasts
+	BoostSteps           uint8         // Steps that a speed boost lasts
+	BoostSpeed           uint8         // Pacman's speed while boosted
+	MultiplierSteps      uint8         // Steps that a point multiplier lasts
+	PointMultiplier      uint8         // Multiplier for the points scored
+	TeleportCooldown     uint8         // Steps before a teleporter is reused
+	GhostsTeleport       bool          // Whether ghosts use the teleporters
+	Survival             bool          // Play until Pacman is first caught
+	SurvivalPoints       uint16        // Points for each step survived
+	PelletRespawnSteps   uint16        // Steps between pellet respawns
+}
+
+// Returns a configuration object holding the default game constants
+func DefaultConfig() Config {
+
+	// Copy over the fruit types as names
+	fruits := make([]string, len(fruitTypes))
+	for level, fruit := range fruitTypes {
+		fruits[level] = fruitNames[fruit]
+	}
+
+	// Copy over the scatter targets as coordinates, and the personas as names
+	scatterTargets := make([][2]int8, numColors)
+	personas := make([]string, numColors)
+	for color := uint8(0); color < numColors; color++ {
+		row, col := ghostScatterTargets[color].getCoords()
+		scatterTargets[color] = [2]int8{row, col}
+		personas[color] = ghostNames[ghostPersonas[color]]
+	}
+
+	// Return the default values (copying tables, to avoid aliasing them)
+	return Config{
+		UpdatePeriod:         initUpdatePeriod,
+		Lives:                initLives,
+		LevelDuration:        levelDuration,
+		LevelPenaltyDuration: levelPenaltyDuration,
+		ModeWaves:            slices.Clone(modeWaves),
+		FrightSteps:          slices.Clone(ghostFrightSteps),
+		FruitDuration:        fruitDuration,
+		FruitThresholds:      [2]uint16{fruitThreshold1, fruitThreshold2},
+		AngerThresholds:      [2]uint16{angerThreshold1, angerThreshold2},
+		PelletPoints:         pelletPoints,
+		SuperPelletPoints:    superPelletPoints,
+		FruitPoints:          slices.Clone(fruitPoints),
+		FruitTypes:           fruits,
+		ComboMultiplier:      comboMultiplier,
+		GhostDotLimits:       cloneDotLimits(ghostDotLimits),
+		GhostReleaseTimeouts: slices.Clone(ghostReleaseTimeouts),
+		ScatterTargets:       scatterTargets,
+		GhostGlobalDotLimits: slices.Clone(ghostGlobalDotLimits),
+		GhostPersonas:        personas,
+		GhostFlashSteps:      ghostFlashSteps,
+		GhostFrightPeriod:    ghostFrightMovePeriod,
+		GhostMazeDistance:    ghostMazeDistance,
+		GhostTieBreak:        ghostTieBreak,
+		Speeds:               slices.Clone(levelSpeeds),
+		PinkLookahead:        pinkLookahead,
+		CyanPivotAhead:       cyanPivotAhead,
+		OrangeRetreatRadius:  orangeRetreatRadius,
+		FreezeSteps:          freezeSteps,
+		BoostSteps:           boostSteps,
+		BoostSpeed:           boostSpeed,
+		MultiplierSteps:      multiplierSteps,
+		PointMultiplier:      pointMultiplier,
+		TeleportCooldown:     teleportCooldown,
+		GhostsTeleport:       ghostsTeleport,
+		Survival:             survival,
+		SurvivalPoints:       survivalPoints,
+		PelletRespawnSteps:   pelletRespawnSteps,
+	}
+}
+
+/*
+Configure the game constants (this should be called before the game engine is
+created) - invalid values are logged and replaced by their defaults
+*/
+func ConfigGame(conf Config) {
+
+	// Replace any invalid values
+	conf = validateConfig(conf)
+
+	// Apply the timing constants
+	initUpdatePeriod = conf.UpdatePeriod
+	initLives = conf.Lives
+	levelDuration = conf.LevelDuration
+	levelPenaltyDuration = conf.LevelPenaltyDuration
+	modeWaves = slices.Clone(conf.ModeWaves)
+
+	// Apply the fruit and scoring constants
+	fruitDuration = conf.FruitDuration
+	fruitThreshold1, fruitThreshold2 = conf.FruitThresholds[0],
+		conf.FruitThresholds[1]
+	angerThreshold1, angerThreshold2 = conf.AngerThresholds[0],
+		conf.AngerThresholds[1]
+	pelletPoints = conf.PelletPoints
+	superPelletPoints = conf.SuperPelletPoints
+	fruitPoints = slices.Clone(conf.FruitPoints)
+	fruitTypes = make([]uint8, len(conf.FruitTypes))
+	for level, fruit := range conf.FruitTypes {
+		fruitTypes[level] = uint8(fruitType(fruit))
+	}
+	comboMultiplier = conf.ComboMultiplier
+
+	// Apply the ghost constants
+	ghostFrightSteps = slices.Clone(conf.FrightSteps)
+	ghostDotLimits = cloneDotLimits(conf.GhostDotLimits)
+	ghostReleaseTimeouts = slices.Clone(conf.GhostReleaseTimeouts)
+	ghostGlobalDotLimits = slices.Clone(conf.GhostGlobalDotLimits)
+	ghostFlashSteps = conf.GhostFlashSteps
+	ghostFrightMovePeriod = conf.GhostFrightPeriod
+	ghostMazeDistance = conf.GhostMazeDistance
+	ghostTieBreak = conf.GhostTieBreak
+	levelSpeeds = slices.Clone(conf.Speeds)
+	pinkLookahead = conf.PinkLookahead
+	cyanPivotAhead = conf.CyanPivotAhead
+	orangeRetreatRadius = conf.OrangeRetreatRadius
+
+	// Apply the power-up constants
+	freezeSteps = conf.FreezeSteps
+	boostSteps = conf.BoostSteps
+	boostSpeed = conf.BoostSpeed
+	multiplierSteps = conf.MultiplierSteps
+	pointMultiplier = conf.PointMultiplier
+
+	// Apply the teleporter constants
+	teleportCooldown = conf.TeleportCooldown
+	ghostsTeleport = conf.GhostsTeleport
+
+	// Apply the survival game constants
+	survival = conf.Survival
+	survivalPoints = conf.SurvivalPoints
+	pelletRespawnSteps = conf.PelletRespawnSteps
+	for color := uint8(0); color < numColors; color++ {
+		ghostScatterTargets[color] = newLocationState(
+			conf.ScatterTargets[color][0], conf.ScatterTargets[color][1], none)
+		ghostPersonas[color] = personaColor(conf.GhostPersonas[color])
+	}
+}
+
+/*
+Check a set of game constants, replacing any invalid values by their defaults
+(and logging them) - the tables are copied, to avoid aliasing them
+*/
+func validateConfig(conf Config) Config {
+
+	// Start from the defaults, to fall back on for any invalid values
+	def := DefaultConfig()
+
+	// The update period must be positive
+	if conf.UpdatePeriod == 0 {
+		slog.Warn("Update period must be positive, using the default")
+		conf.UpdatePeriod = def.UpdatePeriod
+	}
+
+	// Pacman must start with a life
+	if conf.Lives == 0 {
+		slog.Warn("Lives must be positive, using the default")
+		conf.Lives = def.Lives
+	}
+
+	// Each scatter/chase schedule must be non-empty
+	if len(conf.ModeWaves) == 0 || slices.ContainsFunc(conf.ModeWaves,
+		func(waves []uint32) bool { return len(waves) == 0 }) {
+		slog.Warn("Mode waves must be non-empty, using the defaults")
+		conf.ModeWaves = def.ModeWaves
+	}
+
+	// Per-level tables must have at least one entry
+	if len(conf.FrightSteps) == 0 {
+		conf.FrightSteps = def.FrightSteps
+	}
+	if len(conf.FruitPoints) == 0 {
+		conf.FruitPoints = def.FruitPoints
+	}
+	if len(conf.FruitTypes) == 0 {
+		conf.FruitTypes = def.FruitTypes
+	}
+	if len(conf.GhostDotLimits) == 0 {
+		conf.GhostDotLimits = def.GhostDotLimits
+	}
+	if len(conf.GhostReleaseTimeouts) == 0 {
+		conf.GhostReleaseTimeouts = def.GhostReleaseTimeouts
+	}
+
+	// Fright steps must fit next to the flags they are serialized with
+	conf.FrightSteps = slices.Clone(conf.FrightSteps)
+	for level, steps := range conf.FrightSteps {
+		if steps > maxFrightSteps {
+			defSteps := def.FrightSteps[min(level, len(def.FrightSteps)-1)]
+			slog.Warn("Fright steps must be at most 63, using the default",
+				"level", level+1, "steps", steps, "default", defSteps)
+			conf.FrightSteps[level] = defSteps
+		}
+	}
+
+	// Frightened ghosts must move at some point
+	if conf.GhostFrightPeriod == 0 {
+		conf.GhostFrightPeriod = def.GhostFrightPeriod
+	}
+
+	// Ties between maze distances must be broken one of the known ways
+	switch conf.GhostTieBreak {
+	case "order", "distance":
+	case "":
+		conf.GhostTieBreak = def.GhostTieBreak
+	default:
+		slog.Warn("Ghost tie-break must be order or distance, using the default",
+			"tieBreak", conf.GhostTieBreak, "default", def.GhostTieBreak)
+		conf.GhostTieBreak = def.GhostTieBreak
+	}
+
+	// Every level must have speeds, and the ghosts must move at some point
+	if len(conf.Speeds) == 0 {
+		conf.Speeds = def.Speeds
+	}
+	conf.Speeds = slices.Clone(conf.Speeds)
+	for level, speeds := range conf.Speeds {
+		defSpeeds := def.Speeds[min(level, len(def.Speeds)-1)]
+
+		// Tunnel speeds left out keep their defaults
+		if speeds.Tunnel == 0 {
+			speeds.Tunnel = defSpeeds.Tunnel
+			conf.Speeds[level] = speeds
+		}
+		if !speeds.valid() {
+			slog.Warn("Ghost speeds must be between 1 and 200 (and Pacman's "+
+				"at most 200), using the defaults", "level", level+1)
+			conf.Speeds[level] = defSpeeds
+		}
+	}
+
+	/*
+		Per-ghost tables must cover every ghost - ghosts left out (e.g. the
+		extra ghosts, in a configuration written for four) keep their defaults
+	*/
+	conf.ScatterTargets = padGhosts(conf.ScatterTargets, def.ScatterTargets)
+	conf.GhostGlobalDotLimits = padGhosts(conf.GhostGlobalDotLimits,
+		def.GhostGlobalDotLimits)
+	conf.GhostPersonas = padGhosts(conf.GhostPersonas, def.GhostPersonas)
+	conf.GhostDotLimits = cloneDotLimits(conf.GhostDotLimits)
+	for level, limits := range conf.GhostDotLimits {
+		defLimits := def.GhostDotLimits[min(level, len(def.GhostDotLimits)-1)]
+		conf.GhostDotLimits[level] = padGhosts(limits, defLimits)
+	}
+	for color, persona := range conf.GhostPersonas {
+		if !slices.Contains(ghostNames[:numClassicGhosts], persona) {
+			slog.Warn("Ghost personas must be red, pink, cyan, or orange, "+
+				"using the default", "ghost", ghostNames[color], "persona", persona)
+			conf.GhostPersonas[color] = def.GhostPersonas[color]
+		}
+	}
+
+	// Every level's fruit must be one of the fruit types
+	conf.FruitTypes = slices.Clone(conf.FruitTypes)
+	for level, fruit := range conf.FruitTypes {
+		if fruitType(fruit) < 0 {
+			defFruit := def.FruitTypes[min(level, len(def.FruitTypes)-1)]
+			slog.Warn("Unknown fruit type, using the default", "level",
+				level+1, "fruit", fruit, "default", defFruit)
+			conf.FruitTypes[level] = defFruit
+		}
+	}
+
+	// Ghosts must aim for targets within reach of the largest maze
+	if conf.PinkLookahead > uint8(maxMazeRows) ||
+		conf.CyanPivotAhead > uint8(maxMazeRows) {
+		slog.Warn("Ghost lookaheads must fit in the maze, using the defaults")
+		conf.PinkLookahead = def.PinkLookahead
+		conf.CyanPivotAhead = def.CyanPivotAhead
+	}
+
+	// Boosts must keep Pacman within the speed limit, and multipliers positive
+	if conf.BoostSpeed > maxSpeed {
+		slog.Warn("Boost speed must be at most 200, using the default")
+		conf.BoostSpeed = def.BoostSpeed
+	}
+	if conf.PointMultiplier == 0 {
+		conf.PointMultiplier = def.PointMultiplier
+	}
+
+	// Copy the tables, so that the caller can't change them later
+	conf.ModeWaves = slices.Clone(conf.ModeWaves)
+	conf.FrightSteps = slices.Clone(conf.FrightSteps)
+	conf.FruitPoints = slices.Clone(conf.FruitPoints)
+	conf.GhostReleaseTimeouts = slices.Clone(conf.GhostReleaseTimeouts)
+	return conf
+}
+
+// Copy a table of pellet limits for leaving the ghost house (row by row)
+func cloneDotLimits(table [][]uint8) [][]uint8 {
+	cloned := make([][]uint8, len(table))
+	for level, limits := range table {
+		cloned[level] = slices.Clone(limits)
+	}
+	return cloned
+}
+
+/*
+Make a copy of a per-ghost table with an entry for every ghost, filling in the
+missing entries from the defaults (and dropping any extra ones)
+*/
+func padGhosts[T any](table []T, defaults []T) []T {
+	padded := slices.Clone(defaults[:numColors])
+	copy(padded, table)
+	return padded
+}
+
+// Get the color of a (original) ghost by its name, for its persona
+func personaColor(name string) uint8 {
+	return uint8(max(slices.Index(ghostNames[:numClassicGhosts], name), 0))
+}
@@ -0,0 +1,158 @@
+package game
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"time"
+)
+
+/*
+Deep copies of the game state, so that the game can be played forward (e.g.
+to predict the ghosts, or for a bot's Monte Carlo rollouts) without touching
+the live game - a copy shares only the read-only parts of the original (its
+maze layout and rules), and has its own locations, ghosts, and random
+number generators, which continue from where the original's left off. Copies
+are simulated: they log nothing, send no events, and don't count towards the
+room's high score, so they can be stepped through (see simulateStep) as fast
+as the ghosts can be planned.
+*/
+
+// Make a deep copy of a ghost state, tied to a (copied) game state
+func (g *ghostState) deepCopy(gs *gameState) *ghostState {
+
+	// Copy over the ghost state, with copies of its locations
+	gCopy := ghostState{
+		loc:           newLocationStateCopy(g.loc),
+		nextLoc:       newLocationStateCopy(g.nextLoc),
+		scatterTarget: newLocationStateCopy(g.scatterTarget),
+		game:          gs,
+		color:         g.color,
+		trappedSteps:  g.trappedSteps,
+		frightSteps:   g.frightSteps,
+		dotCount:      g.dotCount,
+		spawning:      g.spawning,
+		eaten:         g.eaten,
+		waiting:       g.waiting,
+		steering:      g.steering,
+
+		// Skip a new generator ahead to where the ghost's generator is
+		rngSrc: newCountingSource(gs.seed+int64(g.color), g.rngSrc.draws),
+	}
+	gCopy.rng = rand.New(gCopy.rngSrc)
+	return &gCopy
+}
+
+/*
+Make a deep copy of the game state - like a snapshot, this should be called
+from the game engine's go-routine, between updates
+*/
+func (gs *gameState) deepCopy() *gameState {
+
+	// New game state object, filled in using the usual helpers where possible
+	gsCopy := gameState{
+		currTicks:        gs.getCurrTicks(),
+		updatePeriod:     gs.getUpdatePeriod(),
+		mode:             gs.getMode(),
+		lastUnpausedMode: gs.getLastUnpausedMode(),
+		pauseOnUpdate:    gs.getPauseOnUpdate(),
+		modeSteps:        gs.getModeSteps(),
+		modeWave:         gs.getModeWave(),
+		levelSteps:       gs.getLevelSteps(),
+		lifecycle:        gs.getLifecycle(),
+		countdownLeft:    gs.getCountdownLeft(),
+		matchLeft:        gs.getMatchLeft(),
+		currLevel:        gs.getLevel(),
+		currLives:        gs.getLives(),
+		pacmanLoc:        newLocationStateCopy(gs.pacmanLoc),
+		pacmanMoves:      gs.getPacmanMoves(),
+		fruitLoc:         newLocationStateCopy(gs.fruitLoc),
+		fruitSteps:       gs.getFruitSteps(),
+		ghosts:           make([]*ghostState, len(gs.ghosts)),
+		ghostCombo:       gs.ghostCombo,
+		maze:             gs.maze,
+		walls:            gs.walls,
+		seed:             gs.seed,
+		rules:            gs.rules,
+		activeGhosts:     gs.activeGhosts,
+		simulated:        true,
+	}
+
+	// Copy the halting state
+	gsCopy.halted = gs.halted
+	gsCopy.stepPending = gs.stepPending
+
+	// Copy the score
+	gsCopy.currScore = gs.currScore
+	gsCopy.bonusLives = gs.bonusLives
+	gsCopy.highScored = gs.highScored
+
+	// Copy the pellet counters used to release ghosts from the ghost house
+	gsCopy.globalDotCount = gs.globalDotCount
+	gsCopy.globalDotActive = gs.globalDotActive
+	gsCopy.lastPelletTick = gs.lastPelletTick
+
+	// Copy the power-ups, and their effects
+	gsCopy.powerUpsLeft = gs.powerUpsLeft
+	gsCopy.freezeSteps = gs.freezeSteps
+	gsCopy.boostSteps = gs.boostSteps
+	gsCopy.multiplierSteps = gs.multiplierSteps
+
+	// Copy the teleporters' cooldowns
+	gsCopy.teleportSteps = gs.teleportSteps
+
+	// Copy the survival game's progress
+	gsCopy.survivalSteps = gs.survivalSteps
+	gsCopy.respawnSteps = gs.respawnSteps
+
+	// Copy Pacman's tracked pose, and the filter's estimate of it (with its
+	// own copy of the latest reports)
+	gsCopy.pacmanPose = gs.pacmanPose
+	gsCopy.pose = gs.pose
+	gsCopy.pose.samples = slices.Clone(gs.pose.samples)
+
+	// Copy the pellets
+	gsCopy.pellets = gs.pellets
+	gsCopy.superPellets = gs.superPellets
+	gsCopy.numPellets = gs.numPellets
+	gsCopy.pelletsEaten = gs.pelletsEaten
+	gsCopy.levelEaten = gs.levelEaten
+
+	// Copy each of the ghosts, tied to the copy of the game state
+	for color, g := range gs.ghosts {
+		gsCopy.ghosts[color] = g.deepCopy(&gsCopy)
+	}
+
+	// Return the copy
+	return &gsCopy
+}
+
+// The longest to wait for the engine loop to copy the game state
+const stateCopyTimeout = time.Second
+
+/*
+Get a deep copy of the game engine's current state, made by the engine loop
+between updates (so that it is consistent) - safe to call from any go-routine
+*/
+func (ge *GameEngine) copyState() (*gameState, error) {
+
+	// Ask the engine loop for a copy, and wait for it
+	reply := make(chan *gameState, 1)
+	timeout := time.NewTimer(stateCopyTimeout)
+	defer timeout.Stop()
+	select {
+	case ge.copyCh <- reply:
+	case <-ge.quitCh:
+		return nil, fmt.Errorf("game engine stopped")
+	case <-timeout.C:
+		return nil, fmt.Errorf("game engine not responding")
+	}
+	select {
+	case gs := <-reply:
+		return gs, nil
+	case <-ge.quitCh:
+		return nil, fmt.Errorf("game engine stopped")
+	case <-timeout.C:
+		return nil, fmt.Errorf("game engine not responding")
+	}
+}
@@ -1,16 +1,23 @@
 package game
 
 import (
-	"log"
+	"context"
+	"log/slog"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// Keep track of number of active game engines (should only be one)
-var activeGameEngines = 0
+// The highest clock rate that can be requested at runtime
+const maxGameFPS int32 = 240
 
-// Mutex to protect numActiveGameEngines
-var muAGE sync.Mutex
+/*
+Helper function to get the time between ticks for a given clock rate, sped up
+by a given multiplier
+*/
+func tickTime(clockRate int32, speed float64) time.Duration {
+	return time.Duration(float64(time.Second) / (float64(clockRate) * speed))
+}
 
 /*
 A game engine object, to act as an intermediary between the web broker
@@ -22,40 +29,138 @@ type GameEngine struct {
 	webOutputCh chan<- []byte
 	webInputCh  <-chan []byte
 	state       *gameState
-	ticker      *time.Ticker    // serves as the game clock
+	recorder    *replayRecorder // records the game to a replay (nil if not)
+	player      *replayPlayer   // plays back a replay instead (nil if not)
+	speed       float64         // speed multiplier for the game clock
+	prepared    bool            // whether the first update was already done
+	clock       *gameClock      // serves as the game clock
+	clockRate   int32           // clock rate of the game clock (ticks per second)
+	rules       *gameRules      // rules of the games (game_rules.go)
 	wgQuit      *sync.WaitGroup // wait group to make sure it quits safely
+	headless    *headlessRun    // runs without a clock or I/O (nil if not)
+	decision    decisionWindow  // moves expected in competition mode
+
+	// Requests for copies of the game state (see game_copy.go)
+	copyCh chan chan *gameState
+
+	// Broadcast buffers handed back for re-use (broadcast_buffers.go)
+	freeBufs chan []byte
+
+	// The time of the last frame of the engine loop (in Unix nanoseconds),
+	// and the time between frames (zeros if the engine loop is not running)
+	lastFrameTime atomic.Int64
+	framePeriod   atomic.Int64
+
+	// Scalars of the game state, published every frame (engine_counters.go)
+	counters engineCounters
+
+	// Workers planning the ghosts while the loop runs (planner_pool.go)
+	planners atomic.Pointer[plannerPool]
+
+	// States of the last few ticks of the live game (rewind.go)
+	rewind rewindBuffer
 }
 
 // Create a new game engine, casting channels to be uni-directional
 func NewGameEngine(_webOutputCh chan<- []byte, _webInputCh <-chan []byte,
 	_wgQuit *sync.WaitGroup, clockRate int32) *GameEngine {
+	return NewRoomEngine(_webOutputCh, _webInputCh, _wgQuit,
+		RoomSettings{GameFPS: clockRate})
+}
+
+/*
+Create a new game engine for a room, whose games can be set up differently
+from the server-wide defaults (see RoomSettings)
+*/
+func NewRoomEngine(_webOutputCh chan<- []byte, _webInputCh <-chan []byte,
+	_wgQuit *sync.WaitGroup, settings RoomSettings) *GameEngine {
+
+	// Set up the rules of the room's games
+	rules := newGameRules(settings)
 
-	// Time between ticks
-	_tickTime := 1000000 * time.Microsecond / time.Duration(clockRate)
 	ge := GameEngine{
 		quitCh:      make(chan struct{}),
 		webOutputCh: _webOutputCh,
 		webInputCh:  _webInputCh,
-		state:       newGameState(),
-		ticker:      time.NewTicker(_tickTime),
+		state:       newGameState(rules),
+		clock:       newGameClock(tickTime(rules.fps, 1)),
+		clockRate:   rules.fps,
+		rules:       rules,
+		speed:       1,
 		wgQuit:      _wgQuit,
+		copyCh:      make(chan chan *gameState),
+		freeBufs:    make(chan []byte, numFreeBuffers),
 	}
 
 	// Return the game engine
 	return &ge
 }
 
+/*
+Create a new game engine which plays back a recorded replay (at a given speed
+multiplier) instead of a live game - commands from clients are ignored
+*/
+func NewReplayEngine(_webOutputCh chan<- []byte, _webInputCh <-chan []byte,
+	_wgQuit *sync.WaitGroup, path string, speed float64) (*GameEngine, error) {
+
+	// Load the replay
+	rp, err := loadReplay(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// The speed multiplier must be positive
+	if speed <= 0 {
+		speed = 1
+	}
+
+	// Set up the game the same way that the recorded game was set up
+	rules := newGameRules(RoomSettings{GameFPS: rp.fps})
+	rules.selectGhosts(rp.ghosts)
+	ge := GameEngine{
+		quitCh:      make(chan struct{}),
+		webOutputCh: _webOutputCh,
+		webInputCh:  _webInputCh,
+		state:       newGameStateWith(rules, rp.maze, rp.seed),
+		player:      rp,
+		speed:       speed,
+		prepared:    rp.restarted,
+		clock:       newGameClock(tickTime(rp.fps, speed)),
+		clockRate:   rp.fps,
+		rules:       rules,
+		wgQuit:      _wgQuit,
+		copyCh:      make(chan chan *gameState),
+		freeBufs:    make(chan []byte, numFreeBuffers),
+	}
+
+	// A restarted game prepares its first update up front
+	if ge.prepared {
+		ge.state.updateAllGhosts()
+		ge.state.handleStepEvents()
+		ge.state.planAllGhosts()
+	}
+
+	// Log that the replay is being played back
+	slog.Info("Playing back replay", "path", path, "speed", speed)
+
+	// Return the game engine
+	return &ge, nil
+}
+
 // Quit by closing the game engine, in case the loop ends
 func (ge *GameEngine) quit() {
 
 	// Log that the game engine successfully quit
-	log.Println("\033[35mLOG:  Game engine successfully quit\033[0m")
+	slog.Info("Game engine successfully quit", "room", ge.rules.room)
 
 	// Decrement the quit wait group counter
 	ge.wgQuit.Done()
 
-	// Free up the ticker
-	ge.ticker.Stop()
+	// Free up the game clock
+	ge.clock.stop()
+
+	// Finish recording the current game
+	ge.recorder.close()
 }
 
 // Quit function exported to other packages
@@ -63,6 +168,44 @@ func (ge *GameEngine) Quit() {
 	close(ge.quitCh)
 }
 
+/*
+Run the game engine loop until a context is done (e.g. when the server is
+interrupted) - the engine finishes its current tick before quitting, so the
+replay ends on a whole frame
+*/
+func (ge *GameEngine) Run(ctx context.Context) {
+	stop := context.AfterFunc(ctx, ge.Quit)
+	defer stop()
+	ge.RunLoop()
+}
+
+/*
+Restart the game by re-initializing the game state (pellets, ghosts, score,
+lives, etc.) back to the lobby, without restarting the engine
+*/
+func (ge *GameEngine) restart() {
+
+	// Log that the game was restarted
+	slog.Info("Game restarted", "room", ge.rules.room)
+
+	// Create a fresh game state (still steered by the same players), and
+	// prepare the first update
+	state := newGameState(ge.rules)
+	state.copySteering(ge.state)
+	ge.state = state
+	ge.rewind.clear()
+	ge.state.updateAllGhosts()
+	ge.state.handleStepEvents()
+	ge.planGhosts()
+
+	// Record the new game to its own replay, and its own result
+	ge.recorder.close()
+	ge.recorder = newReplayRecorder(ge.state, true)
+	if ge.player == nil && ge.headless == nil {
+		ge.state.results = newResultRecorder(ge.state)
+	}
+}
+
 // Start the game engine - should be launched as a go-routine
 func (ge *GameEngine) RunLoop() {
 
@@ -72,39 +215,53 @@ func (ge *GameEngine) RunLoop() {
 	// Increment the quit wait group counter
 	ge.wgQuit.Add(1)
 
-	// Update the number of active game engines
-	// (Lock the mutex to prevent races in case of a multiple engine issue)
-	var _activeGameEngines int
-	muAGE.Lock()
-	{
-		activeGameEngines++
-		_activeGameEngines = activeGameEngines
-	}
-	muAGE.Unlock()
+	// Once this engine loop stops, it no longer reports as running
+	defer ge.clearFrame()
 
-	// If there was already a game engine, kill this one and throw an error
-	if _activeGameEngines > 1 {
-		log.Println("\033[35m\033[1mERR:  Cannot simultaneously dispatch more" +
-			" than one game engine. Quitting...\033[0m")
-		return
-	}
+	// Plan the ghosts with long-lived workers while the loop runs
+	// (planner_pool.go)
+	ge.startPlanners()
+	defer ge.stopPlanners()
 
-	// Output buffer to store the serialized output
-	outputBuf := make([]byte, 256)
+	// Output buffer to store the serialized output (including extensions)
+	outputBuf := make([]byte, maxStateLen)
 
 	// Length of the serialized output
 	serLen := 0
 
 	// Flag to keep track of whether the last iteration of the loop was a tick
-	justTicked := true
+	// (unless the first update was already done up front)
+	justTicked := !ge.prepared
+
+	// The number of frames since the engine loop started (for autosaves)
+	var frame uint32 = 0
+
+	// Record the first game to a replay (if configured, and it is a new game)
+	if ge.player == nil && !ge.prepared && ge.headless == nil {
+		ge.recorder = newReplayRecorder(ge.state, false)
+	}
+
+	// Collect the first game's result (if configured, and it isn't a replay)
+	if ge.player == nil && ge.headless == nil {
+		ge.state.results = newResultRecorder(ge.state)
+	}
+
+	// Start the game clock's schedule from the first frame
+	ge.clock.reset(ge.clock.period)
 
 	for {
 
+		// Record that the engine loop is still alive (for health checks)
+		ge.markFrame(ge.clock.period)
+
+		// Flag to keep track of whether a restart was requested this frame
+		restartPending := false
+
 		/*
 			If the game did not just tick, we know it was paused, so we can skip
 			these steps as they were already done during the first paused tick
 		*/
-		if justTicked && ge.state.updateReady() {
+		if justTicked && ge.state.isAdvancing() && ge.state.updateReady() {
 			/* STEP 1: Update the ghost positions if necessary */
 
 			// Update all ghosts at once
@@ -131,7 +288,14 @@ func (ge *GameEngine) RunLoop() {
 			/* STEP 2: Start planning the next ghost moves if an update happened */
 
 			// Plan the next ghost moves
-			ge.state.planAllGhosts()
+			ge.planGhosts()
+
+			// If the engine loop was single-stepping, this update completes it
+			ge.state.finishStep()
+
+			// In competition mode, expect a move for the new step
+			// (decision_budget.go)
+			ge.openDecision()
 		}
 
 		/* STEP 3: Serialize the current game state to the output buffer */
@@ -139,22 +303,48 @@ func (ge *GameEngine) RunLoop() {
 		// Re-serialize the current state
 		serLen = ge.state.serFull(outputBuf, 0)
 
+		// Publish the scalars of the state (for health checks)
+		ge.publishCounters()
+
+		// Keep the state, so that the live game can be rewound (rewind.go)
+		if ge.player == nil && ge.headless == nil {
+			ge.recordRewind()
+		}
+
+		// Record the serialized state to the replay, if a keyframe is due
+		ge.recorder.recordKeyframe(outputBuf[:serLen])
+
+		// When playing back a replay, check it against the recorded state
+		ge.player.checkKeyframe(outputBuf[:serLen])
+
 		/* STEP 4: Write the serialized game state to the output channel */
 
 		// Check if a write will be blocked, and try to write the serialized state
-		b := len(ge.webOutputCh) == cap(ge.webOutputCh)
-		start := time.Now()
-		ge.webOutputCh <- outputBuf[:serLen]
+		// (unless running headless, with nobody to write it to - although
+		// the state may be wanted there, e.g. to export a replay)
+		if ge.headless != nil {
+			if hr := ge.headless; hr.onFrame != nil && !hr.onFrame(
+				outputBuf[:serLen], tickTime(ge.clockRate, 1)) {
+				hr.stopped = true
+			}
+		} else {
+			b := len(ge.webOutputCh) == cap(ge.webOutputCh)
+			start := time.Now()
 
-		/*
-			If the write was blocked for too long (> 1ms), send a warning
-			to the terminal
-		*/
-		if b {
-			wait := time.Since(start)
-			if wait > time.Millisecond {
-				log.Printf("\033[35mWARN: The game engine output channel was "+
-					"full (%s)\033[0m\n", wait)
+			// Hand the broker its own (immutable) copy of the state, since the
+			// output buffer is re-used for the next frame
+			ge.webOutputCh <- ge.broadcastBuffer(outputBuf[:serLen])
+
+			/*
+				If the write was blocked for too long (> 1ms), send a warning
+				to the terminal
+			*/
+			if b {
+				wait := time.Since(start)
+				if wait > time.Millisecond {
+					slog.Warn("The game engine output channel was full",
+						"wait", wait)
+				}
 			}
 		}
 
@@ -164,35 +354,173 @@ func (ge *GameEngine) RunLoop() {
 			select {
 			// If we get a message from the web broker, handle it
 			case msg := <-ge.webInputCh:
-				rst := ge.state.interpretCommand(msg)
-				if rst { // Reset if necessary
-					ge.state = newGameState()
-					ge.state.updateAllGhosts()
-					ge.state.handleStepEvents()
-					ge.state.planAllGhosts()
-					justTicked = true
+
+				// Clients can't control a replay that is being played back
+				if ge.player != nil {
+					continue
+				}
+
+				// Reject malformed commands (command_decoder.go)
+				if err := ValidateCommand(msg); err != nil {
+					ge.reportCommandError(msg, err)
+					continue
+				}
+
+				// Snapshot, rewind, and competition mode commands act on the
+				// game engine instead
+				if ge.interpretSnapshotCommand(msg) ||
+					ge.interpretRewindCommand(msg) ||
+					ge.interpretDecisionCommand(msg) {
+					continue
+				}
+
+				// In competition mode, drop moves that missed their step
+				if ge.dropLateMove(msg) {
+					continue
 				}
+
+				ge.recorder.recordInput(msg)
+				rst, err := ge.state.interpretCommand(msg)
+				if err != nil { // Tell the sender why it was ignored (errors.go)
+					ge.reportCommandError(msg, err)
+				}
+				if rst { // Reset at the end of this frame if necessary
+					restartPending = true
+					break read_loop
+				}
+
+			// If asked for a copy of the game state, make one (game_copy.go)
+			case reply := <-ge.copyCh:
+				reply <- ge.state.deepCopy()
 			default:
 				break read_loop
 			}
 		}
 
+		// When playing back a replay, apply the commands recorded this frame
+		for _, msg := range ge.player.frameInputs() {
+			if ge.interpretDecisionCommand(msg) {
+				continue
+			}
+			if rst, _ := ge.state.interpretCommand(msg); rst {
+				restartPending = true
+			}
+		}
+
+		// When running a live game headless, start or resume it automatically
+		if ge.headless != nil && ge.player == nil {
+			ge.state.play()
+		}
+
+		// Save a snapshot every so often, for crash recovery (if configured)
+		if ge.player == nil && ge.headless == nil {
+			ge.autosaveSnapshot(frame)
+		}
+		frame++
+
+		// If the clock rate was changed by a command, adjust the game clock
+		if fps := ge.rules.getFPS(); fps != ge.clockRate {
+			ge.clockRate = fps
+			ge.clock.reset(tickTime(fps, ge.speed))
+		}
+
 		/* STEP 6: Update the game state for the next tick */
 
+		// If the engine loop is halted (and not stepping), freeze the game
+		advancing := ge.state.isAdvancing()
+
+		// Advance the countdown to the start of the game, if there is one
+		if advancing {
+			ge.state.updateCountdown()
+		}
+
 		// Increment the number of ticks
-		if !ge.state.isPaused() {
+		if advancing && !ge.state.isPaused() {
 			justTicked = true
 			ge.state.nextTick()
+			ge.state.updateMatchClock()
+			if ge.headless != nil {
+				ge.headless.ticks++
+			}
 		} else {
 			justTicked = false
 		}
 
-		/* STEP 5: Wait for the ticker to complete the current frame */
-		select {
-		case <-ge.ticker.C:
-		// If we get a quit signal, quit this broker
-		case <-ge.quitCh:
+		// In competition mode, give Pacman its default move once a step's
+		// deadline passes without a move
+		ge.expireDecision()
+
+		// Move on to the next frame of the replay
+		ge.recorder.nextFrame()
+
+		// When a replay finishes playing back, halt on its final state
+		if ge.player.nextFrame() {
+			ge.state.halt()
+		}
+
+		// Restart the game if necessary (it starts out paused, not ticking),
+		// unless playing back a replay (which only holds one game)
+		if restartPending && ge.player == nil {
+			ge.restart()
+			justTicked = false
+		}
+
+		/* STEP 7: Wait for the game clock to complete the current frame */
+
+		// When running headless, skip the wait, stopping once done instead
+		if ge.headless != nil {
+			ge.headless.frames++
+			if ge.headlessDone() {
+				return
+			}
+			select {
+			case <-ge.quitCh:
+				return
+			default:
+			}
+			continue
+		}
+
+		// If we get a quit signal, quit this engine
+		if !ge.clock.wait(ge.quitCh) {
 			return
 		}
 	}
 }
+
+// Record that the engine loop started a frame (with a given time per frame)
+func (ge *GameEngine) markFrame(period time.Duration) {
+	ge.framePeriod.Store(int64(period))
+	ge.lastFrameTime.Store(time.Now().UnixNano())
+}
+
+// Record that the engine loop stopped, so it no longer reports as running
+func (ge *GameEngine) clearFrame() {
+	ge.lastFrameTime.Store(0)
+	ge.framePeriod.Store(0)
+}
+
+/*
+Get the liveness of the engine loop - the time since its last frame, the
+expected time between frames, and whether the engine loop is running at all
+(for health checks)
+*/
+func (ge *GameEngine) Liveness() (age time.Duration, period time.Duration,
+	running bool) {
+	lastFrameTime := ge.lastFrameTime.Load()
+	if lastFrameTime == 0 {
+		return 0, 0, false
+	}
+	return time.Since(time.Unix(0, lastFrameTime)),
+		time.Duration(ge.framePeriod.Load()), true
+}
+
+// Get the name of the game engine's room ("" for the default room)
+func (ge *GameEngine) Room() string {
+	return ge.rules.room
+}
+
+// Get the timing statistics of the game clock (for health checks)
+func (ge *GameEngine) ClockStats() ClockStats {
+	return ge.clock.getStats()
+}
@@ -1,7 +1,7 @@
 package game
 
 import (
-	"log"
+	"fmt"
 	"slices"
 )
 
@@ -11,7 +11,7 @@ import (
 Get a bit within an unsigned integer (treating the integers
 in pellets and walls as bit arrays)
 */
-func getBit[N uint8 | uint16 | uint32, I int8 | uint8](
+func getBit[N uint8 | uint16 | uint32 | uint64, I int8 | uint8](
 	num N, bitIdx I) bool {
 
 	/*
@@ -25,7 +25,7 @@ func getBit[N uint8 | uint16 | uint32, I int8 | uint8](
 Get a bit within an unsigned integer (treating the integers in pellets
 and walls as bit arrays)
 */
-func modifyBit[N uint8 | uint16 | uint32, I int8 | uint8](
+func modifyBit[N uint8 | uint16 | uint32 | uint64, I int8 | uint8](
 	num *N, bitIdx I, bitVal bool) {
 
 	// If the bit is true, we should set the bit, otherwise we clear it
@@ -55,7 +55,7 @@ func (gs *gameState) updateReady() bool {
 
 // Determines if a position is within the bounds of the maze
 func (gs *gameState) inBounds(row int8, col int8) bool {
-	return ((row >= 0 && row < mazeRows) && (col >= 0 && col < mazeCols))
+	return gs.maze.inBounds(row, col)
 }
 
 // Determines if a pellet is at a given location
@@ -64,37 +64,90 @@ func (gs *gameState) pelletAt(row int8, col int8) bool {
 		return false
 	}
 
-	// (Read) lock the pellets array
-	gs.muPellets.RLock()
-	defer gs.muPellets.RUnlock()
-
 	// Returns the bit of the pellet row corresponding to the column
-	return getBit(gs.pellets[row], col)
+	return gs.pellets.get(row, col)
+}
+
+// Determines if a super pellet is at a given location
+func (gs *gameState) superPelletAt(row int8, col int8) bool {
+	if !gs.inBounds(row, col) {
+		return false
+	}
+
+	// Returns the bit of the super pellet row corresponding to the column
+	return gs.superPellets.get(row, col)
+}
+
+/*
+Places a super pellet at a given location (for practice drills), replacing
+any regular pellet already there - returns an error if the location is a wall
+or off the maze
+*/
+func (gs *gameState) placeSuperPellet(row int8, col int8) error {
+
+	// Super pellets can only be placed in empty spaces
+	if gs.wallAt(row, col) {
+		return gs.cellError(row, col)
+	}
+
+	// If there was no pellet here, there is one more now
+	if !gs.pellets.get(row, col) {
+		gs.numPellets++
+	}
+
+	// Set the pellet and super pellet bits
+	gs.pellets.set(row, col, true)
+	gs.superPellets.set(row, col, true)
+
+	// Send a message to the terminal
+	gs.logger().Info("Super pellet placed", "row", row, "col", col,
+		"tick", gs.getCurrTicks())
+	return nil
 }
 
 /*
-Collects a pellet if it is at a given location
-Returns the number of pellets that are left
+Collects a pellet if it is at a given location - returns an error if the
+location is off the maze
 */
-func (gs *gameState) collectPellet(row int8, col int8) {
+func (gs *gameState) collectPellet(row int8, col int8) error {
+
+	// Only locations within the maze can hold pellets
+	if !gs.inBounds(row, col) {
+		return gs.cellError(row, col)
+	}
 
 	// Collect fruit, if applicable
 	if gs.fruitExists() && gs.pacmanLoc.collidesWith(gs.fruitLoc) {
 		gs.setFruitSteps(0)
-		gs.incrementScore(fruitPoints)
+		points := gs.powerUpPoints(gs.getFruitPoints())
+		gs.incrementScore(points)
+
+		// Send a message to the terminal
+		fruit := fruitNames[gs.getFruitType()]
+		gs.logger().Info("Fruit collected", "fruit", fruit,
+			"points", points, "tick", gs.getCurrTicks())
+		gs.logEvent(eventFruitEaten,
+			map[string]any{"fruit": fruit, "points": points})
 	}
 
+	// Collect a power-up, if applicable
+	gs.collectPowerUp(row, col)
+
 	// If there's no pellet, return
 	if !gs.pelletAt(row, col) {
-		return
+		return nil
 	}
 
-	// If we can clear the pellet's bit, decrease the number of pellets
-	modifyBit(&(gs.pellets[row]), col, false)
+	// Check whether this is a super pellet before it is cleared
+	superPellet := gs.superPelletAt(row, col)
+
+	// If we can clear the pellet's bits, decrease the number of pellets
+	gs.pellets.set(row, col, false)
+	gs.superPellets.set(row, col, false)
 	gs.decrementNumPellets()
 
-	// If the we are in particular rows and columns, it is a super pellet
-	superPellet := ((row == 3) || (row == 23)) && ((col == 1) || (col == 26))
+	// Count the pellet towards releasing the next ghost from the ghost house
+	gs.countGhostHouseDot()
 
 	// Make all the ghosts frightened if a super pellet is collected
 	if superPellet {
@@ -103,34 +156,42 @@ func (gs *gameState) collectPellet(row int8, col int8) {
 
 	// Update the score, depending on the pellet type
 	if superPellet {
-		gs.incrementScore(superPelletPoints)
+		gs.incrementScore(gs.powerUpPoints(gs.rules.SuperPelletPoints))
+		gs.logEvent(eventSuperPellet, map[string]any{"row": row, "col": col})
 	} else {
-		gs.incrementScore(pelletPoints)
+		gs.incrementScore(gs.powerUpPoints(gs.rules.PelletPoints))
+		gs.logEvent(eventPellet, map[string]any{"row": row, "col": col})
 	}
 
 	// Act depending on the number of pellets left over
 	numPellets := gs.getNumPellets()
 
-	// Spawn fruit, if applicable
-	if (numPellets == fruitThreshold1) && !gs.fruitExists() {
-		gs.setFruitSteps(fruitDuration)
-	} else if (numPellets == fruitThreshold2) && !gs.fruitExists() {
-		gs.setFruitSteps(fruitDuration)
+	// Spawn fruit, if applicable (counting the pellets eaten on this level,
+	// so that pellets placed or respawned since don't shift the thresholds)
+	numEaten := gs.levelEaten
+	if (numEaten == gs.rules.FruitThresholds[0] ||
+		numEaten == gs.rules.FruitThresholds[1]) &&
+		!gs.fruitExists() {
+		gs.setFruitSteps(gs.rules.FruitDuration)
+		fruitRow, fruitCol := gs.fruitLoc.getCoords()
+		gs.logEvent(eventFruitSpawned, map[string]any{"row": fruitRow,
+			"col": fruitCol, "fruit": fruitNames[gs.getFruitType()]})
 	}
 
 	// Other pellet-related events
-	if numPellets == angerThreshold1 { // Ghosts get angry (speeding up)
+	if numPellets == gs.rules.AngerThresholds[0] { // Ghosts get angry (speeding up)
 		gs.setUpdatePeriod(uint8(max(1, int(gs.getUpdatePeriod())-2)))
-		gs.setMode(chase)
-		gs.setModeSteps(modeDurations[chase])
-	} else if numPellets == angerThreshold2 { // Ghosts get angrier
+		gs.setModeWave(gs.getFinalModeWave())
+	} else if numPellets == gs.rules.AngerThresholds[1] { // Ghosts get angrier
 		gs.setUpdatePeriod(uint8(max(1, int(gs.getUpdatePeriod())-2)))
-		gs.setMode(chase)
-		gs.setModeSteps(modeDurations[chase])
+		gs.setModeWave(gs.getFinalModeWave())
 	} else if numPellets == 0 {
-		gs.levelReset()
+		gs.logEvent(eventLevelComplete,
+			map[string]any{"level": gs.getLevel(), "score": gs.getScore()})
 		gs.incrementLevel()
+		gs.levelReset()
 	}
+	return nil
 }
 
 // Determines if a wall is at a given location
@@ -140,7 +201,7 @@ func (gs *gameState) wallAt(row int8, col int8) bool {
 	}
 
 	// Returns the bit of the wall row corresponding to the column
-	return getBit(gs.walls[row], col)
+	return gs.walls.get(row, col)
 }
 
 // Determines if the ghost house is at a given location
@@ -149,14 +210,19 @@ func (gs *gameState) ghostSpawnAt(row int8, col int8) bool {
 		return false
 	}
 
-	// Returns the bit of the wall row corresponding to the column
-	return ((row >= 13) && (row <= 14)) && ((col >= 11) && (col <= 15))
+	// Returns the bit of the ghost house row corresponding to the column
+	return gs.maze.ghostHouse.get(row, col)
+}
+
+// Clamps a coordinate (e.g. of a target outside the maze) to the range of int8
+func clampCoord(coord int) int8 {
+	return int8(min(max(coord, -128), 127))
 }
 
 // Calculates the squared Euclidean distance between two points
 func (gs *gameState) distSq(row1, col1, row2, col2 int8) int {
-	dx := int(row2 - row1)
-	dy := int(col2 - col1)
+	dx := int(row2) - int(row1)
+	dy := int(col2) - int(col1)
 	return dx*dx + dy*dy
 }
 
@@ -174,8 +240,9 @@ func (gs *gameState) checkCollisions() {
 	// Loop over all the ghosts
 	for _, ghost := range gs.ghosts {
 
-		// Check each collision individually
-		if gs.pacmanLoc.collidesWith(ghost.loc) {
+		// Check each collision individually (within the collision radius in
+		// competition mode, see collision.go)
+		if gs.pacmanCollides(ghost.loc) {
 
 			// If the ghost was already eaten, skip it
 			if ghost.isEaten() {
@@ -187,6 +254,9 @@ func (gs *gameState) checkCollisions() {
 				modifyBit(&ghostRespawnFlag, ghost.color, true)
 				numGhostRespawns++
 			} else {
+				gs.logEvent(eventPacmanCaught, map[string]any{
+					"ghost": ghostNames[ghost.color],
+					"lives": gs.livesLeftIfCaught()})
 				gs.deathReset()
 				return
 			}
@@ -198,8 +268,19 @@ func (gs *gameState) checkCollisions() {
 		return
 	}
 
-	// Lock the motion mutex to synchronize with other ghost update routines
-	gs.respawnGhosts(numGhostRespawns, ghostRespawnFlag)
+	// Respawn the ghosts which were caught
+	gs.respawnGhosts(ghostRespawnFlag)
+}
+
+/*
+Helper function to get the lives that Pacman has left once it is caught (none
+in survival games, which end the first time Pacman is caught, see survival.go)
+*/
+func (gs *gameState) livesLeftIfCaught() uint8 {
+	if gs.rules.Survival || gs.getLives() == 0 {
+		return 0
+	}
+	return gs.getLives() - 1
 }
 
 /***************************** Event-Based Resets *****************************/
@@ -207,33 +288,46 @@ func (gs *gameState) checkCollisions() {
 // Reset the board (while leaving pellets alone) after Pacman dies
 func (gs *gameState) deathReset() {
 
-	// Acquire the Pacman control lock, to prevent other Pacman movement
-	gs.muPacman.Lock()
-	defer gs.muPacman.Unlock()
-
 	// Set the game to be paused at the next update
 	gs.setPauseOnUpdate(true)
 
 	// Set Pacman to be in an empty state
-	gs.pacmanLoc.copyFrom(emptyLoc)
+	gs.pacmanLoc.copyFrom(gs.maze.emptyLoc)
+
+	// Decrease the number of lives Pacman has left (survival games end the
+	// first time Pacman is caught, see survival.go)
+	if gs.rules.Survival {
+		gs.setLives(0)
+	} else {
+		gs.decrementLives()
+	}
 
-	// Decrease the number of lives Pacman has left
-	gs.decrementLives()
+	// If Pacman is out of lives, the game is over
+	if gs.isGameOver() {
+		gs.setLifecycle(lifecycleGameOver)
+		gs.logGameOver()
+		gs.logger().Info("Game over", "score", gs.getScore(), "tick", gs.getCurrTicks())
+	}
 
 	/*
-		If the mode is not the initial mode and the ghosts aren't angry,
-		change the mode back to the initial mode
+		If the ghosts aren't angry, restart the mode schedule from the
+		initial mode
 	*/
-	if gs.getNumPellets() > angerThreshold1 {
-		gs.setMode(initMode)
-		gs.setModeSteps(modeDurations[initMode])
+	if gs.getNumPellets() > gs.rules.AngerThresholds[0] {
+		gs.setModeWave(0)
 	}
 
-	// Set the fruit steps back to 0
+	// Set the fruit steps back to 0, end the power-ups' effects, and make
+	// the teleporters ready
 	gs.setFruitSteps(0)
+	gs.endPowerUps()
+	gs.resetTeleporters()
 
 	// Reset all the ghosts to their original locations
 	gs.resetAllGhosts()
+
+	// Release the ghosts based on pellets eaten since this death
+	gs.resetGhostHouseDots(true)
 }
 
 // Reset the board (including pellets) after Pacman clears a level
@@ -243,82 +337,104 @@ func (gs *gameState) levelReset() {
 	gs.setPauseOnUpdate(true)
 
 	// Set Pacman to be in an empty state
-	gs.pacmanLoc.copyFrom(emptyLoc)
+	gs.pacmanLoc.copyFrom(gs.maze.emptyLoc)
 
-	// If the mode is not the initial mode, change it
-	gs.setMode(initMode)
-	gs.setModeSteps(modeDurations[initMode])
+	// Restart the mode schedule from the initial mode
+	gs.setModeWave(0)
 
 	// Reset the level penalty
-	gs.setLevelSteps(levelDuration)
+	gs.setLevelSteps(gs.rules.LevelDuration)
 
-	// Set the fruit steps back to 0
+	// Set the fruit steps back to 0, end the power-ups' effects, and make
+	// the teleporters ready
 	gs.setFruitSteps(0)
+	gs.endPowerUps()
+	gs.resetTeleporters()
 
 	// Reset all the ghosts to their original locations
 	gs.resetAllGhosts()
 
-	// Reset the pellet bit array and count
+	// Release the ghosts based on their own pellet counters again
+	gs.resetGhostHouseDots(false)
+
+	// Reset the pellet bit array and count, and put back the power-ups
 	gs.resetPellets()
+	gs.resetPowerUps()
 }
 
 /************************** Motion (Pacman Location) **************************/
 
-// Move Pacman one space in a given direction
-func (gs *gameState) movePacmanDir(dir uint8) {
-
-	// Acquire the Pacman control lock, to prevent other Pacman movement
-	gs.muPacman.Lock()
-	defer func() {
+/*
+Move Pacman one space in a given direction - returns an error if the game is
+paused, or the move runs into a wall
+*/
+func (gs *gameState) movePacmanDir(dir uint8) error {
+	return gs.movePacman(dir, true)
+}
 
-		// Unlock when we return
-		gs.muPacman.Unlock()
+/*
+Move Pacman one space in a given direction, optionally limited by its speed
+(tracked moves, which follow the physical robot, aren't limited)
+*/
+func (gs *gameState) movePacman(dir uint8, limited bool) error {
 
-		// Check collisions with all the ghosts
-		gs.checkCollisions()
-	}()
+	// Check collisions with all the ghosts when we return
+	defer gs.checkCollisions()
 
 	// Ignore the command if the game is paused
 	if gs.isPaused() || gs.getPauseOnUpdate() {
-		return
+		return ErrGameNotRunning
 	}
 
 	// Shorthand to make computation simpler
 	pLoc := gs.pacmanLoc
 
 	// Calculate the next row and column
-	nextRow, nextCol := pLoc.getNeighborCoords(dir)
+	nextRow, nextCol := pLoc.getNeighborCoords(gs.maze, dir)
 
 	// Update Pacman's direction
 	pLoc.updateDir(dir)
 
 	// Check if there is a wall at the anticipated location, and return if so
 	if gs.wallAt(nextRow, nextCol) {
-		return
+		return gs.cellError(nextRow, nextCol)
+	}
+
+	// Ignore the move if Pacman has no moves left on this step (speeds.go)
+	if limited && !gs.takePacmanMove() {
+		return nil
 	}
 
 	// Move Pacman the anticipated spot
 	pLoc.updateCoords(nextRow, nextCol)
-	gs.collectPellet(nextRow, nextCol)
+
+	// Teleport Pacman on command moves, if applicable (see teleporters.go)
+	if limited && gs.teleport(pLoc, "pacman") {
+		nextRow, nextCol = pLoc.getCoords()
+	}
+	return gs.collectPellet(nextRow, nextCol)
 }
 
-// Move pacman to destination along shortest path (CV update)
-func (gs *gameState) movePacmanAbsolute(newRow, newCol int8) {
+/*
+Move pacman to destination along shortest path (CV update) - returns an error
+if the game is paused, or the destination is a wall or off the maze
+*/
+func (gs *gameState) movePacmanAbsolute(newRow, newCol int8) error {
 	// Don't update position if we're paused
 	if gs.isPaused() || gs.getPauseOnUpdate() {
-		return
+		return ErrGameNotRunning
 	}
 
 	// Reject invalid coords
 	if gs.wallAt(newRow, newCol) {
-		return
+		return gs.cellError(newRow, newCol)
 	}
 
 	pLoc := gs.pacmanLoc
 
 	// Reject same coords
 	if pLoc.row == newRow && pLoc.col == newCol {
-		return
+		return nil
 	}
 
 	// Find likely path
@@ -326,70 +442,58 @@ func (gs *gameState) movePacmanAbsolute(newRow, newCol int8) {
 
 	// This really shouldn't happen but somehow the pathfinding has failed
 	if path == nil {
-		log.Println("\033[31mERR: Failed to find correct path\033[0m")
-		return
+		return fmt.Errorf("failed to find a path to (%d, %d)", newRow, newCol)
 	}
 
 	// The new position is far from the old one, let's not traverse the path
 	if len(path) > 11 {
-		log.Println("\033[35mWARN: Interpolated path too long! " +
-			"Tracking performance is likely degraded\033[0m")
+		gs.logger().Warn("Interpolated path too long! Tracking performance is "+
+			"likely degraded", "length", len(path))
 
-		// Acquire the Pacman control lock, to prevent other Pacman movement
-		gs.muPacman.Lock()
-		defer func() {
-			// Unlock when we return
-			gs.muPacman.Unlock()
-
-			// Check collisions with all the ghosts
-			gs.checkCollisions()
-		}()
+		// Check collisions with all the ghosts when we return
+		defer gs.checkCollisions()
 
 		// Move Pacman directly to the given position
 		pLoc.updateCoords(newRow, newCol)
-		gs.collectPellet(newRow, newCol)
-
-		return
+		return gs.collectPellet(newRow, newCol)
 	}
 
 	prevPos := pos{gs.pacmanLoc.row, gs.pacmanLoc.col}
 	// Move Pacman along the detected route
 	for i := range path {
 		nextPos := path[i]
-		if nextPos.r < prevPos.r {
-			gs.movePacmanDir(up)
-			gs.checkCollisions()
-			gs.collectPellet(gs.pacmanLoc.getCoords())
-		} else if nextPos.c < prevPos.c {
-			gs.movePacmanDir(left)
-			gs.checkCollisions()
-			gs.collectPellet(gs.pacmanLoc.getCoords())
-		} else if nextPos.r > prevPos.r {
-			gs.movePacmanDir(down)
-			gs.checkCollisions()
-			gs.collectPellet(gs.pacmanLoc.getCoords())
-		} else {
-			gs.movePacmanDir(right)
-			gs.checkCollisions()
-			gs.collectPellet(gs.pacmanLoc.getCoords())
-		}
+		gs.movePacman(prevPos.dirTo(gs.maze, nextPos), false)
+		gs.checkCollisions()
+		gs.collectPellet(gs.pacmanLoc.getCoords())
 		prevPos = nextPos
 	}
+	return nil
 }
 
 type pos struct{ r, c int8 }
 
-func (p pos) getAdjacent() [4]pos {
-	return [...]pos{
-		{p.r + 1, p.c},
-		{p.r, p.c + 1},
-		{p.r - 1, p.c},
-		{p.r, p.c - 1},
+// Get the neighbors of a cell (wrapping around the edges of the maze)
+func (p pos) getAdjacent(maze *mazeLayout) [4]pos {
+	var adjacent [4]pos
+	for i, dir := range [...]uint8{down, right, up, left} {
+		adjacent[i].r, adjacent[i].c = maze.wrapCoords(p.r+dRow[dir],
+			p.c+dCol[dir])
+	}
+	return adjacent
+}
+
+// Get the direction from a cell to one of its neighbors
+func (p pos) dirTo(maze *mazeLayout, adj pos) uint8 {
+	for dir := uint8(0); dir < numDirs; dir++ {
+		row, col := maze.wrapCoords(p.r+dRow[dir], p.c+dCol[dir])
+		if row == adj.r && col == adj.c {
+			return dir
+		}
 	}
+	return none
 }
 
 // Find likely/shortest path to new coords
-// precondition: lock pacman pos
 func (gs *gameState) findLikelyPath(newRow, newCol int8) []pos {
 	// Begin breadth-first search
 	start := pos{gs.pacmanLoc.row, gs.pacmanLoc.col}
@@ -408,7 +512,7 @@ search_loop:
 		queue = queue[1:]
 
 		// Find adjacencies/neighbors of current cell
-		neighbors := curr.getAdjacent()
+		neighbors := curr.getAdjacent(gs.maze)
 		for i := range neighbors {
 			adj := neighbors[i]
 
@@ -451,13 +555,10 @@ search_loop:
 
 // Move Pacman back to its spawn point, if necessary
 func (gs *gameState) tryRespawnPacman() {
-	// Acquire the Pacman control lock, to prevent other Pacman movement
-	gs.muPacman.Lock()
-	defer gs.muPacman.Unlock()
 
 	// Set Pacman to be in its original state
-	if gs.pacmanLoc.isEmpty() && gs.getLives() > 0 {
-		gs.pacmanLoc.copyFrom(pacmanSpawnLoc)
+	if gs.pacmanLoc.isEmpty(gs.maze) && !gs.isGameOver() {
+		gs.pacmanLoc.copyFrom(gs.maze.pacmanSpawn)
 	}
 }
 
@@ -466,24 +567,23 @@ func (gs *gameState) tryRespawnPacman() {
 // Frighten all ghosts at once
 func (gs *gameState) frightenAllGhosts() {
 
-	// Acquire the ghost control lock, to prevent other ghost movement decisions
-	gs.muGhosts.Lock()
-	defer gs.muGhosts.Unlock()
-
 	// Reset the ghost respawn combo back to 0
 	gs.ghostCombo = 0
 
+	// The fright duration depends on the current level
+	frightSteps := gs.getFrightDuration()
+
 	// Loop over all the ghosts
 	for _, ghost := range gs.ghosts {
 
-		/*
-			To frighten a ghost, set its fright steps to a specified value
-			and trap it for one step (to force the direction to reverse)
-		*/
-		ghost.setFrightSteps(ghostFrightSteps)
-		if !ghost.isTrapped() {
-			ghost.setTrappedSteps(1)
+		// Eaten ghosts are already heading back to the ghost house
+		if ghost.isEaten() {
+			continue
 		}
+
+		// To frighten a ghost, set its fright steps and reverse its direction
+		ghost.setFrightSteps(frightSteps)
+		ghost.reverse()
 	}
 }
 
@@ -492,40 +592,23 @@ func (gs *gameState) reverseAllGhosts() {
 
 	// Loop over all the ghosts
 	for _, ghost := range gs.ghosts {
-
-		/*
-			To change the direction a ghost, trap it for one step
-			(to force the direction to reverse)
-		*/
-		if !ghost.isTrapped() {
-			ghost.setTrappedSteps(1)
-		}
+		ghost.reverse()
 	}
 }
 
 // Reset all ghosts at once
 func (gs *gameState) resetAllGhosts() {
 
-	// Acquire the ghost control lock, to prevent other ghost movement
-	gs.muGhosts.Lock()
-	defer gs.muGhosts.Unlock()
-
 	// Reset the ghost respawn combo back to 0
 	gs.ghostCombo = 0
 
-	// Add relevant ghosts to a wait group
-	gs.wgGhosts.Add(int(numColors))
-
 	// Reset each of the ghosts
 	for _, ghost := range gs.ghosts {
-		go ghost.reset()
+		ghost.reset()
 	}
 
-	// Wait for the resets to finish
-	gs.wgGhosts.Wait()
-
 	// If no lives are left, set all ghosts to stare at the player, menacingly
-	if gs.getLives() == 0 {
+	if gs.isGameOver() {
 		for _, ghost := range gs.ghosts {
 			if ghost.color != orange {
 				ghost.nextLoc.updateDir(none)
@@ -537,15 +620,7 @@ func (gs *gameState) resetAllGhosts() {
 }
 
 // Respawn some ghosts, according to a flag
-func (gs *gameState) respawnGhosts(
-	numGhostRespawns int, ghostRespawnFlag uint8) {
-
-	// Acquire the ghost control lock, to prevent other ghost movement
-	gs.muGhosts.Lock()
-	defer gs.muGhosts.Unlock()
-
-	// Add relevant ghosts to a wait group
-	gs.wgGhosts.Add(numGhostRespawns)
+func (gs *gameState) respawnGhosts(ghostRespawnFlag uint8) {
 
 	// Loop over the ghost colors again, to decide which should respawn
 	for _, ghost := range gs.ghosts {
@@ -557,53 +632,120 @@ func (gs *gameState) respawnGhosts(
 			ghost.respawn()
 
 			// Add points corresponding to the current combo length
-			gs.incrementScore(comboMultiplier << uint16(gs.ghostCombo))
+			points := gs.powerUpPoints(
+				gs.rules.ComboMultiplier << uint16(gs.ghostCombo))
+			gs.incrementScore(points)
+			gs.logEvent(eventGhostEaten, map[string]any{
+				"ghost": ghostNames[ghost.color], "points": points})
 
 			// Increment the ghost respawn combo
 			gs.ghostCombo++
 		}
 	}
-
-	// Wait for the respawns to finish
-	gs.wgGhosts.Wait()
 }
 
 // Update all ghosts at once
 func (gs *gameState) updateAllGhosts() {
 
-	// Acquire the ghost control lock, to prevent other ghost movement
-	gs.muGhosts.Lock()
-	defer gs.muGhosts.Unlock()
-
-	// Add relevant ghosts to a wait group
-	gs.wgGhosts.Add(int(numColors))
-
-	// Loop over the individual ghosts
+	// Loop over the individual ghosts, in the order of their colors
 	for _, ghost := range gs.ghosts {
-		go ghost.update()
+		ghost.update()
 	}
-
-	// Wait for the respawns to finish
-	gs.wgGhosts.Wait()
 }
 
 // A game state function to plan all ghosts at once
 func (gs *gameState) planAllGhosts() {
 
-	// Acquire the ghost control lock, to prevent other ghost movement
-	gs.muGhosts.Lock()
-	defer gs.muGhosts.Unlock()
+	// Plan each ghost's next move, in the order of their colors
+	for _, ghost := range gs.ghosts {
+		ghost.planMove()
+	}
+}
+
+/***************************** Ghost House Release ****************************/
+
+// Reset the pellet counters used to release ghosts from the ghost house
+func (gs *gameState) resetGhostHouseDots(useGlobal bool) {
+
+	// Choose between the global counter and the per-ghost counters
+	gs.globalDotCount = 0
+	gs.globalDotActive = useGlobal
 
-	// Add pending ghost plans
-	gs.wgGhosts.Add(int(numColors))
+	// Restart the timer for releasing ghosts when no pellets are eaten
+	gs.lastPelletTick = gs.getCurrTicks()
+}
+
+// Return the next ghost to be released from the ghost house (or nil if none)
+func (gs *gameState) getPreferredGhost() *ghostState {
 
-	// Plan each ghost's next move concurrently
+	// Ghosts leave in order of color (pink, then cyan, then orange, and so on)
 	for _, ghost := range gs.ghosts {
-		go ghost.plan()
+		if ghost.isWaiting() {
+			return ghost
+		}
+	}
+	return nil
+}
+
+// Count an eaten pellet towards releasing a ghost from the ghost house
+func (gs *gameState) countGhostHouseDot() {
+
+	// Restart the timer for releasing ghosts when no pellets are eaten
+	gs.lastPelletTick = gs.getCurrTicks()
+
+	// After a death, pellets are counted by the global counter instead
+	if gs.globalDotActive {
+		if gs.globalDotCount != 255 {
+			gs.globalDotCount++
+		}
+		return
+	}
+
+	// Otherwise, only the next ghost in line counts the pellet
+	if ghost := gs.getPreferredGhost(); ghost != nil {
+		ghost.incDotCount()
+	}
+}
+
+// Release ghosts from the ghost house, if their pellet limits are reached
+func (gs *gameState) updateGhostHouse() {
+
+	// The limits and timeout depend on the current level
+	level := gs.getLevel()
+	dotLimitTable, timeouts := gs.rules.GhostDotLimits, gs.rules.GhostReleaseTimeouts
+	dotLimits := dotLimitTable[levelTableIdx(level, len(dotLimitTable))]
+	timeout := timeouts[levelTableIdx(level, len(timeouts))]
+
+	// Release ghosts one at a time, until one has not reached its limit
+	for ghost := gs.getPreferredGhost(); ghost != nil; ghost = gs.getPreferredGhost() {
+
+		// Check the relevant counter against the ghost's limit
+		var released bool
+		if gs.globalDotActive {
+			released = gs.globalDotCount >= gs.rules.GhostGlobalDotLimits[ghost.color]
+		} else {
+			released = ghost.getDotCount() >= dotLimits[ghost.color]
+		}
+
+		// If Pacman has not eaten a pellet in a while, release the ghost anyway
+		if !released && gs.getCurrTicks()-gs.lastPelletTick >= timeout {
+			released = true
+			gs.lastPelletTick = gs.getCurrTicks()
+		}
+
+		// Stop once a ghost has to keep waiting
+		if !released {
+			break
+		}
+
+		// Release the ghost (it stays trapped for one more step to turn around)
+		ghost.setWaiting(false)
 	}
 
-	// Wait until all pending ghost plans are complete
-	gs.wgGhosts.Wait()
+	// Once every ghost is out, go back to the per-ghost counters
+	if gs.globalDotActive && gs.getPreferredGhost() == nil {
+		gs.globalDotActive = false
+	}
 }
 
 /************************ Ghost Targeting (Chase Mode) ************************/
@@ -620,57 +762,81 @@ func (gs *gameState) getChaseTargetRed() (int8, int8) {
 
 /*
 Returns the chase location of the pink ghost
-(i.e. 4 spaces ahead of Pacman's location)
+(i.e. 4 spaces ahead of Pacman's location, by default)
 */
 func (gs *gameState) getChaseTargetPink() (int8, int8) {
 
-	// Return the red pink's target (4 spaces ahead of Pacman)
-	return gs.pacmanLoc.getAheadCoords(4)
+	// Return the pink ghost's target (PinkLookahead spaces ahead of Pacman)
+	return gs.pacmanLoc.getAheadCoords(int8(gs.rules.PinkLookahead))
 }
 
 /*
 Returns the chase location of the cyan ghost
-(i.e. The red ghost's location, reflected about 2 spaces ahead of Pacman)
+(i.e. The red ghost's location, reflected about 2 spaces ahead of Pacman, by
+default)
 */
 func (gs *gameState) getChaseTargetCyan() (int8, int8) {
 
-	// Get the 'pivot' square, 2 steps ahead of Pacman
-	pivotRow, pivotCol := gs.pacmanLoc.getAheadCoords(2)
+	// Get the 'pivot' square, CyanPivotAhead steps ahead of Pacman
+	pivotRow, pivotCol := gs.pacmanLoc.getAheadCoords(
+		int8(gs.rules.CyanPivotAhead))
 
 	// Get the current location of the red ghost
-	redRow, redCol := gs.ghosts[red].loc.getCoords()
+	redRow, redCol := gs.getCyanReference()
+
+	// Return the pair of coordinates of the calculated target (clamped, since
+	// it can fall far outside of larger mazes)
+	return clampCoord(2*int(pivotRow) - int(redRow)),
+		clampCoord(2*int(pivotCol) - int(redCol))
+}
 
-	// Return the pair of coordinates of the calculated target
-	return (2*pivotRow - redRow),
-		(2*pivotCol - redCol)
+/*
+Returns the location that cyan's target is reflected from: the red ghost's, or
+if red is out of play, that of the first ghost in play copying red, or failing
+that, Pacman's own (so that the target stays near the maze)
+*/
+func (gs *gameState) getCyanReference() (int8, int8) {
+	if gs.isGhostActive(red) {
+		return gs.ghosts[red].loc.getCoords()
+	}
+	for color := range gs.ghosts {
+		if gs.rules.personas[color] == red && gs.isGhostActive(uint8(color)) {
+			return gs.ghosts[color].loc.getCoords()
+		}
+	}
+	return gs.pacmanLoc.getCoords()
 }
 
 /*
-Returns the chase location of the orange ghost
+Returns the chase location of the orange ghost (or a ghost copying it)
 (i.e. Pacman's exact location, the same as red's target most of the time)
 Though, if close enough to Pacman, it should choose its scatter target
 */
-func (gs *gameState) getChaseTargetOrange() (int8, int8) {
+func (gs *gameState) getChaseTargetOrange(color uint8) (int8, int8) {
 
 	// Get Pacman's current location
 	pacmanRow, pacmanCol := gs.pacmanLoc.getCoords()
 
 	// Get the orange ghost's current location
-	orangeRow, orangeCol := gs.ghosts[orange].loc.getCoords()
+	orangeRow, orangeCol := gs.ghosts[color].loc.getCoords()
 
 	// If Pacman is far enough from the ghost, return Pacman's location
-	if gs.distSq(orangeRow, orangeCol, pacmanRow, pacmanCol) >= 64 {
+	radius := int(gs.rules.OrangeRetreatRadius)
+	if gs.distSq(orangeRow, orangeCol, pacmanRow, pacmanCol) >= radius*radius {
 		return (pacmanRow),
 			(pacmanCol)
 	}
 
 	// Otherwise, return the scatter location of orange
-	return gs.ghosts[orange].scatterTarget.getCoords()
+	return gs.ghosts[color].scatterTarget.getCoords()
 }
 
-// Returns the chase location of an arbitrary ghost color
+/*
+Returns the chase location of an arbitrary ghost color, according to its
+persona (the original ghost whose chase behavior it copies)
+*/
 func (gs *gameState) getChaseTarget(color uint8) (int8, int8) {
-	switch color {
+	switch gs.rules.personas[color] {
 	case red:
 		return gs.getChaseTargetRed()
 	case pink:
@@ -678,7 +844,7 @@ func (gs *gameState) getChaseTarget(color uint8) (int8, int8) {
 	case cyan:
 		return gs.getChaseTargetCyan()
 	case orange:
-		return gs.getChaseTargetOrange()
+		return gs.getChaseTargetOrange(color)
 	}
-	return emptyLoc.getCoords()
+	return gs.maze.emptyLoc.getCoords()
 }
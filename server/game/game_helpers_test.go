@@ -0,0 +1,90 @@
+package game
+
+import "testing"
+
+// Set up a simulated game on the default maze, under the default rules
+func newTestGame(t *testing.T) *gameState {
+	t.Helper()
+	maze, ok := lookupMaze(defaultMazeName)
+	if !ok {
+		t.Fatalf("no maze %s", defaultMazeName)
+	}
+	gs := newGameStateWith(newGameRules(RoomSettings{GameFPS: 24}), maze, 1)
+	gs.simulated = true
+	return gs
+}
+
+/*
+The fruit appears after a set number of pellets have been eaten on a level,
+however many pellets were placed on empty cells (for practice drills) first
+*/
+func TestCollectPelletFruitThresholds(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		placed   int  // Super pellets placed on empty cells
+		onPellet bool // Whether one more is placed over a regular pellet
+	}{
+		{"none placed", 0, false},
+		{"one placed", 1, false},
+		{"several placed", 5, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gs := newTestGame(t)
+			pacRow, pacCol := gs.pacmanLoc.getCoords()
+
+			// Place the super pellets, on empty cells away from Pacman
+			placed := 0
+			for row := int8(0); row < gs.maze.rows && placed < tc.placed; row++ {
+				for col := int8(0); col < gs.maze.cols && placed < tc.placed; col++ {
+					if gs.wallAt(row, col) || gs.pelletAt(row, col) ||
+						(row == pacRow && col == pacCol) {
+						continue
+					}
+					if err := gs.placeSuperPellet(row, col); err != nil {
+						t.Fatal(err)
+					}
+					placed++
+				}
+			}
+			if placed != tc.placed {
+				t.Fatalf("only found %d empty cells", placed)
+			}
+			if tc.onPellet {
+				for row := int8(0); row < gs.maze.rows; row++ {
+					col := int8(1)
+					if gs.pelletAt(row, col) && !gs.superPelletAt(row, col) {
+						gs.placeSuperPellet(row, col)
+						break
+					}
+				}
+			}
+
+			// Eat the maze's pellets one by one, and check that the fruit
+			// appears exactly at each threshold
+			thresholds := gs.rules.FruitThresholds
+			eaten := uint16(0)
+			for row := int8(0); row < gs.maze.rows; row++ {
+				for col := int8(0); col < gs.maze.cols; col++ {
+					if eaten == thresholds[1] {
+						return
+					}
+					if !gs.pelletAt(row, col) {
+						continue
+					}
+					if err := gs.collectPellet(row, col); err != nil {
+						t.Fatal(err)
+					}
+					eaten++
+					atThreshold := eaten == thresholds[0] ||
+						eaten == thresholds[1]
+					if gs.fruitExists() != atThreshold {
+						t.Fatalf("after %d pellets eaten: fruit %t, want %t",
+							eaten, gs.fruitExists(), atThreshold)
+					}
+					gs.setFruitSteps(0)
+				}
+			}
+			t.Fatalf("only %d pellets to eat", eaten)
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package game
+
+import (
+	"sync"
+)
+
+// Enum-like declaration to hold the game lifecycle states
+const (
+	lifecycleLobby     uint8 = 0 // Set up, waiting for the game to start
+	lifecycleCountdown uint8 = 1 // Counting down to the start of the game
+	lifecycleRunning   uint8 = 2 // Game in progress
+	lifecyclePaused    uint8 = 3 // Game in progress, but paused
+	lifecycleGameOver  uint8 = 4 // Game finished (needs a restart to play again)
+	numLifecycles      uint8 = 5
+)
+
+// Names of the lifecycle states (for logging)
+var lifecycleNames [numLifecycles]string = [...]string{
+	"lobby",
+	"countdown",
+	"running",
+	"paused",
+	"game over",
+}
+
+// The number of seconds to count down before the game starts (0 to skip)
+var countdownSeconds uint8 = 0
+
+// Mutex accompanying the above variable
+var muCS sync.RWMutex
+
+// Configure the number of seconds to count down before the game starts
+func ConfigCountdownSeconds(_countdownSeconds uint8) {
+	muCS.Lock()
+	{
+		countdownSeconds = _countdownSeconds
+	}
+	muCS.Unlock()
+}
+
+/****************************** Lifecycle State *******************************/
+
+// Helper function to get the lifecycle state of the game
+func (gs *gameState) getLifecycle() uint8 {
+
+	// Return the lifecycle state
+	return gs.lifecycle
+}
+
+// Helper function to set the lifecycle state of the game
+func (gs *gameState) setLifecycle(lifecycle uint8) {
+
+	// Read the current lifecycle state
+	currLifecycle := gs.getLifecycle()
+
+	// If the lifecycle state changes, log the change
+	if currLifecycle != lifecycle {
+		gs.logger().Info("Lifecycle changed", "from", lifecycleNames[currLifecycle],
+			"to", lifecycleNames[lifecycle], "tick", gs.getCurrTicks())
+	}
+
+	gs.lifecycle = lifecycle // Update the lifecycle state
+}
+
+/********************************* Countdown **********************************/
+
+// Helper function to get the number of ticks left in the countdown
+func (gs *gameState) getCountdownLeft() uint16 {
+
+	// Return the number of ticks left
+	return gs.countdownLeft
+}
+
+// Helper function to start counting down to the start of the game
+func (gs *gameState) startCountdown() {
+
+	gs.countdownLeft = gs.rules.countdownTicks() // Set the countdown length
+
+	// Update the lifecycle state, and announce the first second
+	gs.setLifecycle(lifecycleCountdown)
+	gs.announceCountdown()
+}
+
+/*
+Helper function to announce the seconds left in the countdown (0 for the
+start of the game), as a countdown event - clients hear about it ahead of the
+next state, so that they start at the same time as the engine
+*/
+func (gs *gameState) announceCountdown() {
+	count := gs.getCountdownLeft() / uint16(max(gs.rules.getFPS(), 1))
+	gs.logEvent(eventCountdown, map[string]any{"count": count})
+	if count == 0 {
+		gs.logger().Info("Go!", "tick", gs.getCurrTicks())
+	}
+}
+
+// Helper function to advance the countdown (once per tick, even if paused)
+func (gs *gameState) updateCountdown() {
+
+	// If we aren't counting down, there's nothing to do
+	if gs.getLifecycle() != lifecycleCountdown {
+		return
+	}
+
+	// Keep track of whether the countdown just finished, or reached a
+	// whole number of seconds
+	var done, second bool
+	fps := uint16(max(gs.rules.getFPS(), 1))
+
+	if gs.countdownLeft != 0 {
+		gs.countdownLeft-- // Decrease the ticks left
+	}
+	done = (gs.countdownLeft == 0)
+	second = (gs.countdownLeft%fps == 0)
+
+	// Announce each second (3, 2, 1, go), right before it takes effect
+	if second {
+		gs.announceCountdown()
+	}
+
+	// Start the game once the countdown finishes
+	if done {
+		gs.resume()
+	}
+}
@@ -1,6 +1,6 @@
 package game
 
-import "log"
+import "fmt"
 
 // Enum-like declaration to hold the game mode options
 const (
@@ -22,10 +22,6 @@ var modeNames [numModes]string = [...]string{
 // Helper function to get the game mode
 func (gs *gameState) getMode() uint8 {
 
-	// (Read) lock the game mode
-	gs.muMode.RLock()
-	defer gs.muMode.RUnlock()
-
 	// Return the current game mode
 	return gs.mode
 }
@@ -36,18 +32,14 @@ func (gs *gameState) setMode(mode uint8) {
 	// Read the current game mode
 	currMode := gs.getMode()
 
-	// If the game is not paused and won't be paused, log the change
+	// If the game is not paused and won't be paused, log the change (the
+	// observers log it to the terminal, see observer.go)
 	if currMode != paused && mode != paused && currMode != mode {
-		log.Printf("\033[36mGAME: Mode changed (%s -> %s) (t = %d)\033[0m\n",
-			modeNames[currMode], modeNames[mode], gs.getCurrTicks())
+		gs.logEvent(eventModeChange, map[string]any{
+			"from": modeNames[currMode], "to": modeNames[mode]})
 	}
 
-	// (Write) lock the game mode
-	gs.muMode.Lock()
-	{
-		gs.mode = mode // Update the game mode
-	}
-	gs.muMode.Unlock()
+	gs.mode = mode // Update the game mode
 }
 
 /***************************** Last Unpaused Mode *****************************/
@@ -55,10 +47,6 @@ func (gs *gameState) setMode(mode uint8) {
 // Helper function to get the last unpaused mode
 func (gs *gameState) getLastUnpausedMode() uint8 {
 
-	// (Read) lock the game mode
-	gs.muMode.RLock()
-	defer gs.muMode.RUnlock()
-
 	// If the current mode is not paused, return it
 	if gs.mode != paused {
 		return gs.mode
@@ -76,17 +64,11 @@ func (gs *gameState) setLastUnpausedMode(mode uint8) {
 
 	// If the game is paused and the last unpaused mode changes, log the change
 	if gs.getMode() == paused && unpausedMode != mode {
-		log.Printf("\036[32mGAME: Mode changed while paused (%s -> %s) "+
-			"(t = %d)\033[0m\n",
-			modeNames[unpausedMode], modeNames[mode], gs.getCurrTicks())
+		gs.logger().Info("Mode changed while paused", "from", modeNames[unpausedMode],
+			"to", modeNames[mode], "tick", gs.getCurrTicks())
 	}
 
-	// (Write) lock the game mode
-	gs.muMode.Lock()
-	{
-		gs.lastUnpausedMode = mode // Update the game mode
-	}
-	gs.muMode.Unlock()
+	gs.lastUnpausedMode = mode // Update the game mode
 }
 
 /******************************** Pause / Play ********************************/
@@ -110,25 +92,64 @@ func (gs *gameState) pause() {
 	// Set the mode to paused
 	gs.setMode(paused)
 
+	// A game in progress is now paused (a countdown is cancelled instead)
+	switch gs.getLifecycle() {
+	case lifecycleRunning:
+		gs.setLifecycle(lifecyclePaused)
+	case lifecycleCountdown:
+		gs.setLifecycle(lifecycleLobby)
+	}
+
 	// Log message to alert the user
-	log.Printf("\033[32m\033[2mGAME: Paused  (t = %d)\033[0m\n",
-		gs.getCurrTicks())
+	gs.logger().Info("Paused", "tick", gs.getCurrTicks())
 }
 
 // Helper function to play the game
 func (gs *gameState) play() {
 
 	// If the game engine is already playing or can't play, return
-	if !gs.isPaused() || gs.getLives() == 0 || gs.getCurrTicks() == 0xffff {
+	if !gs.isPaused() || gs.isGameOver() || gs.getCurrTicks() == 0xffff {
+		return
+	}
+
+	// If the game is already counting down, let the countdown finish
+	lifecycle := gs.getLifecycle()
+	if lifecycle == lifecycleCountdown {
+		return
+	}
+
+	// If the game hasn't started yet, count down first (if configured)
+	if lifecycle == lifecycleLobby && gs.rules.countdownTicks() > 0 {
+		gs.startCountdown()
+		return
+	}
+
+	// Otherwise, resume the game
+	gs.resume()
+}
+
+// Helper function to resume the game (after any countdown)
+func (gs *gameState) resume() {
+
+	// If the game engine is already playing or can't play, return
+	if !gs.isPaused() || gs.isGameOver() || gs.getCurrTicks() == 0xffff {
 		return
 	}
 
-	// Otherwise, set the current mode to the last unpaused mode
+	// If the game is starting (rather than resuming), log its setup
+	if gs.getLifecycle() != lifecyclePaused {
+		gs.logEvent(eventGameStart,
+			map[string]any{"seed": gs.seed, "maze": gs.maze.name})
+	}
+
+	// Set the current mode to the last unpaused mode
 	gs.setMode(gs.getLastUnpausedMode())
 
+	// The game is now in progress
+	gs.setLifecycle(lifecycleRunning)
+
 	// Log message to alert the user
-	log.Printf("\033[32mGAME: Resumed (t = %d)\033[0m\n",
-		gs.getCurrTicks())
+	gs.logger().Info("Resumed", "tick", gs.getCurrTicks())
 }
 
 /*************************** Pausing on Next Update ***************************/
@@ -136,10 +157,6 @@ func (gs *gameState) play() {
 // Helper function to return whether the game should pause after next update
 func (gs *gameState) getPauseOnUpdate() bool {
 
-	// (Read) lock the game mode
-	gs.muMode.RLock()
-	defer gs.muMode.RUnlock()
-
 	// Return whether the pause on update flag
 	return gs.pauseOnUpdate
 }
@@ -147,12 +164,95 @@ func (gs *gameState) getPauseOnUpdate() bool {
 // Helper function to pause the game after the next update
 func (gs *gameState) setPauseOnUpdate(flag bool) {
 
-	// (Write) lock the game mode
-	gs.muMode.Lock()
-	{
-		gs.pauseOnUpdate = flag // Set a flag to pause at the next update
+	gs.pauseOnUpdate = flag // Set a flag to pause at the next update
+}
+
+/******************************* Engine Halting *******************************/
+
+/*
+Halting freezes the engine loop entirely (ticks, countdowns, and updates), so
+that it can be advanced one update at a time to debug the ghosts' decisions -
+unlike pausing, the game mode and lifecycle are left alone
+*/
+
+// Helper function to determine if the engine loop is halted
+func (gs *gameState) isHalted() bool {
+
+	// Return whether the engine loop is halted
+	return gs.halted
+}
+
+// Helper function to determine if the engine loop should advance this tick
+func (gs *gameState) isAdvancing() bool {
+
+	// The loop advances unless it is halted without a pending step
+	return !gs.halted || gs.stepPending
+}
+
+// Helper function to halt the engine loop
+func (gs *gameState) halt() {
+
+	// If the engine loop is already halted, there's no more to do
+	if gs.isHalted() {
+		return
+	}
+
+	gs.halted = true
+	gs.stepPending = false
+
+	// Log message to alert the user
+	gs.logger().Info("Engine halted", "tick", gs.getCurrTicks())
+}
+
+// Helper function to resume the engine loop after halting it
+func (gs *gameState) unhalt() {
+
+	// If the engine loop is not halted, there's no more to do
+	if !gs.isHalted() {
+		return
 	}
-	gs.muMode.Unlock()
+
+	gs.halted = false
+	gs.stepPending = false
+
+	// Log message to alert the user
+	gs.logger().Info("Engine unhalted", "tick", gs.getCurrTicks())
+}
+
+/*
+Helper function to advance a halted engine loop by exactly one update - the
+ticks up to the next update still pass one at a time, at the usual clock rate
+(returns an error if the engine isn't halted, or the game is paused)
+*/
+func (gs *gameState) requestStep() error {
+
+	// Single-stepping only makes sense while halted
+	if !gs.isHalted() {
+		return invalidCommand('n', "the engine must be halted first")
+	}
+
+	// Ticks don't pass while the game is paused, so the step would never end
+	if gs.isPaused() {
+		return fmt.Errorf("%w: cannot single-step", ErrGameNotRunning)
+	}
+
+	gs.stepPending = true
+	return nil
+}
+
+// Helper function to end a pending step, once its update is complete
+func (gs *gameState) finishStep() {
+
+	wasPending := gs.stepPending
+	gs.stepPending = false
+
+	// If no step was pending, there's no more to do
+	if !wasPending {
+		return
+	}
+
+	// Log message to alert the user
+	gs.logger().Info("Stepped one update", "tick", gs.getCurrTicks())
 }
 
 /********************************* Mode Steps *********************************/
@@ -160,10 +260,6 @@ func (gs *gameState) setPauseOnUpdate(flag bool) {
 // Helper function to get the number of steps until the mode changes
 func (gs *gameState) getModeSteps() uint8 {
 
-	// (Read) lock the mode steps
-	gs.muModeSteps.RLock()
-	defer gs.muModeSteps.RUnlock()
-
 	// Return the mode steps
 	return gs.modeSteps
 }
@@ -171,23 +267,97 @@ func (gs *gameState) getModeSteps() uint8 {
 // Helper function to set the number of steps until the mode changes
 func (gs *gameState) setModeSteps(steps uint8) {
 
-	// (Write) lock the mode steps
-	gs.muModeSteps.Lock()
-	{
-		gs.modeSteps = steps // Set the mode steps
-	}
-	gs.muModeSteps.Unlock()
+	gs.modeSteps = steps // Set the mode steps
 }
 
 // Helper function to decrement the number of steps until the mode changes
 func (gs *gameState) decrementModeSteps() {
 
-	// (Write) lock the mode steps
-	gs.muModeSteps.Lock()
-	{
-		if gs.modeSteps != 0 {
-			gs.modeSteps-- // Decrease the mode steps
-		}
+	if gs.modeSteps != 0 && gs.modeSteps != indefiniteModeSteps {
+		gs.modeSteps-- // Decrease the mode steps
 	}
-	gs.muModeSteps.Unlock()
+}
+
+/******************************* Mode Scheduler *******************************/
+
+// Helper function to get the index of the current phase of the mode schedule
+func (gs *gameState) getModeWave() uint8 {
+
+	// Return the mode wave
+	return gs.modeWave
+}
+
+// Helper function to get the index of the final (indefinite) schedule phase
+func (gs *gameState) getFinalModeWave() uint8 {
+
+	// The schedule ends with a chase phase, just after the listed phases
+	modeWaves := gs.rules.ModeWaves
+	return uint8(len(modeWaves[levelTableIdx(gs.getLevel(), len(modeWaves))]))
+}
+
+// Helper function to get the mode of a phase of the mode schedule
+func (gs *gameState) getWaveMode(wave uint8) uint8 {
+
+	// Phases alternate, starting with the initial mode (scatter)
+	if wave%2 == 0 {
+		return scatter
+	}
+	return chase
+}
+
+/*
+Helper function to get the duration of a phase of the mode schedule in steps,
+given the current level, update period, and tick rate of the game engine
+*/
+func (gs *gameState) getWaveDuration(wave uint8) uint8 {
+
+	// Look up the schedule corresponding to the current level
+	modeWaves := gs.rules.ModeWaves
+	waves := modeWaves[levelTableIdx(gs.getLevel(), len(modeWaves))]
+
+	// The final phase lasts indefinitely
+	if int(wave) >= len(waves) {
+		return indefiniteModeSteps
+	}
+
+	// Convert the phase duration from milliseconds to steps
+	ticks := uint64(waves[wave]) * uint64(gs.rules.getFPS()) / 1000
+	steps := ticks / uint64(gs.getUpdatePeriod())
+
+	// Phases too long to count in steps also last indefinitely
+	if steps >= uint64(indefiniteModeSteps) {
+		return indefiniteModeSteps
+	}
+
+	// Every phase should last for at least one step
+	return uint8(max(1, steps))
+}
+
+// Helper function to move to a given phase of the mode schedule
+func (gs *gameState) setModeWave(wave uint8) {
+
+	// Stop at the final (indefinite) phase of the schedule
+	wave = min(wave, gs.getFinalModeWave())
+
+	// Keep track of the mode before the change
+	prevMode := gs.getLastUnpausedMode()
+
+	// Update the mode, or the mode to resume to if the game is paused
+	mode := gs.getWaveMode(wave)
+	if gs.isPaused() {
+		gs.setLastUnpausedMode(mode)
+	} else {
+		gs.setMode(mode)
+	}
+
+	// Reverse the directions of all ghosts to indicate a mode switch
+	if mode != prevMode {
+		gs.reverseAllGhosts()
+	}
+
+	// Compute the duration of the new phase
+	steps := gs.getWaveDuration(wave)
+
+	gs.modeWave = wave   // Set the mode wave
+	gs.modeSteps = steps // Set the mode steps
 }
@@ -0,0 +1,255 @@
+package game
+
+import (
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+/*
+The rules that a game is played by: the tunable game constants (see Config),
+along with the other settings that can differ between the games hosted by the
+same server (one per room). Each game engine keeps its own rules, starting
+from the server-wide defaults (set through the Config* functions) and applying
+any overrides given for its room.
+*/
+
+/*
+Settings for a game engine, overriding the server-wide defaults for its room
+(zero values and nil pointers keep the defaults, except for the clock rate,
+which must be given)
+*/
+type RoomSettings struct {
+	Name             string   // Room name (keeps replays and snapshots apart)
+	GameFPS          int32    // Clock rate (ticks per second)
+	Maze             string   // Built-in maze profile
+	RandomSeed       *int64   // Seed for the ghosts (0 = from the clock)
+	NumActiveGhosts  *uint8   // Number of ghosts in play
+	DisabledGhosts   []string // Ghosts left out of play (ghost_selection.go)
+	CountdownSeconds *uint8   // Seconds to count down before the game
+	MatchSeconds     *uint16  // Length of the match clock (0 = none)
+	BonusLifeScores  []uint16 // Scores at which Pacman earns an extra life
+	Game             *Config  // Tunable game constants
+
+	// Scheduled changes to the maze's gates (see gates.go)
+	GateSchedule []GateChange
+}
+
+// The rules of a game engine's games
+type gameRules struct {
+	Config // Tunable game constants
+
+	room             string                    // Room name ("" by default)
+	scatterTargets   [numColors]*locationState // Ghost scatter targets
+	personas         [numColors]uint8          // Ghost chase behaviors
+	fruitTypes       []uint8                   // Fruit on each level
+	activeGhosts     uint8                     // Ghosts in play (bit per color)
+	countdownSeconds uint8                     // Countdown before the game
+	matchSeconds     uint16                    // Match clock (0 = none)
+	bonusLifeScores  []uint16                  // Scores for extra lives
+	gateSchedule     []GateChange              // Changes to the gates
+	randomSeed       int64                     // Seed (0 = from the clock)
+	fps              int32                     // Clock rate (ticks/second)
+	maze             *mazeLayout               // Maze layout for new games
+	highScore        uint16                    // Best score of the room's games
+	competition      bool                      // Whether a match is being played
+	muRules          sync.RWMutex              // Mutex for the fields above
+
+	// Observers of the room's games (see observer.go)
+	observers   []Observer
+	muObservers sync.RWMutex
+}
+
+// Create a set of rules from the server-wide defaults, with a room's overrides
+func newGameRules(settings RoomSettings) *gameRules {
+
+	// Start from the server-wide defaults
+	rules := gameRules{
+		Config:          DefaultConfig(),
+		room:            settings.Name,
+		bonusLifeScores: slices.Clone(bonusLifeScores),
+		gateSchedule:    slices.Clone(gateSchedule),
+		maze:            getCurrMaze(),
+		fps:             settings.GameFPS,
+		observers:       []Observer{logObserver{}},
+	}
+	muCS.RLock()
+	{
+		rules.countdownSeconds = countdownSeconds
+	}
+	muCS.RUnlock()
+	muMS.RLock()
+	{
+		rules.matchSeconds = matchSeconds
+	}
+	muMS.RUnlock()
+	muSeed.RLock()
+	{
+		rules.randomSeed = randomSeed
+	}
+	muSeed.RUnlock()
+
+	// Apply the room's overrides
+	if settings.Game != nil {
+		rules.Config = validateConfig(*settings.Game)
+	}
+	if settings.Maze != "" {
+		if maze, ok := lookupMaze(settings.Maze); ok {
+			rules.maze = maze
+		}
+	}
+	if settings.RandomSeed != nil {
+		rules.randomSeed = *settings.RandomSeed
+	}
+	numGhosts, disabled := numActiveGhosts, disabledGhosts
+	if settings.NumActiveGhosts != nil {
+		numGhosts = *settings.NumActiveGhosts
+	}
+	if settings.DisabledGhosts != nil {
+		disabled, _ = GhostSet(settings.DisabledGhosts)
+	}
+	rules.activeGhosts = firstGhosts(numGhosts) &^ disabled
+	if settings.CountdownSeconds != nil {
+		rules.countdownSeconds = *settings.CountdownSeconds
+	}
+	if settings.MatchSeconds != nil {
+		rules.matchSeconds = *settings.MatchSeconds
+	}
+	if settings.BonusLifeScores != nil {
+		rules.bonusLifeScores = slices.Clone(settings.BonusLifeScores)
+		slices.Sort(rules.bonusLifeScores)
+	}
+	if settings.GateSchedule != nil {
+		rules.gateSchedule = slices.Clone(settings.GateSchedule)
+	}
+
+	// Convert the scatter targets into locations, and the personas into colors
+	for color := uint8(0); color < numColors; color++ {
+		rules.scatterTargets[color] = newLocationState(
+			rules.ScatterTargets[color][0], rules.ScatterTargets[color][1], none)
+		rules.personas[color] = personaColor(rules.GhostPersonas[color])
+	}
+
+	// Convert the fruit types from their names
+	rules.fruitTypes = make([]uint8, len(rules.FruitTypes))
+	for level, fruit := range rules.FruitTypes {
+		rules.fruitTypes[level] = uint8(fruitType(fruit))
+	}
+
+	return &rules
+}
+
+// Getter method for the clock rate of the game engine
+func (rules *gameRules) getFPS() int32 {
+	rules.muRules.RLock()
+	defer rules.muRules.RUnlock()
+	return rules.fps
+}
+
+// Getter method for whether competition mode is on (see decision_budget.go)
+func (rules *gameRules) isCompetition() bool {
+	rules.muRules.RLock()
+	defer rules.muRules.RUnlock()
+	return rules.competition
+}
+
+// Setter method for whether competition mode is on
+func (rules *gameRules) setCompetition(competition bool) {
+	rules.muRules.Lock()
+	{
+		rules.competition = competition
+	}
+	rules.muRules.Unlock()
+}
+
+/*
+Setter method for the clock rate of the game engine - the engine picks up the
+new clock rate at the end of its current frame
+*/
+func (rules *gameRules) setFPS(fps int32) {
+
+	// Send a message to the terminal if the clock rate changes
+	if currFPS := rules.getFPS(); currFPS != fps {
+		slog.Info("Game clock rate changed", "room", rules.room,
+			"from", currFPS, "to", fps)
+	}
+
+	rules.muRules.Lock()
+	{
+		rules.fps = fps
+	}
+	rules.muRules.Unlock()
+}
+
+// Getter method for the maze layout of new games
+func (rules *gameRules) getMaze() *mazeLayout {
+	rules.muRules.RLock()
+	defer rules.muRules.RUnlock()
+	return rules.maze
+}
+
+/*
+Select one of the built-in maze profiles by name, for all new games (returns
+whether the profile exists)
+*/
+func (rules *gameRules) selectMaze(name string) bool {
+
+	// Look up the maze profile
+	maze, ok := lookupMaze(name)
+	if !ok {
+		return false
+	}
+
+	// Use the new maze for all new games
+	rules.muRules.Lock()
+	{
+		rules.maze = maze
+	}
+	rules.muRules.Unlock()
+	slog.Info("Selected maze profile", "room", rules.room, "name", name)
+	return true
+}
+
+/*
+Record a game's score towards the room's high score - returns the previous high
+score, and whether it was beaten
+*/
+func (rules *gameRules) recordScore(score uint16) (uint16, bool) {
+	rules.muRules.Lock()
+	defer rules.muRules.Unlock()
+	prev := rules.highScore
+	if score <= prev {
+		return prev, false
+	}
+	rules.highScore = score
+	return prev, true
+}
+
+// Get the countdown length, in ticks
+func (rules *gameRules) countdownTicks() uint16 {
+	return uint16(rules.countdownSeconds) * uint16(rules.getFPS())
+}
+
+// Get the seed for a new game
+func (rules *gameRules) newSeed() int64 {
+
+	// If no seed is configured, seed from the clock instead
+	if rules.randomSeed == 0 {
+		return time.Now().UnixNano()
+	}
+	return rules.randomSeed
+}
+
+/*
+Get the subdirectory of a directory (e.g. for replays or snapshots) that this
+room's files go in, so that rooms don't overwrite each other's files - the
+default room uses the directory itself ("" stays disabled)
+*/
+func (rules *gameRules) roomDir(dir string) string {
+	if dir == "" || rules.room == "" {
+		return dir
+	}
+	return filepath.Join(dir, filepath.Base(rules.room))
+}
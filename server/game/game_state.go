@@ -1,10 +1,8 @@
 package game
 
 import (
-	"log"
-	"math/rand"
+	"log/slog"
 	"sync"
-	"time"
 )
 
 /*
@@ -13,6 +11,20 @@ import (
 	pausing is fine though, as it doesn't increment the current tick amount)
 */
 
+/*
+A game state has a single writer: the go-routine that owns it, which for the
+live game is the game engine's loop (see game_engine.go). Nothing else touches
+it, so it has no locks - commands from clients arrive over the engine's input
+channel and are applied between ticks, and everyone else only ever sees
+immutable snapshots of it: the serialized state that is broadcast each frame,
+or a deep copy of it requested over the engine's copy channel (see
+game_copy.go). Within a tick, the ghosts are updated one after another, in the
+order of their colors, and each ghost's plan only reads the shared state (see
+planner_pool.go), so that a tick depends only on the state before it and the
+commands applied (and replays and simulations play out the same way every
+time).
+*/
+
 /*
 A game state object, to hold the internal game state and provide
 helper methods that can be accessed by the game engine
@@ -21,42 +33,46 @@ type gameState struct {
 
 	/* Message header - 4 bytes */
 
-	currTicks uint16       // Current ticks (see note above)
-	muTicks   sync.RWMutex // Associated mutex
+	currTicks uint16 // Current ticks (see note above)
 
-	updatePeriod uint8        // Ticks / update
-	muPeriod     sync.RWMutex // Associated mutex
+	updatePeriod uint8 // Ticks / update
 
-	lastUnpausedMode uint8        // Last unpaused mode (for pausing purposes)
-	mode             uint8        // Game mode
-	muMode           sync.RWMutex // Associated mutex
-	pauseOnUpdate    bool         // Should pause when an update is ready
+	lastUnpausedMode uint8 // Last unpaused mode (for pausing purposes)
+	mode             uint8 // Game mode
+	pauseOnUpdate    bool  // Should pause when an update is ready
 
 	// The number of steps (update periods) before the mode changes
-	modeSteps   uint8
-	muModeSteps sync.RWMutex // Associated mutex
+	modeSteps uint8
+	modeWave  uint8 // Index of the phase within the mode schedule
 
 	// The number of steps (update periods) before a speedup penalty starts
-	levelSteps   uint16
-	muLevelSteps sync.RWMutex // Associated mutex
+	levelSteps uint16
+
+	// Lifecycle state of the game (lobby, countdown, running, etc.)
+	lifecycle     uint8
+	countdownLeft uint16 // Ticks left in the countdown
+	matchLeft     uint16 // Ticks left on the match clock (0 = none)
+
+	// Engine loop halting (for debugging), independent of the game mode
+	halted      bool // Whether the engine loop is halted
+	stepPending bool // Whether to advance one update while halted
 
 	/* Game information - 4 bytes */
 
-	currScore uint16       // Current score
-	muScore   sync.RWMutex // Associated mutex
+	currScore  uint16 // Current score
+	bonusLives uint8  // Number of bonus life thresholds crossed
+	highScored bool   // Whether this game has beaten the high score
 
-	currLevel uint8        // Current level (by default, starts at 1)
-	muLevel   sync.RWMutex // Associated mutex
+	currLevel uint8 // Current level (by default, starts at 1)
 
-	currLives uint8        // Current lives (by default, starts at 3)
-	muLives   sync.RWMutex // Associated mutex
+	currLives uint8 // Current lives (by default, starts at 3)
 
 	/* Pacman location - 2 bytes */
 
 	pacmanLoc *locationState
 
-	// A mutex for synchronizing updates to Pacman
-	muPacman sync.Mutex
+	// The number of moves Pacman has left on this step (see speeds.go)
+	pacmanMoves uint8
 
 	/* Fruit location - 2 bytes */
 
@@ -64,86 +80,193 @@ type gameState struct {
 
 	// The number of steps (update periods) before fruit disappears
 	fruitSteps uint8
-	muFruit    sync.RWMutex // Associated mutex
 
-	/* Ghosts - 4 * 3 = 12 bytes */
+	/* Power-ups (see power_ups.go) */
 
-	ghosts []*ghostState
+	// Power-ups left on the maze, a bit per power-up
+	powerUpsLeft uint32
+
+	// The number of steps left of each power-up's effect
+	freezeSteps     uint8 // Ghost freeze
+	boostSteps      uint8 // Speed boost
+	multiplierSteps uint8 // Point multiplier
+
+	/* Teleporters (see teleporters.go) */
 
-	// A mutex for synchronizing simultaneous updates of all ghosts
-	muGhosts sync.Mutex
+	// The number of steps left of each teleporter's cooldown
+	teleportSteps [maxTeleporters]uint8
 
-	// A wait group for synchronizing updates of multiple ghosts
-	wgGhosts *sync.WaitGroup
+	/* Survival games (see survival.go) */
+
+	// The number of steps that Pacman has survived
+	survivalSteps uint32
+
+	// The number of steps until the pellets respawn (0 if they don't)
+	respawnSteps uint16
+
+	/* Ghosts - 4 * 4 = 16 bytes (plus 4 for each extra ghost) */
+
+	ghosts []*ghostState
 
 	// A variable to keep track of the current ghost combo
 	ghostCombo uint8
 
-	/* Pellet State - 31 * 4 = 124 bytes */
+	// Pellet counters for releasing ghosts from the ghost house
+	globalDotCount  uint8  // Pellets eaten since Pacman's last death
+	globalDotActive bool   // Whether the global pellet counter is in use
+	lastPelletTick  uint16 // Tick at which the last pellet was eaten
+
+	/* Pellet State - 31 * 4 = 124 bytes (serialized) */
 
-	// Pellets encoded within an array, with each uint32 acting as a bit array
-	pellets    [mazeRows]uint32
-	numPellets uint16       // Number of pellets
-	muPellets  sync.RWMutex // Associated mutex
+	// Pellets encoded within a bit grid, with a bit for each cell
+	pellets      bitGrid
+	superPellets bitGrid // Super pellets (subset of the pellets)
+	numPellets   uint16  // Number of pellets
+	pelletsEaten uint16  // Pellets eaten over the whole game
+	levelEaten   uint16  // Pellets eaten on the current level
 
 	/* Auxiliary (non-serialized) state information */
 
+	// Maze layout (read-only) that this game is played on
+	maze *mazeLayout
+
 	// Wall state
-	walls [mazeRows]uint32
+	walls bitGrid
+
+	// The seed for the ghosts' random number generators (see newGhostState)
+	seed int64
 
-	// A random number generator for making frightened ghost decisions
-	rng *rand.Rand
+	// The rules that this game is played by (read-only, see game_rules.go)
+	rules *gameRules
+
+	// The ghosts in play, a bit per color (read-only, see ghost_selection.go)
+	activeGhosts uint8
+
+	// The estimate of the robot's position from tracking (see pose_filter.go)
+	pose poseFilter
+
+	// Pacman's continuous pose (see pose.go)
+	pacmanPose poseState
+
+	// Collects the game's events for its result files (see results.go, nil
+	// if not)
+	results *resultRecorder
+
+	// How much of the game's result was collected when this copy was kept
+	// for rewinding (see rewind.go)
+	resultMark resultMark
+
+	/*
+		Whether this is a copy of the game being played forward (see
+		game_copy.go), which must not affect anything outside of itself - it
+		logs nothing, sends no events, and doesn't count towards the high score
+	*/
+	simulated bool
 }
 
-// Create a new game state with default values
-func newGameState() *gameState {
+// The seed for the ghosts' random decisions (0 to seed from the clock)
+var randomSeed int64 = 0
+
+// Mutex accompanying the above variable
+var muSeed sync.RWMutex
+
+/*
+Configure the seed for the ghosts' random decisions, so that games with the
+same inputs play out identically (0 picks a new seed for each game)
+*/
+func ConfigRandomSeed(_randomSeed int64) {
+	muSeed.Lock()
+	{
+		randomSeed = _randomSeed
+	}
+	muSeed.Unlock()
+}
+
+// Create a new game state with default values, under a given set of rules
+func newGameState(rules *gameRules) *gameState {
+
+	// Use the current maze layout, with a new seed (if not configured)
+	gs := newGameStateWith(rules, rules.getMaze(), rules.newSeed())
+
+	// Log the seed, so that the game can be reproduced
+	slog.Info("Random seed", "seed", gs.seed)
+	return gs
+}
+
+/*
+Create a new game state under a given set of rules, on a given maze layout,
+with a given seed
+*/
+func newGameStateWith(rules *gameRules, maze *mazeLayout,
+	seed int64) *gameState {
 
 	// New game state object
 	gs := gameState{
 
 		// Message header
 		currTicks:    0,
-		updatePeriod: initUpdatePeriod,
+		updatePeriod: rules.UpdatePeriod,
 		mode:         paused,
 
 		// Additional header-related info
+		lifecycle:        lifecycleLobby,
+		matchLeft:        rules.matchTicks(),
 		lastUnpausedMode: initMode,
 		pauseOnUpdate:    false,
-		modeSteps:        modeDurations[initMode],
-		levelSteps:       levelDuration,
+		modeWave:         0,
+		levelSteps:       rules.LevelDuration,
 
 		// Game info
 		currScore: 0,
 		currLevel: initLevel,
-		currLives: initLives,
+		currLives: rules.Lives,
 
 		// Fruit
 		fruitSteps: 0,
 
 		// Ghosts
-		ghosts:     make([]*ghostState, numColors),
-		wgGhosts:   &sync.WaitGroup{},
+		ghosts:     make([]*ghostState, maze.ghostSlots()),
 		ghostCombo: 0,
 
-		// RNG (random number generation) source
-		rng: rand.New(rand.NewSource(time.Now().UnixNano())),
+		// RNG (random number generation) seed
+		seed: seed,
 
 		// Pellet count at the start
-		numPellets: initPelletCount,
+		numPellets: maze.numPellets,
+
+		// Maze layout
+		maze: maze,
+
+		// Rules
+		rules:        rules,
+		activeGhosts: rules.getActiveGhosts() & firstGhosts(maze.numGhosts),
 	}
 
 	// Declare the initial locations of Pacman and the fruit
-	gs.pacmanLoc = newLocationStateCopy(pacmanSpawnLoc)
-	gs.fruitLoc = newLocationStateCopy(fruitSpawnLoc)
+	gs.pacmanLoc = newLocationStateCopy(maze.pacmanSpawn)
+	gs.fruitLoc = newLocationStateCopy(maze.fruitSpawn)
 
 	// Initialize the ghosts
-	for color := uint8(0); color < numColors; color++ {
-		gs.ghosts[color] = newGhostState(&gs, color)
+	for color := range gs.ghosts {
+		gs.ghosts[color] = newGhostState(&gs, uint8(color))
 	}
 
+	// Start the mode schedule from its first phase
+	gs.modeSteps = gs.getWaveDuration(0)
+
+	// Give Pacman its moves for the first step
+	gs.refillPacmanMoves()
+
 	// Copy over maze bit arrays
-	copy(gs.pellets[:], initPellets[:])
-	copy(gs.walls[:], initWalls[:])
+	gs.pellets = maze.pellets
+	gs.superPellets = maze.superPellets
+	gs.walls = maze.walls
+
+	// Place the power-ups
+	gs.resetPowerUps()
+
+	// Start a survival game, if applicable
+	gs.startSurvival()
 
 	// Return the new game state
 	return &gs
@@ -154,10 +277,6 @@ func newGameState() *gameState {
 // Helper function to get the current ticks
 func (gs *gameState) getCurrTicks() uint16 {
 
-	// (Read) lock the current ticks
-	gs.muTicks.RLock()
-	defer gs.muTicks.RUnlock()
-
 	// Return the current ticks
 	return gs.currTicks
 }
@@ -173,15 +292,11 @@ func (gs *gameState) nextTick() {
 		return
 	} else if currTicks == 0xfffe {
 		gs.pause()
-		log.Println("\033[31mGAME: Max tick limit reached\033[0m")
+		gs.logger().Warn("Max tick limit reached", "tick", currTicks)
 	}
 
-	// (Write) lock the current ticks
-	gs.muTicks.Lock()
-	{
-		gs.currTicks++ // Update the current ticks
-	}
-	gs.muTicks.Unlock()
+	gs.currTicks++ // Update the current ticks
+	gs.updateGates()
 }
 
 /**************************** Upd Period Functions ****************************/
@@ -189,10 +304,6 @@ func (gs *gameState) nextTick() {
 // Helper function to get the update period
 func (gs *gameState) getUpdatePeriod() uint8 {
 
-	// (Read) lock the update period
-	gs.muPeriod.RLock()
-	defer gs.muPeriod.RUnlock()
-
 	// Return the update period
 	return gs.updatePeriod
 }
@@ -201,15 +312,10 @@ func (gs *gameState) getUpdatePeriod() uint8 {
 func (gs *gameState) setUpdatePeriod(period uint8) {
 
 	// Send a message to the terminal
-	log.Printf("\033[36mGAME: Update period changed (%d -> %d) (t = %d)\033[0m\n",
-		gs.getUpdatePeriod(), period, gs.getCurrTicks())
+	gs.logger().Info("Update period changed", "from", gs.getUpdatePeriod(),
+		"to", period, "tick", gs.getCurrTicks())
 
-	// (Write) lock the update period
-	gs.muPeriod.Lock()
-	{
-		gs.updatePeriod = period // Update the update period
-	}
-	gs.muPeriod.Unlock()
+	gs.updatePeriod = period // Update the update period
 }
 
 /******************************* Mode Functions *******************************/
@@ -221,10 +327,6 @@ func (gs *gameState) setUpdatePeriod(period uint8) {
 // Helper function to get the current score of the game
 func (gs *gameState) getScore() uint16 {
 
-	// (Read) lock the current score
-	gs.muScore.RLock()
-	defer gs.muScore.RUnlock()
-
 	// Return the current score
 	return gs.currScore
 }
@@ -236,12 +338,52 @@ func (gs *gameState) incrementScore(change uint16) {
 	score := uint32(gs.currScore)
 	score = min(score+uint32(change), 65535)
 
-	// (Write) lock the current score
-	gs.muScore.Lock()
-	{
-		gs.currScore = uint16(score) // Update the current score
+	// Keep track of how many extra lives were earned by this change
+	var newBonusLives uint8 = 0
+
+	gs.currScore = uint16(score) // Update the current score
+
+	// Check whether the score crossed any bonus life thresholds (survival
+	// games have none, see survival.go)
+	for !gs.rules.Survival && int(gs.bonusLives) < len(gs.rules.bonusLifeScores) &&
+		gs.currScore >= gs.rules.bonusLifeScores[gs.bonusLives] {
+		gs.bonusLives++
+		newBonusLives++
+	}
+
+	// Award an extra life for each threshold crossed
+	for ; newBonusLives > 0; newBonusLives-- {
+		gs.incrementLives()
+	}
+
+	// Check whether the score beat the room's high score
+	gs.checkHighScore(uint16(score))
+}
+
+/*
+Helper function to record the score towards the room's high score, logging an
+event the first time that a game beats it (unless there was no high score yet)
+*/
+func (gs *gameState) checkHighScore(score uint16) {
+
+	// Simulated games don't count towards the high score
+	if gs.simulated {
+		return
+	}
+
+	// Record the score, and check whether the high score was beaten
+	prev, beaten := gs.rules.recordScore(score)
+	if !beaten || prev == 0 {
+		return
+	}
+
+	// Only log the first time that this game beats the high score
+	first := !gs.highScored
+	gs.highScored = true
+	if first {
+		gs.logEvent(eventHighScore,
+			map[string]any{"score": score, "previous": prev})
 	}
-	gs.muScore.Unlock()
 }
 
 /**************************** Game Level Functions ****************************/
@@ -249,10 +391,6 @@ func (gs *gameState) incrementScore(change uint16) {
 // Helper function to get the current level of the game
 func (gs *gameState) getLevel() uint8 {
 
-	// (Read) lock the current level
-	gs.muLevel.RLock()
-	defer gs.muLevel.RUnlock()
-
 	// Return the current level
 	return gs.currLevel
 }
@@ -261,19 +399,13 @@ func (gs *gameState) getLevel() uint8 {
 func (gs *gameState) setLevel(level uint8) {
 
 	// Send a message to the terminal
-	log.Printf("\033[32mGAME: Level changed (%d -> %d) (t = %d)\033[0m\n",
-		gs.getLevel(), level, gs.getCurrTicks())
+	gs.logger().Info("Level changed", "from", gs.getLevel(), "to", level,
+		"tick", gs.getCurrTicks())
 
-	// (Write) lock the current level
-	gs.muLevel.Lock()
-	{
-		gs.currLevel = level // Update the level
+	gs.currLevel = level // Update the level
 
-		// Adjust the initial update period accordingly
-		suggestedPeriod := int(initUpdatePeriod) - 2*(int(level)-1)
-		gs.setUpdatePeriod(uint8(max(1, suggestedPeriod)))
-	}
-	gs.muLevel.Unlock()
+	// Adjust the initial update period accordingly
+	gs.setUpdatePeriod(gs.levelUpdatePeriod(level))
 }
 
 // Helper function to increment the game level
@@ -288,19 +420,36 @@ func (gs *gameState) incrementLevel() {
 	}
 
 	// Send a message to the terminal
-	log.Printf("\033[32mGAME: Next level (%d -> %d) (t = %d)\033[0m\n",
-		level, level+1, gs.getCurrTicks())
+	gs.logger().Info("Next level", "from", level, "to", level+1,
+		"tick", gs.getCurrTicks())
 
-	// (Write) lock the current level
-	gs.muLevel.Lock()
-	{
-		gs.currLevel++ // Update the level
+	gs.currLevel++ // Update the level
 
-		// Adjust the initial update period accordingly
-		suggestedPeriod := int(initUpdatePeriod) - 2*int(level)
-		gs.setUpdatePeriod(uint8(max(1, suggestedPeriod)))
-	}
-	gs.muLevel.Unlock()
+	// Adjust the initial update period accordingly
+	gs.setUpdatePeriod(gs.levelUpdatePeriod(level + 1))
+}
+
+// Helper function to get the index of a level within a per-level table
+func levelTableIdx(level uint8, tableLen int) int {
+
+	// Levels beyond the table use its last entry
+	return max(0, min(int(level), tableLen)-1)
+}
+
+// Helper function to get the initial update period of a given level
+func (gs *gameState) levelUpdatePeriod(level uint8) uint8 {
+
+	// Each level speeds the game up by 2 ticks per update, down to 1
+	suggestedPeriod := int(gs.rules.UpdatePeriod) - 2*(int(level)-1)
+	return uint8(max(1, suggestedPeriod))
+}
+
+// Helper function to get the number of steps ghosts stay frightened for
+func (gs *gameState) getFrightDuration() uint8 {
+
+	// Look up the fright duration corresponding to the current level
+	frightSteps := gs.rules.FrightSteps
+	return frightSteps[levelTableIdx(gs.getLevel(), len(frightSteps))]
 }
 
 /**************************** Game Lives Functions ****************************/
@@ -308,10 +457,6 @@ func (gs *gameState) incrementLevel() {
 // Helper function to get the lives left
 func (gs *gameState) getLives() uint8 {
 
-	// (Read) lock the current lives
-	gs.muLives.RLock()
-	defer gs.muLives.RUnlock()
-
 	// Return the current lives
 	return gs.currLives
 }
@@ -320,15 +465,27 @@ func (gs *gameState) getLives() uint8 {
 func (gs *gameState) setLives(lives uint8) {
 
 	// Send a message to the terminal
-	log.Printf("\033[36mGAME: Lives changed (%d -> %d)\033[0m\n",
-		gs.getLives(), lives)
+	gs.logger().Info("Lives changed", "from", gs.getLives(), "to", lives)
 
-	// (Write) lock the current lives
-	gs.muLives.Lock()
-	{
-		gs.currLives = lives // Update the lives
+	gs.currLives = lives // Update the lives
+}
+
+// Helper function to increment the lives left (as a bonus)
+func (gs *gameState) incrementLives() {
+
+	// Keep track of how many lives Pacman has left
+	lives := gs.getLives()
+
+	// If the lives are at the maximum, don't increment them anymore
+	if lives == 255 {
+		return
 	}
-	gs.muLives.Unlock()
+
+	// Send a message to the terminal
+	gs.logger().Info("Pacman earned an extra life", "from", lives, "to", lives+1,
+		"score", gs.getScore(), "tick", gs.getCurrTicks())
+
+	gs.currLives++ // Update the lives
 }
 
 // Helper function to decrement the lives left
@@ -343,15 +500,18 @@ func (gs *gameState) decrementLives() {
 	}
 
 	// Send a message to the terminal
-	log.Printf("\033[31mGAME: Pacman lost a life (%d -> %d) (t = %d)\033[0m\n",
-		lives, lives-1, gs.getCurrTicks())
+	gs.logger().Info("Pacman lost a life", "from", lives, "to", lives-1,
+		"tick", gs.getCurrTicks())
 
-	// (Write) lock the current lives
-	gs.muLives.Lock()
-	{
-		gs.currLives-- // Update the lives
-	}
-	gs.muLives.Unlock()
+	gs.currLives-- // Update the lives
+}
+
+/*
+Helper function to determine whether the game is over (no lives left, or ended
+by a referee)
+*/
+func (gs *gameState) isGameOver() bool {
+	return gs.getLives() == 0 || gs.getLifecycle() == lifecycleGameOver
 }
 
 /****************************** Pellet Functions ******************************/
@@ -359,40 +519,37 @@ func (gs *gameState) decrementLives() {
 // Helper function to get the number of pellets
 func (gs *gameState) getNumPellets() uint16 {
 
-	// (Read) lock the number of pellets
-	gs.muPellets.RLock()
-	defer gs.muPellets.RUnlock()
-
 	// Return the number of pellets
 	return gs.numPellets
 }
 
-// Helper function to decrement the number of pellets
+// Helper function to get the number of pellets eaten over the whole game
+func (gs *gameState) getPelletsEaten() uint16 {
+
+	// Return the number of pellets eaten
+	return gs.pelletsEaten
+}
+
+// Helper function to decrement the number of pellets (as one is eaten)
 func (gs *gameState) decrementNumPellets() {
 
-	// (Write) lock the number of pellets
-	gs.muPellets.Lock()
-	{
-		if gs.numPellets != 0 {
-			gs.numPellets--
-		}
+	if gs.numPellets != 0 {
+		gs.numPellets--
 	}
-	gs.muPellets.Unlock()
+	gs.pelletsEaten++
+	gs.levelEaten++
 }
 
 // Reset all the pellets on the board
 func (gs *gameState) resetPellets() {
 
-	// (Write) lock the pellets array and number of pellets
-	gs.muPellets.Lock()
-	{
-		// Copy over pellet bit array
-		copy(gs.pellets[:], initPellets[:])
+	// Copy over pellet bit arrays
+	gs.pellets = gs.maze.pellets
+	gs.superPellets = gs.maze.superPellets
 
-		// Set the number of pellets to be the default
-		gs.numPellets = initPelletCount
-	}
-	gs.muPellets.Unlock()
+	// Set the number of pellets to be the default
+	gs.numPellets = gs.maze.numPellets
+	gs.levelEaten = 0
 }
 
 /************************** Fruit Spawning Functions **************************/
@@ -400,10 +557,6 @@ func (gs *gameState) resetPellets() {
 // Helper function to get the number of steps until the fruit disappears
 func (gs *gameState) getFruitSteps() uint8 {
 
-	// (Read) lock the fruit steps
-	gs.muFruit.RLock()
-	defer gs.muFruit.RUnlock()
-
 	// Return the fruit steps
 	return gs.fruitSteps
 }
@@ -418,25 +571,23 @@ func (gs *gameState) fruitExists() bool {
 // Helper function to set the number of steps until the fruit disappears
 func (gs *gameState) setFruitSteps(steps uint8) {
 
-	// (Write) lock the fruit steps
-	gs.muFruit.Lock()
-	{
-		gs.fruitSteps = steps // Set the fruit steps
-	}
-	gs.muFruit.Unlock()
+	gs.fruitSteps = steps // Set the fruit steps
 }
 
 // Helper function to decrement the number of fruit steps
 func (gs *gameState) decrementFruitSteps() {
 
-	// (Write) lock the fruit steps
-	gs.muFruit.Lock()
-	{
-		if gs.fruitSteps != 0 {
-			gs.fruitSteps-- // Decrease the fruit steps
-		}
+	if gs.fruitSteps != 0 {
+		gs.fruitSteps-- // Decrease the fruit steps
 	}
-	gs.muFruit.Unlock()
+}
+
+// Helper function to get the points earned for a fruit on the current level
+func (gs *gameState) getFruitPoints() uint16 {
+
+	// Look up the fruit points corresponding to the current level
+	fruitPoints := gs.rules.FruitPoints
+	return fruitPoints[levelTableIdx(gs.getLevel(), len(fruitPoints))]
 }
 
 /***************************** Level Steps Passed *****************************/
@@ -444,10 +595,6 @@ func (gs *gameState) decrementFruitSteps() {
 // Helper function to get the number of steps until the level speeds up
 func (gs *gameState) getLevelSteps() uint16 {
 
-	// (Read) lock the level steps
-	gs.muLevelSteps.RLock()
-	defer gs.muLevelSteps.RUnlock()
-
 	// Return the level steps
 	return gs.levelSteps
 }
@@ -455,25 +602,15 @@ func (gs *gameState) getLevelSteps() uint16 {
 // Helper function to set the number of steps until the level speeds up
 func (gs *gameState) setLevelSteps(steps uint16) {
 
-	// (Write) lock the level steps
-	gs.muLevelSteps.Lock()
-	{
-		gs.levelSteps = steps // Set the level steps
-	}
-	gs.muLevelSteps.Unlock()
+	gs.levelSteps = steps // Set the level steps
 }
 
 // Helper function to decrement the number of steps until the mode changes
 func (gs *gameState) decrementLevelSteps() {
 
-	// (Write) lock the level steps
-	gs.muLevelSteps.Lock()
-	{
-		if gs.levelSteps != 0 {
-			gs.levelSteps-- // Decrease the level steps
-		}
+	if gs.levelSteps != 0 {
+		gs.levelSteps-- // Decrease the level steps
 	}
-	gs.muLevelSteps.Unlock()
 }
 
 /***************************** Step-Related Events ****************************/
@@ -487,55 +624,47 @@ func (gs *gameState) handleStepEvents() {
 	// Get the current level steps
 	levelSteps := gs.getLevelSteps()
 
-	// If the mode steps are 0, change the mode
+	// If the mode steps are 0, move on to the next phase of the schedule
 	if modeSteps == 0 {
-		switch gs.getMode() {
-		// chase -> scatter
-		case chase:
-			gs.setMode(scatter)
-			gs.setModeSteps(modeDurations[scatter])
-		// scatter -> chase
-		case scatter:
-			gs.setMode(chase)
-			gs.setModeSteps(modeDurations[chase])
-		case paused:
-			switch gs.getLastUnpausedMode() {
-			// chase -> scatter
-			case chase:
-				gs.setLastUnpausedMode(scatter)
-				gs.setModeSteps(modeDurations[scatter])
-			// scatter -> chase
-			case scatter:
-				gs.setLastUnpausedMode(chase)
-				gs.setModeSteps(modeDurations[chase])
-			}
-		}
-
-		// Reverse the directions of all ghosts to indicate a mode switch
-		gs.reverseAllGhosts()
+		gs.setModeWave(gs.getModeWave() + 1)
 	}
 
 	// If the level steps are 0, add a penalty by speeding up the game
 	if levelSteps == 0 {
 
 		// Log the change to the terminal
-		log.Println("\033[31mGAME: Long-game penalty applied\033[0m")
+		gs.logger().Info("Long-game penalty applied", "tick", gs.getCurrTicks())
 
 		// Drop the update period by 2
 		gs.setUpdatePeriod(uint8(max(1, int(gs.getUpdatePeriod())-2)))
 
 		// Reset the level steps to the level penalty duration
-		gs.setLevelSteps(levelPenaltyDuration)
+		gs.setLevelSteps(gs.rules.LevelPenaltyDuration)
 	}
 
 	// Decrement the mode steps
-	if gs.getNumPellets() >= angerThreshold1 {
+	if gs.getNumPellets() >= gs.rules.AngerThresholds[0] {
 		gs.decrementModeSteps()
 	}
 
 	// Decrement the level steps
 	gs.decrementLevelSteps()
 
-	// Decrement the fruit steps
-	gs.decrementFruitSteps()
+	// Release any ghosts that are done waiting in the ghost house
+	gs.updateGhostHouse()
+
+	// Count down the fruit's time on the maze
+	gs.updateFruit()
+
+	// Count down the power-ups' effects
+	gs.updatePowerUps()
+
+	// Count down the teleporters' cooldowns
+	gs.updateTeleporters()
+
+	// Score the step survived, and respawn the pellets (survival games)
+	gs.updateSurvival()
+
+	// Give Pacman its moves for the next step
+	gs.refillPacmanMoves()
 }
@@ -0,0 +1,133 @@
+package game
+
+import (
+	"fmt"
+	"slices"
+)
+
+/*
+Gates are cells of a maze whose wall comes and goes during a game (e.g. a
+gate that opens mid-game to a shortcut), for competition variants and for
+testing how robots re-plan. They are drawn in the maze's grid as 'G' (closed
+at the start of the game) or 'g' (open at the start), and numbered in reading
+order, from 0. A gate is opened or closed on a schedule, set under GateSchedule
+in the configuration (or a room's settings), with each change naming the tick
+of the game it happens on, the gates it applies to (all of them if left out),
+and whether it opens or closes them:
+
+	"GateSchedule": [{"Tick": 1200, "Gates": [0, 1], "Open": true}]
+
+or by a referee, with the referee command 'e' 'w' followed by the gate (1
+byte, 255 for all of them) and whether to open it (1 byte, 0 to close it) -
+see referee.go.
+
+A closed gate is a wall for Pacman and the ghosts alike, but Pacman or a ghost
+caught in a gate as it closes can still leave it. Maze distances and path
+queries treat gates as open cells (see pathfinding.go), so ghosts that aim by
+maze distance may still head for a closed gate. Each change is logged as a
+"gate" event, which the web server relays to clients as a message of its own,
+and the state of every gate is broadcast after the extra ghosts (see
+serGates), so that clients joining mid-game (or after a rewind) know it too.
+*/
+
+// The most gates a maze can have (so that they fit in the serialized state)
+const maxGates = 32
+
+// Gate index that stands for all of the maze's gates (in referee commands)
+const allGates uint8 = 255
+
+// A scheduled change to the gates of a maze
+type GateChange struct {
+	Tick  uint16  // Tick of the game that the change happens on
+	Gates []uint8 // Gates to change, by index (all of them if empty)
+	Open  bool    // Whether to open (rather than close) the gates
+}
+
+// The schedule of changes to the gates, for every game
+var gateSchedule []GateChange = nil
+
+// Configure the schedule of changes to the gates (in no particular order)
+func ConfigGateSchedule(schedule []GateChange) {
+	gateSchedule = slices.Clone(schedule)
+}
+
+// Check whether one of the maze's gates is open
+func (gs *gameState) gateOpen(gate int) bool {
+	row, col := gs.maze.gates[gate][0], gs.maze.gates[gate][1]
+	return !gs.walls.get(row, col)
+}
+
+/*
+Open or close one of the maze's gates, logging the change (if there is one),
+along with what caused it
+*/
+func (gs *gameState) setGate(gate int, open bool, cause string) {
+	if gs.gateOpen(gate) == open {
+		return
+	}
+	row, col := gs.maze.gates[gate][0], gs.maze.gates[gate][1]
+	gs.walls.set(row, col, !open)
+	gs.logEvent(eventGate, map[string]any{"gate": uint8(gate), "row": row,
+		"col": col, "open": open, "cause": cause})
+	gs.logger().Info("Gate changed", "gate", gate, "open", open,
+		"cause", cause, "tick", gs.getCurrTicks())
+}
+
+// Apply the scheduled changes to the gates for the current tick
+func (gs *gameState) updateGates() {
+	if len(gs.maze.gates) == 0 {
+		return
+	}
+	for _, change := range gs.rules.gateSchedule {
+		if change.Tick != gs.getCurrTicks() {
+			continue
+		}
+		for gate := range gs.maze.gates {
+			if len(change.Gates) == 0 ||
+				slices.Contains(change.Gates, uint8(gate)) {
+				gs.setGate(gate, change.Open, "schedule")
+			}
+		}
+	}
+}
+
+/*
+Open or close a gate (or all of them) for a referee - returns an error if the
+maze has no such gate
+*/
+func (gs *gameState) refereeGate(gate uint8, open bool) error {
+	if gate != allGates && int(gate) >= len(gs.maze.gates) {
+		return invalidCommand('e', fmt.Sprintf("no gate %d", gate))
+	}
+	gs.refereeEvent("gate", map[string]any{"gate": gate, "open": open})
+	for idx := range gs.maze.gates {
+		if gate == allGates || idx == int(gate) {
+			gs.setGate(idx, open, "referee")
+		}
+	}
+	return nil
+}
+
+/*
+Serialize the gates of the maze, as a count followed by a row, column, and
+whether it is open (1 or 0) for each (1 + 3 * count bytes)
+*/
+func (gs *gameState) serGates(outputBuf []byte, startIdx int) int {
+
+	// Serialize the count first
+	startIdx = serUint8(uint8(len(gs.maze.gates)), outputBuf, startIdx)
+
+	// Serialize each gate, in order
+	for gate, cell := range gs.maze.gates {
+		var open uint8 = 0
+		if gs.gateOpen(gate) {
+			open = 1
+		}
+		startIdx = serUint8(uint8(cell[0]), outputBuf, startIdx)
+		startIdx = serUint8(uint8(cell[1]), outputBuf, startIdx)
+		startIdx = serUint8(open, outputBuf, startIdx)
+	}
+
+	// Return the starting index of the next field
+	return startIdx
+}
@@ -0,0 +1,46 @@
+package game
+
+/*
+In PvP mode, human players can steer the ghosts ('G' commands, see
+webserver/ghost_players.go):
+
+	'G' + ghost color (1 byte) + direction (1 byte: 0 = up, 1 = left,
+	2 = down, 3 = right, or 4 = none, to hand the ghost back to the AI)
+
+Steering is held like a joystick: whenever the ghost plans a move, it turns in
+the steered direction if that move is legal under the ghost's usual rules (no
+walls, and no reversing), and otherwise the AI chooses for it as usual. Eaten
+ghosts and ghosts leaving the ghost house are always moved by the AI, as are
+the ghosts that nobody is steering. Steering carries over when the game is
+restarted, but isn't part of snapshots or the serialized state.
+*/
+
+// Steer a ghost in a direction (none to hand it back to the AI)
+func (gs *gameState) steerGhost(color uint8, dir uint8) {
+	if int(color) >= len(gs.ghosts) || dir > none {
+		return
+	}
+	gs.ghosts[color].setSteering(dir)
+}
+
+// Set the direction a human player is steering the ghost in
+func (g *ghostState) setSteering(dir uint8) {
+
+	g.steering = dir
+}
+
+// Get the direction a human player is steering the ghost in (none if no one)
+func (g *ghostState) getSteering() uint8 {
+
+	// Return the current steering direction
+	return g.steering
+}
+
+// Carry the ghosts' steering over from another game state (after a restart)
+func (gs *gameState) copySteering(from *gameState) {
+	for color, g := range from.ghosts {
+		if color < len(gs.ghosts) {
+			gs.ghosts[color].setSteering(g.getSteering())
+		}
+	}
+}
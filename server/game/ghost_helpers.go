@@ -1,67 +1,98 @@
 package game
 
-import (
-	"log"
-)
-
 /******************************** Ghost Resets ********************************/
 
 // Respawn the ghost
 func (g *ghostState) reset() {
 
-	// Mark this operation as done once we return
-	defer g.game.wgGhosts.Done()
-
-	// If the ghost is inactive (in a game with fewer ghosts), skip
-	if g.color >= numActiveGhosts {
+	// If the ghost isn't in play (see ghost_selection.go), skip
+	if !g.game.isGhostActive(g.color) {
 		return
 	}
 
-	// Set the ghost to be trapped, spawning, and not frightened
+	// Set the ghost to be spawning, not eaten, and not frightened
 	g.setSpawning(true)
-	g.setTrappedSteps(ghostTrappedSteps[g.color])
+	g.setEaten(false)
 	g.setFrightSteps(0)
 
+	// Every ghost except red waits (trapped) in the ghost house until released
+	g.setDotCount(0)
+	if g.color != red {
+		g.setTrappedSteps(1)
+		g.setWaiting(true)
+	} else {
+		g.setTrappedSteps(0)
+		g.setWaiting(false)
+	}
+
 	// Set the current ghost to be at an empty location
-	g.loc.copyFrom(emptyLoc)
+	g.loc.copyFrom(g.game.maze.emptyLoc)
 
 	/*
 		Set the current location of the ghost to be its spawn point
 		(or pink's spawn location, in the case of red, so it spawns in the box)
 	*/
-	g.nextLoc.copyFrom(ghostSpawnLocs[g.color])
+	g.nextLoc.copyFrom(g.game.maze.ghostSpawns[g.color])
 }
 
 /****************************** Ghost Respawning ******************************/
 
-// Respawn the ghost
+/*
+Respawn the ghost - it becomes a pair of "eyes", which head back to the
+ghost house (at double speed) before respawning there
+*/
 func (g *ghostState) respawn() {
 
-	// Mark this operation as done once we return
-	defer g.game.wgGhosts.Done()
-
-	// If the ghost is inactive (in a game with fewer ghosts), skip
-	if g.color >= numActiveGhosts {
+	// If the ghost isn't in play (see ghost_selection.go), skip
+	if !g.game.isGhostActive(g.color) {
 		return
 	}
 
-	// Set the ghost to be eaten and spawning
-	g.setSpawning(true)
+	// Set the ghost to be eaten, and no longer frightened or trapped
 	g.setEaten(true)
+	g.setSpawning(false)
+	g.setFrightSteps(0)
+	g.setTrappedSteps(0)
+}
 
-	// Set the current ghost to be at an empty location
-	g.loc.copyFrom(emptyLoc)
+/*
+Respawn the ghost if it is eaten and has made it back into the ghost house,
+returning whether it did so
+*/
+func (g *ghostState) tryReturnHome() bool {
+
+	// If the ghost isn't a pair of eyes inside the ghost house, skip
+	if !g.isEaten() || !g.loc.collidesWith(g.game.maze.houseCenter) {
+		return false
+	}
 
 	/*
-		Set the current location of the ghost to be its spawn point
-		(or pink's spawn location, in the case of red, so it spawns in the box)
+		Set the ghost to be spawning again, trapping it for one step so
+		it turns around to leave the ghost house
 	*/
-	if g.color == red {
-		g.nextLoc.updateCoords(ghostSpawnLocs[pink].getCoords())
-	} else {
-		g.nextLoc.updateCoords(ghostSpawnLocs[g.color].getCoords())
+	g.setEaten(false)
+	g.setSpawning(true)
+	g.setTrappedSteps(1)
+	return true
+}
+
+/****************************** Ghost Reversal ********************************/
+
+// Force the ghost to reverse its direction at its next planned move
+func (g *ghostState) reverse() {
+
+	// If the ghost isn't in play (see ghost_selection.go), skip
+	if !g.game.isGhostActive(g.color) {
+		return
 	}
-	g.nextLoc.updateDir(up)
+
+	// Eaten ghosts keep heading back, and trapped ghosts already reverse
+	if g.isEaten() || g.isTrapped() {
+		return
+	}
+
+	// Trapping the ghost for one step forces its direction to reverse
+	g.setTrappedSteps(1)
 }
 
 /******************** Ghost Updates (before serialization) ********************/
@@ -69,58 +100,95 @@ func (g *ghostState) respawn() {
 // Update the ghost's position
 func (g *ghostState) update() {
 
-	// Mark the plan as done once we return
-	defer g.game.wgGhosts.Done()
+	// If the ghost isn't in play (see ghost_selection.go), skip
+	if !g.game.isGhostActive(g.color) {
+		return
+	}
 
 	/*
 		If the ghost is at the red spawn point and not moving downwards,
 		we can mark it as done spawning
 	*/
-	if g.loc.collidesWith(ghostSpawnLocs[red]) && g.loc.getDir() != down {
+	if g.loc.collidesWith(g.game.maze.houseEntrance) &&
+		g.loc.getDir() != down {
 		g.setSpawning(false)
 	}
 
-	// Set the ghost to be no longer eaten, if applicable
-	if g.isEaten() {
-		g.setEaten(false)
-		g.setFrightSteps(0)
-	}
-
 	// Decrement the ghost's frightened steps count if necessary
 	if g.isFrightened() {
 		g.decFrightSteps()
 	}
 
-	// Copy the next location into the current location
-	g.loc.copyFrom(g.nextLoc)
+	// Frozen ghosts stay where they are (see power_ups.go)
+	if g.game.ghostsFrozen() && !g.isEaten() {
+		return
+	}
+
+	// Move to the next location (teleporting, if applicable)
+	g.moveToNext()
+
+	/*
+		Ghosts faster than a cell per step (e.g. eaten ghosts, which move
+		twice as fast by default) plan and take extra moves (speeds.go)
+	*/
+	for moves := g.stepMoves(g.game.stepIndex()); moves > 1; moves-- {
+		if g.tryReturnHome() {
+			break
+		}
+		g.planMove()
+		g.moveToNext()
+	}
+	g.tryReturnHome()
 }
 
 /******************** Ghost Planning (after serialization) ********************/
 
-// Plan the ghost's next move
-func (g *ghostState) plan() {
-
-	// Mark the plan as done once we return
-	defer g.game.wgGhosts.Done()
+// Decide on the ghost's next location and direction, based on its target
+func (g *ghostState) planMove() {
 
 	// If the location is empty (i.e. after a reset/respawn), don't plan
-	if g.loc.isEmpty() {
+	if g.loc.isEmpty(g.game.maze) {
+		return
+	}
+
+	// Frozen ghosts stay in place, except for the eyes (see power_ups.go)
+	if g.game.ghostsFrozen() && !g.isEaten() {
+		g.nextLoc.copyFrom(g.loc)
+		return
+	}
+
+	// Frightened ghosts move slower, staying in place on some steps
+	if g.isFrightened() && !g.isEaten() &&
+		g.getFrightSteps()%g.game.rules.GhostFrightPeriod != 0 {
+		g.nextLoc.copyFrom(g.loc)
+		return
+	}
+
+	// Ghosts slower than a cell per step skip some steps (speeds.go)
+	if g.stepMoves(g.game.stepIndex()+1) == 0 {
+		g.nextLoc.copyFrom(g.loc)
 		return
 	}
 
 	// Determine the next position based on the current direction
-	g.nextLoc.advanceFrom(g.loc)
+	g.nextLoc.advanceFrom(g.loc, g.game.maze)
 
-	// If the ghost is trapped, reverse the current direction and return
+	/*
+		If the ghost is trapped, reverse the current direction and return
+		(ghosts waiting in the ghost house stay trapped until released)
+	*/
 	if g.isTrapped() {
 		g.nextLoc.updateDir(g.nextLoc.getReversedDir())
-		g.decTrappedSteps()
+		if !g.isWaiting() {
+			g.decTrappedSteps()
+		}
 		return
 	}
 
-	// Keep local copies of the fright steps and spawning variables
+	// Keep local copies of the fright steps, spawning, and eaten variables
 	frightSteps := g.getFrightSteps()
 	spawning := g.isSpawning()
+	eaten := g.isEaten()
 
 	// Decide on a target for this ghost, depending on the game mode
 	var targetRow, targetCol int8
@@ -128,15 +196,29 @@ func (g *ghostState) plan() {
 	// Capture the last unpaused current game mode (could be the current mode)
 	mode := g.game.getLastUnpausedMode()
 
+	// Shorthand for the maze layout
+	maze := g.game.maze
+
 	/*
+		If the ghost is eaten, head for the ghost house entrance (red's spawn
+		location), and then into the middle of the ghost house
+
 		If the ghost is spawning in the ghost house, choose red's spawn
 		location as the target to encourage it to leave the ghost house
 
 		Otherwise: pick chase or scatter targets, depending on the mode
 	*/
-	if spawning && !g.loc.collidesWith(ghostSpawnLocs[red]) &&
-		!g.nextLoc.collidesWith(ghostSpawnLocs[red]) {
-		targetRow, targetCol = ghostSpawnLocs[red].getCoords()
+	if eaten {
+		row, col := g.nextLoc.getCoords()
+		if g.nextLoc.collidesWith(maze.houseEntrance) ||
+			maze.isHouseExit(row, col) || g.game.ghostSpawnAt(row, col) {
+			targetRow, targetCol = maze.houseCenter.getCoords()
+		} else {
+			targetRow, targetCol = maze.houseEntrance.getCoords()
+		}
+	} else if spawning && !g.loc.collidesWith(maze.houseEntrance) &&
+		!g.nextLoc.collidesWith(maze.houseEntrance) {
+		targetRow, targetCol = maze.houseEntrance.getCoords()
 	} else if mode == chase { // Chase mode targets
 		targetRow, targetCol = g.game.getChaseTarget(g.color)
 	} else if mode == scatter { // Scatter mode targets
@@ -149,20 +231,20 @@ func (g *ghostState) plan() {
 	*/
 	numValidMoves := 0
 	var moveValid [numDirs]bool
-	var moveDistSq [numDirs]int
+	var moveDist [numDirs]int
 	for dir := uint8(0); dir < numDirs; dir++ {
 
 		// Get the neighboring cell in that location
-		row, col := g.nextLoc.getNeighborCoords(dir)
+		row, col := g.nextLoc.getNeighborCoords(g.game.maze, dir)
 
-		// Calculate the distance from the target to the move location
-		moveDistSq[dir] = g.game.distSq(row, col, targetRow, targetCol)
+		// Calculate the (squared) distance from the target to the move location
+		moveDist[dir] = g.game.distSq(row, col, targetRow, targetCol)
 
 		// Determine if that move is valid
 		moveValid[dir] = !g.game.wallAt(row, col)
 
-		// Considerations when the ghost is spawning
-		if spawning {
+		// Considerations when the ghost is spawning (or returning as eyes)
+		if spawning || eaten {
 
 			// Determine if the move would be within the ghost house
 			if g.game.ghostSpawnAt(row, col) {
@@ -173,7 +255,7 @@ func (g *ghostState) plan() {
 				Determine if the move would help the ghost escape the ghost house,
 				and make it a valid one if so
 			*/
-			if row == ghostHouseExitRow && col == ghostHouseExitCol {
+			if maze.isHouseExit(row, col) {
 				moveValid[dir] = true
 			}
 		}
@@ -193,12 +275,24 @@ func (g *ghostState) plan() {
 	if numValidMoves == 0 {
 		row, col := g.nextLoc.getCoords()
 		dir := g.nextLoc.getDir()
-		log.Printf("\033[2m\033[36mWARN: %s has nowhere to go "+
-			"(row = %d, col = %d, dir = %s, spawning = %t)\n\033[0m",
-			ghostNames[g.color], row, col, dirNames[dir], spawning)
+		g.game.logger().Warn("Ghost has nowhere to go", "ghost", ghostNames[g.color],
+			"row", row, "col", col, "dir", dirNames[dir], "spawning", spawning,
+			"tick", g.game.getCurrTicks())
 		return
 	}
 
+	// A human player's steering wins, where the move is legal (ghost_control.go)
+	if dir := g.getSteering(); dir < numDirs && moveValid[dir] &&
+		!spawning && !eaten {
+		g.nextLoc.updateDir(dir)
+		return
+	}
+
+	// If configured, aim by maze distance instead, where possible
+	if g.game.rules.GhostMazeDistance {
+		g.game.useMazeDist(&moveDist, moveValid, g.nextLoc, targetRow, targetCol)
+	}
+
 	/*
 		 	If the ghost will still frightened one tick later, immediately choose
 			a random valid direction and return
@@ -206,7 +300,7 @@ func (g *ghostState) plan() {
 	if frightSteps > 1 {
 
 		// Generate a random index out of the valid moves
-		randomNum := g.game.rng.Intn(numValidMoves)
+		randomNum := g.rng.Intn(numValidMoves)
 
 		// Loop over all directions
 		for dir, count := uint8(0), 0; dir < numDirs; dir++ {
@@ -238,9 +332,9 @@ func (g *ghostState) plan() {
 		}
 
 		// Compare this direction to the best so far
-		if moveDistSq[dir] < bestDist {
+		if moveDist[dir] < bestDist {
 			bestDir = dir
-			bestDist = moveDistSq[dir]
+			bestDist = moveDist[dir]
 		}
 	}
 
@@ -0,0 +1,64 @@
+package game
+
+import (
+	"fmt"
+)
+
+/*
+Bots can plan around the ghosts without re-implementing their AI by asking
+where the ghosts will be over the next few steps: the ghosts' own update and
+planning logic is played forward on a copy of the game (see game_copy.go), one
+step (update period) at a time. The prediction assumes that Pacman stays where
+it is, and that no step events happen (the mode doesn't change, and no ghosts
+are released from the ghost house), so it holds until Pacman moves or one of
+those events happens. Even frightened ghosts are predicted exactly, since the
+copy continues the ghosts' random number generators.
+*/
+
+// The most steps that the ghosts can be predicted for at once
+const maxPredictSteps = 64
+
+/*
+Play the ghosts forward for a number of steps, returning the cell (row and
+column) of each ghost after each step - ghosts out of play are at the maze's
+empty location (see maze_size.go). This changes the game state, so it should
+only be called on a copy
+*/
+func (gs *gameState) predictGhosts(steps int) [][][2]int8 {
+	cells := make([][][2]int8, steps)
+	for step := range cells {
+
+		// Move the ghosts, and plan their next moves, as the engine loop does
+		gs.updateAllGhosts()
+		gs.planAllGhosts()
+
+		// Record where each ghost ended up
+		cells[step] = make([][2]int8, len(gs.ghosts))
+		for color, g := range gs.ghosts {
+			row, col := g.loc.getCoords()
+			cells[step][color] = [2]int8{row, col}
+		}
+	}
+	return cells
+}
+
+/*
+Predict the cells of the ghosts (in the order of their colors) after each of
+the next few steps of the game engine's current game - exported for clients'
+prediction queries, and safe to call while the engine loop runs
+*/
+func (ge *GameEngine) PredictGhosts(steps int) ([][][2]int8, error) {
+
+	// Check the number of steps
+	if steps < 1 || steps > maxPredictSteps {
+		return nil, fmt.Errorf("can only predict 1 to %d steps",
+			maxPredictSteps)
+	}
+
+	// Play the ghosts forward on a copy of the game
+	gs, err := ge.copyState()
+	if err != nil {
+		return nil, err
+	}
+	return gs.predictGhosts(steps), nil
+}
@@ -0,0 +1,91 @@
+package game
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+)
+
+/*
+For practice (e.g. pellet-clearing routes, or vision tracking without being
+chased), individual ghosts can be taken out of play - like the ghosts beyond
+NumActiveGhosts, a ghost that isn't in play stays hidden, and never moves,
+catches Pacman, or gets eaten. The ghosts in play are kept as a set of colors
+(a bit per color, with red as the lowest bit), chosen:
+
+	- in config.json, by naming the ghosts to leave out under DisabledGhosts
+	  (on top of NumActiveGhosts), for the whole server or for a room
+	- while the server runs, with the command 'g' followed by the set of ghosts
+	  to keep in play (1 byte, e.g. 0 for no ghosts at all), which restarts
+	  the game to apply it
+
+Each game keeps the set of ghosts it started with, and replays record it.
+*/
+
+// The set of all the ghosts
+const allGhosts uint8 = 0xff >> (8 - numColors)
+
+// The ghosts to leave out of play (a bit per color)
+var disabledGhosts uint8 = 0
+
+// Configure the ghosts to leave out of play, by name
+func ConfigDisabledGhosts(names []string) error {
+	set, err := GhostSet(names)
+	if err != nil {
+		return err
+	}
+	disabledGhosts = set
+	return nil
+}
+
+// Get the set of ghosts (a bit per color) with the given names
+func GhostSet(names []string) (uint8, error) {
+	set := uint8(0)
+	for _, name := range names {
+		color := slices.Index(ghostNames[:], name)
+		if color < 0 {
+			return 0, fmt.Errorf("unknown ghost \"%s\"", name)
+		}
+		set |= 1 << color
+	}
+	return set, nil
+}
+
+// Get the names of the ghosts in a set
+func ghostSetNames(set uint8) []string {
+	names := []string{}
+	for color := uint8(0); color < numColors; color++ {
+		if set&(1<<color) != 0 {
+			names = append(names, ghostNames[color])
+		}
+	}
+	return names
+}
+
+// Get the set of the first few ghosts (e.g. for NumActiveGhosts)
+func firstGhosts(num uint8) uint8 {
+	return allGhosts >> (numColors - min(num, numColors))
+}
+
+// Getter method for the ghosts in play in new games
+func (rules *gameRules) getActiveGhosts() uint8 {
+	rules.muRules.RLock()
+	defer rules.muRules.RUnlock()
+	return rules.activeGhosts
+}
+
+// Select the ghosts in play (a bit per color), for all new games
+func (rules *gameRules) selectGhosts(set uint8) {
+	rules.muRules.Lock()
+	{
+		rules.activeGhosts = set & allGhosts
+	}
+	rules.muRules.Unlock()
+	slog.Info("Selected ghosts", "room", rules.room,
+		"ghosts", ghostSetNames(set))
+}
+
+// Check whether a ghost is in play in this game
+func (gs *gameState) isGhostActive(color uint8) bool {
+	return gs.activeGhosts&(1<<color) != 0
+}
@@ -1,27 +1,41 @@
 package game
 
 import (
-	"sync"
+	"math/rand"
 )
 
-// Enum-like declaration to hold the ghost colors
+/*
+Enum-like declaration to hold the ghost colors - the first four are the
+ghosts of the original game, and the rest only play in mazes that declare
+more ghosts (see maze.go)
+*/
 const (
 	red       uint8 = 0
 	pink      uint8 = 1
 	cyan      uint8 = 2
 	orange    uint8 = 3
-	numColors uint8 = 4
+	purple    uint8 = 4
+	green     uint8 = 5
+	blue      uint8 = 6
+	white     uint8 = 7
+	numColors uint8 = 8
 )
 
+/*
+The number of ghosts of the original game, which are always serialized (even
+if the maze has fewer), so that the serialized state keeps its layout
+*/
+const numClassicGhosts uint8 = 4
+
 /*
 The number of "active" ghosts (the others are invisible and don't affect
-the progression of the game)
+the progression of the game), up to the number that the maze declares
 */
-var numActiveGhosts uint8 = 4
+var numActiveGhosts uint8 = numColors
 
 // Configure the number of active ghosts
 func ConfigNumActiveGhosts(_numActiveGhosts uint8) {
-	numActiveGhosts = _numActiveGhosts
+	numActiveGhosts = min(_numActiveGhosts, numColors)
 }
 
 // Names of the ghosts (not the nicknames, just the colors, for debugging)
@@ -30,6 +44,10 @@ var ghostNames [numColors]string = [...]string{
 	"pink",
 	"cyan",
 	"orange",
+	"purple",
+	"green",
+	"blue",
+	"white",
 }
 
 /*
@@ -43,9 +61,39 @@ type ghostState struct {
 	color         uint8
 	trappedSteps  uint8
 	frightSteps   uint8
-	spawning      bool         // Flag set when spawning
-	eaten         bool         // Flag set when eaten and returning to ghost house
-	muState       sync.RWMutex // Mutex to lock general state parameters
+	dotCount      uint8 // Pellets counted towards leaving the ghost house
+	spawning      bool  // Flag set when spawning
+	eaten         bool  // Flag set when eaten and returning to ghost house
+	waiting       bool  // Flag set when waiting to leave the ghost house
+	steering      uint8 // Direction steered by a human player (or none)
+
+	// A random number generator for making frightened ghost decisions
+	rng    *rand.Rand
+	rngSrc *countingSource // Source of the generator (to save its state)
+}
+
+/*
+A random number source that counts how many numbers it has generated, so that
+its state can be saved and restored (by re-seeding it and skipping ahead)
+*/
+type countingSource struct {
+	rand.Source
+	draws uint64 // Numbers generated since seeding
+}
+
+// Create a new counting source with a given seed, skipping a number of draws
+func newCountingSource(seed int64, draws uint64) *countingSource {
+	src := countingSource{Source: rand.NewSource(seed)}
+	for src.draws < draws {
+		src.Int63()
+	}
+	return &src
+}
+
+// Generate a random number, counting it
+func (src *countingSource) Int63() int64 {
+	src.draws++
+	return src.Source.Int63()
 }
 
 // Create a new ghost state with given location and color values
@@ -53,20 +101,35 @@ func newGhostState(_gameState *gameState, _color uint8) *ghostState {
 
 	// Ghost state object
 	g := ghostState{
-		loc:           newLocationStateCopy(emptyLoc),
-		nextLoc:       newLocationStateCopy(ghostSpawnLocs[_color]),
-		scatterTarget: newLocationStateCopy(ghostScatterTargets[_color]),
+		loc:           newLocationStateCopy(_gameState.maze.emptyLoc),
+		nextLoc:       newLocationStateCopy(_gameState.maze.ghostSpawns[_color]),
+		scatterTarget: newLocationStateCopy(_gameState.rules.scatterTargets[_color]),
 		game:          _gameState,
 		color:         _color,
-		trappedSteps:  ghostTrappedSteps[_color],
+		trappedSteps:  0,
 		frightSteps:   0,
+		dotCount:      0,
 		spawning:      true,
 		eaten:         false,
+		waiting:       false,
+		steering:      none,
+
+		// Each ghost gets its own generator (derived from the game's seed), so
+		// that each ghost's decisions only depend on its own history
+		rngSrc: newCountingSource(_gameState.seed+int64(_color), 0),
+	}
+	g.rng = rand.New(g.rngSrc)
+
+	// Every ghost except red starts out waiting in the ghost house
+	if _color != red {
+		g.trappedSteps = 1
+		g.waiting = true
 	}
 
-	// If the color is greater than the number of active ghosts, hide this ghost
-	if _color >= numActiveGhosts {
-		g.nextLoc = newLocationStateCopy(emptyLoc)
+	// If the ghost isn't in play, hide it (ghost_selection.go)
+	if !_gameState.isGhostActive(_color) {
+		g.nextLoc = newLocationStateCopy(_gameState.maze.emptyLoc)
+		g.waiting = false
 	}
 
 	// Return the ghost state
@@ -75,46 +138,38 @@ func newGhostState(_gameState *gameState, _color uint8) *ghostState {
 
 /*************************** Ghost Frightened State ***************************/
 
+// The most fright steps a ghost can have (they share a byte with two flags)
+const maxFrightSteps uint8 = 63
+
 // Set the fright steps of a ghost
 func (g *ghostState) setFrightSteps(steps uint8) {
 
-	// (Write) lock the ghost state
-	g.muState.Lock()
-	{
-		g.frightSteps = steps
-	}
-	g.muState.Unlock()
+	g.frightSteps = steps
 }
 
 // Decrement the fright steps of a ghost
 func (g *ghostState) decFrightSteps() {
 
-	// (Write) lock the ghost state
-	g.muState.Lock()
-	{
-		g.frightSteps--
-	}
-	g.muState.Unlock()
+	g.frightSteps--
 }
 
 // Get the fright steps of a ghost
 func (g *ghostState) getFrightSteps() uint8 {
 
-	// (Read) lock the ghost state
-	g.muState.RLock()
-	defer g.muState.RUnlock()
-
 	// Return the current fright steps
 	return g.frightSteps
 }
 
+// Check if a ghost is flashing (frightened, but about to stop being so)
+func (g *ghostState) isFlashing() bool {
+
+	// Return whether there are only a few fright steps left
+	return g.frightSteps > 0 && g.frightSteps <= g.game.rules.GhostFlashSteps
+}
+
 // Check if a ghost is frightened
 func (g *ghostState) isFrightened() bool {
 
-	// (Read) lock the ghost state
-	g.muState.RLock()
-	defer g.muState.RUnlock()
-
 	// Return whether there is at least one fright step left
 	return g.frightSteps > 0
 }
@@ -124,32 +179,18 @@ func (g *ghostState) isFrightened() bool {
 // Set the trapped steps of a ghost
 func (g *ghostState) setTrappedSteps(steps uint8) {
 
-	// (Write) lock the ghost state
-	g.muState.Lock()
-	{
-		g.trappedSteps = steps
-	}
-	g.muState.Unlock()
+	g.trappedSteps = steps
 }
 
 // Decrement the trapped steps of a ghost
 func (g *ghostState) decTrappedSteps() {
 
-	// (Write) lock the ghost state
-	g.muState.Lock()
-	{
-		g.trappedSteps--
-	}
-	g.muState.Unlock()
+	g.trappedSteps--
 }
 
 // Check if a ghost is trapped
 func (g *ghostState) isTrapped() bool {
 
-	// (Read) lock the ghost state
-	g.muState.RLock()
-	defer g.muState.RUnlock()
-
 	// Return whether there is at least one fright step left
 	return g.trappedSteps > 0
 }
@@ -159,21 +200,12 @@ func (g *ghostState) isTrapped() bool {
 // Set the ghost spawning flag
 func (g *ghostState) setSpawning(spawning bool) {
 
-	// (Write) lock the ghost state
-	g.muState.Lock()
-	{
-		g.spawning = spawning
-	}
-	g.muState.Unlock()
+	g.spawning = spawning
 }
 
 // Check if a ghost is spawning
 func (g *ghostState) isSpawning() bool {
 
-	// (Read) lock the ghost state
-	g.muState.RLock()
-	defer g.muState.RUnlock()
-
 	// Return the current ghost spawning flag
 	return g.spawning
 }
@@ -183,21 +215,48 @@ func (g *ghostState) isSpawning() bool {
 // Set the ghost eaten flag
 func (g *ghostState) setEaten(eaten bool) {
 
-	// (Write) lock the ghost state
-	g.muState.Lock()
-	{
-		g.eaten = eaten
-	}
-	g.muState.Unlock()
+	g.eaten = eaten
 }
 
 // Check if a ghost is eaten
 func (g *ghostState) isEaten() bool {
 
-	// (Read) lock the ghost state
-	g.muState.RLock()
-	defer g.muState.RUnlock()
-
 	// Return the current ghost eaten flag
 	return g.eaten
 }
+
+/**************************** Ghost House Waiting *****************************/
+
+// Set the ghost waiting flag
+func (g *ghostState) setWaiting(waiting bool) {
+
+	g.waiting = waiting
+}
+
+// Check if a ghost is waiting to leave the ghost house
+func (g *ghostState) isWaiting() bool {
+
+	// Return the current ghost waiting flag
+	return g.waiting
+}
+
+// Set the dot count of a ghost
+func (g *ghostState) setDotCount(count uint8) {
+
+	g.dotCount = count
+}
+
+// Increment the dot count of a ghost
+func (g *ghostState) incDotCount() {
+
+	if g.dotCount != 255 {
+		g.dotCount++
+	}
+}
+
+// Get the dot count of a ghost
+func (g *ghostState) getDotCount() uint8 {
+
+	// Return the current dot count
+	return g.dotCount
+}
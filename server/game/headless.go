@@ -0,0 +1,89 @@
+package game
+
+import (
+	"time"
+)
+
+/*
+Headless runs play a game on the game engine as fast as the CPU allows, for AI
+training and for regression testing rule changes: the engine loop runs without
+any web broker (no output is sent, and no commands are read), doesn't wait for
+the game clock between frames, and saves no snapshots or replays. A live game
+is started (and resumed after Pacman is caught) automatically, since there is
+no referee to do it, and the run stops by itself once the game is over, once a
+number of ticks were played, or once a replay finishes playing back.
+*/
+
+// The state of a headless run of the engine loop
+type headlessRun struct {
+	maxTicks uint32 // Number of ticks to stop after (0 for no limit)
+	ticks    uint32 // Number of ticks played so far
+	frames   uint32 // Number of frames played so far
+
+	/*
+		Function called with the serialized state of each frame, and the
+		frame's length of game time - returns false to stop the run (nil if
+		the states aren't needed, see replay_export.go)
+	*/
+	onFrame func(state []byte, period time.Duration) bool
+	stopped bool // Whether onFrame stopped the run
+}
+
+// The outcome of a headless run, e.g. to compare before and after a rule change
+type HeadlessResult struct {
+	Frames      uint32  `json:"frames"`
+	Ticks       uint32  `json:"ticks"`
+	Score       uint16  `json:"score"`
+	Level       uint8   `json:"level"`
+	Lives       uint8   `json:"lives"`
+	Pellets     uint16  `json:"pellets"`
+	GameOver    bool    `json:"gameOver"`
+	Divergences uint32  `json:"divergences"` // Replay keyframes that differed
+	Seed        int64   `json:"seed"`
+	ElapsedMs   float64 `json:"elapsedMs"`
+}
+
+/*
+Decide whether a headless run should stop after the current frame - once the
+game is over, the tick limit is reached, the game can't tick any further, or
+the replay being played back finished (or the run was stopped)
+*/
+func (ge *GameEngine) headlessDone() bool {
+	hr := ge.headless
+	return hr.stopped || ge.state.isGameOver() ||
+		(hr.maxTicks > 0 && hr.ticks >= hr.maxTicks) ||
+		ge.state.getCurrTicks() == 0xffff ||
+		(ge.player != nil && ge.player.finished)
+}
+
+/*
+Run the engine loop headless (see above) until it stops by itself, or until
+the game engine is told to quit, then report how the game went - blocks, so it
+shouldn't be launched alongside RunLoop. The engine may be created without
+channels to the web broker (nil)
+*/
+func (ge *GameEngine) RunHeadless(maxTicks uint32) HeadlessResult {
+
+	// Run the engine loop to completion
+	ge.headless = &headlessRun{maxTicks: maxTicks}
+	start := time.Now()
+	ge.RunLoop()
+	elapsed := time.Since(start)
+
+	// Summarize the final state of the game
+	result := HeadlessResult{
+		Frames:    ge.headless.frames,
+		Ticks:     ge.headless.ticks,
+		Score:     ge.state.getScore(),
+		Level:     ge.state.getLevel(),
+		Lives:     ge.state.getLives(),
+		Pellets:   ge.state.getNumPellets(),
+		GameOver:  ge.state.isGameOver(),
+		Seed:      ge.state.seed,
+		ElapsedMs: float64(elapsed.Microseconds()) / 1000,
+	}
+	if ge.player != nil {
+		result.Divergences = ge.player.divergences
+	}
+	return result
+}
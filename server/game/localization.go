@@ -0,0 +1,89 @@
+package game
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+/*
+Localization reports come from the overhead-camera tracking system ('l'
+commands, see webserver/localization.go), with the physical robot's position in
+cell units - the center of the cell at (row, col) is at exactly (row, col) -
+and optionally its heading (in radians, see pose.go).
+Each report is filtered (see pose_filter.go) and snapped to a cell, which is
+checked before Pacman is moved there (along the most likely path, as for 'x'
+commands): reports that aren't finite numbers or that fall outside the maze,
+impossible jumps, and cells inside a wall or that can't be reached from
+Pacman's cell are rejected, as are reports while the game is paused (when the
+robot is usually being carried back into place). Once Pacman is in the
+reported cell, the filtered position (with the heading) becomes its pose.
+*/
+
+/*
+Decode a localization report (big-endian float32s: row, column, then the
+optional heading - NaN if it is left out)
+*/
+func decodeLocalization(report []byte) (row, col, heading float64) {
+	row = float64(math.Float32frombits(binary.BigEndian.Uint32(report[0:4])))
+	col = float64(math.Float32frombits(binary.BigEndian.Uint32(report[4:8])))
+	heading = math.NaN()
+	if len(report) >= 12 {
+		heading = float64(math.Float32frombits(
+			binary.BigEndian.Uint32(report[8:12])))
+	}
+	return row, col, heading
+}
+
+/*
+Move Pacman to the cell reported by the tracking system, if it checks out -
+returns an error if the report is outside the maze or inside a wall (reports
+rejected by the filter, or while paused, are part of tracking, not errors)
+*/
+func (gs *gameState) localizePacman(row, col, heading float64) error {
+
+	// Ignore reports while paused, as for other moves (starting the filter
+	// over, as the robot is being put back)
+	if gs.isPaused() || gs.getPauseOnUpdate() {
+		gs.pose.reset()
+		return nil
+	}
+
+	// Check that the report is within the maze
+	if math.IsNaN(row) || math.IsNaN(col) ||
+		row <= -0.5 || row >= float64(gs.maze.rows)-0.5 ||
+		col <= -0.5 || col >= float64(gs.maze.cols)-0.5 {
+		return fmt.Errorf("%w: localization (%.2f, %.2f)", ErrOutOfBounds,
+			row, col)
+	}
+
+	// Filter it, rejecting impossible jumps
+	estRow, estCol, ok := gs.pose.update(row, col, gs.getCurrTicks(),
+		gs.rules.getFPS(), gs.logger())
+	if !ok {
+		gs.logger().Debug("Localization rejected (impossible jump)",
+			"row", row, "col", col)
+		return nil
+	}
+
+	// Snap it to a cell, and check that the cell can be reached
+	pRow, pCol := gs.pacmanLoc.getCoords()
+	cellRow, cellCol := snapCoord(estRow, pRow), snapCoord(estCol, pCol)
+	if gs.wallAt(cellRow, cellCol) {
+		return fmt.Errorf("%w: localization (%.2f, %.2f)", ErrWallCollision,
+			row, col)
+	}
+	if gs.mazeDist(pRow, pCol, cellRow, cellCol) < 0 {
+		gs.logger().Debug("Localization rejected (unreachable)",
+			"row", row, "col", col)
+		return nil
+	}
+
+	// Move Pacman there, taking on the filtered position as its pose
+	err := gs.movePacmanAbsolute(cellRow, cellCol)
+	if newRow, newCol := gs.pacmanLoc.getCoords(); newRow == cellRow &&
+		newCol == cellCol {
+		gs.setPacmanPose(estRow, estCol, heading)
+	}
+	return err
+}
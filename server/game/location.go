@@ -1,7 +1,5 @@
 package game
 
-import "sync"
-
 // Directions:                U   L   D   R  None
 var dRow [5]int8 = [...]int8{-1, -0, +1, +0, +0}
 var dCol [5]int8 = [...]int8{-0, -1, +0, +1, +0}
@@ -32,7 +30,6 @@ type locationState struct {
 	row int8  // Row
 	col int8  // Col
 	dir uint8 // Index of the direction, within the direction arrays
-	sync.RWMutex
 }
 
 // Create a new location state with given position and direction values
@@ -47,10 +44,6 @@ func newLocationState(_row int8, _col int8, _dir uint8) *locationState {
 // Create a new location state as a copy-by-value of an existing one
 func newLocationStateCopy(_loc *locationState) *locationState {
 
-	// Just to be thread safe, (read) lock the other state
-	_loc.RLock()
-	defer _loc.RUnlock()
-
 	// Copy over the variables into a new location state
 	return &locationState{
 		row: _loc.row,
@@ -64,43 +57,20 @@ func newLocationStateCopy(_loc *locationState) *locationState {
 // Determine if another location state matches with the given location
 func (loc *locationState) collidesWith(loc2 *locationState) bool {
 
-	// (Read) lock the states (to prevent other reads or writes)
-	loc.RLock()
-	loc2.RLock()
-	defer func() {
-		loc.RUnlock()
-		loc2.RUnlock()
-	}()
-
-	// If any of the rows or columns is at least 32, they don't collide
-	if loc.row >= 32 || loc.col >= 32 || loc2.row >= 32 || loc2.col >= 32 {
-		return false
-	}
-
 	// Return if both coordinates match
 	return ((loc.row == loc2.row) && (loc.col == loc2.col))
 }
 
-// Determine if a given location state matches with the empty location
-func (loc *locationState) isEmpty() bool {
-
-	// (Read) lock the states (to prevent other reads or writes)
-	loc.RLock()
-	defer func() {
-		loc.RUnlock()
-	}()
+// Determine if a given location state matches with a maze's empty location
+func (loc *locationState) isEmpty(maze *mazeLayout) bool {
 
 	// Return if both coordinates match
-	return ((loc.row == emptyLoc.row) && (loc.col == emptyLoc.col))
+	return ((loc.row == maze.emptyLoc.row) && (loc.col == maze.emptyLoc.col))
 }
 
 // Return a direction corresponding to an existing location
 func (loc *locationState) getDir() uint8 {
 
-	// Lock the states for thread safety
-	loc.RLock()
-	defer loc.RUnlock()
-
 	// Return the direction
 	return loc.dir
 }
@@ -128,25 +98,39 @@ func (loc *locationState) getReversedDir() uint8 {
 // Return a set of coordinates corresponding to an existing location
 func (loc *locationState) getCoords() (int8, int8) {
 
-	// Lock the states for thread safety
-	loc.RLock()
-	defer loc.RUnlock()
-
 	// Return the pair of coordinates
 	return (loc.row),
 		(loc.col)
 }
 
-// Create a new set of coordinates as the neighbor of an existing location
-func (loc *locationState) getNeighborCoords(dir uint8) (int8, int8) {
-
-	// Lock the states for thread safety
-	loc.RLock()
-	defer loc.RUnlock()
+/*
+Create a new set of coordinates as the neighbor of an existing location
+(wrapping around the edges of the maze, see wrapCoords)
+*/
+func (loc *locationState) getNeighborCoords(maze *mazeLayout,
+	dir uint8) (int8, int8) {
 
 	// Add the deltas to the coordinates and return the pair
-	return (loc.row + dRow[dir]),
-		(loc.col + dCol[dir])
+	return maze.wrapCoords(loc.row+dRow[dir], loc.col+dCol[dir])
+}
+
+/*
+Wrap a set of coordinates just off an edge of the maze around to the opposite
+edge, so that moves out of the maze's tunnels come back in on the other side
+(see maze.go) - all other coordinates are returned unchanged
+*/
+func (maze *mazeLayout) wrapCoords(row int8, col int8) (int8, int8) {
+	if row == -1 {
+		row = maze.rows - 1
+	} else if row == maze.rows {
+		row = 0
+	}
+	if col == -1 {
+		col = maze.cols - 1
+	} else if col == maze.cols {
+		col = 0
+	}
+	return row, col
 }
 
 /*
@@ -155,10 +139,6 @@ of a given location state
 */
 func (loc *locationState) getAheadCoords(spaces int8) (int8, int8) {
 
-	// Lock the states for thread safety
-	loc.RLock()
-	defer loc.RUnlock()
-
 	// Add the deltas to the coordinates and return the pair
 	return (loc.row + dRow[loc.dir]*spaces),
 		(loc.col + dCol[loc.dir]*spaces)
@@ -177,13 +157,13 @@ func (loc *locationState) copyFrom(loc2 *locationState) {
 }
 
 /*
-Set the given location to be one time step after another location,
-and copy the current direction
+Set the given location to be one time step after another location (in a
+given maze), and copy the current direction
 */
-func (loc *locationState) advanceFrom(loc2 *locationState) {
+func (loc *locationState) advanceFrom(loc2 *locationState, maze *mazeLayout) {
 
 	// Set the next location to be one ahead of the current one
-	loc.updateCoords(loc2.getAheadCoords(1))
+	loc.updateCoords(loc2.getNeighborCoords(maze, loc2.getDir()))
 
 	// Keep the same direction by default
 	loc.updateDir(loc2.getDir())
@@ -192,10 +172,6 @@ func (loc *locationState) advanceFrom(loc2 *locationState) {
 // Copy all the variables from another location state into the given location
 func (loc *locationState) updateDir(dir uint8) {
 
-	// Lock the state for thread safety
-	loc.Lock()
-	defer loc.Unlock()
-
 	// Update the values
 	loc.dir = dir
 }
@@ -203,10 +179,6 @@ func (loc *locationState) updateDir(dir uint8) {
 // Move a given location state to specified coordinates
 func (loc *locationState) updateCoords(row int8, col int8) {
 
-	// Lock the state for thread safety
-	loc.Lock()
-	defer loc.Unlock()
-
 	// Update the values
 	loc.row = row
 	loc.col = col
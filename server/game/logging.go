@@ -1,6 +1,10 @@
 package game
 
-import "sync"
+import (
+	"context"
+	"log/slog"
+	"sync"
+)
 
 // Determines whether commands received in the input channel get printed
 var commandLogEnable bool = false
@@ -23,3 +27,26 @@ func SetCommandLogEnable(en bool) {
 	}
 	muLC.Unlock()
 }
+
+// A log handler which drops every record (for simulated games)
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
+// A logger which drops every record (for simulated games)
+var discardLogger = slog.New(discardHandler{})
+
+/*
+Get the logger for a game state's messages - copies of the game that are being
+played forward (see game_copy.go) stay silent, so that they don't flood the
+logs
+*/
+func (gs *gameState) logger() *slog.Logger {
+	if gs.simulated {
+		return discardLogger
+	}
+	return slog.Default()
+}
@@ -0,0 +1,72 @@
+package game
+
+import (
+	"sync"
+)
+
+/*
+In the Pacbot competition, each game is a round of fixed length (e.g. 3
+minutes). With a match clock configured, each game starts with that many
+seconds on the clock, which runs down with the game's ticks - so it stops
+while the game is paused or counting down - and when it runs out, the game is
+over with the score it has: a "time_up" event records the final score, before
+the usual "game_over" event.
+
+The ticks left on the clock are sent with each broadcast state (see
+serMatchLeft), as 0 if there is no match clock.
+*/
+
+// The length of each game's match clock, in seconds (0 for no clock)
+var matchSeconds uint16 = 0
+
+// Mutex accompanying the above variable
+var muMS sync.RWMutex
+
+// Configure the length of each game's match clock, in seconds (0 for no clock)
+func ConfigMatchSeconds(_matchSeconds uint16) {
+	muMS.Lock()
+	{
+		matchSeconds = _matchSeconds
+	}
+	muMS.Unlock()
+}
+
+// Get the length of the match clock, in ticks (0 for no clock)
+func (rules *gameRules) matchTicks() uint16 {
+	ticks := uint32(rules.matchSeconds) * uint32(rules.getFPS())
+	return uint16(min(ticks, 0xffff))
+}
+
+// Helper function to get the number of ticks left on the match clock
+func (gs *gameState) getMatchLeft() uint16 {
+
+	// Return the number of ticks left
+	return gs.matchLeft
+}
+
+// Helper function to run down the match clock (once per tick of the game)
+func (gs *gameState) updateMatchClock() {
+
+	// Keep track of whether the clock just ran out
+	var done bool
+
+	if gs.matchLeft != 0 {
+		gs.matchLeft-- // Decrease the ticks left
+		done = (gs.matchLeft == 0)
+	}
+
+	// End the game once time is up
+	if done && !gs.isGameOver() {
+		gs.logEvent(eventTimeUp, map[string]any{"score": gs.getScore()})
+		gs.logger().Info("Time up", "score", gs.getScore(), "tick",
+			gs.getCurrTicks())
+		gs.endGame()
+	}
+}
+
+// Serialize the ticks left on the match clock (2 bytes, 0 for no clock)
+func (gs *gameState) serMatchLeft(outputBuf []byte, startIdx int) int {
+
+	// Serialize the ticks left, and return the starting index of the next field
+	return serUint16(gs.getMatchLeft(), outputBuf, startIdx)
+}
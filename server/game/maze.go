@@ -0,0 +1,504 @@
+package game
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+/*
+Maze layouts are stored as plain-text grids, with one line per row and one
+character per column (column 0 on the left):
+
+	'#' - wall
+	'.' - pellet
+	'o' - super pellet
+	' ' - empty space
+	'-' - ghost house exit (a wall for Pacman)
+	'H' - ghost house interior (a wall for Pacman)
+	'1' - pink's spawn location (ghost house interior)
+	'2' - cyan's spawn location (ghost house interior)
+	'3' - orange's spawn location (ghost house interior)
+	'4' to '7' - the extra ghosts' spawn locations (purple, green, blue, and
+	      white, in the ghost house interior)
+	'T' - tunnel (empty space, where ghosts slow down - see speeds.go)
+	'P' - Pacman's spawn location (empty space)
+	'F' - the fruit's spawn location (empty space)
+	'G' - gate, closed at the start of the game (a wall, see gates.go)
+	'g' - gate, open at the start of the game (empty space)
+	'Z', 'S', 'X' - power-ups: ghost freeze, speed boost, and point
+	      multiplier (empty space, see power_ups.go)
+	'a' to 'f' - teleporters, each drawn at both of its ends (empty space,
+	      see teleporters.go)
+
+Red spawns at the ghost house entrance, the empty space next to the exit, and
+eaten ghosts return to the ghost house center, the interior cell next to it.
+The spawn locations declare the maze's ghosts: red, along with one ghost for
+each spawn location, numbered without gaps (e.g. '1' to '3' for the four
+ghosts of the original game, or '1' to '7' for eight ghosts). A maze without
+a ghost house exit has no ghosts at all.
+
+Tunnels on opposite edges of the maze (the first and last columns of a row, or
+the first and last rows of a column) connect around the edge, so Pacman and
+the ghosts leaving the maze through one come back in through the other. Any
+other open cells facing each other across the edges must be tunnels too, while
+open cells facing a wall stay dead ends, as the edges are otherwise walls
+(except for tunnels, which must lead somewhere). Mazes must also be playable,
+which is checked as they are loaded (see maze_validation.go).
+
+Every row must have the same number of columns, and mazes can be of any size
+up to 63 rows and 63 columns (see maze_size.go).
+*/
+
+// Built-in maze layouts, compiled into the server
+//
+//go:embed mazes/*.txt
+var builtinMazes embed.FS
+
+// The name of the maze layout that is used by default
+const defaultMazeName = "classic"
+
+// The longest allowed maze name (so that it fits in the serialized state)
+const maxMazeNameLen = 32
+
+/*
+A maze layout object, holding the (read-only) information about the maze that
+a game is played on - bit arrays follow the same convention as the pellets
+*/
+type mazeLayout struct {
+	name         string         // Name of the maze (for clients)
+	grid         []byte         // Plain-text grid (for replays)
+	rows         int8           // Number of rows
+	cols         int8           // Number of columns
+	walls        bitGrid        // Walls (from Pacman's perspective)
+	pellets      bitGrid        // Initial pellets (including super pellets)
+	superPellets bitGrid        // Super pellets
+	ghostHouse   bitGrid        // Ghost house interior
+	tunnels      bitGrid        // Tunnels
+	gateCells    bitGrid        // Gates (see gates.go)
+	gates        [][2]int8      // Gates (row, col), in reading order
+	powerUps     []powerUp      // Power-ups, in reading order
+	teleporters  []teleporter   // Teleporters, in order of their letters
+	wraps        bool           // Whether tunnels connect around the edges
+	numPellets   uint16         // Initial number of pellets
+	pacmanSpawn  *locationState // Spawn location of Pacman
+	fruitSpawn   *locationState // Spawn location of the fruit
+	emptyLoc     *locationState // "Invalid" location (see maze_size.go)
+
+	// Ghost house exit, and the cells just outside and inside of it
+	houseExit     *locationState
+	houseEntrance *locationState
+	houseCenter   *locationState
+
+	// Spawn locations of the ghosts, and the number of ghosts it declares
+	ghostSpawns [numColors]*locationState
+	numGhosts   uint8
+
+	// Distances between the open cells (see pathfinding.go)
+	distances mazeDistances
+}
+
+// Built-in maze profiles (classic, practice, competition, etc.), by name
+var mazeProfiles map[string]*mazeLayout = mustLoadBuiltinMazes()
+
+// The maze layout that new games are played on
+var currMaze *mazeLayout = mazeProfiles[defaultMazeName]
+
+// Mutex accompanying the above variable
+var muMaze sync.RWMutex
+
+// Getter method for the current maze layout
+func getCurrMaze() *mazeLayout {
+	muMaze.RLock()
+	defer muMaze.RUnlock()
+	return currMaze
+}
+
+// Setter method for the current maze layout
+func setCurrMaze(maze *mazeLayout) {
+	muMaze.Lock()
+	{
+		currMaze = maze
+	}
+	muMaze.Unlock()
+}
+
+// Look up one of the built-in maze profiles by name (logging unknown names)
+func lookupMaze(name string) (*mazeLayout, bool) {
+	maze, ok := mazeProfiles[name]
+	if !ok {
+		slog.Error("Unknown maze profile", "name", name)
+	}
+	return maze, ok
+}
+
+// Get the names of the built-in maze profiles, in order
+func MazeNames() []string {
+	names := make([]string, 0, len(mazeProfiles))
+	for name := range mazeProfiles {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+/*
+Find a maze by the name that the serialized state gives it (a built-in
+profile, or the maze loaded from MazeFile) - returns nil if there is none
+*/
+func findMaze(name string) *mazeLayout {
+	if maze, ok := mazeProfiles[name]; ok {
+		return maze
+	}
+	if maze := getCurrMaze(); maze.name == name {
+		return maze
+	}
+	return nil
+}
+
+/*
+Get the plain-text grid of a maze, one line per row, by the name that the
+serialized state gives it - for viewers that only see the maze's name
+*/
+func MazeGrid(name string) ([]string, bool) {
+	maze := findMaze(name)
+	if maze == nil {
+		return nil, false
+	}
+	lines := strings.Split(strings.TrimRight(string(maze.grid), "\r\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, "\r")
+	}
+	return lines, true
+}
+
+// Configure the built-in maze profile to use (if empty, classic is kept)
+func ConfigMaze(name string) {
+
+	// If no profile is given, use the default maze
+	if name == "" {
+		return
+	}
+
+	// Select the profile, for all new games
+	if maze, ok := lookupMaze(name); ok {
+		setCurrMaze(maze)
+		slog.Info("Selected maze profile", "name", name)
+	}
+}
+
+/*
+Configure the maze layout to load from a file (if the path is empty or the
+file can't be loaded, the selected built-in maze is kept)
+*/
+func ConfigMazeFile(path string) {
+
+	// If no file is given, use the built-in maze
+	if path == "" {
+		return
+	}
+
+	// Read the maze layout from the file
+	data, err := os.ReadFile(path)
+	if err != nil {
+		slog.Error("Maze read error", "err", err)
+		return
+	}
+
+	// Name the maze after the file (without any extension)
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+
+	// Parse the layout, and keep the built-in maze if it is invalid
+	maze, err := parseMaze(name, data)
+	if err != nil {
+		slog.Error("Maze parse error", "path", path, "err", err)
+		return
+	}
+
+	// Use the new maze for all new games
+	setCurrMaze(maze)
+	slog.Info("Loaded maze layout", "path", path)
+}
+
+// Load all of the built-in mazes, panicking if any are invalid
+func mustLoadBuiltinMazes() map[string]*mazeLayout {
+
+	// List the embedded maze files
+	entries, err := builtinMazes.ReadDir("mazes")
+	if err != nil {
+		panic(err)
+	}
+
+	// Load each of the mazes, named after their files
+	profiles := make(map[string]*mazeLayout)
+	for _, entry := range entries {
+
+		// Read the maze layout from the embedded files
+		data, err := builtinMazes.ReadFile("mazes/" + entry.Name())
+		if err != nil {
+			panic(err)
+		}
+
+		// Parse the layout
+		name := strings.TrimSuffix(entry.Name(), ".txt")
+		maze, err := parseMaze(name, data)
+		if err != nil {
+			panic(fmt.Sprintf("built-in maze %s: %v", name, err))
+		}
+		profiles[name] = maze
+	}
+	return profiles
+}
+
+// Parse a maze layout from its plain-text grid representation
+func parseMaze(name string, data []byte) (*mazeLayout, error) {
+
+	// Make sure the name can be serialized
+	if len(name) > maxMazeNameLen {
+		return nil, fmt.Errorf("name longer than %d characters", maxMazeNameLen)
+	}
+
+	// Split the grid into rows, ignoring trailing newlines and carriage returns
+	lines := bytes.Split(bytes.TrimRight(data, "\r\n"), []byte("\n"))
+	if len(lines) > int(maxMazeRows) {
+		return nil, fmt.Errorf("more than %d rows", maxMazeRows)
+	}
+
+	// The first row sets the number of columns, which every row must match
+	cols := len(bytes.TrimRight(lines[0], "\r"))
+	if cols == 0 || cols > int(maxMazeCols) {
+		return nil, fmt.Errorf("row 0: expected 1 to %d columns, found %d",
+			maxMazeCols, cols)
+	}
+
+	// New maze layout object
+	maze := mazeLayout{name: name, grid: bytes.Clone(data),
+		rows: int8(len(lines)), cols: int8(cols)}
+	empty := emptyCoord(maze.rows, maze.cols)
+	maze.emptyLoc = newLocationState(empty, empty, none)
+
+	// Ends of the teleporters, by letter (paired up once the grid is read)
+	var teleporterEnds [maxTeleporters][][2]int8
+
+	// Loop over each cell of the grid
+	for row, line := range lines {
+		line = bytes.TrimRight(line, "\r")
+		if len(line) != cols {
+			return nil, fmt.Errorf("row %d: expected %d columns, found %d",
+				row, cols, len(line))
+		}
+
+		for col, cell := range line {
+			switch cell {
+			case '#':
+				maze.walls.set(int8(row), int8(col), true)
+			case '.':
+				maze.pellets.set(int8(row), int8(col), true)
+				maze.numPellets++
+			case 'o':
+				maze.pellets.set(int8(row), int8(col), true)
+				maze.superPellets.set(int8(row), int8(col), true)
+				maze.numPellets++
+			case ' ':
+			case 'H':
+				maze.walls.set(int8(row), int8(col), true)
+				maze.ghostHouse.set(int8(row), int8(col), true)
+			case 'T':
+				maze.tunnels.set(int8(row), int8(col), true)
+			case 'G', 'g':
+				if row == 0 || row == int(maze.rows)-1 || col == 0 ||
+					col == int(maze.cols)-1 {
+					return nil, fmt.Errorf("row %d, col %d: gates can't be on "+
+						"the edges of the maze", row, col)
+				}
+				if len(maze.gates) == maxGates {
+					return nil, fmt.Errorf("row %d, col %d: more than %d gates",
+						row, col, maxGates)
+				}
+				maze.gateCells.set(int8(row), int8(col), true)
+				maze.walls.set(int8(row), int8(col), cell == 'G')
+				maze.gates = append(maze.gates, [2]int8{int8(row), int8(col)})
+			case 'Z', 'S', 'X':
+				if err := maze.addPowerUp(int8(row), int8(col),
+					cell); err != nil {
+					return nil, err
+				}
+			case 'a', 'b', 'c', 'd', 'e', 'f':
+				teleporterEnds[cell-'a'] = append(teleporterEnds[cell-'a'],
+					[2]int8{int8(row), int8(col)})
+			case 'P':
+				if maze.pacmanSpawn != nil {
+					return nil, fmt.Errorf("row %d, col %d: duplicate Pacman spawn",
+						row, col)
+				}
+				maze.pacmanSpawn = newLocationState(int8(row), int8(col), right)
+			case 'F':
+				if maze.fruitSpawn != nil {
+					return nil, fmt.Errorf("row %d, col %d: duplicate fruit spawn",
+						row, col)
+				}
+				maze.fruitSpawn = newLocationState(int8(row), int8(col), none)
+			case '-':
+				if maze.houseExit != nil {
+					return nil, fmt.Errorf("row %d, col %d: duplicate ghost house exit",
+						row, col)
+				}
+				maze.walls.set(int8(row), int8(col), true)
+				maze.houseExit = newLocationState(int8(row), int8(col), none)
+			default:
+
+				// Ghost spawn locations are numbered by color (red excluded)
+				color := uint8(cell - '0')
+				if cell < '1' || color >= numColors {
+					return nil, fmt.Errorf("row %d, col %d: unknown cell '%c'",
+						row, col, cell)
+				}
+				if maze.ghostSpawns[color] != nil {
+					return nil, fmt.Errorf("row %d, col %d: duplicate %s spawn",
+						row, col, ghostNames[color])
+				}
+				maze.walls.set(int8(row), int8(col), true)
+				maze.ghostHouse.set(int8(row), int8(col), true)
+				maze.ghostSpawns[color] = newLocationState(
+					int8(row), int8(col), ghostSpawnDirs[color])
+			}
+		}
+	}
+
+	// Pair up the ends of the teleporters
+	if err := maze.pairTeleporters(teleporterEnds); err != nil {
+		return nil, err
+	}
+
+	// Make sure that the spawn locations were specified
+	if maze.pacmanSpawn == nil {
+		return nil, fmt.Errorf("missing Pacman spawn ('P')")
+	}
+	if maze.fruitSpawn == nil {
+		return nil, fmt.Errorf("missing fruit spawn ('F')")
+	}
+
+	// Check the cells facing each other across the edges of the maze
+	for row := int8(0); row < maze.rows; row++ {
+		if err := maze.checkWrap(row, 0, row, maze.cols-1); err != nil {
+			return nil, err
+		}
+	}
+	for col := int8(0); col < maze.cols; col++ {
+		if err := maze.checkWrap(0, col, maze.rows-1, col); err != nil {
+			return nil, err
+		}
+	}
+
+	// Count the ghosts, whose spawn locations must be numbered without gaps
+	for color := uint8(1); color < numColors; color++ {
+		if maze.ghostSpawns[color] == nil {
+			continue
+		}
+		if maze.ghostSpawns[color-1] == nil && color > 1 {
+			return nil, fmt.Errorf("missing %s spawn ('%d')",
+				ghostNames[color-1], color-1)
+		}
+		maze.numGhosts = color + 1
+	}
+
+	// Ghosts that the maze doesn't declare (if any) spawn nowhere
+	for color := max(maze.numGhosts, 1); color < numColors; color++ {
+		maze.ghostSpawns[color] = newLocationStateCopy(maze.emptyLoc)
+	}
+
+	// A maze without a ghost house has no ghosts
+	if maze.houseExit == nil {
+		maze.ghostSpawns[red] = newLocationStateCopy(maze.emptyLoc)
+		if maze.numGhosts > 0 {
+			return nil, fmt.Errorf("missing ghost house exit ('-')")
+		}
+		maze.computeDistances()
+		if err := maze.validate(); err != nil {
+			return nil, err
+		}
+		return &maze, nil
+	}
+	maze.numGhosts = max(maze.numGhosts, 1)
+
+	// Find the cells just outside and inside of the ghost house exit
+	for dir := uint8(0); dir < numDirs; dir++ {
+		row, col := maze.houseExit.getNeighborCoords(&maze, dir)
+		if !maze.inBounds(row, col) {
+			continue
+		}
+		if maze.ghostHouse.get(row, col) {
+			maze.houseCenter = newLocationState(row, col, none)
+		} else if !maze.walls.get(row, col) {
+			maze.houseEntrance = newLocationState(row, col, none)
+		}
+	}
+	if maze.houseEntrance == nil || maze.houseCenter == nil {
+		return nil, fmt.Errorf("ghost house exit must connect the ghost " +
+			"house to an empty space")
+	}
+
+	// Red spawns at the ghost house entrance
+	maze.ghostSpawns[red] = newLocationStateCopy(maze.houseEntrance)
+	maze.ghostSpawns[red].updateDir(ghostSpawnDirs[red])
+
+	// Find the distances between the open cells, for pathfinding
+	maze.computeDistances()
+
+	// Check that the maze is playable (see maze_validation.go)
+	if err := maze.validate(); err != nil {
+		return nil, err
+	}
+
+	// Return the maze layout
+	return &maze, nil
+}
+
+/*
+Get the number of ghosts in games on the maze (at least the four ghosts of the
+original game, which are hidden if the maze doesn't declare them)
+*/
+func (maze *mazeLayout) ghostSlots() int {
+	return int(max(maze.numGhosts, numClassicGhosts))
+}
+
+// Determines if the ghost house exit is at a given location
+func (maze *mazeLayout) isHouseExit(row int8, col int8) bool {
+	exitRow, exitCol := maze.houseExit.getCoords()
+	return row == exitRow && col == exitCol
+}
+
+/*
+Check a pair of cells on opposite edges of the maze, which connect around the
+edge if both are open - only tunnels may connect this way
+*/
+func (maze *mazeLayout) checkWrap(row1, col1, row2, col2 int8) error {
+	if maze.walls.get(row1, col1) || maze.walls.get(row2, col2) {
+		return nil
+	}
+	if !maze.tunnelAt(row1, col1) || !maze.tunnelAt(row2, col2) {
+		return fmt.Errorf("row %d, col %d: open cells facing each other "+
+			"across the edges must both be tunnels ('T')", row1, col1)
+	}
+	maze.wraps = true
+	return nil
+}
+
+// Determines if a tunnel is at a given location
+func (maze *mazeLayout) tunnelAt(row int8, col int8) bool {
+	if !maze.inBounds(row, col) {
+		return false
+	}
+	return maze.tunnels.get(row, col)
+}
+
+// Determines if a position is within the bounds of the maze
+func (maze *mazeLayout) inBounds(row int8, col int8) bool {
+	return (row >= 0 && row < maze.rows) && (col >= 0 && col < maze.cols)
+}
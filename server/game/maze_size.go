@@ -0,0 +1,96 @@
+package game
+
+/*
+Mazes can have any number of rows and columns, as given by their files (see
+maze.go), up to 63 of each - the most that fit in the 6 bits that serialized
+locations hold each coordinate in, with one value left over for the empty
+location (where Pacman and the ghosts are kept while out of play). The empty
+location is at row and column 32 on mazes of up to 32 rows and columns (as it
+has always been for the classic maze), and at row and column 63 on larger ones.
+
+The maze's cells (e.g. its walls, or the pellets left) are kept as grids of
+bits, one for each cell, sized for the largest maze - so that the grids are
+copied by value along with the game state, whatever the size of the maze.
+Cells off the grid always read as clear.
+*/
+
+// The most rows that a maze can have
+const maxMazeRows int8 = 63
+
+// The most columns that a maze can have
+const maxMazeCols int8 = 63
+
+// The largest maze that keeps the empty location at row and column 32
+const smallMazeSize int8 = 32
+
+// The size of the classic maze (assumed for states serialized without a size)
+const (
+	classicMazeRows int8 = 31
+	classicMazeCols int8 = 28
+)
+
+/*
+The number of rows in the pellets field of the serialized state (those of the
+classic maze) - larger mazes send all of their pellets in an extension
+*/
+const pelletFieldRows int8 = 31
+
+// The number of columns in the pellets field of the serialized state
+const pelletFieldCols int8 = 32
+
+/*
+Get the coordinate of the empty location (both its row and column) on a maze
+with the given number of rows and columns
+*/
+func emptyCoord(rows int8, cols int8) int8 {
+	if rows <= smallMazeSize && cols <= smallMazeSize {
+		return 32
+	}
+	return 63
+}
+
+// A grid of bits, one for each cell of a maze (bit col of each row)
+type bitGrid [maxMazeRows]uint64
+
+// Get the bit of a cell (false for cells off the grid)
+func (grid *bitGrid) get(row int8, col int8) bool {
+	if row < 0 || row >= maxMazeRows || col < 0 || col >= maxMazeCols {
+		return false
+	}
+	return getBit(grid[row], col)
+}
+
+// Set or clear the bit of a cell (ignoring cells off the grid)
+func (grid *bitGrid) set(row int8, col int8, value bool) {
+	if row < 0 || row >= maxMazeRows || col < 0 || col >= maxMazeCols {
+		return
+	}
+	modifyBit(&grid[row], col, value)
+}
+
+// Determines if any bit of a row is set (to skip over empty rows)
+func (grid *bitGrid) rowSet(row int8) bool {
+	return grid[row] != 0
+}
+
+/*
+Get the bits of 32 columns of a row, starting at column 32 * word (for the
+serialized state, whose rows are made of uint32s)
+*/
+func (grid *bitGrid) word(row int8, word int) uint32 {
+	return uint32(grid[row] >> (32 * word))
+}
+
+// Set the bits of 32 columns of a row, starting at column 32 * word
+func (grid *bitGrid) setWord(row int8, word int, bits uint32) {
+	grid[row] &^= uint64(0xffffffff) << (32 * word)
+	grid[row] |= uint64(bits) << (32 * word)
+}
+
+/*
+Get the number of uint32s that each row of a maze's cells takes up in the
+serialized state, for a given number of columns
+*/
+func rowWords(cols int8) int {
+	return (int(cols) + 31) / 32
+}
@@ -0,0 +1,158 @@
+package game
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+Besides being well-formed (see parseMaze), a maze must be playable, which is
+checked as it is loaded so that a broken layout is turned down with a clear
+error rather than behaving strangely in-game (e.g. a level that can never be
+cleared). Every problem found is reported, one per line:
+
+	- every pellet, power-up, teleporter end, the fruit's spawn, and the
+	  ghost house entrance can be reached from Pacman's spawn (with gates
+	  open, see gates.go, and without the teleporters)
+	- every ghost's spawn location can be reached from the ghost house
+	  center, through the ghost house
+	- every tunnel on an edge of the maze faces a tunnel on the opposite
+	  edge, so that no tunnel is a dead end
+
+Maze files can be checked without running the server, with --validate.
+*/
+
+// Check that a parsed maze is playable, returning every problem found
+func (maze *mazeLayout) validate() error {
+	var problems []error
+
+	// Everything Pacman (and the ghosts, once they leave the ghost house)
+	// needs must be connected to Pacman's spawn
+	pRow, pCol := maze.pacmanSpawn.getCoords()
+	for row := int8(0); row < maze.rows; row++ {
+		for col := int8(0); col < maze.cols && maze.pellets.rowSet(row); col++ {
+			if maze.pellets.get(row, col) &&
+				maze.mazeDist(pRow, pCol, row, col) < 0 {
+				problems = append(problems, fmt.Errorf("row %d, col %d: "+
+					"pellet can't be reached from Pacman's spawn", row, col))
+			}
+		}
+	}
+	for _, p := range maze.powerUps {
+		if maze.mazeDist(pRow, pCol, p.row, p.col) < 0 {
+			problems = append(problems, fmt.Errorf("row %d, col %d: "+
+				"power-up can't be reached from Pacman's spawn", p.row, p.col))
+		}
+	}
+	for _, t := range maze.teleporters {
+		for _, end := range t.ends {
+			if maze.mazeDist(pRow, pCol, end[0], end[1]) < 0 {
+				problems = append(problems, fmt.Errorf("row %d, col %d: "+
+					"teleporter can't be reached from Pacman's spawn", end[0],
+					end[1]))
+			}
+		}
+	}
+	fRow, fCol := maze.fruitSpawn.getCoords()
+	if maze.mazeDist(pRow, pCol, fRow, fCol) < 0 {
+		problems = append(problems, fmt.Errorf("row %d, col %d: fruit spawn "+
+			"can't be reached from Pacman's spawn", fRow, fCol))
+	}
+	if maze.houseEntrance != nil {
+		eRow, eCol := maze.houseEntrance.getCoords()
+		if maze.mazeDist(pRow, pCol, eRow, eCol) < 0 {
+			problems = append(problems, fmt.Errorf("row %d, col %d: ghost "+
+				"house exit can't be reached from Pacman's spawn", eRow, eCol))
+		}
+	}
+
+	// The ghosts must be able to reach the exit from their spawn locations
+	if maze.houseCenter != nil {
+		inside := maze.ghostHouseCells()
+		for color := uint8(1); color < maze.numGhosts; color++ {
+			row, col := maze.ghostSpawns[color].getCoords()
+			if !inside.get(row, col) {
+				problems = append(problems, fmt.Errorf("row %d, col %d: %s "+
+					"spawn can't reach the ghost house exit", row, col,
+					ghostNames[color]))
+			}
+		}
+	}
+
+	// Tunnels on the edges must lead somewhere
+	for row := int8(0); row < maze.rows; row++ {
+		for col := int8(0); col < maze.cols; col++ {
+			if err := maze.checkTunnelEnd(row, col); err != nil {
+				problems = append(problems, err)
+			}
+		}
+	}
+
+	return errors.Join(problems...)
+}
+
+/*
+Find the cells of the ghost house that are connected to its center (where the
+ghosts leave from), moving through the ghost house only
+*/
+func (maze *mazeLayout) ghostHouseCells() *bitGrid {
+	var inside bitGrid
+	row, col := maze.houseCenter.getCoords()
+	inside.set(row, col, true)
+	queue := [][2]int8{{row, col}}
+	for len(queue) > 0 {
+		row, col := queue[0][0], queue[0][1]
+		queue = queue[1:]
+		for dir := uint8(0); dir < numDirs; dir++ {
+			nextRow, nextCol := row+dRow[dir], col+dCol[dir]
+			if inside.get(nextRow, nextCol) ||
+				!maze.ghostHouse.get(nextRow, nextCol) {
+				continue
+			}
+			inside.set(nextRow, nextCol, true)
+			queue = append(queue, [2]int8{nextRow, nextCol})
+		}
+	}
+	return &inside
+}
+
+/*
+Check a tunnel on an edge of the maze, which must face a tunnel on the
+opposite edge (cells that aren't tunnels on an edge are left alone)
+*/
+func (maze *mazeLayout) checkTunnelEnd(row, col int8) error {
+	if !maze.tunnelAt(row, col) {
+		return nil
+	}
+	var facing [][2]int8
+	if col == 0 || col == maze.cols-1 {
+		facing = append(facing, [2]int8{row, maze.cols - 1 - col})
+	}
+	if row == 0 || row == maze.rows-1 {
+		facing = append(facing, [2]int8{maze.rows - 1 - row, col})
+	}
+	for _, cell := range facing {
+		if !maze.tunnelAt(cell[0], cell[1]) {
+			return fmt.Errorf("row %d, col %d: tunnel on the edge of the "+
+				"maze doesn't face a tunnel on the opposite edge", row, col)
+		}
+	}
+	return nil
+}
+
+/*
+Check the maze layout in a file, as it would be loaded with MazeFile (exported
+for --validate) - returns every problem found
+*/
+func ValidateMazeFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	_, err = parseMaze(name, data)
+	return err
+}
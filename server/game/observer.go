@@ -0,0 +1,136 @@
+package game
+
+import (
+	"log/slog"
+	"slices"
+)
+
+/*
+Observers let other code follow a game as it is played, through typed hooks
+rather than the event log's JSON (see events.go) - e.g. loggers, stats
+collectors, or bridges to webhooks and overlays. An observer is registered on
+a game engine, and stays registered across restarts of its game:
+
+	type pelletCounter struct {
+		game.NopObserver
+		pellets int
+	}
+
+	func (pc *pelletCounter) OnPelletEaten(ev game.EventInfo, row, col int8,
+		super bool) {
+		pc.pellets++
+	}
+
+	ge.AddObserver(&pelletCounter{})
+
+The hooks are called from the game engine's go-routine, as the events happen
+(and in the same order as the event log), so they shouldn't block - anything
+slow should be handed off to another go-routine. Simulated games, such as the
+ones that bots plan with, don't call any hooks.
+*/
+
+// An observer of the events of a game (embed NopObserver to only handle some)
+type Observer interface {
+	OnPelletEaten(ev EventInfo, row, col int8, super bool)  // Pellet eaten
+	OnGhostEaten(ev EventInfo, ghost string, points uint16) // Ghost eaten
+	OnPacmanCaught(ev EventInfo, ghost string, lives uint8) // Lives left
+	OnModeChange(ev EventInfo, from string, to string)      // Mode names
+	OnLevelComplete(ev EventInfo, level uint8)              // Level cleared
+	OnFruitSpawn(ev EventInfo, row, col int8)               // Fruit spawned
+}
+
+// The state of the game when an event happened, for observers
+type EventInfo struct {
+	Room  string // Room the game is played in ("" for the default room)
+	Tick  uint16 // Tick the event happened on
+	Score uint16 // Score after the event
+	Level uint8  // Level the event happened on
+	Lives uint8  // Lives left (before losing one, if Pacman was caught)
+}
+
+// An observer that ignores every event, for observers to embed
+type NopObserver struct{}
+
+func (NopObserver) OnPelletEaten(EventInfo, int8, int8, bool) {}
+func (NopObserver) OnGhostEaten(EventInfo, string, uint16)    {}
+func (NopObserver) OnPacmanCaught(EventInfo, string, uint8)   {}
+func (NopObserver) OnModeChange(EventInfo, string, string)    {}
+func (NopObserver) OnLevelComplete(EventInfo, uint8)          {}
+func (NopObserver) OnFruitSpawn(EventInfo, int8, int8)        {}
+
+/*
+An observer which logs the events that aren't logged anywhere else (registered
+on every game engine)
+*/
+type logObserver struct {
+	NopObserver
+}
+
+// Log a change of the game mode
+func (logObserver) OnModeChange(ev EventInfo, from string, to string) {
+	slog.Info("Mode changed", "from", from, "to", to, "tick", ev.Tick)
+}
+
+// Register an observer on the game engine's games
+func (ge *GameEngine) AddObserver(obs Observer) {
+	ge.rules.muObservers.Lock()
+	{
+		ge.rules.observers = append(ge.rules.observers, obs)
+	}
+	ge.rules.muObservers.Unlock()
+}
+
+// Unregister an observer from the game engine's games
+func (ge *GameEngine) RemoveObserver(obs Observer) {
+	ge.rules.muObservers.Lock()
+	{
+		ge.rules.observers = slices.DeleteFunc(ge.rules.observers,
+			func(o Observer) bool { return o == obs })
+	}
+	ge.rules.muObservers.Unlock()
+}
+
+// Call the observers' hook for a game event (if the event has one)
+func (gs *gameState) notifyObservers(event string, details map[string]any) {
+
+	// Take a copy of the observers, so that they can (un)register others
+	gs.rules.muObservers.RLock()
+	observers := slices.Clone(gs.rules.observers)
+	gs.rules.muObservers.RUnlock()
+	if len(observers) == 0 {
+		return
+	}
+
+	// The details of each event are as logged (see events.go)
+	ev := EventInfo{
+		Room:  gs.rules.room,
+		Tick:  gs.getCurrTicks(),
+		Score: gs.getScore(),
+		Level: gs.getLevel(),
+		Lives: gs.getLives(),
+	}
+	row, _ := details["row"].(int8)
+	col, _ := details["col"].(int8)
+	ghost, _ := details["ghost"].(string)
+	for _, obs := range observers {
+		switch event {
+		case eventPellet, eventSuperPellet:
+			obs.OnPelletEaten(ev, row, col, event == eventSuperPellet)
+		case eventGhostEaten:
+			points, _ := details["points"].(uint16)
+			obs.OnGhostEaten(ev, ghost, points)
+		case eventPacmanCaught:
+			lives, _ := details["lives"].(uint8)
+			obs.OnPacmanCaught(ev, ghost, lives)
+		case eventModeChange:
+			from, _ := details["from"].(string)
+			to, _ := details["to"].(string)
+			obs.OnModeChange(ev, from, to)
+		case eventLevelComplete:
+			level, _ := details["level"].(uint8)
+			obs.OnLevelComplete(ev, level)
+		case eventFruitSpawned:
+			obs.OnFruitSpawn(ev, row, col)
+		}
+	}
+}
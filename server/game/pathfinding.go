@@ -0,0 +1,286 @@
+package game
+
+import (
+	"container/heap"
+	"fmt"
+)
+
+/*
+Maze distances are the lengths of the shortest paths between cells (moving one
+cell up, down, left, or right at a time, and never through walls), as opposed
+to the straight-line distances that the ghosts normally aim with. Since mazes
+are small and never change during a game, the distances between every pair of
+open cells are found once, with a breadth-first search from each cell, when
+the maze is loaded - so looking one up is just an array access. Gates (see
+gates.go) count as open cells, whether they are open or closed.
+
+Paths between two cells are found with an A* search, guided by the Manhattan
+distance to the goal, and returned as a sequence of moves (directions). Ties
+between equally promising cells are broken by the order they were found in,
+so the same query always gets the same path.
+*/
+
+// Distance marking a pair of cells that aren't connected
+const unreachableDist uint16 = 0xffff
+
+// Pre-computed distances between the open cells of a maze
+type mazeDistances struct {
+	cellIdx  [maxMazeRows][maxMazeCols]int16 // Open cell indices (-1 = wall)
+	numCells int                             // Number of open cells
+	dists    []uint16                        // Distances, by cell pairs
+}
+
+// Find the distances between every pair of open cells of a maze
+func (maze *mazeLayout) computeDistances() {
+	md := &maze.distances
+
+	// Number the open cells, remembering the coordinates of each
+	var coords [][2]int8
+	for row := int8(0); row < maxMazeRows; row++ {
+		for col := int8(0); col < maxMazeCols; col++ {
+			md.cellIdx[row][col] = -1
+			if maze.inBounds(row, col) && (!maze.walls.get(row, col) ||
+				maze.gateCells.get(row, col)) {
+				md.cellIdx[row][col] = int16(len(coords))
+				coords = append(coords, [2]int8{row, col})
+			}
+		}
+	}
+	md.numCells = len(coords)
+
+	// Search outwards from each open cell, filling in its row of the table
+	md.dists = make([]uint16, md.numCells*md.numCells)
+	queue := make([]int16, 0, md.numCells)
+	for src := range coords {
+		dists := md.dists[src*md.numCells : (src+1)*md.numCells]
+		for idx := range dists {
+			dists[idx] = unreachableDist
+		}
+		dists[src] = 0
+		queue = append(queue[:0], int16(src))
+		for len(queue) > 0 {
+
+			// Visit the next cell, adding its unvisited neighbors to the queue
+			curr := queue[0]
+			queue = queue[1:]
+			row, col := coords[curr][0], coords[curr][1]
+			for dir := uint8(0); dir < numDirs; dir++ {
+				next := md.index(maze.wrapCoords(row+dRow[dir], col+dCol[dir]))
+				if next >= 0 && dists[next] == unreachableDist {
+					dists[next] = dists[curr] + 1
+					queue = append(queue, next)
+				}
+			}
+		}
+	}
+}
+
+// Get the index of an open cell (-1 for walls and cells out of bounds)
+func (md *mazeDistances) index(row, col int8) int16 {
+	if row < 0 || row >= maxMazeRows || col < 0 || col >= maxMazeCols {
+		return -1
+	}
+	return md.cellIdx[row][col]
+}
+
+/*
+Get the maze distance between two cells, in moves - returns -1 if either cell
+is a wall (from Pacman's perspective) or out of bounds, or if there is no path
+between them
+*/
+func (maze *mazeLayout) mazeDist(row1, col1, row2, col2 int8) int {
+	md := &maze.distances
+	idx1, idx2 := md.index(row1, col1), md.index(row2, col2)
+	if idx1 < 0 || idx2 < 0 {
+		return -1
+	}
+	dist := md.dists[int(idx1)*md.numCells+int(idx2)]
+	if dist == unreachableDist {
+		return -1
+	}
+	return int(dist)
+}
+
+// Get the maze distance between two cells in the game's maze (see above)
+func (gs *gameState) mazeDist(row1, col1, row2, col2 int8) int {
+	return gs.maze.mazeDist(row1, col1, row2, col2)
+}
+
+/*
+Multiplier of the maze distances of a ghost's moves, so that the (squared)
+straight-line distances, which are always smaller, only break ties between them
+(if the ghosts' tie-break is "distance")
+*/
+const mazeDistScale = 1 << 17
+
+/*
+Replace the straight-line distances of a ghost's valid moves with their maze
+distances to the target, with ties broken by the straight-line distances if
+the rules say so (so that of two equally short paths, the ghost takes the one
+that heads towards the target) - unless the target or any of the moves isn't an open cell (e.g. a
+scatter target, or a move within the ghost house), in which case the
+straight-line distances are kept, so that all moves are compared alike
+*/
+func (gs *gameState) useMazeDist(moveDist *[numDirs]int,
+	moveValid [numDirs]bool, loc *locationState, targetRow, targetCol int8) {
+	var dists [numDirs]int
+	for dir := uint8(0); dir < numDirs; dir++ {
+		if !moveValid[dir] {
+			continue
+		}
+		row, col := loc.getNeighborCoords(gs.maze, dir)
+		dists[dir] = gs.mazeDist(row, col, targetRow, targetCol)
+		if dists[dir] < 0 {
+			return
+		}
+	}
+	byDistance := gs.rules.GhostTieBreak == "distance"
+	for dir := uint8(0); dir < numDirs; dir++ {
+		if !moveValid[dir] {
+			continue
+		}
+		if byDistance {
+			moveDist[dir] = dists[dir]*mazeDistScale + moveDist[dir]
+		} else {
+			moveDist[dir] = dists[dir]
+		}
+	}
+}
+
+// A cell waiting to be expanded by the A* search
+type pathNode struct {
+	idx      int16 // Index of the cell
+	estimate int   // Distance so far, plus the estimate to the goal
+	order    int   // Order in which the cell was queued (to break ties)
+}
+
+// A priority queue of cells for the A* search (implements heap.Interface)
+type pathQueue []pathNode
+
+func (pq pathQueue) Len() int { return len(pq) }
+
+func (pq pathQueue) Less(i, j int) bool {
+	if pq[i].estimate != pq[j].estimate {
+		return pq[i].estimate < pq[j].estimate
+	}
+	return pq[i].order < pq[j].order
+}
+
+func (pq pathQueue) Swap(i, j int) { pq[i], pq[j] = pq[j], pq[i] }
+
+func (pq *pathQueue) Push(node any) { *pq = append(*pq, node.(pathNode)) }
+
+func (pq *pathQueue) Pop() any {
+	node := (*pq)[len(*pq)-1]
+	*pq = (*pq)[:len(*pq)-1]
+	return node
+}
+
+// Manhattan distance between two cells (never more than their maze distance)
+func manhattanDist(row1, col1, row2, col2 int8) int {
+	return abs(int(row2)-int(row1)) + abs(int(col2)-int(col1))
+}
+
+/*
+Estimate the maze distance between two cells for the path search (never more
+than it) - the Manhattan distance, unless the maze's tunnels wrap around its
+edges, in which case the shortcuts around the edges are counted too
+*/
+func (maze *mazeLayout) estimateDist(row1, col1, row2, col2 int8) int {
+	if !maze.wraps {
+		return manhattanDist(row1, col1, row2, col2)
+	}
+	dRows, dCols := abs(int(row2)-int(row1)), abs(int(col2)-int(col1))
+	return min(dRows, int(maze.rows)-dRows) + min(dCols, int(maze.cols)-dCols)
+}
+
+// Absolute value of an integer
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+/*
+Find a shortest path between two open cells of a maze with an A* search,
+returning the moves (directions) to follow from the first cell - returns an
+error if either cell is a wall or out of bounds, or if there is no path
+*/
+func (maze *mazeLayout) findPath(fromRow, fromCol, toRow,
+	toCol int8) ([]uint8, error) {
+	md := &maze.distances
+
+	// Check that both cells are open
+	src, dst := md.index(fromRow, fromCol), md.index(toRow, toCol)
+	if src < 0 {
+		return nil, fmt.Errorf("(%d, %d) is not an open cell", fromRow, fromCol)
+	}
+	if dst < 0 {
+		return nil, fmt.Errorf("(%d, %d) is not an open cell", toRow, toCol)
+	}
+
+	// Coordinates of each open cell, by index
+	coords := make([][2]int8, md.numCells)
+	for row := int8(0); row < maze.rows; row++ {
+		for col := int8(0); col < maze.cols; col++ {
+			if idx := md.cellIdx[row][col]; idx >= 0 {
+				coords[idx] = [2]int8{row, col}
+			}
+		}
+	}
+
+	// Distance to each cell so far, and the move that reached it
+	dists := make([]int, md.numCells)
+	for idx := range dists {
+		dists[idx] = -1
+	}
+	moves := make([]uint8, md.numCells)
+	dists[src] = 0
+
+	// Expand the most promising cell until the goal is reached
+	pq := pathQueue{{idx: src,
+		estimate: maze.estimateDist(fromRow, fromCol, toRow, toCol)}}
+	for order := 1; pq.Len() > 0 && dists[dst] < 0; {
+		curr := heap.Pop(&pq).(pathNode)
+		row, col := coords[curr.idx][0], coords[curr.idx][1]
+		for dir := uint8(0); dir < numDirs; dir++ {
+			nextRow, nextCol := maze.wrapCoords(row+dRow[dir], col+dCol[dir])
+			next := md.index(nextRow, nextCol)
+			if next < 0 || (dists[next] >= 0 &&
+				dists[next] <= dists[curr.idx]+1) {
+				continue
+			}
+			dists[next] = dists[curr.idx] + 1
+			moves[next] = dir
+			heap.Push(&pq, pathNode{idx: next, order: order,
+				estimate: dists[next] +
+					maze.estimateDist(nextRow, nextCol, toRow, toCol)})
+			order++
+		}
+	}
+	if dists[dst] < 0 {
+		return nil, fmt.Errorf("no path from (%d, %d) to (%d, %d)",
+			fromRow, fromCol, toRow, toCol)
+	}
+
+	// Walk back from the goal, to list the moves in order
+	path := make([]uint8, dists[dst])
+	for idx, step := dst, dists[dst]-1; step >= 0; step-- {
+		path[step] = moves[idx]
+		row, col := coords[idx][0], coords[idx][1]
+		idx = md.index(maze.wrapCoords(row-dRow[moves[idx]],
+			col-dCol[moves[idx]]))
+	}
+	return path, nil
+}
+
+/*
+Find a shortest path between two cells of the maze that the room's games are
+played on (see findPath) - exported for clients' path queries, and safe to
+call while the engine loop runs
+*/
+func (ge *GameEngine) FindPath(fromRow, fromCol, toRow,
+	toCol int8) ([]uint8, error) {
+	return ge.rules.getMaze().findPath(fromRow, fromCol, toRow, toCol)
+}
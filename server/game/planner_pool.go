@@ -0,0 +1,155 @@
+package game
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+/*
+The live game's ghosts are planned by long-lived planner workers, one per
+ghost, rather than by go-routines spawned for every update. Each update, the
+engine loop hands each worker its ghost and waits for all of them to finish
+(a barrier, built from a fan-out and a fan-in over channels):
+
+	engine:   plan ──┬─> worker 0 (red)    ──┬─> next step
+	                 ├─> worker 1 (pink)   ──┤
+	                 ├─> worker 2 (cyan)   ──┤
+	                 └─> worker 3 (orange) ──┘
+
+Planning a ghost only reads the shared game state, and only writes the ghost's
+own next move, trapped steps, and random number generator, so the plans are the
+same as if the ghosts were planned one after another (which copies of the game
+still do, see game_copy.go). Each worker times its ghost's plans, so that the
+planning latency of each ghost can be checked (see PlannerStats).
+*/
+
+// Planning latency of a ghost, exported for health checks
+type PlannerStats struct {
+	Ghost string        // Name of the ghost
+	Last  time.Duration // Time taken by the last plan
+	Mean  time.Duration // Mean time taken by a plan
+	Max   time.Duration // Longest time taken by a plan
+	Plans uint64        // Number of plans timed
+}
+
+// Planning latency of a ghost, as measured by its worker
+type plannerTimes struct {
+	last  atomic.Int64 // Time taken by the last plan (ns)
+	total atomic.Int64 // Total time taken by the plans (ns)
+	max   atomic.Int64 // Longest time taken by a plan (ns)
+	plans atomic.Uint64
+}
+
+/*
+A pool of planner workers, owned by a game engine loop (workers are started as
+ghosts need them, up to one for each color)
+*/
+type plannerPool struct {
+	jobs    []chan *ghostState      // Ghosts to plan, one channel per worker
+	done    chan struct{}           // Signals from the workers that a plan is done
+	times   [numColors]plannerTimes // Planning latency of each worker's ghost
+	workers atomic.Int32            // Number of workers started
+}
+
+// Create a pool of planner workers, one for each of a number of ghosts
+func newPlannerPool(numGhosts int) *plannerPool {
+	pp := plannerPool{done: make(chan struct{}, numColors)}
+	pp.addWorkers(numGhosts)
+	return &pp
+}
+
+// Start planner workers, until there is one for each of a number of ghosts
+func (pp *plannerPool) addWorkers(numGhosts int) {
+	for len(pp.jobs) < numGhosts {
+		jobs := make(chan *ghostState, 1)
+		go pp.work(jobs, &pp.times[len(pp.jobs)])
+		pp.jobs = append(pp.jobs, jobs)
+		pp.workers.Add(1)
+	}
+}
+
+// Plan each ghost handed to a worker, timing each plan
+func (pp *plannerPool) work(jobs <-chan *ghostState, times *plannerTimes) {
+	for ghost := range jobs {
+		start := time.Now()
+		ghost.planMove()
+		elapsed := int64(time.Since(start))
+
+		// Only this worker writes its times, so there is no need to lock
+		times.last.Store(elapsed)
+		times.total.Add(elapsed)
+		times.max.Store(max(times.max.Load(), elapsed))
+		times.plans.Add(1)
+
+		pp.done <- struct{}{}
+	}
+}
+
+// Plan the next move of each ghost, waiting until all of them are planned
+func (pp *plannerPool) planAll(ghosts []*ghostState) {
+	pp.addWorkers(len(ghosts))
+	for idx, ghost := range ghosts {
+		pp.jobs[idx] <- ghost
+	}
+	for range ghosts {
+		<-pp.done
+	}
+}
+
+// Stop the planner workers
+func (pp *plannerPool) stop() {
+	for _, jobs := range pp.jobs {
+		close(jobs)
+	}
+}
+
+// Get the planning latency of each ghost (in the order of their colors)
+func (pp *plannerPool) getStats() []PlannerStats {
+	stats := make([]PlannerStats, pp.workers.Load())
+	for idx := range stats {
+		times := &pp.times[idx]
+		stats[idx] = PlannerStats{
+			Ghost: ghostNames[idx],
+			Last:  time.Duration(times.last.Load()),
+			Max:   time.Duration(times.max.Load()),
+			Plans: times.plans.Load(),
+		}
+		if stats[idx].Plans != 0 {
+			stats[idx].Mean = time.Duration(times.total.Load() /
+				int64(stats[idx].Plans))
+		}
+	}
+	return stats
+}
+
+// Start the planner workers of the engine loop
+func (ge *GameEngine) startPlanners() {
+	ge.planners.Store(newPlannerPool(len(ge.state.ghosts)))
+}
+
+// Stop the planner workers of the engine loop, once it stops
+func (ge *GameEngine) stopPlanners() {
+	if pp := ge.planners.Swap(nil); pp != nil {
+		pp.stop()
+	}
+}
+
+/*
+Plan the next move of each ghost of the live game, with the engine loop's
+planner workers if it is running (or one after another, if not)
+*/
+func (ge *GameEngine) planGhosts() {
+	if pp := ge.planners.Load(); pp != nil {
+		pp.planAll(ge.state.ghosts)
+		return
+	}
+	ge.state.planAllGhosts()
+}
+
+// Get the planning latency of each ghost (for health checks)
+func (ge *GameEngine) PlannerStats() []PlannerStats {
+	if pp := ge.planners.Load(); pp != nil {
+		return pp.getStats()
+	}
+	return nil
+}
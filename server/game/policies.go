@@ -0,0 +1,136 @@
+package game
+
+import (
+	"math/rand"
+	"sort"
+)
+
+/*
+Built-in policies for Pacman, which decide its move for each step of a
+simulated game - baselines for benchmarking bots against (see the tournament
+runner in the main package), and stand-ins for a bot when testing rule
+changes:
+
+	stay   - never moves
+	random - moves in a random open direction (chosen from a generator seeded
+	         by the game's seed and state hash, so that the same game plays
+	         out the same way)
+	greedy - heads for the nearest pellet by maze distance, avoiding cells
+	         within two moves of a dangerous (not frightened) ghost
+*/
+
+// A policy for Pacman, choosing a direction to move in for the next step
+type Policy func(sim *Simulation) uint8
+
+// The built-in policies, by name
+var builtinPolicies = map[string]Policy{
+	"stay":   stayPolicy,
+	"random": randomPolicy,
+	"greedy": greedyPolicy,
+}
+
+// Get a built-in policy by name
+func BuiltinPolicy(name string) (Policy, bool) {
+	policy, ok := builtinPolicies[name]
+	return policy, ok
+}
+
+// Get the names of the built-in policies, in alphabetical order
+func PolicyNames() []string {
+	names := make([]string, 0, len(builtinPolicies))
+	for name := range builtinPolicies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Get the directions Pacman can move in from its current cell
+func (gs *gameState) openDirs() []uint8 {
+	row, col := gs.pacmanLoc.getCoords()
+	dirs := make([]uint8, 0, numDirs)
+	for dir := uint8(0); dir < numDirs; dir++ {
+		if !gs.wallAt(gs.maze.wrapCoords(row+dRow[dir], col+dCol[dir])) {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// Never move
+func stayPolicy(sim *Simulation) uint8 {
+	return numDirs
+}
+
+// Move in a random open direction
+func randomPolicy(sim *Simulation) uint8 {
+	dirs := sim.state.openDirs()
+	if len(dirs) == 0 {
+		return numDirs
+	}
+	rng := rand.New(rand.NewSource(sim.state.seed ^ int64(sim.Hash())))
+	return dirs[rng.Intn(len(dirs))]
+}
+
+/*
+Get the maze distance from a cell to the nearest dangerous ghost (one that is
+in play and not frightened), or -1 if there is none
+*/
+func (gs *gameState) ghostDanger(row, col int8) int {
+	nearest := -1
+	for _, g := range gs.ghosts {
+		if g.isFrightened() || g.isEaten() || g.isSpawning() {
+			continue
+		}
+		gRow, gCol := g.loc.getCoords()
+		if dist := gs.mazeDist(row, col, gRow, gCol); dist >= 0 &&
+			(nearest < 0 || dist < nearest) {
+			nearest = dist
+		}
+	}
+	return nearest
+}
+
+// Get the maze distance from a cell to the nearest pellet (-1 if none is left)
+func (gs *gameState) nearestPellet(row, col int8) int {
+	nearest := -1
+	for pRow := int8(0); pRow < gs.maze.rows; pRow++ {
+		for pCol := int8(0); pCol < gs.maze.cols; pCol++ {
+			if !gs.pelletAt(pRow, pCol) {
+				continue
+			}
+			if dist := gs.mazeDist(row, col, pRow, pCol); dist >= 0 &&
+				(nearest < 0 || dist < nearest) {
+				nearest = dist
+			}
+		}
+	}
+	return nearest
+}
+
+// The closest a greedy Pacman lets a dangerous ghost get, in moves
+const greedySafeDist = 2
+
+// Head for the nearest pellet, avoiding cells near dangerous ghosts
+func greedyPolicy(sim *Simulation) uint8 {
+	gs := sim.state
+	row, col := gs.pacmanLoc.getCoords()
+
+	// Prefer safe moves (nearest the pellets), then unsafe ones (furthest from
+	// the ghosts), staying put if there are no moves
+	best, bestSafe, bestCost := numDirs, false, 0
+	for _, dir := range gs.openDirs() {
+		nextRow, nextCol := gs.maze.wrapCoords(row+dRow[dir], col+dCol[dir])
+		danger := gs.ghostDanger(nextRow, nextCol)
+		safe := danger < 0 || danger > greedySafeDist
+		cost := -danger
+		if safe {
+			cost = gs.nearestPellet(nextRow, nextCol)
+		}
+		if best == numDirs || (safe && !bestSafe) ||
+			(safe == bestSafe && cost < bestCost) {
+			best, bestSafe, bestCost = dir, safe, cost
+		}
+	}
+	return best
+}
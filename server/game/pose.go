@@ -0,0 +1,80 @@
+package game
+
+import (
+	"math"
+)
+
+/*
+Alongside its cell, Pacman has a continuous pose: its fractional row and
+column (in cell units, with the center of each cell at whole numbers) and its
+heading (in radians, measured from the direction of increasing columns towards
+increasing rows, so right = 0 and down = pi/2). While the tracking system is
+reporting the robot's position (see localization.go), the pose is its filtered
+estimate of the robot, with the heading it reports (if any). Otherwise - and
+as soon as Pacman leaves the cell the pose was tracked in, e.g. after a
+direction move or a respawn - the pose is the center of Pacman's cell, facing
+its direction.
+
+The pose is sent with each broadcast state (see serPacmanPose), so that
+visualizers can render the robot's motion smoothly between cells.
+*/
+
+// Pacman's continuous pose, as last tracked
+type poseState struct {
+	tracked          bool    // Whether the pose has been tracked
+	row, col         float32 // Fractional row and column
+	heading          float32 // Heading in radians (NaN to use the direction)
+	cellRow, cellCol int8    // Pacman's cell when the pose was tracked
+}
+
+// Get the heading of a direction (0 if there is none)
+func dirHeading(dir uint8) float32 {
+	if dir >= numDirs {
+		return 0
+	}
+	return float32(math.Atan2(float64(dRow[dir]), float64(dCol[dir])))
+}
+
+/*
+Set Pacman's pose from the tracking system, while it is in its current cell
+(a heading that isn't finite falls back on Pacman's direction)
+*/
+func (gs *gameState) setPacmanPose(row, col, heading float64) {
+	if math.IsNaN(heading) || math.IsInf(heading, 0) {
+		heading = math.NaN()
+	}
+	cellRow, cellCol := gs.pacmanLoc.getCoords()
+	ps := &gs.pacmanPose
+	ps.tracked = true
+	ps.row, ps.col, ps.heading = float32(row), float32(col), float32(heading)
+	ps.cellRow, ps.cellCol = cellRow, cellCol
+}
+
+// Get Pacman's pose (the center of its cell, unless tracked there)
+func (gs *gameState) getPacmanPose() (row, col, heading float32) {
+
+	// Pacman's cell and direction
+	cellRow, cellCol := gs.pacmanLoc.getCoords()
+	heading = dirHeading(gs.pacmanLoc.getDir())
+
+	// Use the tracked pose, if it is still in Pacman's cell
+	ps := &gs.pacmanPose
+	if ps.tracked && ps.cellRow == cellRow && ps.cellCol == cellCol {
+		if !math.IsNaN(float64(ps.heading)) {
+			heading = ps.heading
+		}
+		return ps.row, ps.col, heading
+	}
+	return float32(cellRow), float32(cellCol), heading
+}
+
+// Serialize Pacman's pose (12 bytes: row, column, and heading, as float32s)
+func (gs *gameState) serPacmanPose(outputBuf []byte, startIdx int) int {
+
+	// Serialize the pose, and return the starting index of the next field
+	row, col, heading := gs.getPacmanPose()
+	for _, x := range [...]float32{row, col, heading} {
+		startIdx = serUint32(math.Float32bits(x), outputBuf, startIdx)
+	}
+	return startIdx
+}
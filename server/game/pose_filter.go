@@ -0,0 +1,167 @@
+package game
+
+import (
+	"log/slog"
+	"math"
+)
+
+/*
+The positions reported by the tracking system (see localization.go) are noisy,
+so they are filtered before they move Pacman: each report updates an estimate
+of the robot's position, either as a moving average of the latest reports, or
+with a simple Kalman filter (per axis, assuming the robot stays put between
+reports, with a process noise given by its top speed and a measurement noise
+given by the camera's). Reports further from the estimate than the robot could
+have moved since the last accepted report (at its top speed, give or take a
+cell) are rejected as impossible jumps - unless several arrive in a row, in
+which case the robot really is there (e.g. it was picked up and put down), and
+the filter starts over from the latest report.
+
+The estimate is then snapped to a grid cell with some hysteresis, so that
+Pacman only leaves its cell once the estimate is clearly past the cell's edge,
+rather than flickering between two cells while the robot sits on the boundary.
+The filter starts over whenever the game is paused, as the robot is usually
+being carried back into place.
+*/
+
+// Kinds of filter for the tracking system's reports
+const (
+	poseFilterNone    = "none"    // Use each report as is
+	poseFilterAverage = "average" // Moving average of the latest reports
+	poseFilterKalman  = "kalman"  // Simple Kalman filter
+)
+
+// The kind of filter for the tracking system's reports
+var poseFilterKind string = poseFilterKalman
+
+// The number of reports averaged by the moving average filter
+var poseFilterWindow int = 5
+
+// The standard deviation of the camera's noise, in cells
+var poseNoise float64 = 0.15
+
+// The robot's top speed, in cells per second
+var poseMaxSpeed float64 = 8
+
+/*
+Configure the filter for the tracking system's reports ("none", "average", or
+"kalman", the default if left out), the number of reports in the moving average, the standard deviation
+of the camera's noise (in cells), and the robot's top speed (in cells per
+second)
+*/
+func ConfigPoseFilter(kind string, window int, noise float64,
+	maxSpeed float64) {
+	switch kind {
+	case poseFilterNone, poseFilterAverage, poseFilterKalman:
+		poseFilterKind = kind
+	case "":
+		poseFilterKind = poseFilterKalman
+	default:
+		slog.Warn("Unknown pose filter, using a Kalman filter instead",
+			"filter", kind)
+		poseFilterKind = poseFilterKalman
+	}
+	poseFilterWindow = max(window, 1)
+	poseNoise = max(noise, 0.01)
+	poseMaxSpeed = max(maxSpeed, 0)
+}
+
+// Distance (in cells) a report can stray beyond how far the robot could move
+const poseJumpSlack float64 = 1
+
+// Impossible jumps in a row before the filter starts over from the latest
+const poseReacquireReports int = 5
+
+// Distance (in cells) past a cell's edge the estimate must reach to leave it
+const poseHysteresis float64 = 0.15
+
+// The filter's estimate of the robot's position, from the reports so far
+type poseFilter struct {
+	active   bool         // Whether there is an estimate yet
+	row, col float64      // Estimated position, in cells
+	variance float64      // Variance of the estimate (Kalman filter)
+	samples  [][2]float64 // Latest reports (moving average filter)
+	lastTick uint16       // Tick of the last accepted report
+	rejected int          // Impossible jumps in a row
+}
+
+// Start the filter over from a report
+func (pf *poseFilter) restart(row, col float64, tick uint16) {
+	pf.active = true
+	pf.row, pf.col = row, col
+	pf.variance = poseNoise * poseNoise
+	pf.samples = append(pf.samples[:0], [2]float64{row, col})
+	pf.lastTick = tick
+	pf.rejected = 0
+}
+
+// Forget the estimate, so that the filter starts over from the next report
+func (pf *poseFilter) reset() {
+	pf.active = false
+}
+
+/*
+Update the estimate with a report, at a given tick of a game clock running at
+a given rate (logging to a given logger) - returns the new estimate, or false
+if the report was rejected as an impossible jump
+*/
+func (pf *poseFilter) update(row, col float64, tick uint16, fps int32,
+	logger *slog.Logger) (float64, float64, bool) {
+
+	// Without a filter, use the report as is
+	if poseFilterKind == poseFilterNone {
+		return row, col, true
+	}
+
+	// Start from the first report
+	if !pf.active {
+		pf.restart(row, col, tick)
+		return row, col, true
+	}
+
+	// Reject reports the robot couldn't have reached, unless they persist
+	elapsed := float64(max(tick-pf.lastTick, 1)) / float64(fps)
+	reach := poseMaxSpeed * elapsed
+	if math.Hypot(row-pf.row, col-pf.col) > reach+poseJumpSlack {
+		pf.rejected++
+		if pf.rejected < poseReacquireReports {
+			return 0, 0, false
+		}
+		logger.Warn("Tracking jumped, starting over", "row", row, "col", col)
+		pf.restart(row, col, tick)
+		return row, col, true
+	}
+	pf.rejected = 0
+	pf.lastTick = tick
+
+	// Update the estimate
+	switch poseFilterKind {
+	case poseFilterAverage:
+		pf.samples = append(pf.samples, [2]float64{row, col})
+		if len(pf.samples) > poseFilterWindow {
+			pf.samples = pf.samples[len(pf.samples)-poseFilterWindow:]
+		}
+		pf.row, pf.col = 0, 0
+		for _, sample := range pf.samples {
+			pf.row += sample[0] / float64(len(pf.samples))
+			pf.col += sample[1] / float64(len(pf.samples))
+		}
+	case poseFilterKalman:
+
+		// The robot may have moved up to its reach (taken as two deviations)
+		pf.variance += reach * reach / 4
+		gain := pf.variance / (pf.variance + poseNoise*poseNoise)
+		pf.row += gain * (row - pf.row)
+		pf.col += gain * (col - pf.col)
+		pf.variance *= 1 - gain
+	}
+	return pf.row, pf.col, true
+}
+
+// Snap a coordinate to a cell, staying in the current cell unless clearly out
+func snapCoord(x float64, curr int8) int8 {
+	if math.Abs(x-float64(curr)) <= 0.5+poseHysteresis {
+		return curr
+	}
+	return int8(math.Round(x))
+}
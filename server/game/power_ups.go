@@ -0,0 +1,192 @@
+package game
+
+import (
+	"fmt"
+)
+
+/*
+Power-ups are optional items that a maze can place, beyond the super pellets,
+for variants of the game (and for teaching bots to weigh up detours). They are
+drawn in the maze's grid on empty space, numbered in reading order from 0, and
+Pacman collects one by moving onto its cell:
+
+	'Z' - ghost freeze: every ghost but the eaten ones (the eyes) stays where
+	      it is for FreezeSteps steps - frozen ghosts still catch Pacman
+	'S' - speed boost: Pacman's speed is raised to BoostSpeed for BoostSteps
+	      steps (only felt when Pacman's speed is limited, see speeds.go)
+	'X' - point multiplier: pellets, ghosts, and fruit are worth
+	      PointMultiplier times their points for MultiplierSteps steps
+
+Each effect has its own counter of the steps it has left, and collecting a
+power-up whose effect is running starts its counter over. The power-ups come
+back with the pellets at the start of each level, while the effects end when
+Pacman is caught or clears the level. Each collection is logged as a
+"power_up" event, and every state carries the power-ups left on the maze and
+the effects' counters after the fruit type (see serPowerUps).
+*/
+
+// The most power-ups a maze can have (so that they fit in the serialized state)
+const maxPowerUps = 32
+
+// Enum-like declaration to hold the power-up types
+const (
+	powerUpFreeze     uint8 = 0 // Freezes the ghosts
+	powerUpBoost      uint8 = 1 // Speeds up Pacman
+	powerUpMultiplier uint8 = 2 // Multiplies the points scored
+	numPowerUpTypes   uint8 = 3
+)
+
+// Names of the power-up types
+var powerUpNames [numPowerUpTypes]string = [...]string{
+	"freeze",
+	"boost",
+	"multiplier",
+}
+
+// Characters that stand for the power-up types in a maze's grid
+var powerUpCells [numPowerUpTypes]byte = [...]byte{'Z', 'S', 'X'}
+
+// A power-up placed by a maze
+type powerUp struct {
+	row  int8  // Row of the power-up's cell
+	col  int8  // Column of the power-up's cell
+	kind uint8 // Type of the power-up
+}
+
+// Add a power-up to a maze being parsed, from its character in the grid
+func (maze *mazeLayout) addPowerUp(row int8, col int8, cell byte) error {
+	if len(maze.powerUps) == maxPowerUps {
+		return fmt.Errorf("row %d, col %d: more than %d power-ups", row, col,
+			maxPowerUps)
+	}
+	for kind, char := range powerUpCells {
+		if char == cell {
+			maze.powerUps = append(maze.powerUps,
+				powerUp{row: row, col: col, kind: uint8(kind)})
+		}
+	}
+	return nil
+}
+
+/*
+Get the power-ups of a maze, with a bit for each (all of them, as at the start
+of a level)
+*/
+func (maze *mazeLayout) allPowerUps() uint32 {
+	return uint32(uint64(1)<<len(maze.powerUps) - 1)
+}
+
+// Helper function to put every power-up back on the maze
+func (gs *gameState) resetPowerUps() {
+	gs.powerUpsLeft = gs.maze.allPowerUps()
+}
+
+// Helper function to end the effects of the power-ups
+func (gs *gameState) endPowerUps() {
+	gs.freezeSteps = 0
+	gs.boostSteps = 0
+	gs.multiplierSteps = 0
+}
+
+// Collect the power-up at a given cell, if there is one left there
+func (gs *gameState) collectPowerUp(row int8, col int8) {
+
+	// Look for a power-up left at the cell
+	for idx, p := range gs.maze.powerUps {
+		if p.row != row || p.col != col ||
+			!getBit(gs.powerUpsLeft, uint8(idx)) {
+			continue
+		}
+		modifyBit(&gs.powerUpsLeft, uint8(idx), false)
+
+		// Start (or start over) the power-up's effect
+		switch p.kind {
+		case powerUpFreeze:
+			gs.freezeSteps = gs.rules.FreezeSteps
+		case powerUpBoost:
+			gs.boostSteps = gs.rules.BoostSteps
+		case powerUpMultiplier:
+			gs.multiplierSteps = gs.rules.MultiplierSteps
+		}
+
+		// Send a message to the terminal
+		gs.logger().Info("Power-up collected", "powerUp", powerUpNames[p.kind],
+			"tick", gs.getCurrTicks())
+		gs.logEvent(eventPowerUp, map[string]any{
+			"powerUp": powerUpNames[p.kind], "row": row, "col": col})
+	}
+}
+
+// Count down the steps left of each power-up's effect
+func (gs *gameState) updatePowerUps() {
+	if gs.freezeSteps != 0 {
+		gs.freezeSteps--
+	}
+	if gs.boostSteps != 0 {
+		gs.boostSteps--
+	}
+	if gs.multiplierSteps != 0 {
+		gs.multiplierSteps--
+	}
+}
+
+// Determines if the ghosts are frozen by a power-up
+func (gs *gameState) ghostsFrozen() bool {
+	return gs.freezeSteps > 0
+}
+
+// Get Pacman's speed on this step, raised while it is boosted by a power-up
+func (gs *gameState) pacmanSpeed() uint8 {
+	speed := gs.levelSpeeds().Pacman
+	if gs.boostSteps > 0 && speed != 0 {
+		speed = max(speed, gs.rules.BoostSpeed)
+	}
+	return speed
+}
+
+/*
+Get the points scored for something worth a given number of points, multiplied
+while a power-up's point multiplier is running
+*/
+func (gs *gameState) powerUpPoints(points uint16) uint16 {
+	if gs.multiplierSteps == 0 {
+		return points
+	}
+	return uint16(min(uint32(points)*uint32(gs.rules.PointMultiplier), 65535))
+}
+
+/*
+Serialize the power-ups, as a count of those left on the maze followed by a
+row, column, and type (1 byte each) for each, then the steps left of the
+ghost freeze, speed boost, and point multiplier (1 byte each) - 4 + 3 * count
+bytes
+*/
+func (gs *gameState) serPowerUps(outputBuf []byte, startIdx int) int {
+
+	// Serialize the count of the power-ups left first
+	var count uint8 = 0
+	for idx := range gs.maze.powerUps {
+		if getBit(gs.powerUpsLeft, uint8(idx)) {
+			count++
+		}
+	}
+	startIdx = serUint8(count, outputBuf, startIdx)
+
+	// Serialize each power-up left, in order
+	for idx, p := range gs.maze.powerUps {
+		if !getBit(gs.powerUpsLeft, uint8(idx)) {
+			continue
+		}
+		startIdx = serUint8(uint8(p.row), outputBuf, startIdx)
+		startIdx = serUint8(uint8(p.col), outputBuf, startIdx)
+		startIdx = serUint8(p.kind, outputBuf, startIdx)
+	}
+
+	// Serialize the steps left of each effect
+	startIdx = serUint8(gs.freezeSteps, outputBuf, startIdx)
+	startIdx = serUint8(gs.boostSteps, outputBuf, startIdx)
+	startIdx = serUint8(gs.multiplierSteps, outputBuf, startIdx)
+
+	// Return the starting index of the next field
+	return startIdx
+}
@@ -0,0 +1,194 @@
+package game
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+/*
+Referee commands let a referee settle disputes over the physical robot during
+a match (e.g. a ghost caught a robot that was only clipping a corner) by
+correcting the game directly. They are sent as 'e' followed by an action byte
+and its arguments:
+
+	's' + change (2 bytes, signed) - adjust the score (clamped at 0)
+	'l' + change (1 byte, signed)  - grant (positive) or revoke lives
+	'g' + ghost color (1 byte)     - respawn a ghost in the ghost house
+	't' + row, col (1 byte each)   - teleport Pacman (without eating anything)
+	'e'                            - end the game
+	'w' + gate, open (1 byte each) - open or close a gate (see gates.go)
+	'n' + text                     - annotate the event log
+
+Revoking the last life, like ending the game, makes it game over. Every
+referee command is recorded in the event log (as a "referee" event, with the
+action and its details), so that the match's record shows how it was settled.
+*/
+
+// Referee command actions (following the 'e' of the command)
+const (
+	refereeScore    byte = 's' // Adjust the score
+	refereeLives    byte = 'l' // Grant or revoke lives
+	refereeGhost    byte = 'g' // Respawn a ghost
+	refereeTeleport byte = 't' // Teleport Pacman
+	refereeEnd      byte = 'e' // End the game
+	refereeNote     byte = 'n' // Annotate the event log
+	refereeGate     byte = 'w' // Open or close a gate
+)
+
+// The length of each action's arguments (annotations can be any length)
+var refereeArgsLen = map[byte]int{
+	refereeScore:    2,
+	refereeLives:    1,
+	refereeGhost:    1,
+	refereeTeleport: 2,
+	refereeEnd:      0,
+	refereeGate:     2,
+}
+
+// The longest annotation kept in the event log, in bytes
+const maxRefereeNote = 200
+
+/*
+Interpret a referee command (following the 'e') - returns an error if the
+command couldn't be carried out
+*/
+func (gs *gameState) interpretRefereeCommand(msg []byte) error {
+
+	// Check the arguments of the action
+	if len(msg) == 0 {
+		return invalidCommand('e', "missing action")
+	}
+	action, args := msg[0], msg[1:]
+	if n, ok := refereeArgsLen[action]; (ok && len(args) != n) ||
+		(!ok && action != refereeNote) {
+		return invalidCommand('e', fmt.Sprintf("bad action '%c'", action))
+	}
+
+	// Carry out the action
+	switch action {
+	case refereeScore:
+		gs.adjustScore(int16(args[0])<<8 | int16(args[1]))
+	case refereeLives:
+		gs.adjustLives(int8(args[0]))
+	case refereeGhost:
+		return gs.forceRespawnGhost(args[0])
+	case refereeTeleport:
+		return gs.teleportPacman(int8(args[0]), int8(args[1]))
+	case refereeEnd:
+		gs.refereeEvent("end", nil)
+		gs.endGame()
+	case refereeNote:
+		gs.annotate(string(args))
+	case refereeGate:
+		return gs.refereeGate(args[0], args[1] != 0)
+	}
+	return nil
+}
+
+// Record a referee command in the event log (and the server's log)
+func (gs *gameState) refereeEvent(action string, details map[string]any) {
+	if details == nil {
+		details = make(map[string]any)
+	}
+	gs.logger().Info("Referee command", "action", action, "details", details,
+		"tick", gs.getCurrTicks())
+	details["action"] = action
+	gs.logEvent(eventReferee, details)
+}
+
+// Adjust the score by a (signed) amount, clamped at 0
+func (gs *gameState) adjustScore(change int16) {
+	from := gs.getScore()
+
+	// Increases count towards bonus lives and the high score, as usual
+	if change >= 0 {
+		gs.incrementScore(uint16(change))
+	} else {
+		gs.currScore = uint16(max(int32(gs.currScore)+int32(change), 0))
+	}
+	gs.refereeEvent("score", map[string]any{"change": change,
+		"from": from, "to": gs.getScore()})
+}
+
+// Grant (for a positive change) or revoke lives, ending the game at 0
+func (gs *gameState) adjustLives(change int8) {
+	from := gs.getLives()
+	lives := min(max(int16(from)+int16(change), 0), 255)
+	gs.setLives(uint8(lives))
+	gs.refereeEvent("lives", map[string]any{"change": change,
+		"from": from, "to": lives})
+	if lives == 0 {
+		gs.endGame()
+	}
+}
+
+/*
+Respawn a ghost in the ghost house, releasing it straight away - returns an
+error if the ghost isn't in play
+*/
+func (gs *gameState) forceRespawnGhost(color uint8) error {
+
+	// Only ghosts in play can respawn
+	if !gs.isGhostActive(color) {
+		return invalidCommand('e', fmt.Sprintf("ghost %d not in play", color))
+	}
+
+	ghost := gs.ghosts[color]
+	ghost.reset()
+	ghost.setWaiting(false)
+	gs.refereeEvent("respawn", map[string]any{"ghost": ghostNames[color]})
+	return nil
+}
+
+/*
+Teleport Pacman to a cell, without eating anything there (a correction, rather
+than a move), but checking for collisions with the ghosts as usual - returns an
+error if the cell is a wall or off the maze
+*/
+func (gs *gameState) teleportPacman(row, col int8) error {
+
+	// Only open cells can be teleported to
+	if gs.wallAt(row, col) {
+		return gs.cellError(row, col)
+	}
+	fromRow, fromCol := gs.pacmanLoc.getCoords()
+	gs.refereeEvent("teleport", map[string]any{"fromRow": fromRow,
+		"fromCol": fromCol, "row": row, "col": col})
+
+	gs.pacmanLoc.updateCoords(row, col)
+
+	// Check collisions with all the ghosts
+	gs.checkCollisions()
+	return nil
+}
+
+// End the game, making it game over (with the lives it has left)
+func (gs *gameState) endGame() {
+
+	// If the game is already over, there's nothing to do
+	if gs.getLifecycle() == lifecycleGameOver {
+		return
+	}
+
+	// Pause the game for good
+	gs.pause()
+	gs.setLifecycle(lifecycleGameOver)
+	gs.logGameOver()
+	gs.logger().Info("Game over", "score", gs.getScore(), "tick",
+		gs.getCurrTicks())
+}
+
+// Annotate the event log with a referee's note
+func (gs *gameState) annotate(text string) {
+
+	// Keep the note short, and valid UTF-8
+	text = strings.ToValidUTF8(text, "")
+	if len(text) > maxRefereeNote {
+		text = text[:maxRefereeNote]
+	}
+	for !utf8.ValidString(text) {
+		text = text[:len(text)-1]
+	}
+	gs.refereeEvent("note", map[string]any{"text": text})
+}
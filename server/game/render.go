@@ -0,0 +1,469 @@
+package game
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"strconv"
+)
+
+/*
+Frames of the game can be rendered as images, for stream overlays and match
+reports that only need a snapshot of the board rather than the visualizer.
+Both renderers work from the serialized state (as broadcast to clients), with
+the walls taken from the maze named in it (and its closed gates drawn like the
+ghost house door, see gates.go):
+
+	PNG - the board, with a status bar above it showing the score (in a small
+	      built-in digit font) and the lives left (as Pacman icons)
+	SVG - the same board, with the score, level, lives, and mode as text
+
+Each cell is drawn as a square of renderCellSize pixels (or SVG units). Ghosts
+are drawn in their colors, or in blue while frightened (white while flashing),
+and as a pair of eyes while respawning after being eaten. Power-ups are drawn
+as small squares, in a color for each type (see power_ups.go), and teleporters
+as rings at both of their ends, in the walls' color while cooling down (see
+teleporters.go).
+*/
+
+// The size of a cell in a rendered frame, in pixels
+const renderCellSize = 16
+
+// The height of the status bar above the board, in cells
+const renderStatusRows = 2
+
+// Get the size of a rendered frame (of the state's maze), in pixels
+func renderSize(state *stateJSON) (int, int) {
+	return int(state.Cols) * renderCellSize,
+		(int(state.Rows) + renderStatusRows) * renderCellSize
+}
+
+// Indices of the colors in the palette of rendered frames
+const (
+	renderBackground uint8 = iota
+	renderWall
+	renderDoor
+	renderPellet
+	renderPacman
+	renderFruit
+	renderFrightened
+	renderFlashing
+	renderEyes
+	renderPupils
+	renderText
+	renderTeleporter
+	renderPowerUps // First of the power-up colors (in the order of the types)
+
+	// First of the ghost colors (in the order of the colors)
+	renderGhosts = renderPowerUps + numPowerUpTypes
+)
+
+/*
+Palette of rendered frames - a fixed palette, so that frames can be encoded as
+paletted images (e.g. for animations) without quantizing them
+*/
+var renderPalette = color.Palette{
+	color.RGBA{0, 0, 0, 255},       // Background
+	color.RGBA{33, 33, 200, 255},   // Wall
+	color.RGBA{255, 184, 222, 255}, // Ghost house exit
+	color.RGBA{255, 184, 151, 255}, // Pellet
+	color.RGBA{255, 255, 0, 255},   // Pacman
+	color.RGBA{255, 40, 40, 255},   // Fruit
+	color.RGBA{60, 60, 255, 255},   // Frightened ghost
+	color.RGBA{240, 240, 255, 255}, // Flashing ghost
+	color.RGBA{255, 255, 255, 255}, // Eyes
+	color.RGBA{30, 30, 160, 255},   // Pupils
+	color.RGBA{222, 222, 222, 255}, // Text
+	color.RGBA{0, 210, 170, 255},   // Teleporter
+	color.RGBA{140, 220, 255, 255}, // Ghost freeze
+	color.RGBA{120, 255, 80, 255},  // Speed boost
+	color.RGBA{255, 80, 255, 255},  // Point multiplier
+	color.RGBA{255, 0, 0, 255},     // Red
+	color.RGBA{255, 160, 220, 255}, // Pink
+	color.RGBA{0, 255, 255, 255},   // Cyan
+	color.RGBA{255, 170, 60, 255},  // Orange
+	color.RGBA{170, 60, 240, 255},  // Purple
+	color.RGBA{0, 200, 70, 255},    // Green
+	color.RGBA{80, 140, 255, 255},  // Blue
+	color.RGBA{200, 200, 200, 255}, // White
+}
+
+// Bitmaps of the digits 0-9 (3 x 5 pixels, one row per 3 bits), for the score
+var renderDigits = [10][5]uint8{
+	{7, 5, 5, 5, 7}, {2, 6, 2, 2, 7}, {7, 1, 7, 4, 7}, {7, 1, 7, 1, 7},
+	{5, 5, 7, 1, 1}, {7, 4, 7, 1, 7}, {7, 4, 7, 5, 7}, {7, 1, 1, 1, 1},
+	{7, 5, 7, 5, 7}, {7, 5, 7, 1, 7},
+}
+
+// The size of each pixel of the digit bitmaps, in pixels
+const renderDigitScale = 3
+
+/*
+Render a serialized game state (as produced by serFull) as a PNG image -
+exported so that the web server can offer it to clients
+*/
+func RenderPNG(buf []byte) ([]byte, error) {
+	state, err := decodeState(buf)
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	if err := png.Encode(&out, renderFrame(state)); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// Render a decoded game state as a paletted image
+func renderFrame(state *stateJSON) *image.Paletted {
+	size := renderCellSize
+	width, height := renderSize(state)
+	img := image.NewPaletted(image.Rect(0, 0, width, height), renderPalette)
+
+	// Status bar: the score on the left, and the lives on the right
+	x := size / 2
+	for _, digit := range strconv.Itoa(int(state.Score)) {
+		renderDigit(img, x, (renderStatusRows*size-5*renderDigitScale)/2,
+			int(digit-'0'))
+		x += 4 * renderDigitScale
+	}
+	for life := 0; life < int(state.Lives); life++ {
+		cx := float64(width - size - life*(size+size/2))
+		renderPacmanShape(img, cx, float64(renderStatusRows*size)/2,
+			float64(size)*0.45, left)
+	}
+
+	// Walls and pellets
+	grid, _ := MazeGrid(state.Maze)
+	for row := int8(0); row < state.Rows; row++ {
+		for col := int8(0); col < state.Cols; col++ {
+			cx, cy := renderCellCenter(row, col)
+			switch renderTile(grid, row, col) {
+			case '#':
+				x, y := int(cx)-size/2, int(cy)-size/2
+				renderRect(img, x, y, x+size, y+size, renderWall)
+			case '-':
+				x, y := int(cx)-size/2, int(cy)-2
+				renderRect(img, x, y, x+size, y+4, renderDoor)
+			}
+			if renderGateClosed(state, row, col) {
+				x, y := int(cx)-size/2, int(cy)-size/2
+				renderRect(img, x, y, x+size, y+size, renderDoor)
+			}
+			if state.pellets.get(row, col) {
+				radius := float64(size) / 8
+				if renderIsSuperPellet(state, row, col) {
+					radius = float64(size) / 3
+				}
+				renderCircle(img, cx, cy, radius, renderPellet)
+			}
+		}
+	}
+
+	// Teleporters, as rings at both ends
+	for _, t := range state.Teleporters {
+		for _, end := range t.Ends {
+			cx, cy := renderCellCenter(end.Row, end.Col)
+			renderCircle(img, cx, cy, float64(size)*0.45,
+				renderTeleporterColor(&t))
+			renderCircle(img, cx, cy, float64(size)*0.3, renderBackground)
+		}
+	}
+
+	// Power-ups, as squares in the color of their type
+	for _, p := range state.PowerUps {
+		cx, cy := renderCellCenter(p.Row, p.Col)
+		x, y := int(cx)-size/4, int(cy)-size/4
+		renderRect(img, x, y, x+size/2, y+size/2, renderPowerUps+p.kind)
+	}
+
+	// Fruit, Pacman, and the ghosts (on top of everything else)
+	if state.Fruit != nil {
+		cx, cy := renderCellCenter(state.Fruit.Row, state.Fruit.Col)
+		renderCircle(img, cx, cy, float64(size)*0.35, renderFruit)
+	}
+	if renderOnBoard(state, state.Pacman.Row, state.Pacman.Col) {
+		cx, cy := renderCellCenter(state.Pacman.Row, state.Pacman.Col)
+		renderPacmanShape(img, cx, cy, float64(size)*0.45, state.Pacman.dir)
+	}
+	for ghostColor, ghost := range state.Ghosts {
+		if renderOnBoard(state, ghost.Row, ghost.Col) {
+			renderGhost(img, &ghost, uint8(ghostColor))
+		}
+	}
+	return img
+}
+
+// Check whether a cell is on the board (rather than an empty location)
+func renderOnBoard(state *stateJSON, row, col int8) bool {
+	return row >= 0 && row < state.Rows && col >= 0 && col < state.Cols
+}
+
+// Get the center of a cell in a rendered frame, in pixels
+func renderCellCenter(row, col int8) (float64, float64) {
+	size := float64(renderCellSize)
+	return (float64(col) + 0.5) * size,
+		(float64(row) + renderStatusRows + 0.5) * size
+}
+
+// Get the tile at a cell of a maze's grid (empty space if outside of it)
+func renderTile(grid []string, row, col int8) byte {
+	if int(row) < len(grid) && int(col) < len(grid[row]) {
+		return grid[row][col]
+	}
+	return ' '
+}
+
+// Check whether a pellet in the state is a super pellet
+func renderIsSuperPellet(state *stateJSON, row, col int8) bool {
+	for _, super := range state.SuperPellets {
+		if super[0] == row && super[1] == col {
+			return true
+		}
+	}
+	return false
+}
+
+// Check whether there is a closed gate at a cell of the state (see gates.go)
+func renderGateClosed(state *stateJSON, row, col int8) bool {
+	for _, gate := range state.Gates {
+		if gate.Row == row && gate.Col == col {
+			return !gate.Open
+		}
+	}
+	return false
+}
+
+// Get the palette index that a teleporter is drawn in (see teleporters.go)
+func renderTeleporterColor(t *teleporterJSON) uint8 {
+	if t.CooldownSteps > 0 {
+		return renderWall
+	}
+	return renderTeleporter
+}
+
+// Get the palette index that a ghost is drawn in
+func renderGhostColor(ghost *ghostJSON, ghostColor uint8) uint8 {
+	switch {
+	case ghost.Flashing:
+		return renderFlashing
+	case ghost.FrightSteps > 0:
+		return renderFrightened
+	}
+	return renderGhosts + ghostColor%numColors
+}
+
+// Fill a rectangle of a frame
+func renderRect(img *image.Paletted, x0, y0, x1, y1 int, idx uint8) {
+	for y := y0; y < y1; y++ {
+		for x := x0; x < x1; x++ {
+			img.SetColorIndex(x, y, idx)
+		}
+	}
+}
+
+// Fill a circle of a frame
+func renderCircle(img *image.Paletted, cx, cy, radius float64, idx uint8) {
+	for y := int(cy - radius); y <= int(cy+radius); y++ {
+		for x := int(cx - radius); x <= int(cx+radius); x++ {
+			dx, dy := float64(x)+0.5-cx, float64(y)+0.5-cy
+			if dx*dx+dy*dy <= radius*radius {
+				img.SetColorIndex(x, y, idx)
+			}
+		}
+	}
+}
+
+// Draw Pacman, with its mouth open towards its direction
+func renderPacmanShape(img *image.Paletted, cx, cy, radius float64,
+	dir uint8) {
+	facing := 0.0
+	if dir < numDirs {
+		facing = math.Atan2(float64(dRow[dir]), float64(dCol[dir]))
+	}
+	for y := int(cy - radius); y <= int(cy+radius); y++ {
+		for x := int(cx - radius); x <= int(cx+radius); x++ {
+			dx, dy := float64(x)+0.5-cx, float64(y)+0.5-cy
+			if dx*dx+dy*dy > radius*radius {
+				continue
+			}
+
+			// Leave out a wedge (of 60 degrees either way) for the mouth
+			angle := math.Abs(math.Remainder(math.Atan2(dy, dx)-facing,
+				2*math.Pi))
+			if dir >= numDirs || angle > math.Pi/3 {
+				img.SetColorIndex(x, y, renderPacman)
+			}
+		}
+	}
+}
+
+// Draw a ghost: a body (unless it was eaten) and a pair of eyes
+func renderGhost(img *image.Paletted, ghost *ghostJSON, ghostColor uint8) {
+	cx, cy := renderCellCenter(ghost.Row, ghost.Col)
+	size := float64(renderCellSize)
+	if !ghost.Eaten {
+
+		// A round top, over a square bottom
+		idx := renderGhostColor(ghost, ghostColor)
+		renderCircle(img, cx, cy-size*0.05, size*0.45, idx)
+		renderRect(img, int(cx-size*0.45), int(cy), int(cx+size*0.45),
+			int(cy+size*0.45), idx)
+	}
+
+	// The eyes look in the ghost's direction
+	var lookX, lookY float64
+	if ghost.dir < numDirs {
+		lookX, lookY = float64(dCol[ghost.dir]), float64(dRow[ghost.dir])
+	}
+	for _, side := range []float64{-1, 1} {
+		ex, ey := cx+side*size*0.18, cy-size*0.1
+		renderCircle(img, ex, ey, size*0.14, renderEyes)
+		renderCircle(img, ex+lookX*size*0.06, ey+lookY*size*0.06, size*0.07,
+			renderPupils)
+	}
+}
+
+// Draw a digit of the score, with its top left corner at a given pixel
+func renderDigit(img *image.Paletted, x, y int, digit int) {
+	for row, bits := range renderDigits[digit] {
+		for col := 0; col < 3; col++ {
+			if bits&(4>>col) != 0 {
+				px, py := x+col*renderDigitScale, y+row*renderDigitScale
+				renderRect(img, px, py, px+renderDigitScale,
+					py+renderDigitScale, renderText)
+			}
+		}
+	}
+}
+
+/*
+Render a serialized game state (as produced by serFull) as an SVG image -
+exported so that the web server can offer it to clients
+*/
+func RenderSVG(buf []byte) ([]byte, error) {
+	state, err := decodeState(buf)
+	if err != nil {
+		return nil, err
+	}
+	size := float64(renderCellSize)
+	width, height := renderSize(state)
+	var out bytes.Buffer
+	hex := func(idx uint8) string {
+		r, g, b, _ := renderPalette[idx].RGBA()
+		return fmt.Sprintf("#%02x%02x%02x", r>>8, g>>8, b>>8)
+	}
+	fmt.Fprintf(&out, `<svg xmlns="http://www.w3.org/2000/svg" `+
+		`width="%d" height="%d" viewBox="0 0 %d %d">`+"\n",
+		width, height, width, height)
+	fmt.Fprintf(&out, `<rect width="%d" height="%d" fill="%s"/>`+"\n",
+		width, height, hex(renderBackground))
+
+	// Status bar
+	fmt.Fprintf(&out, `<text x="%g" y="%g" fill="%s" font-family="monospace" `+
+		`font-size="%g">SCORE %d  LEVEL %d  LIVES %d  %s</text>`+"\n",
+		size/2, size*1.4, hex(renderText), size, state.Score, state.Level,
+		state.Lives, state.Mode)
+
+	// Walls and pellets
+	grid, _ := MazeGrid(state.Maze)
+	for row := int8(0); row < state.Rows; row++ {
+		for col := int8(0); col < state.Cols; col++ {
+			cx, cy := renderCellCenter(row, col)
+			switch renderTile(grid, row, col) {
+			case '#':
+				fmt.Fprintf(&out, `<rect x="%g" y="%g" width="%g" height="%g" `+
+					`fill="%s"/>`+"\n", cx-size/2, cy-size/2, size, size,
+					hex(renderWall))
+			case '-':
+				fmt.Fprintf(&out, `<rect x="%g" y="%g" width="%g" height="4" `+
+					`fill="%s"/>`+"\n", cx-size/2, cy-2, size, hex(renderDoor))
+			}
+			if renderGateClosed(state, row, col) {
+				fmt.Fprintf(&out, `<rect x="%g" y="%g" width="%g" height="%g" `+
+					`fill="%s"/>`+"\n", cx-size/2, cy-size/2, size, size,
+					hex(renderDoor))
+			}
+			if state.pellets.get(row, col) {
+				radius := size / 8
+				if renderIsSuperPellet(state, row, col) {
+					radius = size / 3
+				}
+				fmt.Fprintf(&out, `<circle cx="%g" cy="%g" r="%g" fill="%s"/>`+
+					"\n", cx, cy, radius, hex(renderPellet))
+			}
+		}
+	}
+
+	// Teleporters, as rings at both ends
+	for _, t := range state.Teleporters {
+		for _, end := range t.Ends {
+			cx, cy := renderCellCenter(end.Row, end.Col)
+			fmt.Fprintf(&out, `<circle cx="%g" cy="%g" r="%g" fill="none" `+
+				`stroke="%s" stroke-width="%g"/>`+"\n", cx, cy, size*0.375,
+				hex(renderTeleporterColor(&t)), size*0.15)
+		}
+	}
+
+	// Power-ups, as squares in the color of their type
+	for _, p := range state.PowerUps {
+		cx, cy := renderCellCenter(p.Row, p.Col)
+		fmt.Fprintf(&out, `<rect x="%g" y="%g" width="%g" height="%g" `+
+			`fill="%s"/>`+"\n", cx-size/4, cy-size/4, size/2, size/2,
+			hex(renderPowerUps+p.kind))
+	}
+
+	// Fruit, Pacman, and the ghosts
+	if state.Fruit != nil {
+		cx, cy := renderCellCenter(state.Fruit.Row, state.Fruit.Col)
+		fmt.Fprintf(&out, `<circle cx="%g" cy="%g" r="%g" fill="%s"/>`+"\n",
+			cx, cy, size*0.35, hex(renderFruit))
+	}
+	if renderOnBoard(state, state.Pacman.Row, state.Pacman.Col) {
+		cx, cy := renderCellCenter(state.Pacman.Row, state.Pacman.Col)
+		radius := size * 0.45
+		if dir := state.Pacman.dir; dir < numDirs {
+
+			// A circle with a wedge cut out (of 60 degrees either way)
+			facing := math.Atan2(float64(dRow[dir]), float64(dCol[dir]))
+			x0, y0 := cx+radius*math.Cos(facing-math.Pi/3),
+				cy+radius*math.Sin(facing-math.Pi/3)
+			x1, y1 := cx+radius*math.Cos(facing+math.Pi/3),
+				cy+radius*math.Sin(facing+math.Pi/3)
+			fmt.Fprintf(&out, `<path d="M%.2f,%.2f L%.2f,%.2f `+
+				`A%g,%g 0 1,0 %.2f,%.2f Z" fill="%s"/>`+"\n", cx, cy, x0, y0,
+				radius, radius, x1, y1, hex(renderPacman))
+		} else {
+			fmt.Fprintf(&out, `<circle cx="%g" cy="%g" r="%g" fill="%s"/>`+
+				"\n", cx, cy, radius, hex(renderPacman))
+		}
+	}
+	for ghostColor, ghost := range state.Ghosts {
+		if !renderOnBoard(state, ghost.Row, ghost.Col) {
+			continue
+		}
+		cx, cy := renderCellCenter(ghost.Row, ghost.Col)
+		if !ghost.Eaten {
+			fmt.Fprintf(&out, `<path d="M%g,%g A%g,%g 0 0,1 %g,%g V%g H%g Z" `+
+				`fill="%s"/>`+"\n", cx-size*0.45, cy, size*0.45, size*0.45,
+				cx+size*0.45, cy, cy+size*0.45, cx-size*0.45,
+				hex(renderGhostColor(&ghost, uint8(ghostColor))))
+		}
+		var lookX, lookY float64
+		if ghost.dir < numDirs {
+			lookX, lookY = float64(dCol[ghost.dir]), float64(dRow[ghost.dir])
+		}
+		for _, side := range []float64{-1, 1} {
+			ex, ey := cx+side*size*0.18, cy-size*0.1
+			fmt.Fprintf(&out, `<circle cx="%g" cy="%g" r="%g" fill="%s"/>`+
+				`<circle cx="%g" cy="%g" r="%g" fill="%s"/>`+"\n",
+				ex, ey, size*0.14, hex(renderEyes), ex+lookX*size*0.06,
+				ey+lookY*size*0.06, size*0.07, hex(renderPupils))
+		}
+	}
+	out.WriteString("</svg>\n")
+	return out.Bytes(), nil
+}
@@ -0,0 +1,402 @@
+package game
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+/*
+Replays record the inputs of a game (every command received, tagged with the
+engine frame it arrived on), along with periodic keyframes of the full
+serialized state - since the game is deterministic given its seed, this is
+enough to reproduce it exactly. Each game is recorded to its own file, in the
+following (big-endian) format:
+
+	Header:
+		"PBRP"                 - magic bytes
+		version (1 byte)       - replay format version
+		restarted (1 byte)     - whether the game was started by a restart,
+		                         which prepares the first update up front
+		active ghosts (1 byte) - ghosts in play (a bit per color, red lowest),
+		                         or their number in version 1 replays
+		seed (8 bytes)         - seed for the ghosts' random decisions
+		clock rate (2 bytes)   - game engine clock rate, in ticks per second
+		name len (1 byte)      - length of the maze name
+		name                   - maze name
+		grid len (2 bytes)     - length of the maze grid
+		grid                   - maze layout, as a plain-text grid
+
+	Records (until the end of the file):
+		'i', frame (4 bytes), msg len (1 byte), msg
+			- a command received during the given frame
+		'k', frame (4 bytes), state len (2 bytes), state
+			- the full serialized state broadcast during the given frame
+		'e', frame (4 bytes)
+			- the end of the game, after the given number of frames
+
+Frames count iterations of the engine loop since the game started (including
+paused and halted ones), so that inputs can be applied at the same point of
+the game during playback - the game constants in the configuration should
+match the ones that the game was recorded with
+*/
+
+// Magic bytes at the start of every replay file
+const replayMagic = "PBRP"
+
+// Current version of the replay format
+const replayVersion uint8 = 2
+
+// Record types within a replay file
+const (
+	replayInput    byte = 'i'
+	replayKeyframe byte = 'k'
+	replayEnd      byte = 'e'
+)
+
+// The number of frames between keyframes of the full state
+const replayKeyframePeriod uint32 = 240 // 10 seconds at 24 fps
+
+// The directory to record replays to (empty to disable recording)
+var replayDir string = ""
+
+// Mutex accompanying the above variable
+var muReplayDir sync.RWMutex
+
+// Configure the directory to record replays to (empty to disable recording)
+func ConfigReplayDir(_replayDir string) {
+	muReplayDir.Lock()
+	{
+		replayDir = _replayDir
+	}
+	muReplayDir.Unlock()
+}
+
+// Getter method for the replay directory
+func getReplayDir() string {
+	muReplayDir.RLock()
+	defer muReplayDir.RUnlock()
+	return replayDir
+}
+
+/*
+A replay recorder object, which records the inputs and keyframes of a single
+game to a replay file (only used by the game engine's go-routine)
+*/
+type replayRecorder struct {
+	file    *os.File      // Replay file
+	writer  *bufio.Writer // Buffered writer for the file
+	frame   uint32        // Current frame of the engine loop
+	scratch []byte        // Scratch buffer for record headers
+}
+
+/*
+Create a new replay recorder for a game, writing the replay header - returns
+nil if recording is disabled or the file can't be created
+*/
+func newReplayRecorder(gs *gameState, restarted bool) *replayRecorder {
+
+	// If no replay directory is configured, don't record (each room records
+	// to its own subdirectory)
+	dir := gs.rules.roomDir(getReplayDir())
+	if dir == "" {
+		return nil
+	}
+
+	// Create the replay file, named after the time the game started
+	name := fmt.Sprintf("replay_%s.pbr",
+		time.Now().Format("20060102_150405.000"))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		slog.Error("Replay directory error", "err", err)
+		return nil
+	}
+	file, err := os.Create(filepath.Join(dir, name))
+	if err != nil {
+		slog.Error("Replay file error", "err", err)
+		return nil
+	}
+
+	// New replay recorder object
+	rr := replayRecorder{
+		file:    file,
+		writer:  bufio.NewWriter(file),
+		frame:   0,
+		scratch: make([]byte, 16),
+	}
+
+	// Magic bytes and version
+	rr.writer.WriteString(replayMagic)
+	rr.writer.WriteByte(replayVersion)
+
+	// Game setup
+	if restarted {
+		rr.writer.WriteByte(1)
+	} else {
+		rr.writer.WriteByte(0)
+	}
+	rr.writer.WriteByte(gs.activeGhosts)
+
+	// Seed and clock rate
+	idx := serUint64(uint64(gs.seed), rr.scratch, 0)
+	idx = serUint16(uint16(gs.rules.getFPS()), rr.scratch, idx)
+	rr.writer.Write(rr.scratch[:idx])
+
+	// Maze name and grid
+	rr.writer.WriteByte(uint8(len(gs.maze.name)))
+	rr.writer.WriteString(gs.maze.name)
+	idx = serUint16(uint16(len(gs.maze.grid)), rr.scratch, 0)
+	rr.writer.Write(rr.scratch[:idx])
+	rr.writer.Write(gs.maze.grid)
+
+	// Log that the game is being recorded
+	slog.Info("Recording replay", "path", file.Name())
+
+	// Return the replay recorder
+	return &rr
+}
+
+// Record a command received during the current frame
+func (rr *replayRecorder) recordInput(msg []byte) {
+
+	// If the recorder is disabled, or the command is too long, skip it
+	if rr == nil || len(msg) > 255 {
+		return
+	}
+
+	// Write the record type, frame, and command
+	rr.writer.WriteByte(replayInput)
+	idx := serUint32(rr.frame, rr.scratch, 0)
+	idx = serUint8(uint8(len(msg)), rr.scratch, idx)
+	rr.writer.Write(rr.scratch[:idx])
+	rr.writer.Write(msg)
+}
+
+// Record the state broadcast during the current frame, if a keyframe is due
+func (rr *replayRecorder) recordKeyframe(state []byte) {
+
+	// If the recorder is disabled, or no keyframe is due, skip this frame
+	if rr == nil || rr.frame%replayKeyframePeriod != 0 {
+		return
+	}
+
+	// Write the record type, frame, and state, flushing the file along with it
+	rr.writer.WriteByte(replayKeyframe)
+	idx := serUint32(rr.frame, rr.scratch, 0)
+	idx = serUint16(uint16(len(state)), rr.scratch, idx)
+	rr.writer.Write(rr.scratch[:idx])
+	rr.writer.Write(state)
+	rr.flush()
+}
+
+// Move on to the next frame of the engine loop
+func (rr *replayRecorder) nextFrame() {
+	if rr != nil {
+		rr.frame++
+	}
+}
+
+// Flush any buffered records to the replay file
+func (rr *replayRecorder) flush() {
+	if err := rr.writer.Flush(); err != nil {
+		slog.Error("Replay write error", "err", err)
+	}
+}
+
+// Finish recording, closing the replay file
+func (rr *replayRecorder) close() {
+
+	// If the recorder is disabled, there's nothing to close
+	if rr == nil {
+		return
+	}
+
+	// Mark the end of the game, then flush and close the file
+	rr.writer.WriteByte(replayEnd)
+	idx := serUint32(rr.frame, rr.scratch, 0)
+	rr.writer.Write(rr.scratch[:idx])
+	rr.flush()
+	rr.file.Close()
+	slog.Info("Saved replay", "path", rr.file.Name(), "frames", rr.frame)
+}
+
+/****************************** Replay Playback *******************************/
+
+// A command recorded during a particular frame of a replay
+type replayInputRecord struct {
+	frame uint32
+	msg   []byte
+}
+
+/*
+A replay player object, which feeds the recorded inputs of a replay back into
+the game engine frame by frame (only used by the game engine's go-routine)
+*/
+type replayPlayer struct {
+	path      string              // Path to the replay file (for logging)
+	restarted bool                // Whether the game was started by a restart
+	ghosts    uint8               // Ghosts in play (a bit per color)
+	seed      int64               // Seed for the ghosts' random decisions
+	fps       int32               // Clock rate when the game started
+	maze      *mazeLayout         // Maze layout that the game was played on
+	inputs    []replayInputRecord // Recorded commands, in order
+	keyframes map[uint32][]byte   // Recorded states, by frame
+	endFrame  uint32              // Number of frames in the game
+	frame     uint32              // Current frame of the engine loop
+	inputIdx  int                 // Index of the next command to apply
+	finished  bool                // Whether the playback has finished
+
+	// Number of keyframes that differed from the played back state
+	divergences uint32
+}
+
+// Load a replay from a file, to play it back
+func loadReplay(path string) (*replayPlayer, error) {
+
+	// Read the whole replay file (replays are compact, so this is fine)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check the magic bytes and version
+	headerLen := len(replayMagic) + 14
+	if len(data) < headerLen || string(data[:len(replayMagic)]) != replayMagic {
+		return nil, fmt.Errorf("not a replay file")
+	}
+	data = data[len(replayMagic):]
+	if data[0] != replayVersion && data[0] != 1 {
+		return nil, fmt.Errorf("unsupported replay version %d", data[0])
+	}
+
+	// New replay player object, filled in from the fixed-length header
+	rp := replayPlayer{
+		path:      path,
+		restarted: data[1] != 0,
+		ghosts:    data[2] & allGhosts,
+		seed:      int64(binary.BigEndian.Uint64(data[3:11])),
+		fps:       int32(binary.BigEndian.Uint16(data[11:13])),
+		keyframes: make(map[uint32][]byte),
+	}
+	if data[0] == 1 {
+		rp.ghosts = firstGhosts(data[2]) // Version 1 counts the ghosts
+	}
+	data = data[13:]
+
+	// Maze name and grid
+	nameLen := int(data[0])
+	if len(data) < 1+nameLen+2 {
+		return nil, fmt.Errorf("truncated header")
+	}
+	name := string(data[1 : 1+nameLen])
+	data = data[1+nameLen:]
+	gridLen := int(binary.BigEndian.Uint16(data))
+	if len(data) < 2+gridLen {
+		return nil, fmt.Errorf("truncated header")
+	}
+	rp.maze, err = parseMaze(name, data[2:2+gridLen])
+	if err != nil {
+		return nil, fmt.Errorf("maze: %v", err)
+	}
+	data = data[2+gridLen:]
+
+	// Read the records until the end of the file (or a truncated record, in
+	// case the server stopped before the replay was closed)
+	for len(data) >= 5 {
+		recType, frame := data[0], binary.BigEndian.Uint32(data[1:5])
+		data = data[5:]
+		switch recType {
+		case replayInput:
+			if len(data) < 1 || len(data) < 1+int(data[0]) {
+				data = nil
+				break
+			}
+			msg := data[1 : 1+int(data[0])]
+			rp.inputs = append(rp.inputs, replayInputRecord{frame, msg})
+			data = data[1+len(msg):]
+		case replayKeyframe:
+			if len(data) < 2 || len(data) < 2+int(binary.BigEndian.Uint16(data)) {
+				data = nil
+				break
+			}
+			state := data[2 : 2+int(binary.BigEndian.Uint16(data))]
+			rp.keyframes[frame] = state
+			data = data[2+len(state):]
+		case replayEnd:
+			rp.endFrame = frame
+			data = nil
+		default:
+			return nil, fmt.Errorf("unknown record type '%c'", recType)
+		}
+		rp.endFrame = max(rp.endFrame, frame)
+	}
+
+	// Return the replay player
+	return &rp, nil
+}
+
+/*
+Check the state broadcast during the current frame against the recorded
+keyframe (if there is one), warning if the playback has diverged
+*/
+func (rp *replayPlayer) checkKeyframe(state []byte) {
+
+	// If not playing back, or there is no keyframe, skip this frame
+	if rp == nil {
+		return
+	}
+	keyframe, ok := rp.keyframes[rp.frame]
+	if !ok {
+		return
+	}
+
+	// Compare the states
+	if !bytes.Equal(keyframe, state) {
+		rp.divergences++
+		slog.Warn("Replay diverged from the recorded game - check that the "+
+			"configuration matches", "frame", rp.frame)
+	}
+}
+
+// Get the commands recorded during the current frame
+func (rp *replayPlayer) frameInputs() [][]byte {
+
+	// If not playing back, there are no commands
+	if rp == nil {
+		return nil
+	}
+
+	// Collect the commands for this frame
+	var msgs [][]byte
+	for rp.inputIdx < len(rp.inputs) && rp.inputs[rp.inputIdx].frame <= rp.frame {
+		msgs = append(msgs, rp.inputs[rp.inputIdx].msg)
+		rp.inputIdx++
+	}
+	return msgs
+}
+
+/*
+Move on to the next frame of the replay - returns true once, when the end of
+the recorded game is reached
+*/
+func (rp *replayPlayer) nextFrame() bool {
+
+	// If not playing back, or already finished, there's nothing to do
+	if rp == nil || rp.finished {
+		return false
+	}
+
+	// Move on, and check whether the end was reached
+	rp.frame++
+	if rp.frame >= rp.endFrame {
+		rp.finished = true
+		slog.Info("Replay finished", "frames", rp.frame)
+		return true
+	}
+	return false
+}
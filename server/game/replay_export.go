@@ -0,0 +1,320 @@
+package game
+
+import (
+	"bufio"
+	"bytes"
+	"compress/lzw"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"math"
+	"sync"
+	"time"
+)
+
+/*
+A recorded game can be exported as an animation, e.g. for match reports and
+highlight reels: its replay is played back headless (see headless.go), and the
+states are rendered as frames (see render.go) at a fixed frame rate, either as
+an animated GIF, or as a stream of PNG images for a video encoder to read (the
+server's --export mode pipes them into ffmpeg to make an MP4). Stretches where
+nothing changes (e.g. waiting in the lobby, or while paused) are cut down to
+replayExportMaxHold, so that the animation doesn't dwell on them, and the final
+state is held for as long at the end.
+
+The GIF is written as it is played back, rather than kept in memory: each frame
+after the first only holds the rectangle of the board that changed since the
+one before it, drawn over the previous frame.
+*/
+
+// The longest that an unchanging state is shown for in an exported animation
+const replayExportMaxHold = 2 * time.Second
+
+/*
+Play back a replay headless, calling a function with each state to show in an
+animation at a given frame rate, along with the number of frames to show it for
+(the function's state is only valid during the call)
+*/
+func sampleReplay(path string, fps float64,
+	show func(state []byte, frames int) error) error {
+
+	// Check the frame rate
+	if fps <= 0 || math.IsInf(fps, 0) || math.IsNaN(fps) {
+		return fmt.Errorf("invalid frame rate %g", fps)
+	}
+	frameTime := time.Duration(float64(time.Second) / fps)
+	maxHold := max(int(float64(replayExportMaxHold)/float64(frameTime)), 1)
+
+	// Create a game engine to play back the replay
+	var wgQuit sync.WaitGroup
+	ge, err := NewReplayEngine(nil, nil, &wgQuit, path, 1)
+	if err != nil {
+		return err
+	}
+
+	// The state being shown, and the number of frames it is shown for so far
+	var shown []byte
+	held := 0
+	var showErr error
+	flush := func() bool {
+		if held > 0 {
+			showErr = show(shown, min(held, maxHold))
+		}
+		return showErr == nil
+	}
+
+	// Each engine frame covers a span of game time, which may hold the times of
+	// some of the animation's frames
+	var elapsed, next time.Duration
+	ge.headless = &headlessRun{
+		onFrame: func(state []byte, period time.Duration) bool {
+			frames := 0
+			for elapsed += period; next < elapsed; next += frameTime {
+				frames++
+			}
+			if frames == 0 {
+				return true
+			}
+			if bytes.Equal(state, shown) {
+				held += frames
+				return true
+			}
+			if !flush() {
+				return false
+			}
+			shown, held = append(shown[:0], state...), frames
+			return true
+		},
+	}
+	ge.RunLoop()
+	if showErr != nil {
+		return showErr
+	}
+
+	// Hold the final state at the end (the engine loop stops before it would
+	// serialize the state that ended the game)
+	buf := make([]byte, maxStateLen)
+	final := buf[:ge.state.serFull(buf, 0)]
+	if !bytes.Equal(final, shown) {
+		if !flush() {
+			return showErr
+		}
+		shown = final
+	}
+	held = maxHold
+	flush()
+	return showErr
+}
+
+/*
+Export a replay as a stream of PNG images, one for each frame of an animation
+at a given frame rate (e.g. for a video encoder) - returns the number of frames
+*/
+func ExportReplayPNG(path string, fps float64, out io.Writer) (int, error) {
+	count := 0
+	var frame bytes.Buffer
+	err := sampleReplay(path, fps, func(state []byte, frames int) error {
+
+		// Render the state once, and repeat it for as many frames as needed
+		decoded, err := decodeState(state)
+		if err != nil {
+			return err
+		}
+		frame.Reset()
+		if err := png.Encode(&frame, renderFrame(decoded)); err != nil {
+			return err
+		}
+		for i := 0; i < frames; i++ {
+			if _, err := out.Write(frame.Bytes()); err != nil {
+				return err
+			}
+		}
+		count += frames
+		return nil
+	})
+	return count, err
+}
+
+/*
+Export a replay as an animated GIF, at a given frame rate - returns the number
+of frames in the animation (which may be fewer than the frame rate suggests,
+since unchanging frames are merged)
+*/
+func ExportReplayGIF(path string, fps float64, out io.Writer) (int, error) {
+	gw := newGIFWriter(out)
+	var prev *image.Paletted
+	count := 0
+
+	// Frame delays are in hundredths of a second, so keep the rounding from
+	// adding up over the animation
+	frames := 0
+	err := sampleReplay(path, fps, func(state []byte, hold int) error {
+		decoded, err := decodeState(state)
+		if err != nil {
+			return err
+		}
+		img := renderFrame(decoded)
+		start := math.Round(float64(frames) * 100 / fps)
+		frames += hold
+		delay := math.Round(float64(frames)*100/fps) - start
+		if err := gw.writeFrame(img, prev, uint16(min(delay, 0xffff))); err != nil {
+			return err
+		}
+		prev = img
+		count++
+		return nil
+	})
+	if err != nil {
+		return count, err
+	}
+	return count, gw.close()
+}
+
+/*
+A writer of animated GIFs, which writes each frame as it comes (the standard
+library's encoder needs every frame up front) - all frames share the palette
+of rendered frames, and loop forever
+*/
+type gifWriter struct {
+	w      *bufio.Writer
+	header bool // Whether the header was written yet
+}
+
+// The number of bits per pixel in exported GIFs (enough for the palette)
+const gifPaletteBits = 5
+
+// Create a new GIF writer
+func newGIFWriter(out io.Writer) *gifWriter {
+	return &gifWriter{w: bufio.NewWriter(out)}
+}
+
+// Write the GIF header, for frames of a given size
+func (gw *gifWriter) writeHeader(width, height int) {
+
+	// Logical screen descriptor, with a global color table
+	gw.w.WriteString("GIF89a")
+	binary.Write(gw.w, binary.LittleEndian, [2]uint16{uint16(width),
+		uint16(height)})
+	gw.w.Write([]byte{0x80 | 0x70 | (gifPaletteBits - 1), 0, 0})
+
+	// Global color table (padded to a power of 2 with black)
+	for i := 0; i < 1<<gifPaletteBits; i++ {
+		var r, g, b uint32
+		if i < len(renderPalette) {
+			r, g, b, _ = renderPalette[i].RGBA()
+		}
+		gw.w.Write([]byte{byte(r >> 8), byte(g >> 8), byte(b >> 8)})
+	}
+
+	// Loop forever (the Netscape application extension)
+	gw.w.Write([]byte{0x21, 0xff, 0x0b})
+	gw.w.WriteString("NETSCAPE2.0")
+	gw.w.Write([]byte{0x03, 0x01, 0x00, 0x00, 0x00})
+	gw.header = true
+}
+
+/*
+Write a frame, shown for a given delay (in hundredths of a second) - only the
+rectangle that differs from the previous frame (if there is one) is written
+*/
+func (gw *gifWriter) writeFrame(img, prev *image.Paletted,
+	delay uint16) error {
+	bounds := img.Bounds()
+	if !gw.header {
+		gw.writeHeader(bounds.Dx(), bounds.Dy())
+	}
+
+	// Find the rectangle that changed (a single pixel if none did, since a
+	// frame can't be empty)
+	rect := bounds
+	if prev != nil {
+		rect = image.Rectangle{}
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				if img.ColorIndexAt(x, y) != prev.ColorIndexAt(x, y) {
+					rect = rect.Union(image.Rect(x, y, x+1, y+1))
+				}
+			}
+		}
+		if rect.Empty() {
+			rect = image.Rect(0, 0, 1, 1)
+		}
+	}
+
+	// Graphic control extension: the delay, keeping the frame in place for
+	// the next one to be drawn over
+	gw.w.Write([]byte{0x21, 0xf9, 0x04, 0x04})
+	binary.Write(gw.w, binary.LittleEndian, delay)
+	gw.w.Write([]byte{0x00, 0x00})
+
+	// Image descriptor, without a local color table
+	gw.w.WriteByte(0x2c)
+	binary.Write(gw.w, binary.LittleEndian, [4]uint16{uint16(rect.Min.X),
+		uint16(rect.Min.Y), uint16(rect.Dx()), uint16(rect.Dy())})
+	gw.w.WriteByte(0x00)
+
+	// The pixels of the rectangle, compressed into sub-blocks
+	gw.w.WriteByte(gifPaletteBits)
+	bw := gifBlockWriter{w: gw.w}
+	lw := lzw.NewWriter(&bw, lzw.LSB, gifPaletteBits)
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		start := img.PixOffset(rect.Min.X, y)
+		if _, err := lw.Write(img.Pix[start : start+rect.Dx()]); err != nil {
+			return err
+		}
+	}
+	if err := lw.Close(); err != nil {
+		return err
+	}
+	return bw.close()
+}
+
+// Finish the GIF
+func (gw *gifWriter) close() error {
+	if !gw.header {
+		return fmt.Errorf("no frames to write")
+	}
+	gw.w.WriteByte(0x3b)
+	return gw.w.Flush()
+}
+
+// A writer which splits compressed image data into GIF sub-blocks
+type gifBlockWriter struct {
+	w   *bufio.Writer
+	buf [255]byte
+	n   int
+}
+
+// Write compressed data, writing out each sub-block once full
+func (bw *gifBlockWriter) Write(data []byte) (int, error) {
+	for _, b := range data {
+		bw.buf[bw.n] = b
+		if bw.n++; bw.n == len(bw.buf) {
+			if err := bw.flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return len(data), nil
+}
+
+// Write out the current sub-block, if it isn't empty
+func (bw *gifBlockWriter) flush() error {
+	if bw.n == 0 {
+		return nil
+	}
+	bw.w.WriteByte(byte(bw.n))
+	_, err := bw.w.Write(bw.buf[:bw.n])
+	bw.n = 0
+	return err
+}
+
+// Write out the last sub-block, followed by the terminator
+func (bw *gifBlockWriter) close() error {
+	if err := bw.flush(); err != nil {
+		return err
+	}
+	return bw.w.WriteByte(0x00)
+}
@@ -0,0 +1,291 @@
+package game
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+After each game, a result file is written for the judges, in two formats:
+JSON, with a summary of the game (final score, level reached, pellets eaten,
+and lives left) followed by its score timeline, deaths, ghosts eaten, and
+fruit collected, and CSV, with the timeline alone (one row per event, with the
+score after it):
+
+	tick,level,event,ghost,points,score,lives,action
+	1234,1,ghost_eaten,pink,400,2730,3,
+
+The timeline holds every event that changes the score or the lives (pellets,
+ghosts, fruit, deaths, and referee corrections), along with completed levels
+and the end of the game. Both files are named after the time the game ended
+(result_<time>.json and result_<time>.csv), in the results directory (each
+room in its own subdirectory). Games that are restarted before they end, and
+replays being played back, leave no result; a game restored from a snapshot
+only has the events since it was restored, and a rewound game drops the events
+that were rewound (see rewind.go). Judges can list and download the
+files through the REST API (see webserver/results.go).
+*/
+
+// The directory to write game results to (empty to disable results)
+var resultsDir string = ""
+
+// Mutex accompanying the above variable
+var muResultsDir sync.RWMutex
+
+// Configure the directory to write game results to (empty to disable results)
+func ConfigResultsDir(_resultsDir string) {
+	muResultsDir.Lock()
+	{
+		resultsDir = _resultsDir
+	}
+	muResultsDir.Unlock()
+}
+
+// Getter method for the results directory
+func getResultsDir() string {
+	muResultsDir.RLock()
+	defer muResultsDir.RUnlock()
+	return resultsDir
+}
+
+// An event on a game's timeline
+type resultEntry struct {
+	Tick   uint16 `json:"tick"`
+	Level  uint8  `json:"level"`
+	Event  string `json:"event"`
+	Ghost  string `json:"ghost,omitempty"`
+	Points uint16 `json:"points,omitempty"`
+	Score  uint16 `json:"score"`            // Score after the event
+	Lives  uint8  `json:"lives"`            // Lives after the event
+	Action string `json:"action,omitempty"` // Referee commands only
+}
+
+// The result of a game, as written to the JSON result file
+type gameResult struct {
+	Room        string        `json:"room,omitempty"`
+	Maze        string        `json:"maze"`
+	Seed        int64         `json:"seed"`
+	StartedAt   time.Time     `json:"startedAt"`
+	EndedAt     time.Time     `json:"endedAt"`
+	Score       uint16        `json:"score"`
+	Level       uint8         `json:"level"`
+	Pellets     uint16        `json:"pellets"` // Eaten over the whole game
+	Lives       uint8         `json:"lives"`
+	Timeline    []resultEntry `json:"timeline"`
+	Deaths      []resultEntry `json:"deaths"`
+	GhostsEaten []resultEntry `json:"ghostsEaten"`
+	Fruit       []resultEntry `json:"fruit"`
+}
+
+// The events that go on the timeline
+var timelineEvents = map[string]bool{
+	eventPellet:        true,
+	eventSuperPellet:   true,
+	eventGhostEaten:    true,
+	eventPacmanCaught:  true,
+	eventFruitEaten:    true,
+	eventLevelComplete: true,
+	eventReferee:       true,
+	eventTimeUp:        true,
+	eventGameOver:      true,
+}
+
+/*
+A result recorder object, which collects the events of a single game for its
+result files (only used by the game engine's go-routine)
+*/
+type resultRecorder struct {
+	dir    string     // Directory to write the result files to
+	result gameResult // Result collected so far
+}
+
+/*
+Create a new result recorder for a game - returns nil if results are disabled
+*/
+func newResultRecorder(gs *gameState) *resultRecorder {
+
+	// If no results directory is configured, don't record (each room writes
+	// to its own subdirectory)
+	dir := gs.rules.roomDir(getResultsDir())
+	if dir == "" {
+		return nil
+	}
+	return &resultRecorder{
+		dir: dir,
+		result: gameResult{
+			Room:      gs.rules.room,
+			Maze:      gs.maze.name,
+			Seed:      gs.seed,
+			StartedAt: time.Now().UTC(),
+
+			// Empty rather than null, for games without any
+			Timeline:    []resultEntry{},
+			Deaths:      []resultEntry{},
+			GhostsEaten: []resultEntry{},
+			Fruit:       []resultEntry{},
+		},
+	}
+}
+
+// Record a game event (with its details) towards the game's result
+func (rec *resultRecorder) record(gs *gameState, event string,
+	details map[string]any) {
+
+	// If the recorder is disabled, there's nothing to record
+	if rec == nil {
+		return
+	}
+
+	// The game's start resets the clock (it may have waited in the lobby)
+	if event == eventGameStart {
+		rec.result.StartedAt = time.Now().UTC()
+		return
+	}
+	if !timelineEvents[event] {
+		return
+	}
+
+	// Put the event on the timeline, with the score and lives after it
+	entry := resultEntry{
+		Tick:  gs.getCurrTicks(),
+		Level: gs.getLevel(),
+		Event: event,
+		Score: gs.getScore(),
+		Lives: gs.getLives(),
+	}
+	entry.Ghost, _ = details["ghost"].(string)
+	entry.Points, _ = details["points"].(uint16)
+	entry.Action, _ = details["action"].(string)
+	if lives, ok := details["lives"].(uint8); ok {
+		entry.Lives = lives // Pacman is caught before losing the life
+	}
+	switch event {
+	case eventPellet:
+		entry.Points = gs.powerUpPoints(gs.rules.PelletPoints)
+	case eventSuperPellet:
+		entry.Points = gs.powerUpPoints(gs.rules.SuperPelletPoints)
+	case eventPacmanCaught:
+		rec.result.Deaths = append(rec.result.Deaths, entry)
+	case eventGhostEaten:
+		rec.result.GhostsEaten = append(rec.result.GhostsEaten, entry)
+	case eventFruitEaten:
+		rec.result.Fruit = append(rec.result.Fruit, entry)
+	}
+	rec.result.Timeline = append(rec.result.Timeline, entry)
+}
+
+/*
+The number of entries on each of a result's lists, to trim the result back to
+when the game is rewound
+*/
+type resultMark struct {
+	timeline, deaths, ghostsEaten, fruit int
+}
+
+// Mark how much of the game's result has been collected so far
+func (rec *resultRecorder) mark() resultMark {
+	if rec == nil {
+		return resultMark{}
+	}
+	return resultMark{
+		timeline:    len(rec.result.Timeline),
+		deaths:      len(rec.result.Deaths),
+		ghostsEaten: len(rec.result.GhostsEaten),
+		fruit:       len(rec.result.Fruit),
+	}
+}
+
+// Drop the events collected since a mark (as the game is rewound past them)
+func (rec *resultRecorder) trim(mark resultMark) {
+	if rec == nil {
+		return
+	}
+	result := &rec.result
+	result.Timeline = result.Timeline[:min(mark.timeline, len(result.Timeline))]
+	result.Deaths = result.Deaths[:min(mark.deaths, len(result.Deaths))]
+	result.GhostsEaten = result.GhostsEaten[:min(mark.ghostsEaten,
+		len(result.GhostsEaten))]
+	result.Fruit = result.Fruit[:min(mark.fruit, len(result.Fruit))]
+}
+
+// Write the game's result files, once the game is over
+func (rec *resultRecorder) save(gs *gameState) {
+
+	// If the recorder is disabled, there's nothing to save
+	if rec == nil {
+		return
+	}
+
+	// Summarize the game
+	result := &rec.result
+	result.EndedAt = time.Now().UTC()
+	result.Score = gs.getScore()
+	result.Level = gs.getLevel()
+	result.Pellets = gs.getPelletsEaten()
+	result.Lives = gs.getLives()
+
+	// Write both files, named after the time the game ended
+	if err := os.MkdirAll(rec.dir, 0755); err != nil {
+		slog.Error("Results directory error", "err", err)
+		return
+	}
+	name := "result_" + result.EndedAt.Local().Format("20060102_150405.000")
+	path := filepath.Join(rec.dir, name)
+	if err := rec.writeJSON(path + ".json"); err != nil {
+		slog.Error("Result file error", "err", err)
+		return
+	}
+	if err := rec.writeCSV(path + ".csv"); err != nil {
+		slog.Error("Result file error", "err", err)
+		return
+	}
+	slog.Info("Game result saved", "room", result.Room, "path", path+".json",
+		"score", result.Score)
+}
+
+// Write the game's result as JSON
+func (rec *resultRecorder) writeJSON(path string) error {
+	out, err := json.MarshalIndent(&rec.result, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, out, 0644)
+}
+
+// Write the game's timeline as CSV
+func (rec *resultRecorder) writeCSV(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// One row per event, after a header
+	w := csv.NewWriter(file)
+	w.Write([]string{"tick", "level", "event", "ghost", "points", "score",
+		"lives", "action"})
+	for _, entry := range rec.result.Timeline {
+		w.Write([]string{
+			strconv.Itoa(int(entry.Tick)),
+			strconv.Itoa(int(entry.Level)),
+			entry.Event,
+			entry.Ghost,
+			strconv.Itoa(int(entry.Points)),
+			strconv.Itoa(int(entry.Score)),
+			strconv.Itoa(int(entry.Lives)),
+			entry.Action,
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return file.Close()
+}
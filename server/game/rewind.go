@@ -0,0 +1,173 @@
+package game
+
+import (
+	"log/slog"
+	"sync"
+)
+
+/*
+The engine keeps the states of the last few seconds of the live game, one for
+each tick, so that a referee can rewind the game and play it through again -
+e.g. to reproduce a disputed collision at a competition. A rewind command is
+'z' followed by the number of ticks to go back:
+
+	'z' + ticks (2 bytes) - rewind the game by a number of ticks
+
+The game goes back to the state that was broadcast that many ticks ago (or the
+oldest one kept, if it was longer ago than that), halted (see game_modes.go),
+so that it can be stepped through one update at a time ('n') or played on from
+there ('H'). The ghosts make the same decisions as the first time around, as
+their random number generators are rewound along with them, but the replay of
+the game is closed, since it no longer matches what was played. The game's
+result carries on, without the events that were rewound (see results.go).
+
+The states are kept in a ring buffer covering RewindSeconds of play (at the
+room's clock rate), deep copies of the state that are made as each tick is
+broadcast (see game_copy.go). The buffer is cleared whenever the game is
+replaced (on a restart or a restored snapshot).
+*/
+
+// The number of seconds of play that can be rewound (0 to disable rewinding)
+var rewindSeconds float64 = 0
+
+// Mutex accompanying the above variable
+var muRewind sync.RWMutex
+
+// Configure the number of seconds of play that can be rewound
+func ConfigRewindSeconds(seconds float64) {
+	muRewind.Lock()
+	{
+		rewindSeconds = max(seconds, 0)
+	}
+	muRewind.Unlock()
+}
+
+// Getter method for the number of seconds of play that can be rewound
+func getRewindSeconds() float64 {
+	muRewind.RLock()
+	defer muRewind.RUnlock()
+	return rewindSeconds
+}
+
+// A ring buffer of the states of the last few ticks of the game
+type rewindBuffer struct {
+	states []*gameState // States, oldest first starting at the start index
+	start  int          // Index of the oldest state
+	count  int          // Number of states kept
+}
+
+// Forget all of the states kept
+func (rb *rewindBuffer) clear() {
+	clear(rb.states)
+	rb.start, rb.count = 0, 0
+}
+
+// Keep a state, replacing the oldest one if the buffer is full
+func (rb *rewindBuffer) push(gs *gameState) {
+	if len(rb.states) == 0 {
+		return
+	}
+	if rb.count < len(rb.states) {
+		rb.states[(rb.start+rb.count)%len(rb.states)] = gs
+		rb.count++
+		return
+	}
+	rb.states[rb.start] = gs
+	rb.start = (rb.start + 1) % len(rb.states)
+}
+
+// Get the newest state kept (nil if there is none)
+func (rb *rewindBuffer) newest() *gameState {
+	if rb.count == 0 {
+		return nil
+	}
+	return rb.states[(rb.start+rb.count-1)%len(rb.states)]
+}
+
+/*
+Take the state from a number of ticks before the newest one (or the oldest
+kept), forgetting any newer states
+*/
+func (rb *rewindBuffer) rewind(ticks int) *gameState {
+	if rb.count == 0 {
+		return nil
+	}
+	rb.count = max(rb.count-ticks, 1)
+	for idx := rb.count; idx < len(rb.states); idx++ {
+		rb.states[(rb.start+idx)%len(rb.states)] = nil
+	}
+	return rb.newest()
+}
+
+/*
+Keep a copy of the live game's state as it is broadcast, if a tick has passed
+since the last one kept (only called from the engine loop)
+*/
+func (ge *GameEngine) recordRewind() {
+
+	// Size the buffer to the configured number of seconds of play
+	size := int(getRewindSeconds() * float64(ge.clockRate))
+	if size != len(ge.rewind.states) {
+		ge.rewind.clear()
+		ge.rewind.states = make([]*gameState, size)
+	}
+
+	// Only keep one state per tick (a paused game stays on the same tick)
+	if last := ge.rewind.newest(); last != nil &&
+		last.getCurrTicks() == ge.state.getCurrTicks() {
+		return
+	}
+	past := ge.state.deepCopy()
+	past.resultMark = ge.state.results.mark()
+	ge.rewind.push(past)
+}
+
+/*
+Interpret the rewind commands, which act on the game engine rather than the
+game state - returns whether the message was a rewind command
+*/
+func (ge *GameEngine) interpretRewindCommand(msg []byte) bool {
+
+	// Ignore any other commands
+	if len(msg) == 0 || msg[0] != 'z' {
+		return false
+	}
+	if len(msg) != 3 {
+		ge.reportCommandError(msg, invalidCommand('z', "expected 2 bytes"))
+		return true
+	}
+	ticks := int(msg[1])<<8 | int(msg[2])
+
+	// Find the state to go back to (the newest state is the current one)
+	past := ge.rewind.rewind(ticks)
+	if past == nil {
+		slog.Error("No states to rewind to. Ignoring...", "room", ge.rules.room)
+		return true
+	}
+	from := ge.state.getCurrTicks()
+
+	// The replay of the current game no longer applies
+	ge.recorder.close()
+	ge.recorder = nil
+
+	// Replace the game state with a live copy of the past one (still steered
+	// by the same players), halted so it can be stepped through, and carry on
+	// collecting its result without the events rewound (or start over, if the
+	// result was already saved)
+	state := past.deepCopy()
+	state.simulated = false
+	state.copySteering(ge.state)
+	state.halt()
+	if ge.player == nil && ge.headless == nil {
+		state.results = ge.state.results
+		if state.results != nil {
+			state.results.trim(past.resultMark)
+		} else {
+			state.results = newResultRecorder(state)
+		}
+	}
+	ge.state = state
+	slog.Info("Rewound game", "room", ge.rules.room, "from", from,
+		"to", state.getCurrTicks())
+	return true
+}
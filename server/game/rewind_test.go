@@ -0,0 +1,106 @@
+package game
+
+import "testing"
+
+/*
+Pushing past the buffer's size wraps around over the oldest states, and
+rewinding forgets the newer states wherever they sit in the ring
+*/
+func TestRewindBufferWraparound(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		size      int // Number of states the buffer holds
+		pushed    int // Ticks of the states pushed (1 to pushed)
+		rewound   int // Ticks to rewind
+		wantTicks int // Ticks of the state rewound to (0 = none)
+		wantCount int // Number of states kept after the rewind
+	}{
+		{"empty buffer", 0, 5, 1, 0, 0},
+		{"nothing pushed", 4, 0, 1, 0, 0},
+		{"not yet full", 4, 3, 1, 2, 2},
+		{"exactly full", 4, 4, 2, 2, 2},
+		{"wrapped once", 4, 6, 1, 5, 3},
+		{"wrapped past the end", 4, 7, 3, 4, 1},
+		{"past the oldest", 4, 9, 10, 6, 1},
+		{"no ticks", 3, 5, 0, 5, 3},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rb := rewindBuffer{states: make([]*gameState, tc.size)}
+			for ticks := 1; ticks <= tc.pushed; ticks++ {
+				rb.push(&gameState{currTicks: uint16(ticks)})
+			}
+			got := rb.rewind(tc.rewound)
+			switch {
+			case tc.wantTicks == 0 && got != nil:
+				t.Fatalf("rewound to tick %d, want none", got.currTicks)
+			case tc.wantTicks == 0:
+			case got == nil:
+				t.Fatalf("rewound to none, want tick %d", tc.wantTicks)
+			case got.currTicks != uint16(tc.wantTicks):
+				t.Fatalf("rewound to tick %d, want %d", got.currTicks,
+					tc.wantTicks)
+			}
+			if rb.count != tc.wantCount {
+				t.Fatalf("%d states kept, want %d", rb.count, tc.wantCount)
+			}
+
+			// The states forgotten should be released, and the ones kept
+			// should still run oldest to newest
+			kept := 0
+			for _, gs := range rb.states {
+				if gs != nil {
+					kept++
+				}
+			}
+			if kept != rb.count {
+				t.Fatalf("%d states still held, want %d", kept, rb.count)
+			}
+			for idx := 0; idx < rb.count; idx++ {
+				gs := rb.states[(rb.start+idx)%len(rb.states)]
+				want := tc.wantTicks - rb.count + 1 + idx
+				if gs.currTicks != uint16(want) {
+					t.Fatalf("state %d at tick %d, want %d", idx,
+						gs.currTicks, want)
+				}
+			}
+
+			// New states should go right after the one rewound to
+			if tc.size > 0 {
+				rb.push(&gameState{currTicks: 100})
+				if newest := rb.newest(); newest.currTicks != 100 {
+					t.Fatalf("newest state at tick %d after a push, want 100",
+						newest.currTicks)
+				}
+			}
+		})
+	}
+}
+
+// Trimming a game's result drops exactly the events recorded after the mark
+func TestResultRecorderTrim(t *testing.T) {
+	gs := newTestGame(t)
+	rec := &resultRecorder{}
+	rec.record(gs, eventPellet, nil)
+	rec.record(gs, eventGhostEaten, map[string]any{"ghost": "red"})
+	mark := rec.mark()
+	rec.record(gs, eventPellet, nil)
+	rec.record(gs, eventPacmanCaught, map[string]any{"lives": uint8(2)})
+	rec.record(gs, eventFruitEaten, nil)
+	rec.record(gs, eventGhostEaten, map[string]any{"ghost": "pink"})
+
+	rec.trim(mark)
+	res := rec.result
+	if len(res.Timeline) != 2 || len(res.Deaths) != 0 ||
+		len(res.GhostsEaten) != 1 || len(res.Fruit) != 0 {
+		t.Fatalf("trimmed to %d timeline, %d deaths, %d ghosts and %d fruit "+
+			"entries, want 2, 0, 1 and 0", len(res.Timeline), len(res.Deaths),
+			len(res.GhostsEaten), len(res.Fruit))
+	}
+	if res.GhostsEaten[0].Ghost != "red" {
+		t.Fatalf("kept the ghost eaten %q, want red", res.GhostsEaten[0].Ghost)
+	}
+
+	// A disabled recorder marks and trims nothing
+	var disabled *resultRecorder
+	disabled.trim(disabled.mark())
+}
@@ -0,0 +1,313 @@
+package game
+
+import (
+	"encoding/json"
+)
+
+/*
+A machine-readable description of the binary game state (as produced by
+serFull), for team clients in other languages to generate their decoders from
+- printed by running the server with --dump-schema. Fields are listed in
+order, with their byte offsets (null once the offsets depend on
+variable-length fields) and sizes (0 for variable-length fields), and the
+meanings of any bits packed into them. Locations and enums are described once,
+under "types" and "enums"
+*/
+
+// The layout of the game state
+type stateSchema struct {
+	Endianness string                  `json:"endianness"`
+	Fields     []schemaField           `json:"fields"`
+	Types      map[string]schemaType   `json:"types"`
+	Enums      map[string][]schemaEnum `json:"enums"`
+}
+
+// A field of the game state
+type schemaField struct {
+	Name        string        `json:"name"`
+	Offset      *int          `json:"offset"`
+	Size        int           `json:"size"`
+	Type        string        `json:"type"`
+	Count       int           `json:"count,omitempty"`
+	Enum        string        `json:"enum,omitempty"`
+	Bits        []schemaBits  `json:"bits,omitempty"`
+	Fields      []schemaField `json:"fields,omitempty"`
+	Extension   bool          `json:"extension,omitempty"`
+	Description string        `json:"description"`
+}
+
+// A group of bits packed into a field
+type schemaBits struct {
+	Name        string `json:"name"`
+	Mask        uint32 `json:"mask"`
+	Shift       int    `json:"shift"`
+	Signed      bool   `json:"signed,omitempty"`
+	Description string `json:"description"`
+}
+
+// A compound type, used by several fields
+type schemaType struct {
+	Size        int           `json:"size"`
+	Fields      []schemaField `json:"fields"`
+	Description string        `json:"description"`
+}
+
+// A value of an enum
+type schemaEnum struct {
+	Name  string `json:"name"`
+	Value uint8  `json:"value"`
+	DRow  *int8  `json:"dRow,omitempty"`
+	DCol  *int8  `json:"dCol,omitempty"`
+}
+
+// A builder for a list of fields, keeping track of their offsets
+type schemaBuilder struct {
+	fields []schemaField
+	offset int
+	fixed  bool // whether the offsets are still known
+}
+
+// Add a field, advancing the offset past it
+func (sb *schemaBuilder) add(field schemaField) {
+	if sb.fixed {
+		offset := sb.offset
+		field.Offset = &offset
+	}
+	count := max(field.Count, 1)
+	if field.Size == 0 {
+		sb.fixed = false
+	}
+	sb.offset += field.Size * count
+	sb.fields = append(sb.fields, field)
+}
+
+// Lay out a list of fields (of a compound type) one after another
+func schemaFields(fields ...schemaField) []schemaField {
+	sb := schemaBuilder{fixed: true}
+	for _, field := range fields {
+		sb.add(field)
+	}
+	return sb.fields
+}
+
+// Make an enum from a list of names (indexed by value)
+func schemaEnumOf(names []string) []schemaEnum {
+	values := make([]schemaEnum, len(names))
+	for value, name := range names {
+		values[value] = schemaEnum{Name: name, Value: uint8(value)}
+	}
+	return values
+}
+
+// Describe the layout of the game state
+func buildStateSchema() stateSchema {
+
+	// Packet header, and general game state information
+	sb := schemaBuilder{fixed: true}
+	sb.add(schemaField{Name: "ticks", Size: 2, Type: "uint16",
+		Description: "Ticks since the game started"})
+	sb.add(schemaField{Name: "updatePeriod", Size: 1, Type: "uint8",
+		Description: "Ticks per step"})
+	sb.add(schemaField{Name: "mode", Size: 1, Type: "uint8", Enum: "mode",
+		Description: "Game mode"})
+	sb.add(schemaField{Name: "modeSteps", Size: 1, Type: "uint8",
+		Description: "Steps until the mode changes (255 = indefinitely)"})
+	sb.add(schemaField{Name: "modeDuration", Size: 1, Type: "uint8",
+		Description: "Steps that the current mode lasts for"})
+	sb.add(schemaField{Name: "levelSteps", Size: 2, Type: "uint16",
+		Description: "Steps until the level speeds up"})
+	sb.add(schemaField{Name: "score", Size: 2, Type: "uint16",
+		Description: "Current score"})
+	sb.add(schemaField{Name: "level", Size: 1, Type: "uint8",
+		Description: "Current level (starting at 1)"})
+	sb.add(schemaField{Name: "lives", Size: 1, Type: "uint8",
+		Description: "Lives left"})
+	sb.add(schemaField{Name: "ghostCombo", Size: 1, Type: "uint8",
+		Description: "Ghosts eaten during the current fright"})
+
+	// Ghosts
+	sb.add(schemaField{Name: "ghosts", Size: 4, Type: "ghost",
+		Count: int(numClassicGhosts), Enum: "ghostColor",
+		Description: "The original four ghosts, in the order of their colors",
+		Fields: schemaFields(
+			schemaField{Name: "location", Size: 2, Type: "location",
+				Description: "Location and direction of the ghost"},
+			schemaField{Name: "frightSteps", Size: 1, Type: "uint8",
+				Description: "Fright steps and flags",
+				Bits: []schemaBits{
+					{Name: "frightSteps", Mask: 0b00111111, Shift: 0,
+						Description: "Steps until the fright ends"},
+					{Name: "flashing", Mask: 0b01000000, Shift: 6,
+						Description: "Whether the fright is ending soon"},
+					{Name: "spawning", Mask: 0b10000000, Shift: 7,
+						Description: "Whether the ghost is leaving the " +
+							"ghost house"},
+				}},
+			schemaField{Name: "trappedSteps", Size: 1, Type: "uint8",
+				Description: "Trapped steps and flag",
+				Bits: []schemaBits{
+					{Name: "trappedSteps", Mask: 0b01111111, Shift: 0,
+						Description: "Steps until the ghost is released"},
+					{Name: "eaten", Mask: 0b10000000, Shift: 7,
+						Description: "Whether the ghost was eaten"},
+				}},
+		)})
+
+	// Pacman and the fruit
+	sb.add(schemaField{Name: "pacman", Size: 2, Type: "location",
+		Description: "Location and direction of Pacman"})
+	sb.add(schemaField{Name: "fruit", Size: 2, Type: "location",
+		Description: "Location of the fruit (row and column 32 if there " +
+			"is no fruit, or 63 on mazes of more than 32 rows or columns)"})
+	sb.add(schemaField{Name: "fruitSteps", Size: 1, Type: "uint8",
+		Description: "Steps since the fruit spawned"})
+	sb.add(schemaField{Name: "fruitDuration", Size: 1, Type: "uint8",
+		Description: "Steps that the fruit stays for"})
+
+	// Pellets
+	sb.add(schemaField{Name: "pellets", Size: 4, Type: "uint32",
+		Count: int(pelletFieldRows),
+		Description: "Pellets in each row, as a bit per column (bit 0 = " +
+			"column 0), for the first 31 rows and 32 columns of the maze " +
+			"(see mazeSize for larger mazes)"})
+
+	// Extensions
+	sb.add(schemaField{Name: "lifecycle", Size: 1, Type: "uint8",
+		Enum: "lifecycle", Extension: true,
+		Description: "Lifecycle state of the game"})
+	sb.add(schemaField{Name: "maze", Size: 0, Type: "string8",
+		Extension: true,
+		Description: "Name of the maze, as a length (1 byte) followed by " +
+			"the name"})
+	sb.add(schemaField{Name: "superPellets", Size: 0, Type: "cells8",
+		Extension: true,
+		Description: "Super pellets, as a count (1 byte) followed by a " +
+			"row and column (1 byte each) for each"})
+	sb.add(schemaField{Name: "gameFPS", Size: 2, Type: "uint16",
+		Extension:   true,
+		Description: "Clock rate of the game engine, in ticks per second"})
+	sb.add(schemaField{Name: "pacmanPose", Size: 12, Type: "pose",
+		Extension: true,
+		Description: "Pacman's continuous pose (the center of its cell, " +
+			"unless tracked)"})
+	sb.add(schemaField{Name: "matchLeft", Size: 2, Type: "uint16",
+		Extension: true,
+		Description: "Ticks left on the match clock (0 if there is no " +
+			"match clock)"})
+	sb.add(schemaField{Name: "extraGhosts", Size: 0, Type: "ghosts8",
+		Enum: "ghostColor", Extension: true,
+		Description: "Ghosts beyond the original four, as a count (1 " +
+			"byte) followed by each ghost (laid out as in ghosts), in the " +
+			"order of their colors"})
+	sb.add(schemaField{Name: "gates", Size: 0, Type: "gates8",
+		Extension: true,
+		Description: "Gates of the maze (cells whose wall opens and " +
+			"closes), as a count (1 byte) followed by a row, column, and " +
+			"whether it is open (1 byte each, 1 = open) for each"})
+	sb.add(schemaField{Name: "mazeSize", Size: 0, Type: "mazeSize",
+		Extension: true,
+		Description: "Size of the maze, as its number of rows and columns " +
+			"(1 byte each), followed by all of its pellets if it has more " +
+			"than 31 rows or 32 columns, as (cols + 31) / 32 uint32s per " +
+			"row (bit 0 of the first = column 0)"})
+	sb.add(schemaField{Name: "fruitType", Size: 1, Type: "uint8",
+		Enum: "fruitType", Extension: true,
+		Description: "Fruit of the current level (whether or not it is " +
+			"out)"})
+	sb.add(schemaField{Name: "fruitPoints", Size: 2, Type: "uint16",
+		Extension:   true,
+		Description: "Points that the fruit of the current level is worth"})
+	sb.add(schemaField{Name: "powerUps", Size: 0, Type: "powerUps8",
+		Enum: "powerUp", Extension: true,
+		Description: "Power-ups left on the maze, as a count (1 byte) " +
+			"followed by a row, column, and type (1 byte each) for each"})
+	sb.add(schemaField{Name: "freezeSteps", Size: 1, Type: "uint8",
+		Extension:   true,
+		Description: "Steps left of the ghost freeze (0 if not frozen)"})
+	sb.add(schemaField{Name: "boostSteps", Size: 1, Type: "uint8",
+		Extension:   true,
+		Description: "Steps left of Pacman's speed boost"})
+	sb.add(schemaField{Name: "multiplierSteps", Size: 1, Type: "uint8",
+		Extension:   true,
+		Description: "Steps left of the point multiplier"})
+	sb.add(schemaField{Name: "teleporters", Size: 0, Type: "teleporters8",
+		Extension: true,
+		Description: "Teleporters of the maze, as a count (1 byte) " +
+			"followed by the row and column of both ends and the steps " +
+			"left of its cooldown (1 byte each, 0 = ready) for each"})
+	sb.add(schemaField{Name: "survival", Size: 1, Type: "uint8",
+		Extension:   true,
+		Description: "Whether the game is a survival game (1 if so)"})
+	sb.add(schemaField{Name: "survivalSteps", Size: 4, Type: "uint32",
+		Extension:   true,
+		Description: "Steps that Pacman has survived (survival games only)"})
+	sb.add(schemaField{Name: "respawnSteps", Size: 2, Type: "uint16",
+		Extension: true,
+		Description: "Steps until the pellets respawn (0 if they don't, " +
+			"or it isn't a survival game)"})
+	sb.add(schemaField{Name: "stateHash", Size: 8, Type: "uint64",
+		Extension: true,
+		Description: "Hash of the game state, for detecting desyncs (only " +
+			"sent if StateHash is enabled)"})
+
+	// Directions, with their row and column components
+	directions := schemaEnumOf(dirNames[:])
+	for dir := range directions {
+		directions[dir].DRow, directions[dir].DCol = &dRow[dir], &dCol[dir]
+	}
+
+	return stateSchema{
+		Endianness: "big",
+		Fields:     sb.fields,
+		Types: map[string]schemaType{
+			"location": {Size: 2,
+				Description: "A cell, with a direction component in the " +
+					"top 2 bits of each coordinate",
+				Fields: schemaFields(
+					schemaField{Name: "row", Size: 1, Type: "uint8",
+						Bits: []schemaBits{
+							{Name: "row", Mask: 0x3f, Shift: 0,
+								Description: "Row"},
+							{Name: "dRow", Mask: 0xc0, Shift: 6, Signed: true,
+								Description: "Row component of the direction"},
+						}},
+					schemaField{Name: "col", Size: 1, Type: "uint8",
+						Bits: []schemaBits{
+							{Name: "col", Mask: 0x3f, Shift: 0,
+								Description: "Column"},
+							{Name: "dCol", Mask: 0xc0, Shift: 6, Signed: true,
+								Description: "Column component of the " +
+									"direction"},
+						}},
+				)},
+			"pose": {Size: 12,
+				Description: "A continuous position in cell units (with the " +
+					"center of each cell at whole numbers) and heading",
+				Fields: schemaFields(
+					schemaField{Name: "row", Size: 4, Type: "float32",
+						Description: "Fractional row"},
+					schemaField{Name: "col", Size: 4, Type: "float32",
+						Description: "Fractional column"},
+					schemaField{Name: "heading", Size: 4, Type: "float32",
+						Description: "Heading in radians (right = 0, " +
+							"down = pi/2)"},
+				)},
+		},
+		Enums: map[string][]schemaEnum{
+			"direction":  directions,
+			"ghostColor": schemaEnumOf(ghostNames[:]),
+			"mode":       schemaEnumOf(modeNames[:]),
+			"lifecycle":  schemaEnumOf(lifecycleNames[:]),
+			"fruitType":  schemaEnumOf(fruitNames[:]),
+			"powerUp":    schemaEnumOf(powerUpNames[:]),
+		},
+	}
+}
+
+/*
+Get the layout of the binary game state as JSON - exported for the
+--dump-schema mode
+*/
+func StateSchema() ([]byte, error) {
+	return json.MarshalIndent(buildStateSchema(), "", "  ")
+}
@@ -11,7 +11,7 @@ IMPORTANT NOTE: All serializations are encoded in big-endian form
 Get the byte at a particular index (0 = least significant byte,
 1 = second least, etc.)
 */
-func getByte[T uint8 | uint16 | uint32](num T, byteIdx int) byte {
+func getByte[T uint8 | uint16 | uint32 | uint64](num T, byteIdx int) byte {
 
 	/*
 		Uses bitwise operation magic (not really, look up how the >> and &
@@ -58,15 +58,28 @@ func serUint32(num uint32, outputBuf []byte, startIdx int) int {
 	return startIdx
 }
 
+// Serialize a uint64 (eight getByte calls)
+func serUint64(num uint64, outputBuf []byte, startIdx int) int {
+
+	// Loop over each of the 8 bytes within the number (MSB first)
+	for byteIdx := 7; byteIdx >= 0; byteIdx-- {
+
+		// Serialize the byte
+		outputBuf[startIdx] = getByte(num, byteIdx)
+
+		// Add 1 to the start index, to prepare for serializing the next byte
+		startIdx++
+	}
+
+	// Return the starting index of the next field
+	return startIdx
+}
+
 /***************************** Field Serialization ****************************/
 
 // Serialize a location (no getByte calls, serialized manually)
 func serLocation(loc *locationState, outputBuf []byte, startIdx int) int {
 
-	// (Read) lock the location state
-	loc.RLock()
-	defer loc.RUnlock()
-
 	// Cover each coordinate of the location, one at a time
 	outputBuf[startIdx+0] = byte((dRow[loc.dir] << 6) | loc.row)
 	outputBuf[startIdx+1] = byte((dCol[loc.dir] << 6) | loc.col)
@@ -106,7 +119,7 @@ func (gs *gameState) serModeSteps(outputBuf []byte, startIdx int) int {
 	startIdx = serUint8(gs.getModeSteps(), outputBuf, startIdx)
 
 	// Serialize the duration of this (last unpaused) mode
-	modeDuration := modeDurations[gs.getLastUnpausedMode()]
+	modeDuration := gs.getWaveDuration(gs.getModeWave())
 	startIdx = serUint8(modeDuration, outputBuf, startIdx)
 
 	// Return the starting index of the next field
@@ -150,18 +163,24 @@ func (gs *gameState) serGhostCombo(outputBuf []byte, startIdx int) int {
 	return serUint8(gs.ghostCombo, outputBuf, startIdx)
 }
 
-// Serialize the pellets (4 * mazeRows bytes)
-func (gs *gameState) serPellets(outputBuf []byte, startIdx int) int {
+// Serialize the lifecycle state (1 byte)
+func (gs *gameState) serLifecycle(outputBuf []byte, startIdx int) int {
+
+	// Serialize and return the starting index of the next field
+	return serUint8(gs.getLifecycle(), outputBuf, startIdx)
+}
 
-	// (Read) lock the pellets array
-	gs.muPellets.RLock()
-	defer gs.muPellets.RUnlock()
+/*
+Serialize the pellets (4 * 31 bytes), for the first 31 rows and 32 columns of
+the maze - larger mazes send all of their pellets again with their size
+*/
+func (gs *gameState) serPellets(outputBuf []byte, startIdx int) int {
 
 	// Loop over each row
-	for row := int8(0); row < mazeRows; row++ {
+	for row := int8(0); row < pelletFieldRows; row++ {
 
 		// Serialize each row from uint32 to 4 bytes
-		startIdx = serUint32(gs.pellets[row], outputBuf, startIdx)
+		startIdx = serUint32(gs.pellets.word(row, 0), outputBuf, startIdx)
 	}
 
 	// Return the starting index of the next field
@@ -171,7 +190,7 @@ func (gs *gameState) serPellets(outputBuf []byte, startIdx int) int {
 // Serialize the location of Pacman (2 bytes)
 func (gs *gameState) serPacman(outputBuf []byte, startIdx int) int {
 
-	// Serialize the pacman state (with locking to be thread-safe)
+	// Serialize the pacman state
 	startIdx = serLocation(gs.pacmanLoc, outputBuf, startIdx)
 
 	// Return the starting index of the next field
@@ -181,23 +200,18 @@ func (gs *gameState) serPacman(outputBuf []byte, startIdx int) int {
 // Serialize the location of the fruit (2 bytes)
 func (gs *gameState) serFruit(outputBuf []byte, startIdx int) int {
 
-	// (Read) lock the fruit state
-	gs.muFruit.RLock()
-	{
-		if gs.fruitExists() { // Serialize the fruit's location if it exists
-			startIdx = serLocation(gs.fruitLoc, outputBuf, startIdx)
-		} else { // Otherwise, give an empty (0x00 0x00) location
-			startIdx = serLocation(emptyLoc, outputBuf, startIdx)
-		}
+	if gs.fruitExists() { // Serialize the fruit's location if it exists
+		startIdx = serLocation(gs.fruitLoc, outputBuf, startIdx)
+	} else { // Otherwise, give an empty (0x00 0x00) location
+		startIdx = serLocation(gs.maze.emptyLoc, outputBuf, startIdx)
 	}
-	gs.muFruit.RUnlock()
 
 	// Serialize the number of steps the fruit has been spawned
 	fruitSteps := gs.getFruitSteps()
 	startIdx = serUint8(fruitSteps, outputBuf, startIdx)
 
 	// Serialize the duration of the fruit
-	fruitDuration := fruitDuration
+	fruitDuration := gs.rules.FruitDuration
 	startIdx = serUint8(fruitDuration, outputBuf, startIdx)
 
 	// Return the starting index of the next field
@@ -213,9 +227,11 @@ func (gs *gameState) serGhost(color uint8, outputBuf []byte, startIdx int) int {
 	// Serialize the location information first
 	startIdx = serLocation(g.loc, outputBuf, startIdx)
 
-	// Lock the ghost's other state variables
-	g.muState.RLock()
-	defer g.muState.RUnlock()
+	// Add a flag at the 6th bit to indicate flashing (fright ending soon)
+	var flashFlag uint8 = 0
+	if g.isFlashing() {
+		flashFlag = 0b01000000
+	}
 
 	// Add a flag at the 7th (highest) bit to indicate spawning
 	var spawnFlag uint8 = 0
@@ -223,8 +239,8 @@ func (gs *gameState) serGhost(color uint8, outputBuf []byte, startIdx int) int {
 		spawnFlag = 0b10000000
 	}
 
-	// Serialize the fright steps and spawn flag info next
-	startIdx = serUint8(g.frightSteps|spawnFlag, outputBuf, startIdx)
+	// Serialize the fright steps, flash flag, and spawn flag info next
+	startIdx = serUint8(g.frightSteps|flashFlag|spawnFlag, outputBuf, startIdx)
 
 	// Add a flag at the 7th (highest) bit to indicate eaten
 	var eatenFlag uint8 = 0
@@ -252,8 +268,102 @@ func (gs *gameState) serGhosts(outputBuf []byte, startIdx int) int {
 	return startIdx
 }
 
+/*
+Serialize the ghosts beyond the original four (see maze.go), as a count
+followed by each ghost's information (1 + 4 * count bytes)
+*/
+func (gs *gameState) serExtraGhosts(outputBuf []byte, startIdx int) int {
+
+	// Serialize the count first
+	count := max(len(gs.ghosts)-int(numClassicGhosts), 0)
+	startIdx = serUint8(uint8(count), outputBuf, startIdx)
+
+	// Serialize the extra ghost states, in order of color
+	for color := numClassicGhosts; int(color) < len(gs.ghosts); color++ {
+		startIdx = gs.serGhost(color, outputBuf, startIdx)
+	}
+
+	// Return the starting index of the next field
+	return startIdx
+}
+
+/*
+Serialize the locations of the super pellets, as a count followed by a row
+and column for each (1 + 2 * count bytes)
+*/
+func (gs *gameState) serSuperPellets(outputBuf []byte, startIdx int) int {
+
+	// Leave space for the count, and fill it in once we are done
+	countIdx := startIdx
+	startIdx++
+	var count uint8 = 0
+
+	// Loop over each cell, serializing the locations of the super pellets
+	for row := int8(0); row < gs.maze.rows; row++ {
+		for col := int8(0); col < gs.maze.cols && gs.superPellets.rowSet(row); col++ {
+			if gs.superPellets.get(row, col) && count < 255 {
+				startIdx = serUint8(uint8(row), outputBuf, startIdx)
+				startIdx = serUint8(uint8(col), outputBuf, startIdx)
+				count++
+			}
+		}
+	}
+	serUint8(count, outputBuf, countIdx)
+
+	// Return the starting index of the next field
+	return startIdx
+}
+
+// Serialize the name of the maze (1 + name length bytes)
+func (gs *gameState) serMazeName(outputBuf []byte, startIdx int) int {
+
+	// Serialize the length of the name first
+	name := gs.maze.name
+	startIdx = serUint8(uint8(len(name)), outputBuf, startIdx)
+
+	// Serialize the name itself, and return the starting index of the next field
+	return startIdx + copy(outputBuf[startIdx:], name)
+}
+
+/*
+Serialize the size of the maze, as its number of rows and columns (1 byte
+each), followed by all of its pellets if they don't fit in the pellets field
+(more than 31 rows or 32 columns), as (cols + 31) / 32 uint32s per row
+(2 or 2 + 4 * rows * words bytes)
+*/
+func (gs *gameState) serMazeSize(outputBuf []byte, startIdx int) int {
+
+	// Serialize the number of rows and columns first
+	rows, cols := gs.maze.rows, gs.maze.cols
+	startIdx = serUint8(uint8(rows), outputBuf, startIdx)
+	startIdx = serUint8(uint8(cols), outputBuf, startIdx)
+
+	// Serialize the pellets of larger mazes, row by row
+	if rows > pelletFieldRows || cols > pelletFieldCols {
+		for row := int8(0); row < rows; row++ {
+			for word := 0; word < rowWords(cols); word++ {
+				startIdx = serUint32(gs.pellets.word(row, word), outputBuf,
+					startIdx)
+			}
+		}
+	}
+
+	// Return the starting index of the next field
+	return startIdx
+}
+
+// Serialize the clock rate of the game engine, in ticks per second (2 bytes)
+func (gs *gameState) serGameFPS(outputBuf []byte, startIdx int) int {
+
+	// Serialize the clock rate, and return the starting index of the next field
+	return serUint16(uint16(gs.rules.getFPS()), outputBuf, startIdx)
+}
+
 /***************************** State Serialization ****************************/
 
+// The most bytes that a serialized state can take up (with every extension)
+const maxStateLen = 2048
+
 // Serialize all the information of the game state
 func (gs *gameState) serFull(outputBuf []byte, startIdx int) int {
 
@@ -282,6 +392,29 @@ func (gs *gameState) serFull(outputBuf []byte, startIdx int) int {
 	// Pellets - serializes the pellets to the buffer
 	startIdx = gs.serPellets(outputBuf, startIdx)
 
+	/*
+		Extensions - fields added after the pellets, so that older clients
+		reading up to the pellets are unaffected
+	*/
+	startIdx = gs.serLifecycle(outputBuf, startIdx)
+	startIdx = gs.serMazeName(outputBuf, startIdx)
+	startIdx = gs.serSuperPellets(outputBuf, startIdx)
+	startIdx = gs.serGameFPS(outputBuf, startIdx)
+	startIdx = gs.serPacmanPose(outputBuf, startIdx)
+	startIdx = gs.serMatchLeft(outputBuf, startIdx)
+	startIdx = gs.serExtraGhosts(outputBuf, startIdx)
+	startIdx = gs.serGates(outputBuf, startIdx)
+	startIdx = gs.serMazeSize(outputBuf, startIdx)
+	startIdx = gs.serFruitType(outputBuf, startIdx)
+	startIdx = gs.serPowerUps(outputBuf, startIdx)
+	startIdx = gs.serTeleporters(outputBuf, startIdx)
+	startIdx = gs.serSurvival(outputBuf, startIdx)
+
+	// State hash - only sent if enabled (state_hash.go)
+	if stateHashEnabled {
+		startIdx = gs.serStateHash(outputBuf, startIdx)
+	}
+
 	// Return the starting index of the next field
 	return startIdx
 }
@@ -0,0 +1,390 @@
+package game
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+/*
+The JSON representation of the game state is an alternative to the compact
+binary serialization, for clients that would rather not decode bit-packed
+fields by hand (browsers, quick prototypes, scripting-language bots). It is
+produced from the binary serialization itself, so the two always agree:
+
+	{
+	  "ticks": 1234, "updatePeriod": 12, "mode": "chase", ...,
+	  "ghosts": [{"color": "red", "row": 11, "col": 13, "dir": "left", ...}],
+	  "pacman": {"row": 23, "col": 13, "dir": "right"},
+	  "fruit": null,
+	  "pellets": [[1, 1], [1, 2], ...],
+	  ...
+	}
+*/
+
+// The JSON representation of the game state
+type stateJSON struct {
+	Ticks           uint16           `json:"ticks"`
+	UpdatePeriod    uint8            `json:"updatePeriod"`
+	Mode            string           `json:"mode"`
+	ModeSteps       uint8            `json:"modeSteps"`
+	ModeDuration    uint8            `json:"modeDuration"`
+	LevelSteps      uint16           `json:"levelSteps"`
+	Score           uint16           `json:"score"`
+	Level           uint8            `json:"level"`
+	Lives           uint8            `json:"lives"`
+	GhostCombo      uint8            `json:"ghostCombo"`
+	Ghosts          []ghostJSON      `json:"ghosts"`
+	Pacman          locationJSON     `json:"pacman"`
+	Fruit           *cellJSON        `json:"fruit"`
+	FruitSteps      uint8            `json:"fruitSteps"`
+	FruitDuration   uint8            `json:"fruitDuration"`
+	Pellets         [][2]int8        `json:"pellets"`
+	Lifecycle       string           `json:"lifecycle,omitempty"`
+	Maze            string           `json:"maze,omitempty"`
+	SuperPellets    [][2]int8        `json:"superPellets,omitempty"`
+	GameFPS         uint16           `json:"gameFPS,omitempty"`
+	PacmanPose      *poseJSON        `json:"pacmanPose,omitempty"`
+	MatchLeft       uint16           `json:"matchLeft,omitempty"`
+	Gates           []gateJSON       `json:"gates,omitempty"`
+	Rows            int8             `json:"rows"`
+	Cols            int8             `json:"cols"`
+	FruitType       string           `json:"fruitType,omitempty"`
+	FruitPoints     uint16           `json:"fruitPoints,omitempty"`
+	PowerUps        []powerUpJSON    `json:"powerUps,omitempty"`
+	FreezeSteps     uint8            `json:"freezeSteps,omitempty"`
+	BoostSteps      uint8            `json:"boostSteps,omitempty"`
+	MultiplierSteps uint8            `json:"multiplierSteps,omitempty"`
+	Teleporters     []teleporterJSON `json:"teleporters,omitempty"`
+	Survival        bool             `json:"survival,omitempty"`
+	SurvivalSteps   uint32           `json:"survivalSteps,omitempty"`
+	RespawnSteps    uint16           `json:"respawnSteps,omitempty"`
+	StateHash       string           `json:"stateHash,omitempty"` // In hexadecimal
+
+	// Raw values, for other representations (left out of the JSON)
+	mode      uint8
+	lifecycle uint8
+	fruitType uint8
+	pellets   bitGrid
+	stateHash uint64
+}
+
+// The JSON representation of a ghost
+type ghostJSON struct {
+	Color        string `json:"color"`
+	Row          int8   `json:"row"`
+	Col          int8   `json:"col"`
+	Dir          string `json:"dir"`
+	FrightSteps  uint8  `json:"frightSteps"`
+	Flashing     bool   `json:"flashing"`
+	Spawning     bool   `json:"spawning"`
+	TrappedSteps uint8  `json:"trappedSteps"`
+	Eaten        bool   `json:"eaten"`
+	dir          uint8  // Raw direction index
+}
+
+// The JSON representation of a location with a direction
+type locationJSON struct {
+	Row int8   `json:"row"`
+	Col int8   `json:"col"`
+	Dir string `json:"dir"`
+	dir uint8  // Raw direction index
+}
+
+// The JSON representation of a continuous pose
+type poseJSON struct {
+	Row     float32 `json:"row"`
+	Col     float32 `json:"col"`
+	Heading float32 `json:"heading"` // In radians
+}
+
+// The JSON representation of a cell in the maze
+type cellJSON struct {
+	Row int8 `json:"row"`
+	Col int8 `json:"col"`
+}
+
+// The JSON representation of a gate of the maze (see gates.go)
+type gateJSON struct {
+	Row  int8 `json:"row"`
+	Col  int8 `json:"col"`
+	Open bool `json:"open"`
+}
+
+// The JSON representation of a power-up left on the maze (see power_ups.go)
+type powerUpJSON struct {
+	Row  int8   `json:"row"`
+	Col  int8   `json:"col"`
+	Type string `json:"type"`
+	kind uint8  // Raw power-up type
+}
+
+// The JSON representation of a teleporter of the maze (see teleporters.go)
+type teleporterJSON struct {
+	Ends          [2]cellJSON `json:"ends"`
+	CooldownSteps uint8       `json:"cooldownSteps"` // 0 if it is ready
+}
+
+/*
+A reader over a serialized game state, which keeps track of any reads past the
+end of the buffer (so that the fields can be read without checking each one)
+*/
+type serReader struct {
+	buf []byte
+	idx int
+	err error
+}
+
+// Read an individual byte
+func (r *serReader) uint8() uint8 {
+	if r.idx+1 > len(r.buf) {
+		r.err = fmt.Errorf("serialized state truncated at byte %d", r.idx)
+		return 0
+	}
+	r.idx++
+	return r.buf[r.idx-1]
+}
+
+// Read a uint16 (MSB first)
+func (r *serReader) uint16() uint16 {
+	return uint16(r.uint8())<<8 | uint16(r.uint8())
+}
+
+// Read a uint32 (MSB first)
+func (r *serReader) uint32() uint32 {
+	return uint32(r.uint16())<<16 | uint32(r.uint16())
+}
+
+// Read a uint64 (MSB first)
+func (r *serReader) uint64() uint64 {
+	return uint64(r.uint32())<<32 | uint64(r.uint32())
+}
+
+// Read a float32 (MSB first)
+func (r *serReader) float32() float32 {
+	return math.Float32frombits(r.uint32())
+}
+
+// Whether there are more bytes to read (for optional extensions)
+func (r *serReader) more() bool {
+	return r.err == nil && r.idx < len(r.buf)
+}
+
+// Read a location (the inverse of serLocation)
+func (r *serReader) location() locationJSON {
+
+	// Each byte holds a direction component in its top 2 bits (as a signed
+	// number), and a coordinate in the rest
+	rowByte, colByte := r.uint8(), r.uint8()
+	row, col := int8(rowByte&0x3f), int8(colByte&0x3f)
+	dr, dc := int8(rowByte)>>6, int8(colByte)>>6
+
+	// Find the direction with these components
+	dir := none
+	for d := up; d < numDirs; d++ {
+		if dRow[d] == dr && dCol[d] == dc {
+			dir = d
+		}
+	}
+	return locationJSON{Row: row, Col: col, Dir: dirNames[dir], dir: dir}
+}
+
+// Read a ghost's information (the inverse of serGhost)
+func (r *serReader) ghost(color uint8) ghostJSON {
+
+	// The ghost's flags are in the top bits of the fright and trapped steps
+	loc := r.location()
+	fright, trapped := r.uint8(), r.uint8()
+	return ghostJSON{
+		Color:        nameOf(ghostNames[:], color),
+		Row:          loc.Row,
+		Col:          loc.Col,
+		Dir:          loc.Dir,
+		dir:          loc.dir,
+		FrightSteps:  fright & 0b00111111,
+		Flashing:     fright&0b01000000 != 0,
+		Spawning:     fright&0b10000000 != 0,
+		TrappedSteps: trapped & 0b01111111,
+		Eaten:        trapped&0b10000000 != 0,
+	}
+}
+
+// Look up a name by its index, falling back to the index itself
+func nameOf(names []string, idx uint8) string {
+	if int(idx) < len(names) {
+		return names[idx]
+	}
+	return fmt.Sprint(idx)
+}
+
+/*
+Convert a serialized game state (as produced by serFull) into its JSON
+representation - exported so that the web server can offer it to clients
+*/
+func StateToJSON(buf []byte) ([]byte, error) {
+	state, err := decodeState(buf)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(state)
+}
+
+// Decode a serialized game state (as produced by serFull)
+func decodeState(buf []byte) (*stateJSON, error) {
+
+	// Read the fields in the same order that serFull writes them
+	r := serReader{buf: buf}
+	var state stateJSON
+
+	// Packet header
+	state.Ticks = r.uint16()
+	state.UpdatePeriod = r.uint8()
+	state.mode = r.uint8()
+	state.Mode = nameOf(modeNames[:], state.mode)
+	state.ModeSteps = r.uint8()
+	state.ModeDuration = r.uint8()
+	state.LevelSteps = r.uint16()
+
+	// General game state information
+	state.Score = r.uint16()
+	state.Level = r.uint8()
+	state.Lives = r.uint8()
+	state.GhostCombo = r.uint8()
+
+	// Ghosts (the original four - any others come in an extension)
+	for color := uint8(0); color < numClassicGhosts; color++ {
+		state.Ghosts = append(state.Ghosts, r.ghost(color))
+	}
+
+	// Pacman
+	state.Pacman = r.location()
+
+	// Fruit (checked against the empty location once the maze size is known)
+	fruit := r.location()
+	state.FruitSteps = r.uint8()
+	state.FruitDuration = r.uint8()
+
+	// Pellets (listed once the maze size is known)
+	for row := int8(0); row < pelletFieldRows; row++ {
+		state.pellets.setWord(row, 0, r.uint32())
+	}
+
+	// Extensions (left out if the state was serialized without them)
+	if r.more() {
+		state.lifecycle = r.uint8()
+		state.Lifecycle = nameOf(lifecycleNames[:], state.lifecycle)
+	}
+	if r.more() {
+		name := make([]byte, r.uint8())
+		for i := range name {
+			name[i] = r.uint8()
+		}
+		state.Maze = string(name)
+	}
+	if r.more() {
+		count := r.uint8()
+		for i := uint8(0); i < count; i++ {
+			row, col := int8(r.uint8()), int8(r.uint8())
+			state.SuperPellets = append(state.SuperPellets, [2]int8{row, col})
+		}
+	}
+	if r.more() {
+		state.GameFPS = r.uint16()
+	}
+	if r.more() {
+		state.PacmanPose = &poseJSON{Row: r.float32(), Col: r.float32(),
+			Heading: r.float32()}
+	}
+	if r.more() {
+		state.MatchLeft = r.uint16()
+	}
+	if r.more() {
+		count := r.uint8()
+		for i := uint8(0); i < count; i++ {
+			state.Ghosts = append(state.Ghosts, r.ghost(numClassicGhosts+i))
+		}
+	}
+	if r.more() {
+		count := r.uint8()
+		for i := uint8(0); i < count; i++ {
+			row, col := int8(r.uint8()), int8(r.uint8())
+			state.Gates = append(state.Gates,
+				gateJSON{Row: row, Col: col, Open: r.uint8() != 0})
+		}
+	}
+	state.Rows, state.Cols = classicMazeRows, classicMazeCols
+	if r.more() {
+		rows, cols := r.uint8(), r.uint8()
+		if rows > uint8(maxMazeRows) || cols > uint8(maxMazeCols) {
+			return nil, fmt.Errorf("maze size %d x %d out of range", rows, cols)
+		}
+		state.Rows, state.Cols = int8(rows), int8(cols)
+		if state.Rows > pelletFieldRows || state.Cols > pelletFieldCols {
+			state.pellets = bitGrid{}
+			for row := int8(0); row < state.Rows; row++ {
+				for word := 0; word < rowWords(state.Cols); word++ {
+					state.pellets.setWord(row, word, r.uint32())
+				}
+			}
+		}
+	}
+	if r.more() {
+		state.fruitType = r.uint8()
+		state.FruitType = nameOf(fruitNames[:], state.fruitType)
+		state.FruitPoints = r.uint16()
+	}
+	if r.more() {
+		count := r.uint8()
+		for i := uint8(0); i < count; i++ {
+			row, col, kind := int8(r.uint8()), int8(r.uint8()), r.uint8()
+			state.PowerUps = append(state.PowerUps, powerUpJSON{Row: row,
+				Col: col, Type: nameOf(powerUpNames[:], kind), kind: kind})
+		}
+		state.FreezeSteps = r.uint8()
+		state.BoostSteps = r.uint8()
+		state.MultiplierSteps = r.uint8()
+	}
+	if r.more() {
+		count := r.uint8()
+		for i := uint8(0); i < count; i++ {
+			var t teleporterJSON
+			for end := range t.Ends {
+				t.Ends[end] = cellJSON{Row: int8(r.uint8()),
+					Col: int8(r.uint8())}
+			}
+			t.CooldownSteps = r.uint8()
+			state.Teleporters = append(state.Teleporters, t)
+		}
+	}
+	if r.more() {
+		state.Survival = r.uint8() != 0
+		state.SurvivalSteps = r.uint32()
+		state.RespawnSteps = r.uint16()
+	}
+	if r.more() {
+		state.stateHash = r.uint64()
+		state.StateHash = fmt.Sprintf("%016x", state.stateHash)
+	}
+
+	// Check that the whole state could be read
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	// Fruit (an empty location means there is no fruit)
+	empty := emptyCoord(state.Rows, state.Cols)
+	if fruit.Row != empty || fruit.Col != empty {
+		state.Fruit = &cellJSON{Row: fruit.Row, Col: fruit.Col}
+	}
+
+	// Pellets, as a list of cells
+	state.Pellets = [][2]int8{}
+	for row := int8(0); row < state.Rows; row++ {
+		for col := int8(0); col < state.Cols; col++ {
+			if state.pellets.get(row, col) {
+				state.Pellets = append(state.Pellets, [2]int8{row, col})
+			}
+		}
+	}
+	return &state, nil
+}
@@ -0,0 +1,408 @@
+package game
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+/*
+The protobuf representation of the game state and commands follows the schema
+published in proto/pacbot.proto (at the top of the repository), so that team
+clients can generate matching decoders in any language. The messages are
+small and fixed, so they are encoded by hand here (in the protobuf wire
+format) rather than through generated code - any changes to the schema should
+be mirrored in the field numbers below (serialize_proto_test.go checks the
+messages against the schema itself, so that the two can't drift apart).
+*/
+
+// Protobuf wire types
+const (
+	wireVarint  uint64 = 0
+	wireFixed64 uint64 = 1
+	wireBytes   uint64 = 2
+	wireFixed32 uint64 = 5
+)
+
+/****************************** Protobuf Writing ******************************/
+
+// A writer for protobuf messages
+type protoWriter struct {
+	buf []byte
+}
+
+// Write a field tag (field number and wire type)
+func (w *protoWriter) tag(field int, wireType uint64) {
+	w.buf = binary.AppendUvarint(w.buf, uint64(field)<<3|wireType)
+}
+
+// Write a varint field (left out if zero, as in proto3)
+func (w *protoWriter) varint(field int, v uint64) {
+	if v != 0 {
+		w.tag(field, wireVarint)
+		w.buf = binary.AppendUvarint(w.buf, v)
+	}
+}
+
+// Write a bool field (left out if false, as in proto3)
+func (w *protoWriter) boolean(field int, b bool) {
+	if b {
+		w.varint(field, 1)
+	}
+}
+
+// Write a length-delimited field (always written, so that presence is kept)
+func (w *protoWriter) bytes(field int, b []byte) {
+	w.tag(field, wireBytes)
+	w.buf = binary.AppendUvarint(w.buf, uint64(len(b)))
+	w.buf = append(w.buf, b...)
+}
+
+// Write a fixed64 field (left out if zero, as in proto3)
+func (w *protoWriter) fixed64(field int, v uint64) {
+	if v != 0 {
+		w.tag(field, wireFixed64)
+		w.buf = binary.LittleEndian.AppendUint64(w.buf, v)
+	}
+}
+
+// Write a float field (left out if zero, as in proto3)
+func (w *protoWriter) float(field int, v float32) {
+	if v != 0 {
+		w.tag(field, wireFixed32)
+		w.buf = binary.LittleEndian.AppendUint32(w.buf, math.Float32bits(v))
+	}
+}
+
+// Write an embedded message field, filled in by a given function
+func (w *protoWriter) message(field int, fill func(*protoWriter)) {
+	var sub protoWriter
+	fill(&sub)
+	w.bytes(field, sub.buf)
+}
+
+// Write a Location message's fields
+func (w *protoWriter) location(row int8, col int8, dir uint8) {
+	w.varint(1, uint64(row))
+	w.varint(2, uint64(col))
+	w.varint(3, uint64(dir))
+}
+
+// Write a Cell message's fields
+func (w *protoWriter) cell(row int8, col int8) {
+	w.varint(1, uint64(row))
+	w.varint(2, uint64(col))
+}
+
+/*
+Convert a serialized game state (as produced by serFull) into a GameState
+protobuf message - exported so that the web server can offer it to clients
+*/
+func StateToProto(buf []byte) ([]byte, error) {
+
+	// Decode the serialized state first
+	state, err := decodeState(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	// Write the fields of the GameState message, in order
+	var w protoWriter
+	w.varint(1, uint64(state.Ticks))
+	w.varint(2, uint64(state.UpdatePeriod))
+	w.varint(3, uint64(state.mode))
+	w.varint(4, uint64(state.ModeSteps))
+	w.varint(5, uint64(state.ModeDuration))
+	w.varint(6, uint64(state.LevelSteps))
+	w.varint(7, uint64(state.Score))
+	w.varint(8, uint64(state.Level))
+	w.varint(9, uint64(state.Lives))
+	w.varint(10, uint64(state.GhostCombo))
+	for color, ghost := range state.Ghosts {
+		w.message(11, func(gw *protoWriter) {
+			gw.varint(1, uint64(color))
+			gw.message(2, func(lw *protoWriter) {
+				lw.location(ghost.Row, ghost.Col, ghost.dir)
+			})
+			gw.varint(3, uint64(ghost.FrightSteps))
+			gw.boolean(4, ghost.Flashing)
+			gw.boolean(5, ghost.Spawning)
+			gw.varint(6, uint64(ghost.TrappedSteps))
+			gw.boolean(7, ghost.Eaten)
+		})
+	}
+	w.message(12, func(lw *protoWriter) {
+		lw.location(state.Pacman.Row, state.Pacman.Col, state.Pacman.dir)
+	})
+	if state.Fruit != nil {
+		w.message(13, func(cw *protoWriter) {
+			cw.cell(state.Fruit.Row, state.Fruit.Col)
+		})
+	}
+	w.varint(14, uint64(state.FruitSteps))
+	w.varint(15, uint64(state.FruitDuration))
+
+	// The pellet bitmap, as packed fixed32 rows (little-endian, as in protobuf)
+	// with (cols + 31) / 32 of them for each row of the maze
+	w.message(16, func(pw *protoWriter) {
+		words := rowWords(state.Cols)
+		rows := make([]byte, 0, 4*int(state.Rows)*words)
+		for row := int8(0); row < state.Rows; row++ {
+			for word := 0; word < words; word++ {
+				rows = binary.LittleEndian.AppendUint32(rows,
+					state.pellets.word(row, word))
+			}
+		}
+		pw.bytes(1, rows)
+	})
+
+	// Extensions
+	w.varint(17, uint64(state.lifecycle))
+	if state.Maze != "" {
+		w.bytes(18, []byte(state.Maze))
+	}
+	for _, cell := range state.SuperPellets {
+		w.message(19, func(cw *protoWriter) {
+			cw.cell(cell[0], cell[1])
+		})
+	}
+	w.varint(20, uint64(state.GameFPS))
+	w.fixed64(21, state.stateHash)
+	if pose := state.PacmanPose; pose != nil {
+		w.message(22, func(pw *protoWriter) {
+			pw.float(1, pose.Row)
+			pw.float(2, pose.Col)
+			pw.float(3, pose.Heading)
+		})
+	}
+	w.varint(23, uint64(state.MatchLeft))
+	for _, gate := range state.Gates {
+		w.message(24, func(gw *protoWriter) {
+			gw.message(1, func(cw *protoWriter) {
+				cw.cell(gate.Row, gate.Col)
+			})
+			gw.boolean(2, gate.Open)
+		})
+	}
+	w.varint(25, uint64(state.Rows))
+	w.varint(26, uint64(state.Cols))
+	w.varint(27, uint64(state.fruitType))
+	w.varint(28, uint64(state.FruitPoints))
+	for _, p := range state.PowerUps {
+		w.message(29, func(pw *protoWriter) {
+			pw.message(1, func(cw *protoWriter) {
+				cw.cell(p.Row, p.Col)
+			})
+			pw.varint(2, uint64(p.kind))
+		})
+	}
+	w.varint(30, uint64(state.FreezeSteps))
+	w.varint(31, uint64(state.BoostSteps))
+	w.varint(32, uint64(state.MultiplierSteps))
+	for _, t := range state.Teleporters {
+		w.message(33, func(tw *protoWriter) {
+			for _, end := range t.Ends {
+				tw.message(1, func(cw *protoWriter) {
+					cw.cell(end.Row, end.Col)
+				})
+			}
+			tw.varint(2, uint64(t.CooldownSteps))
+		})
+	}
+	w.boolean(34, state.Survival)
+	w.varint(35, uint64(state.SurvivalSteps))
+	w.varint(36, uint64(state.RespawnSteps))
+
+	return w.buf, nil
+}
+
+/****************************** Protobuf Reading ******************************/
+
+// A field read from a protobuf message
+type protoField struct {
+	num      int
+	wireType uint64
+	value    uint64 // Value of a varint or fixed field
+	data     []byte // Contents of a length-delimited field
+}
+
+// Read all the fields of a protobuf message, in order
+func readProtoFields(msg []byte) ([]protoField, error) {
+	var fields []protoField
+	for len(msg) > 0 {
+
+		// Read the field tag
+		tag, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return nil, fmt.Errorf("invalid field tag")
+		}
+		msg = msg[n:]
+		field := protoField{num: int(tag >> 3), wireType: tag & 7}
+
+		// Read the field's value, according to its wire type
+		switch field.wireType {
+		case wireVarint:
+			field.value, n = binary.Uvarint(msg)
+			if n <= 0 {
+				return nil, fmt.Errorf("invalid varint (field %d)", field.num)
+			}
+			msg = msg[n:]
+		case wireFixed64:
+			if len(msg) < 8 {
+				return nil, fmt.Errorf("truncated field %d", field.num)
+			}
+			field.value = binary.LittleEndian.Uint64(msg)
+			msg = msg[8:]
+		case wireFixed32:
+			if len(msg) < 4 {
+				return nil, fmt.Errorf("truncated field %d", field.num)
+			}
+			field.value = uint64(binary.LittleEndian.Uint32(msg))
+			msg = msg[4:]
+		case wireBytes:
+			length, n := binary.Uvarint(msg)
+			if n <= 0 || uint64(len(msg)-n) < length {
+				return nil, fmt.Errorf("truncated field %d", field.num)
+			}
+			field.data = msg[n : n+int(length)]
+			msg = msg[n+int(length):]
+		default:
+			return nil, fmt.Errorf("unsupported wire type %d (field %d)",
+				field.wireType, field.num)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// Read a Cell message's row and column
+func readProtoCell(msg []byte) (uint8, uint8, error) {
+	fields, err := readProtoFields(msg)
+	if err != nil {
+		return 0, 0, err
+	}
+	var row, col uint8
+	for _, f := range fields {
+		switch f.num {
+		case 1:
+			row = uint8(f.value)
+		case 2:
+			col = uint8(f.value)
+		}
+	}
+	return row, col, nil
+}
+
+// The commands with no arguments, by their field number in Command
+var protoSimpleCommands = map[int]byte{
+	1:  'p', // pause
+	2:  'P', // play
+	3:  'r', // restart
+	9:  'h', // halt
+	10: 'H', // unhalt
+	11: 'n', // step
+	13: 'k', // save_snapshot
+}
+
+// The movement commands, by direction index
+var protoMoveCommands = [numDirs]byte{'w', 'a', 's', 'd'}
+
+/*
+Convert a Command protobuf message into the equivalent byte command (as read by
+interpretCommand) - exported so that the web server can accept it from clients
+*/
+func CommandFromProto(msg []byte) ([]byte, error) {
+
+	// Read the fields of the command
+	fields, err := readProtoFields(msg)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty command")
+	}
+
+	// Only one command can be set (if more are, the last one wins)
+	f := fields[len(fields)-1]
+	if cmd, ok := protoSimpleCommands[f.num]; ok {
+		return []byte{cmd}, nil
+	}
+	switch f.num {
+
+	// Move in a direction
+	case 4:
+		if f.value >= uint64(numDirs) {
+			return nil, fmt.Errorf("invalid direction %d", f.value)
+		}
+		return []byte{protoMoveCommands[f.value]}, nil
+
+	// Absolute position, or super pellet placement
+	case 5, 6:
+		row, col, err := readProtoCell(f.data)
+		if err != nil {
+			return nil, err
+		}
+		if f.num == 5 {
+			return []byte{'x', row, col}, nil
+		}
+		return []byte{'o', row, col}, nil
+
+	// Update period
+	case 7:
+		return []byte{'u', uint8(min(f.value, 255))}, nil
+
+	// Clock rate
+	case 8:
+		fps := uint16(min(f.value, 65535))
+		return []byte{'f', uint8(fps >> 8), uint8(fps)}, nil
+
+	// Maze profile, or snapshot to restore
+	case 12:
+		return append([]byte{'m'}, f.data...), nil
+	case 14:
+		return append([]byte{'K'}, f.data...), nil
+
+	// Referee command
+	case 15:
+		return refereeCommandFromProto(f.data)
+	}
+	return nil, fmt.Errorf("unknown command (field %d)", f.num)
+}
+
+// Convert a RefereeCommand protobuf message into the equivalent byte command
+func refereeCommandFromProto(msg []byte) ([]byte, error) {
+
+	// Read the fields of the command
+	fields, err := readProtoFields(msg)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty referee command")
+	}
+
+	// Only one action can be set (if more are, the last one wins)
+	f := fields[len(fields)-1]
+	change := int64(f.value>>1) ^ -int64(f.value&1) // (zigzag-encoded)
+	switch f.num {
+	case 1:
+		change := int16(min(max(change, -32768), 32767))
+		return []byte{'e', refereeScore, byte(change >> 8), byte(change)}, nil
+	case 2:
+		change := int8(min(max(change, -128), 127))
+		return []byte{'e', refereeLives, byte(change)}, nil
+	case 3:
+		return []byte{'e', refereeGhost, uint8(min(f.value, 255))}, nil
+	case 4:
+		row, col, err := readProtoCell(f.data)
+		if err != nil {
+			return nil, err
+		}
+		return []byte{'e', refereeTeleport, row, col}, nil
+	case 5:
+		return []byte{'e', refereeEnd}, nil
+	case 6:
+		return append([]byte{'e', refereeNote}, f.data...), nil
+	}
+	return nil, fmt.Errorf("unknown referee command (field %d)", f.num)
+}
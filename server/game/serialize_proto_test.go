@@ -0,0 +1,425 @@
+package game
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/bufbuild/protocompile"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+/*
+The protobuf messages are encoded and decoded by hand (see serialize_proto.go),
+so these tests check them against the published schema instead: the schema is
+compiled from proto/pacbot.proto, and the messages are read and written
+through it, so that any field number, wire type, or enum that drifts from the
+schema fails here.
+*/
+
+// Compile the published schema
+func loadProtoSchema(t *testing.T) protoreflect.FileDescriptor {
+	t.Helper()
+	compiler := protocompile.Compiler{
+		Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{
+			ImportPaths: []string{"../../proto"},
+		}),
+	}
+	files, err := compiler.Compile(context.Background(), "pacbot.proto")
+	if err != nil {
+		t.Fatalf("compiling the schema: %v", err)
+	}
+	return files[0]
+}
+
+// Create an empty message of a type from the schema
+func newProtoMessage(t *testing.T, schema protoreflect.FileDescriptor,
+	name string) *dynamicpb.Message {
+	t.Helper()
+	md := schema.Messages().ByName(protoreflect.Name(name))
+	if md == nil {
+		t.Fatalf("no message %s in the schema", name)
+	}
+	return dynamicpb.NewMessage(md)
+}
+
+// Get a field of a message by its name in the schema
+func protoGet(t *testing.T, m protoreflect.Message,
+	name string) protoreflect.Value {
+	t.Helper()
+	fd := m.Descriptor().Fields().ByName(protoreflect.Name(name))
+	if fd == nil {
+		t.Fatalf("no field %s in %s", name, m.Descriptor().Name())
+	}
+	return m.Get(fd)
+}
+
+// Get a scalar field of a message as an unsigned integer
+func protoUint(t *testing.T, m protoreflect.Message, name string) uint64 {
+	t.Helper()
+	fd := m.Descriptor().Fields().ByName(protoreflect.Name(name))
+	v := protoGet(t, m, name)
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		if v.Bool() {
+			return 1
+		}
+		return 0
+	case protoreflect.EnumKind:
+		return uint64(v.Enum())
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind:
+		return uint64(v.Int())
+	}
+	return v.Uint()
+}
+
+// Fail if a message (or any message within it) has fields the schema lacks
+func checkNoUnknown(t *testing.T, m protoreflect.Message, path string) {
+	t.Helper()
+	if len(m.GetUnknown()) > 0 {
+		t.Errorf("%s: fields not in the schema: %x", path, m.GetUnknown())
+	}
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		if fd.Message() == nil {
+			return true
+		}
+		if fd.IsList() {
+			for i := 0; i < v.List().Len(); i++ {
+				checkNoUnknown(t, v.List().Get(i).Message(),
+					fmt.Sprintf("%s.%s[%d]", path, fd.Name(), i))
+			}
+			return true
+		}
+		checkNoUnknown(t, v.Message(), path+"."+string(fd.Name()))
+		return true
+	})
+}
+
+// Check a Cell (or Location) message against a row and column
+func checkProtoCell(t *testing.T, m protoreflect.Message, path string,
+	row, col int8) {
+	t.Helper()
+	gotRow, gotCol := protoUint(t, m, "row"), protoUint(t, m, "col")
+	if gotRow != uint64(row) || gotCol != uint64(col) {
+		t.Errorf("%s: got (%d, %d), want (%d, %d)", path, int64(gotRow),
+			int64(gotCol), row, col)
+	}
+}
+
+// Set up a game on a maze with every part of the state in use
+func newProtoTestGame(t *testing.T, mazeName string) *gameState {
+	t.Helper()
+	maze, ok := lookupMaze(mazeName)
+	if !ok {
+		t.Fatalf("no maze %s", mazeName)
+	}
+	rules := newGameRules(RoomSettings{GameFPS: 24})
+	rules.Survival = true
+	rules.matchSeconds = 60
+	gs := newGameStateWith(rules, maze, 1)
+	gs.simulated = true
+	gs.currScore = 1234
+	gs.survivalSteps = 77
+	gs.boostSteps = 5
+	gs.setFruitSteps(9)
+	gs.frightenAllGhosts()
+	row, col := gs.pacmanLoc.getCoords()
+	gs.setPacmanPose(float64(row)+0.25, float64(col)-0.25, 1.5)
+	return gs
+}
+
+func TestStateToProtoMatchesSchema(t *testing.T) {
+	schema := loadProtoSchema(t)
+	defer ConfigStateHash(stateHashEnabled)
+	ConfigStateHash(true)
+
+	for _, mazeName := range []string{defaultMazeName, "gates", "powerups",
+		"teleporters"} {
+		t.Run(mazeName, func(t *testing.T) {
+			gs := newProtoTestGame(t, mazeName)
+			buf := make([]byte, maxStateLen)
+			buf = buf[:gs.serFull(buf, 0)]
+			want, err := decodeState(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := StateToProto(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Decode the message through the schema
+			msg := newProtoMessage(t, schema, "GameState")
+			if err := proto.Unmarshal(data, msg); err != nil {
+				t.Fatalf("decoding through the schema: %v", err)
+			}
+			checkNoUnknown(t, msg, "GameState")
+
+			// Scalar fields
+			for name, value := range map[string]uint64{
+				"ticks":            uint64(want.Ticks),
+				"update_period":    uint64(want.UpdatePeriod),
+				"mode":             uint64(want.mode),
+				"mode_steps":       uint64(want.ModeSteps),
+				"mode_duration":    uint64(want.ModeDuration),
+				"level_steps":      uint64(want.LevelSteps),
+				"score":            uint64(want.Score),
+				"level":            uint64(want.Level),
+				"lives":            uint64(want.Lives),
+				"ghost_combo":      uint64(want.GhostCombo),
+				"fruit_steps":      uint64(want.FruitSteps),
+				"fruit_duration":   uint64(want.FruitDuration),
+				"lifecycle":        uint64(want.lifecycle),
+				"game_fps":         uint64(want.GameFPS),
+				"state_hash":       want.stateHash,
+				"match_left":       uint64(want.MatchLeft),
+				"rows":             uint64(want.Rows),
+				"cols":             uint64(want.Cols),
+				"fruit_type":       uint64(want.fruitType),
+				"fruit_points":     uint64(want.FruitPoints),
+				"freeze_steps":     uint64(want.FreezeSteps),
+				"boost_steps":      uint64(want.BoostSteps),
+				"multiplier_steps": uint64(want.MultiplierSteps),
+				"survival":         1,
+				"survival_steps":   uint64(want.SurvivalSteps),
+				"respawn_steps":    uint64(want.RespawnSteps),
+			} {
+				if got := protoUint(t, msg, name); got != value {
+					t.Errorf("%s: got %d, want %d", name, got, value)
+				}
+			}
+			if got := protoGet(t, msg, "maze").String(); got != want.Maze {
+				t.Errorf("maze: got %q, want %q", got, want.Maze)
+			}
+
+			// Ghosts
+			ghosts := protoGet(t, msg, "ghosts").List()
+			if ghosts.Len() != len(want.Ghosts) {
+				t.Fatalf("ghosts: got %d, want %d", ghosts.Len(),
+					len(want.Ghosts))
+			}
+			for color, ghost := range want.Ghosts {
+				g := ghosts.Get(color).Message()
+				path := fmt.Sprintf("ghosts[%d]", color)
+				loc := protoGet(t, g, "loc").Message()
+				checkProtoCell(t, loc, path+".loc", ghost.Row, ghost.Col)
+				for name, value := range map[string]uint64{
+					"color":         uint64(color),
+					"fright_steps":  uint64(ghost.FrightSteps),
+					"flashing":      boolUint(ghost.Flashing),
+					"spawning":      boolUint(ghost.Spawning),
+					"trapped_steps": uint64(ghost.TrappedSteps),
+					"eaten":         boolUint(ghost.Eaten),
+				} {
+					if got := protoUint(t, g, name); got != value {
+						t.Errorf("%s.%s: got %d, want %d", path, name, got,
+							value)
+					}
+				}
+				if got := protoUint(t, loc, "dir"); got != uint64(ghost.dir) {
+					t.Errorf("%s.loc.dir: got %d, want %d", path, got,
+						ghost.dir)
+				}
+			}
+
+			// Pacman, its pose, and the fruit
+			pacman := protoGet(t, msg, "pacman").Message()
+			checkProtoCell(t, pacman, "pacman", want.Pacman.Row,
+				want.Pacman.Col)
+			if got := protoUint(t, pacman, "dir"); got != uint64(want.Pacman.dir) {
+				t.Errorf("pacman.dir: got %d, want %d", got, want.Pacman.dir)
+			}
+			pose := protoGet(t, msg, "pacman_pose").Message()
+			for name, value := range map[string]float32{
+				"row":     want.PacmanPose.Row,
+				"col":     want.PacmanPose.Col,
+				"heading": want.PacmanPose.Heading,
+			} {
+				got := float32(protoGet(t, pose, name).Float())
+				if math.Abs(float64(got-value)) > 1e-6 {
+					t.Errorf("pacman_pose.%s: got %g, want %g", name, got, value)
+				}
+			}
+			if want.Fruit == nil {
+				t.Fatal("the test game has no fruit")
+			}
+			checkProtoCell(t, protoGet(t, msg, "fruit").Message(), "fruit",
+				want.Fruit.Row, want.Fruit.Col)
+
+			// Pellets, as rows of words
+			words := protoGet(t, protoGet(t, msg, "pellets").Message(),
+				"rows").List()
+			if words.Len() != int(want.Rows)*rowWords(want.Cols) {
+				t.Fatalf("pellets: got %d words, want %d", words.Len(),
+					int(want.Rows)*rowWords(want.Cols))
+			}
+			for row := int8(0); row < want.Rows; row++ {
+				for word := 0; word < rowWords(want.Cols); word++ {
+					idx := int(row)*rowWords(want.Cols) + word
+					got := uint32(words.Get(idx).Uint())
+					if got != want.pellets.word(row, word) {
+						t.Errorf("pellets row %d word %d: got %08x, want %08x",
+							row, word, got, want.pellets.word(row, word))
+					}
+				}
+			}
+			superPellets := protoGet(t, msg, "super_pellets").List()
+			if superPellets.Len() != len(want.SuperPellets) {
+				t.Fatalf("super_pellets: got %d, want %d",
+					superPellets.Len(), len(want.SuperPellets))
+			}
+			for i, cell := range want.SuperPellets {
+				checkProtoCell(t, superPellets.Get(i).Message(),
+					fmt.Sprintf("super_pellets[%d]", i), cell[0], cell[1])
+			}
+
+			// Gates, power-ups, and teleporters (for the mazes with them)
+			gates := protoGet(t, msg, "gates").List()
+			if gates.Len() != len(want.Gates) {
+				t.Fatalf("gates: got %d, want %d", gates.Len(), len(want.Gates))
+			}
+			for i, gate := range want.Gates {
+				g := gates.Get(i).Message()
+				path := fmt.Sprintf("gates[%d]", i)
+				checkProtoCell(t, protoGet(t, g, "cell").Message(), path,
+					gate.Row, gate.Col)
+				if got := protoUint(t, g, "open"); got != boolUint(gate.Open) {
+					t.Errorf("%s.open: got %d, want %t", path, got, gate.Open)
+				}
+			}
+			powerUps := protoGet(t, msg, "power_ups").List()
+			if powerUps.Len() != len(want.PowerUps) {
+				t.Fatalf("power_ups: got %d, want %d", powerUps.Len(),
+					len(want.PowerUps))
+			}
+			for i, p := range want.PowerUps {
+				m := powerUps.Get(i).Message()
+				path := fmt.Sprintf("power_ups[%d]", i)
+				checkProtoCell(t, protoGet(t, m, "cell").Message(), path,
+					p.Row, p.Col)
+				if got := protoUint(t, m, "type"); got != uint64(p.kind) {
+					t.Errorf("%s.type: got %d, want %d", path, got, p.kind)
+				}
+			}
+			teleporters := protoGet(t, msg, "teleporters").List()
+			if teleporters.Len() != len(want.Teleporters) {
+				t.Fatalf("teleporters: got %d, want %d", teleporters.Len(),
+					len(want.Teleporters))
+			}
+			for i, tp := range want.Teleporters {
+				m := teleporters.Get(i).Message()
+				path := fmt.Sprintf("teleporters[%d]", i)
+				ends := protoGet(t, m, "ends").List()
+				if ends.Len() != len(tp.Ends) {
+					t.Fatalf("%s.ends: got %d, want %d", path, ends.Len(),
+						len(tp.Ends))
+				}
+				for end, cell := range tp.Ends {
+					checkProtoCell(t, ends.Get(end).Message(),
+						fmt.Sprintf("%s.ends[%d]", path, end), cell.Row,
+						cell.Col)
+				}
+				got := protoUint(t, m, "cooldown_steps")
+				if got != uint64(tp.CooldownSteps) {
+					t.Errorf("%s.cooldown_steps: got %d, want %d", path, got,
+						tp.CooldownSteps)
+				}
+			}
+		})
+	}
+}
+
+// Convert a flag to the value of a protobuf bool
+func boolUint(b bool) uint64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func TestCommandFromProtoMatchesSchema(t *testing.T) {
+	schema := loadProtoSchema(t)
+
+	// Set a field of a command by its name in the schema (nil for Empty)
+	set := func(m *dynamicpb.Message, name string, value any) {
+		fd := m.Descriptor().Fields().ByName(protoreflect.Name(name))
+		if fd == nil {
+			t.Fatalf("no field %s in %s", name, m.Descriptor().Name())
+		}
+		switch v := value.(type) {
+		case nil:
+			m.Set(fd, protoreflect.ValueOfMessage(
+				dynamicpb.NewMessage(fd.Message())))
+		case [2]int32:
+			cell := dynamicpb.NewMessage(fd.Message())
+			cell.Set(cell.Descriptor().Fields().ByName("row"),
+				protoreflect.ValueOfInt32(v[0]))
+			cell.Set(cell.Descriptor().Fields().ByName("col"),
+				protoreflect.ValueOfInt32(v[1]))
+			m.Set(fd, protoreflect.ValueOfMessage(cell))
+		case *dynamicpb.Message:
+			m.Set(fd, protoreflect.ValueOfMessage(v))
+		case protoreflect.EnumNumber:
+			m.Set(fd, protoreflect.ValueOfEnum(v))
+		default:
+			m.Set(fd, protoreflect.ValueOf(v))
+		}
+	}
+
+	// Build a referee command with one action set
+	referee := func(name string, value any) *dynamicpb.Message {
+		m := newProtoMessage(t, schema, "RefereeCommand")
+		set(m, name, value)
+		return m
+	}
+
+	for _, tc := range []struct {
+		field string
+		value any
+		want  []byte
+	}{
+		{"pause", nil, []byte{'p'}},
+		{"play", nil, []byte{'P'}},
+		{"restart", nil, []byte{'r'}},
+		{"move", protoreflect.EnumNumber(left), []byte{'a'}},
+		{"move", protoreflect.EnumNumber(down), []byte{'s'}},
+		{"position", [2]int32{23, 13}, []byte{'x', 23, 13}},
+		{"super_pellet", [2]int32{5, 6}, []byte{'o', 5, 6}},
+		{"update_period", uint32(8), []byte{'u', 8}},
+		{"clock_rate", uint32(300), []byte{'f', 1, 44}},
+		{"halt", nil, []byte{'h'}},
+		{"unhalt", nil, []byte{'H'}},
+		{"step", nil, []byte{'n'}},
+		{"maze", "mini", []byte("mmini")},
+		{"save_snapshot", nil, []byte{'k'}},
+		{"restore_snapshot", "a.json", []byte("Ka.json")},
+		{"referee", referee("adjust_score", int32(-50)),
+			[]byte{'e', refereeScore, 0xff, 0xce}},
+		{"referee", referee("adjust_lives", int32(2)),
+			[]byte{'e', refereeLives, 2}},
+		{"referee", referee("respawn_ghost", protoreflect.EnumNumber(cyan)),
+			[]byte{'e', refereeGhost, cyan}},
+		{"referee", referee("teleport", [2]int32{1, 2}),
+			[]byte{'e', refereeTeleport, 1, 2}},
+		{"referee", referee("end_game", nil), []byte{'e', refereeEnd}},
+		{"referee", referee("note", "ok"), []byte{'e', refereeNote, 'o', 'k'}},
+	} {
+		cmd := newProtoMessage(t, schema, "Command")
+		set(cmd, tc.field, tc.value)
+		data, err := proto.Marshal(cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := CommandFromProto(data)
+		if err != nil {
+			t.Errorf("%s: %v", tc.field, err)
+			continue
+		}
+		if string(got) != string(tc.want) {
+			t.Errorf("%s: got %q, want %q", tc.field, got, tc.want)
+		}
+	}
+}
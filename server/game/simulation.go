@@ -0,0 +1,121 @@
+package game
+
+/*
+Simulations let bots embedded in the server process (and test harnesses) play
+a game forward many times over, e.g. for Monte Carlo rollouts: a simulation is
+a copy of a game (see game_copy.go), stepped through one update at a time with
+the same logic as the engine loop, but without the engine loop's clock, so
+thousands of steps can be played per second. Like any copy of the game, a
+simulation logs nothing, sends no events, and doesn't touch the room's high
+score.
+*/
+
+/*
+Play the game forward to its next update, as the engine loop would (but
+without waiting for the game clock), with Pacman first moving one cell in a
+direction (none to stay in place) - returns false, without changing anything,
+if the game is paused (e.g. after Pacman was caught, or before it started)
+*/
+func (gs *gameState) simulateStep(dir uint8) bool {
+
+	// Paused games don't advance
+	if gs.isPaused() || gs.getPauseOnUpdate() {
+		return false
+	}
+
+	// Move Pacman (checking for collisions and collecting any pellet)
+	if dir < numDirs {
+		gs.movePacmanDir(dir)
+	}
+
+	// Advance the ticks to the next update (at most an update period later)
+	for ticks := gs.getUpdatePeriod(); ticks > 0; ticks-- {
+		gs.nextTick()
+		if gs.updateReady() {
+			break
+		}
+	}
+
+	// Update the ghosts and Pacman, as the engine loop does on each update
+	gs.updateAllGhosts()
+	gs.tryRespawnPacman()
+	if gs.getPauseOnUpdate() {
+		gs.pause()
+		gs.setPauseOnUpdate(false)
+	}
+	gs.checkCollisions()
+	gs.handleStepEvents()
+
+	// Plan the ghosts' next moves
+	gs.planAllGhosts()
+	return true
+}
+
+// A simulated copy of a game, for in-process bots to play forward
+type Simulation struct {
+	state *gameState
+}
+
+/*
+Start a simulation from the game engine's current game - safe to call while
+the engine loop runs
+*/
+func (ge *GameEngine) Simulate() (*Simulation, error) {
+	gs, err := ge.copyState()
+	if err != nil {
+		return nil, err
+	}
+	return &Simulation{state: gs}, nil
+}
+
+// Make an independent copy of the simulation (e.g. for each rollout)
+func (sim *Simulation) Clone() *Simulation {
+	return &Simulation{state: sim.state.deepCopy()}
+}
+
+/*
+Play the simulation forward by one update, with Pacman first moving one cell
+in a direction (0 = up, 1 = left, 2 = down, 3 = right, 4 = stay in place, as
+in the serialized state) - returns false if the game is paused, e.g. because
+Pacman was caught
+*/
+func (sim *Simulation) Step(dir uint8) bool {
+	return sim.state.simulateStep(dir)
+}
+
+// Get the score of the simulated game
+func (sim *Simulation) Score() uint16 {
+	return sim.state.getScore()
+}
+
+// Get the lives left in the simulated game
+func (sim *Simulation) Lives() uint8 {
+	return sim.state.getLives()
+}
+
+// Get the level of the simulated game
+func (sim *Simulation) Level() uint8 {
+	return sim.state.getLevel()
+}
+
+// Get the number of pellets left in the simulated game
+func (sim *Simulation) Pellets() uint16 {
+	return sim.state.getNumPellets()
+}
+
+/*
+Get the state hash of the simulated game (see state_hash.go), e.g. as a key for
+a transposition table
+*/
+func (sim *Simulation) Hash() uint64 {
+	return sim.state.stateHash()
+}
+
+/*
+Get the serialized state of the simulated game, in the same format that is
+broadcast to clients (so that it can be decoded with the client package)
+*/
+func (sim *Simulation) State() []byte {
+	buf := make([]byte, maxStateLen)
+	return buf[:sim.state.serFull(buf, 0)]
+}
@@ -0,0 +1,466 @@
+package game
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+/*
+Snapshots hold the complete state of a game (unlike the serialized state sent
+to clients, they include everything needed to continue the game exactly where
+it left off, such as the ghost house counters and the random number generator
+states) - they are saved as JSON, so that they can also be inspected or edited
+by hand when debugging
+*/
+
+// Current version of the snapshot format
+const snapshotVersion uint8 = 1
+
+// The number of frames between automatic snapshots (for crash recovery)
+const snapshotAutosavePeriod uint32 = 240 // 10 seconds at 24 fps
+
+// The name of the automatic snapshot file, within the snapshot directory
+const snapshotAutosaveName = "autosave.json"
+
+// The directory to save snapshots to (empty to disable snapshots)
+var snapshotDir string = ""
+
+// Mutex accompanying the above variable
+var muSnapshotDir sync.RWMutex
+
+/*
+Configure the directory to save snapshots to - the game is also saved there
+periodically, for crash recovery (empty to disable snapshots)
+*/
+func ConfigSnapshotDir(_snapshotDir string) {
+	muSnapshotDir.Lock()
+	{
+		snapshotDir = _snapshotDir
+	}
+	muSnapshotDir.Unlock()
+}
+
+// Getter method for the snapshot directory
+func getSnapshotDir() string {
+	muSnapshotDir.RLock()
+	defer muSnapshotDir.RUnlock()
+	return snapshotDir
+}
+
+/***************************** Snapshot Contents ******************************/
+
+// A snapshot of a location state
+type locSnapshot struct {
+	Row int8
+	Col int8
+	Dir uint8
+}
+
+// A snapshot of a ghost state
+type ghostSnapshot struct {
+	Loc           locSnapshot
+	NextLoc       locSnapshot
+	ScatterTarget locSnapshot
+	TrappedSteps  uint8
+	FrightSteps   uint8
+	DotCount      uint8
+	Spawning      bool
+	Eaten         bool
+	Waiting       bool
+	RngDraws      uint64 // Numbers generated by the ghost's generator
+}
+
+// A snapshot of a game state
+type gameSnapshot struct {
+	Version uint8
+
+	// Header
+	CurrTicks        uint16
+	UpdatePeriod     uint8
+	Mode             uint8
+	LastUnpausedMode uint8
+	PauseOnUpdate    bool
+	ModeSteps        uint8
+	ModeWave         uint8
+	LevelSteps       uint16
+	Lifecycle        uint8
+	CountdownLeft    uint16
+	MatchLeft        uint16
+
+	// Game information
+	CurrScore  uint16
+	BonusLives uint8
+	CurrLevel  uint8
+	CurrLives  uint8
+
+	// Pacman and the fruit
+	PacmanLoc   locSnapshot
+	PacmanMoves uint8
+	FruitLoc    locSnapshot
+	FruitSteps  uint8
+
+	// Ghosts
+	Ghosts          []ghostSnapshot
+	GhostCombo      uint8
+	GlobalDotCount  uint8
+	GlobalDotActive bool
+	LastPelletTick  uint16
+
+	// Pellets
+	Pellets      bitGrid
+	SuperPellets bitGrid
+	NumPellets   uint16
+	PelletsEaten uint16
+	LevelEaten   uint16
+
+	// Whether each of the maze's gates is open (see gates.go)
+	Gates []bool
+
+	// Power-ups left, and the steps left of their effects (see power_ups.go)
+	PowerUpsLeft    uint32
+	FreezeSteps     uint8
+	BoostSteps      uint8
+	MultiplierSteps uint8
+
+	// Steps left of each teleporter's cooldown (see teleporters.go)
+	TeleportSteps []uint8
+
+	// Progress of a survival game (see survival.go)
+	SurvivalSteps uint32
+	RespawnSteps  uint16
+
+	// Maze layout and seed
+	MazeName string
+	MazeGrid string
+	Seed     int64
+}
+
+// Take a snapshot of a location state
+func (loc *locationState) snapshot() locSnapshot {
+	row, col := loc.getCoords()
+	return locSnapshot{Row: row, Col: col, Dir: loc.getDir()}
+}
+
+// Create a location state from a snapshot
+func (snap locSnapshot) restore() *locationState {
+	return newLocationState(snap.Row, snap.Col, snap.Dir)
+}
+
+/*
+Take a snapshot of the complete game state - this should be called from the
+game engine's go-routine, between updates
+*/
+func (gs *gameState) snapshot() *gameSnapshot {
+
+	// New snapshot object, filled in using the usual helpers where possible
+	snap := gameSnapshot{
+		Version:          snapshotVersion,
+		CurrTicks:        gs.getCurrTicks(),
+		UpdatePeriod:     gs.getUpdatePeriod(),
+		Mode:             gs.getMode(),
+		LastUnpausedMode: gs.getLastUnpausedMode(),
+		PauseOnUpdate:    gs.getPauseOnUpdate(),
+		ModeSteps:        gs.getModeSteps(),
+		ModeWave:         gs.getModeWave(),
+		LevelSteps:       gs.getLevelSteps(),
+		Lifecycle:        gs.getLifecycle(),
+		CountdownLeft:    gs.getCountdownLeft(),
+		MatchLeft:        gs.getMatchLeft(),
+		CurrScore:        gs.getScore(),
+		CurrLevel:        gs.getLevel(),
+		CurrLives:        gs.getLives(),
+		PacmanLoc:        gs.pacmanLoc.snapshot(),
+		PacmanMoves:      gs.getPacmanMoves(),
+		FruitLoc:         gs.fruitLoc.snapshot(),
+		FruitSteps:       gs.getFruitSteps(),
+		GhostCombo:       gs.ghostCombo,
+		PowerUpsLeft:     gs.powerUpsLeft,
+		FreezeSteps:      gs.freezeSteps,
+		BoostSteps:       gs.boostSteps,
+		MultiplierSteps:  gs.multiplierSteps,
+		SurvivalSteps:    gs.survivalSteps,
+		RespawnSteps:     gs.respawnSteps,
+		MazeName:         gs.maze.name,
+		MazeGrid:         string(gs.maze.grid),
+		Seed:             gs.seed,
+	}
+
+	// Take a snapshot of the gates
+	for gate := range gs.maze.gates {
+		snap.Gates = append(snap.Gates, gs.gateOpen(gate))
+	}
+
+	// Take a snapshot of the teleporters' cooldowns
+	for idx := range gs.maze.teleporters {
+		snap.TeleportSteps = append(snap.TeleportSteps, gs.teleportSteps[idx])
+	}
+
+	// Take a snapshot of the bonus lives
+	snap.BonusLives = gs.bonusLives
+
+	// Take a snapshot of the pellet counters used to release ghosts from the
+	// ghost house
+	snap.GlobalDotCount = gs.globalDotCount
+	snap.GlobalDotActive = gs.globalDotActive
+	snap.LastPelletTick = gs.lastPelletTick
+
+	// Take a snapshot of the pellets
+	snap.Pellets = gs.pellets
+	snap.SuperPellets = gs.superPellets
+	snap.NumPellets = gs.numPellets
+	snap.PelletsEaten = gs.pelletsEaten
+	snap.LevelEaten = gs.levelEaten
+
+	// Take a snapshot of each ghost
+	for _, g := range gs.ghosts {
+		snap.Ghosts = append(snap.Ghosts, ghostSnapshot{
+			Loc:           g.loc.snapshot(),
+			NextLoc:       g.nextLoc.snapshot(),
+			ScatterTarget: g.scatterTarget.snapshot(),
+			TrappedSteps:  g.trappedSteps,
+			FrightSteps:   g.frightSteps,
+			DotCount:      g.dotCount,
+			Spawning:      g.spawning,
+			Eaten:         g.eaten,
+			Waiting:       g.waiting,
+			RngDraws:      g.rngSrc.draws,
+		})
+	}
+
+	// Return the snapshot
+	return &snap
+}
+
+// Create a game state from a snapshot, under a given set of rules
+func (snap *gameSnapshot) restore(rules *gameRules) (*gameState, error) {
+
+	// Make sure that the snapshot is compatible
+	if snap.Version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", snap.Version)
+	}
+	if snap.Mode >= numModes || snap.LastUnpausedMode >= numModes ||
+		snap.Lifecycle >= numLifecycles || snap.UpdatePeriod == 0 {
+		return nil, fmt.Errorf("invalid game mode or timing")
+	}
+
+	// Load the maze layout that the game was played on
+	maze, err := parseMaze(snap.MazeName, []byte(snap.MazeGrid))
+	if err != nil {
+		return nil, fmt.Errorf("maze: %v", err)
+	}
+	if len(snap.Ghosts) != maze.ghostSlots() {
+		return nil, fmt.Errorf("expected %d ghosts, found %d",
+			maze.ghostSlots(), len(snap.Ghosts))
+	}
+
+	// Start from a new game state, and fill in the rest of the snapshot
+	gs := newGameStateWith(rules, maze, snap.Seed)
+	gs.currTicks = snap.CurrTicks
+	gs.updatePeriod = snap.UpdatePeriod
+	gs.mode = snap.Mode
+	gs.lastUnpausedMode = snap.LastUnpausedMode
+	gs.pauseOnUpdate = snap.PauseOnUpdate
+	gs.modeSteps = snap.ModeSteps
+	gs.modeWave = snap.ModeWave
+	gs.levelSteps = snap.LevelSteps
+	gs.lifecycle = snap.Lifecycle
+	gs.countdownLeft = snap.CountdownLeft
+	gs.matchLeft = snap.MatchLeft
+	gs.currScore = snap.CurrScore
+	gs.bonusLives = snap.BonusLives
+	gs.currLevel = snap.CurrLevel
+	gs.currLives = snap.CurrLives
+	gs.pacmanLoc = snap.PacmanLoc.restore()
+	gs.pacmanMoves = snap.PacmanMoves
+	gs.fruitLoc = snap.FruitLoc.restore()
+	gs.fruitSteps = snap.FruitSteps
+	gs.ghostCombo = snap.GhostCombo
+	gs.globalDotCount = snap.GlobalDotCount
+	gs.globalDotActive = snap.GlobalDotActive
+	gs.lastPelletTick = snap.LastPelletTick
+	gs.pellets = snap.Pellets
+	gs.superPellets = snap.SuperPellets
+	gs.numPellets = snap.NumPellets
+	gs.pelletsEaten = snap.PelletsEaten
+	gs.levelEaten = snap.LevelEaten
+	gs.powerUpsLeft = snap.PowerUpsLeft & maze.allPowerUps()
+	gs.freezeSteps = snap.FreezeSteps
+	gs.boostSteps = snap.BoostSteps
+	gs.multiplierSteps = snap.MultiplierSteps
+	copy(gs.teleportSteps[:len(maze.teleporters)], snap.TeleportSteps)
+	gs.survivalSteps = snap.SurvivalSteps
+	gs.respawnSteps = snap.RespawnSteps
+	for gate, open := range snap.Gates {
+		if gate < len(maze.gates) {
+			row, col := maze.gates[gate][0], maze.gates[gate][1]
+			gs.walls.set(row, col, !open)
+		}
+	}
+
+	// Restore each of the ghosts
+	for color, gSnap := range snap.Ghosts {
+		g := gs.ghosts[color]
+		g.loc = gSnap.Loc.restore()
+		g.nextLoc = gSnap.NextLoc.restore()
+		g.scatterTarget = gSnap.ScatterTarget.restore()
+		g.trappedSteps = gSnap.TrappedSteps
+		g.frightSteps = gSnap.FrightSteps
+		g.dotCount = gSnap.DotCount
+		g.spawning = gSnap.Spawning
+		g.eaten = gSnap.Eaten
+		g.waiting = gSnap.Waiting
+
+		// Skip the random number generator ahead to where it was
+		g.rngSrc = newCountingSource(gs.seed+int64(color), gSnap.RngDraws)
+		g.rng = rand.New(g.rngSrc)
+	}
+
+	// Return the restored game state
+	return gs, nil
+}
+
+/****************************** Snapshot Files *******************************/
+
+// Save a snapshot of a game state to a file (atomically, via a temporary file)
+func saveSnapshot(gs *gameState, path string) error {
+
+	// Encode the snapshot as (indented) JSON
+	data, err := json.MarshalIndent(gs.snapshot(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first, so a crash can't leave a partial file
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// Load a game state from a snapshot file, under a given set of rules
+func loadSnapshot(path string, rules *gameRules) (*gameState, error) {
+
+	// Read the snapshot file
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Decode the snapshot, and restore the game state from it
+	var snap gameSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, err
+	}
+	return snap.restore(rules)
+}
+
+/***************************** Engine Integration *****************************/
+
+/*
+Restore the game engine's state from a snapshot file (e.g. to recover from a
+crash, or to debug the ghosts from a particular point) - this should be
+called before the engine loop is started
+*/
+func (ge *GameEngine) RestoreSnapshot(path string) error {
+
+	// Load the snapshot
+	gs, err := loadSnapshot(path, ge.rules)
+	if err != nil {
+		return err
+	}
+
+	// Replace the game state (whose update for this tick already happened)
+	ge.state = gs
+	ge.prepared = true
+	slog.Info("Restored snapshot", "path", path, "tick", gs.getCurrTicks())
+	return nil
+}
+
+/*
+Interpret the snapshot commands, which act on the game engine rather than the
+game state - returns whether the message was a snapshot command
+*/
+func (ge *GameEngine) interpretSnapshotCommand(msg []byte) bool {
+
+	// Ignore any other commands
+	if len(msg) == 0 || (msg[0] != 'k' && msg[0] != 'K') {
+		return false
+	}
+
+	// Snapshots need a directory to be saved to (each room saves to its own
+	// subdirectory)
+	dir := ge.rules.roomDir(getSnapshotDir())
+	if dir == "" {
+		slog.Error("No snapshot directory configured. Ignoring...")
+		return true
+	}
+
+	switch msg[0] {
+
+	// Save a snapshot, named after the current time
+	case 'k':
+		name := fmt.Sprintf("snapshot_%s.json",
+			time.Now().Format("20060102_150405.000"))
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			slog.Error("Snapshot directory error", "err", err)
+			return true
+		}
+		if err := saveSnapshot(ge.state, path); err != nil {
+			slog.Error("Snapshot save error", "err", err)
+			return true
+		}
+		slog.Info("Saved snapshot", "path", path,
+			"tick", ge.state.getCurrTicks())
+
+	// Restore a snapshot by name, from the snapshot directory
+	case 'K':
+		path := filepath.Join(dir, filepath.Base(string(msg[1:])))
+		gs, err := loadSnapshot(path, ge.rules)
+		if err != nil {
+			slog.Error("Snapshot load error", "err", err)
+			return true
+		}
+
+		// The replay of the current game no longer applies
+		ge.recorder.close()
+		ge.recorder = nil
+
+		// Replace the game state, collecting its result from here on (and
+		// forget the states of the game it replaces, see rewind.go)
+		ge.state = gs
+		ge.rewind.clear()
+		if ge.player == nil {
+			ge.state.results = newResultRecorder(gs)
+		}
+		slog.Info("Restored snapshot", "path", path, "tick", gs.getCurrTicks())
+	}
+
+	return true
+}
+
+// Save a snapshot automatically every so often, for crash recovery
+func (ge *GameEngine) autosaveSnapshot(frame uint32) {
+
+	// Only save periodically, and only if a directory is configured
+	dir := ge.rules.roomDir(getSnapshotDir())
+	if dir == "" || frame%snapshotAutosavePeriod != 0 {
+		return
+	}
+
+	// Save the snapshot, overwriting the last one
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		slog.Error("Snapshot directory error", "err", err)
+		return
+	}
+	if err := saveSnapshot(ge.state, filepath.Join(dir, snapshotAutosaveName)); err != nil {
+		slog.Error("Snapshot save error", "err", err)
+	}
+}
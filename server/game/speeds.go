@@ -0,0 +1,116 @@
+package game
+
+/*
+As in the arcade game, Pacman and the ghosts move at different speeds: each
+level gives a speed, in percent of one cell per step (update period), for
+Pacman, for ghosts chasing or scattering, for frightened ghosts, for ghosts in
+the maze's tunnels ('T' cells, see maze.go), and for eaten ghosts (the "eyes"
+heading back to the ghost house), up to 200 (two cells per step). Tunnels slow
+down every ghost but the eyes, frightened or not, which gives Pacman an escape
+route through them.
+
+An entity slower than a cell per step skips its move on some steps, and a
+faster one makes an extra move on some steps, following a fixed pattern that
+spreads its moves out as evenly as possible - at 75, a ghost moves on three
+steps out of every four. The patterns follow the game's step count, so games
+(and their replays) stay deterministic.
+
+Pacman's moves come from its bot rather than the game clock, so its speed
+limits how many of them count on each step (moves beyond that are ignored,
+like moves into a wall) - a speed of 0 leaves Pacman unlimited, and moves
+tracked from the physical robot's location are never limited. Frightened
+ghosts also keep skipping steps by GhostFrightPeriod, so set that to 1 to leave
+their speed to the table.
+*/
+
+// Movement speeds on a level, in percent of one cell per step
+type LevelSpeeds struct {
+	Pacman uint8 // Pacman (0 = as fast as its moves arrive)
+	Ghost  uint8 // Ghosts chasing or scattering
+	Fright uint8 // Frightened ghosts
+	Tunnel uint8 // Ghosts in a tunnel (other than eaten ghosts)
+	Eyes   uint8 // Eaten ghosts, heading back to the ghost house
+}
+
+// The fastest allowed speed (two cells per step)
+const maxSpeed uint8 = 200
+
+// The number of steps after which every speed's pattern repeats
+const speedCycle uint32 = 100
+
+/*
+Get the number of moves made on a given step at a given speed - the moves up
+to the end of the step, less the moves up to its start
+*/
+func speedMoves(speed uint8, step uint16) uint8 {
+	s := uint32(step) % speedCycle
+	return uint8((s+1)*uint32(speed)/100 - s*uint32(speed)/100)
+}
+
+// Check that a level's speeds are allowed (every ghost must move at some point)
+func (speeds LevelSpeeds) valid() bool {
+	return speeds.Pacman <= maxSpeed &&
+		speeds.Ghost > 0 && speeds.Ghost <= maxSpeed &&
+		speeds.Fright > 0 && speeds.Fright <= maxSpeed &&
+		speeds.Tunnel > 0 && speeds.Tunnel <= maxSpeed &&
+		speeds.Eyes > 0 && speeds.Eyes <= maxSpeed
+}
+
+// Get the movement speeds on the current level
+func (gs *gameState) levelSpeeds() LevelSpeeds {
+	speeds := gs.rules.Speeds
+	return speeds[levelTableIdx(gs.getLevel(), len(speeds))]
+}
+
+// Get the number of the current step, for the speed patterns
+func (gs *gameState) stepIndex() uint16 {
+	return gs.getCurrTicks() / uint16(gs.getUpdatePeriod())
+}
+
+// Get the number of moves that a ghost makes on a given step
+func (g *ghostState) stepMoves(step uint16) uint8 {
+
+	// The ghost's speed depends on its state, and on whether it is in a tunnel
+	speeds := g.game.levelSpeeds()
+	speed := speeds.Ghost
+	if g.isEaten() {
+		speed = speeds.Eyes
+	} else if g.inTunnel() {
+		speed = speeds.Tunnel
+	} else if g.isFrightened() {
+		speed = speeds.Fright
+	}
+	return speedMoves(speed, step)
+}
+
+// Determines if the ghost is in one of the maze's tunnels
+func (g *ghostState) inTunnel() bool {
+	return g.game.maze.tunnelAt(g.loc.getCoords())
+}
+
+// Give Pacman its moves for the next step
+func (gs *gameState) refillPacmanMoves() {
+	moves := speedMoves(gs.pacmanSpeed(), gs.stepIndex()+1)
+
+	gs.pacmanMoves = moves
+}
+
+// Getter method for the moves Pacman has left on this step
+func (gs *gameState) getPacmanMoves() uint8 {
+	return gs.pacmanMoves
+}
+
+/*
+Use up one of Pacman's moves on this step, returning whether it had one left
+(always, if its speed is unlimited)
+*/
+func (gs *gameState) takePacmanMove() bool {
+	if gs.levelSpeeds().Pacman == 0 {
+		return true
+	}
+	if gs.pacmanMoves == 0 {
+		return false
+	}
+	gs.pacmanMoves--
+	return true
+}
@@ -0,0 +1,126 @@
+package game
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+)
+
+/*
+A state hash is a 64-bit fingerprint of everything that decides how a game
+plays out from here: the pellets and super pellets, the locations and
+directions of Pacman, the fruit, and the ghosts (with the ghosts' planned
+moves), which gates are open (see gates.go), which power-ups are left and
+their effects' steps (see power_ups.go), the teleporters' cooldowns (see
+teleporters.go), the mode, and the game's counters (mode, level, fright,
+trapped, fruit, and ghost house steps and pellet counts, the ticks since the
+last pellet, the steps until the pellets respawn in survival games, the
+level, lives, and ghost combo). It leaves out the current tick, the score,
+and the steps survived, so that the same position reached at different times
+hashes the same (e.g. for a bot's transposition tables), along with the state
+of the ghosts' random number generators. The fields are hashed with FNV-1a in
+a fixed order, so the hash only changes when the game does.
+
+If enabled, the hash is sent at the end of each broadcast state (8 bytes), so
+that clients tracking the game themselves can detect when they fall out of
+sync with the server.
+*/
+
+// Whether to send the state hash with each broadcast state
+var stateHashEnabled bool = false
+
+// Configure whether to send the state hash with each broadcast state
+func ConfigStateHash(_stateHashEnabled bool) {
+	stateHashEnabled = _stateHashEnabled
+}
+
+// Append a location (row, column, and direction) to the bytes to hash
+func appendLocationHash(buf []byte, loc *locationState) []byte {
+	row, col := loc.getCoords()
+	return append(buf, byte(row), byte(col), loc.getDir())
+}
+
+// Compute the state hash of the game
+func (gs *gameState) stateHash() uint64 {
+
+	// Bytes to hash, in a fixed order
+	buf := make([]byte, 0, 256)
+
+	// Mode and counters
+	buf = append(buf, gs.getMode(), gs.getLastUnpausedMode(),
+		gs.getLifecycle(), gs.getModeWave(), gs.getModeSteps())
+	buf = binary.BigEndian.AppendUint16(buf, gs.getLevelSteps())
+	buf = append(buf, gs.getLevel(), gs.getLives(), gs.ghostCombo)
+
+	// Pacman and the fruit
+	buf = appendLocationHash(buf, gs.pacmanLoc)
+	buf = appendLocationHash(buf, gs.fruitLoc)
+	buf = append(buf, gs.getFruitSteps())
+
+	// Ghosts, with their planned moves and counters
+	for _, g := range gs.ghosts {
+		buf = appendLocationHash(buf, g.loc)
+		buf = appendLocationHash(buf, g.nextLoc)
+		var flags byte = 0
+		for bit, flag := range [...]bool{g.spawning, g.eaten, g.waiting} {
+			if flag {
+				flags |= 1 << bit
+			}
+		}
+		buf = append(buf, g.frightSteps, g.trappedSteps, g.dotCount, flags)
+	}
+
+	// Pellet counters for releasing ghosts from the ghost house (with the
+	// ticks since the last pellet, rather than the tick it was eaten on)
+	currTicks := gs.getCurrTicks()
+	var active byte = 0
+	if gs.globalDotActive {
+		active = 1
+	}
+	buf = append(buf, gs.globalDotCount, active)
+	buf = binary.BigEndian.AppendUint16(buf, currTicks-gs.lastPelletTick)
+
+	// Pellets and super pellets (as uint32s, for each row of the maze)
+	for _, grid := range [...]*bitGrid{&gs.pellets, &gs.superPellets} {
+		for row := int8(0); row < gs.maze.rows; row++ {
+			for word := 0; word < rowWords(gs.maze.cols); word++ {
+				buf = binary.BigEndian.AppendUint32(buf, grid.word(row, word))
+			}
+		}
+	}
+
+	// Gates (only for mazes that have any, so others hash as before)
+	for gate := range gs.maze.gates {
+		var open byte = 0
+		if gs.gateOpen(gate) {
+			open = 1
+		}
+		buf = append(buf, open)
+	}
+
+	// Power-ups (only for mazes that have any, so others hash as before)
+	if len(gs.maze.powerUps) > 0 {
+		buf = binary.BigEndian.AppendUint32(buf, gs.powerUpsLeft)
+		buf = append(buf, gs.freezeSteps, gs.boostSteps, gs.multiplierSteps)
+	}
+
+	// Teleporters' cooldowns (only for mazes that have any, so others hash as
+	// before)
+	buf = append(buf, gs.teleportSteps[:len(gs.maze.teleporters)]...)
+
+	// Steps until the pellets respawn (only for survival games)
+	if gs.rules.Survival {
+		buf = binary.BigEndian.AppendUint16(buf, gs.respawnSteps)
+	}
+
+	// Hash the bytes
+	h := fnv.New64a()
+	h.Write(buf)
+	return h.Sum64()
+}
+
+// Serialize the state hash (8 bytes)
+func (gs *gameState) serStateHash(outputBuf []byte, startIdx int) int {
+
+	// Serialize the hash, and return the starting index of the next field
+	return serUint64(gs.stateHash(), outputBuf, startIdx)
+}
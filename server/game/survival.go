@@ -0,0 +1,106 @@
+package game
+
+/*
+Survival (endless) games run until Pacman is first caught, for demo booths and
+other long-running displays, rather than over levels and lives. They are
+turned on with Survival in the configuration (or a room's settings), and
+differ from the usual game in that:
+
+	- Pacman has a single life - being caught ends the game, and no bonus
+	  lives are awarded
+	- each step that Pacman survives scores SurvivalPoints points, on top of
+	  the pellets, ghosts, and fruit that it eats
+	- the pellets eaten so far (super pellets included) grow back every
+	  PelletRespawnSteps steps, all at once (except under Pacman), so that
+	  the maze never runs dry (0 turns the respawns off)
+
+Clearing the maze still moves on to the next level, as usual. Each respawn is
+logged as a "pellet_respawn" event, with the number of pellets that grew back,
+the game over event carries the steps survived, and every state carries
+whether the game is a survival game, the steps survived, and the steps left
+until the next respawn after the teleporters (see serSurvival).
+*/
+
+// Helper function to start Pacman on a single life, for survival games
+func (gs *gameState) startSurvival() {
+	if !gs.rules.Survival {
+		return
+	}
+	gs.currLives = 1
+	gs.respawnSteps = gs.rules.PelletRespawnSteps
+}
+
+/*
+Score the step that Pacman survived, and count down the steps until the
+pellets respawn, respawning them if it's time (only for survival games, once
+play has started - the update prepared before the game starts isn't counted)
+*/
+func (gs *gameState) updateSurvival() {
+	if !gs.rules.Survival || gs.getLifecycle() != lifecycleRunning {
+		return
+	}
+
+	// Score the step survived
+	gs.survivalSteps++
+	gs.incrementScore(gs.rules.SurvivalPoints)
+
+	// Count down the steps until the pellets respawn (if they ever do)
+	if gs.respawnSteps == 0 {
+		return
+	}
+	gs.respawnSteps--
+	if gs.respawnSteps == 0 {
+		gs.respawnPellets()
+		gs.respawnSteps = gs.rules.PelletRespawnSteps
+	}
+}
+
+// Put back the pellets eaten so far, except for any under Pacman
+func (gs *gameState) respawnPellets() {
+
+	// Put back each pellet of the maze that is missing
+	pRow, pCol := gs.pacmanLoc.getCoords()
+	var count uint16 = 0
+	for row := int8(0); row < gs.maze.rows; row++ {
+		for col := int8(0); col < gs.maze.cols; col++ {
+			if !gs.maze.pellets.get(row, col) || gs.pellets.get(row, col) ||
+				(row == pRow && col == pCol) {
+				continue
+			}
+			gs.pellets.set(row, col, true)
+			gs.superPellets.set(row, col, gs.maze.superPellets.get(row, col))
+			count++
+		}
+	}
+	if count == 0 {
+		return
+	}
+	gs.numPellets += count
+
+	// Send a message to the terminal
+	gs.logger().Info("Pellets respawned", "count", count,
+		"tick", gs.getCurrTicks())
+	gs.logEvent(eventPelletRespawn, map[string]any{"count": count})
+}
+
+/*
+Serialize the survival game's progress, as whether this is a survival game
+(1 byte, 1 if so), the steps survived (4 bytes), and the steps left until the
+pellets respawn (2 bytes, 0 if they don't) - 7 bytes
+*/
+func (gs *gameState) serSurvival(outputBuf []byte, startIdx int) int {
+
+	// Serialize whether this is a survival game first
+	var survival uint8 = 0
+	if gs.rules.Survival {
+		survival = 1
+	}
+	startIdx = serUint8(survival, outputBuf, startIdx)
+
+	// Serialize the steps survived, then the steps until the respawn
+	startIdx = serUint32(gs.survivalSteps, outputBuf, startIdx)
+	startIdx = serUint16(gs.respawnSteps, outputBuf, startIdx)
+
+	// Return the starting index of the next field
+	return startIdx
+}
@@ -0,0 +1,140 @@
+package game
+
+import "testing"
+
+// Set up a simulated survival game, with the pellets respawning periodically
+func newSurvivalTestGame(t *testing.T, respawnSteps uint16) *gameState {
+	t.Helper()
+	gs := newTestGame(t)
+	gs.rules.Survival = true
+	gs.rules.PelletRespawnSteps = respawnSteps
+	gs.startSurvival()
+	return gs
+}
+
+// Eat the first few regular pellets of the maze and its first super pellet
+func eatTestPellets(t *testing.T, gs *gameState, regular int) [][2]int8 {
+	t.Helper()
+	var eaten [][2]int8
+	super := false
+	for row := int8(0); row < gs.maze.rows; row++ {
+		for col := int8(0); col < gs.maze.cols; col++ {
+			if !gs.pelletAt(row, col) {
+				continue
+			}
+			if gs.superPelletAt(row, col) {
+				if super {
+					continue
+				}
+				super = true
+			} else if regular == 0 {
+				continue
+			} else {
+				regular--
+			}
+			if err := gs.collectPellet(row, col); err != nil {
+				t.Fatal(err)
+			}
+			eaten = append(eaten, [2]int8{row, col})
+		}
+	}
+	if regular > 0 || !super {
+		t.Fatalf("not enough pellets to eat")
+	}
+	return eaten
+}
+
+/*
+Respawning puts back every pellet eaten (as a super pellet if it was one),
+except for the one under Pacman, and counts them towards the pellets left
+*/
+func TestRespawnPellets(t *testing.T) {
+	gs := newSurvivalTestGame(t, 0)
+	full := gs.getNumPellets()
+	eaten := eatTestPellets(t, gs, 3)
+	if gs.getNumPellets() != full-uint16(len(eaten)) {
+		t.Fatalf("%d pellets left after eating %d of %d",
+			gs.getNumPellets(), len(eaten), full)
+	}
+
+	// Stand Pacman on one of the regular pellets eaten, then respawn
+	pacman := eaten[0]
+	if gs.maze.superPellets.get(pacman[0], pacman[1]) {
+		pacman = eaten[1]
+	}
+	gs.pacmanLoc.updateCoords(pacman[0], pacman[1])
+	gs.respawnPellets()
+	for _, cell := range eaten {
+		row, col := cell[0], cell[1]
+		if cell == pacman {
+			if gs.pelletAt(row, col) {
+				t.Errorf("pellet respawned under Pacman at (%d, %d)", row, col)
+			}
+			continue
+		}
+		if !gs.pelletAt(row, col) {
+			t.Errorf("pellet at (%d, %d) not respawned", row, col)
+		}
+		if gs.superPelletAt(row, col) != gs.maze.superPellets.get(row, col) {
+			t.Errorf("pellet at (%d, %d) respawned with super %t",
+				row, col, gs.superPelletAt(row, col))
+		}
+	}
+	if gs.getNumPellets() != full-1 {
+		t.Fatalf("%d pellets left after respawning, want %d",
+			gs.getNumPellets(), full-1)
+	}
+
+	// Once Pacman moves off, the last pellet comes back too (and only once)
+	for _, cell := range eaten {
+		if cell != pacman {
+			gs.pacmanLoc.updateCoords(cell[0], cell[1])
+			break
+		}
+	}
+	gs.respawnPellets()
+	gs.respawnPellets()
+	if !gs.pelletAt(pacman[0], pacman[1]) || gs.getNumPellets() != full {
+		t.Fatalf("%d pellets left after respawning again, want %d",
+			gs.getNumPellets(), full)
+	}
+}
+
+/*
+The steps survived and the respawn countdown only run once play has started,
+and the pellets respawn every so many of those steps
+*/
+func TestUpdateSurvivalRespawns(t *testing.T) {
+	const respawnSteps = 3
+	gs := newSurvivalTestGame(t, respawnSteps)
+	full := gs.getNumPellets()
+	eatTestPellets(t, gs, 2)
+	left := gs.getNumPellets()
+
+	// Before the game starts, nothing is counted
+	gs.updateSurvival()
+	if gs.survivalSteps != 0 || gs.respawnSteps != respawnSteps {
+		t.Fatalf("counted %d steps survived (%d to respawn) in the lobby",
+			gs.survivalSteps, gs.respawnSteps)
+	}
+
+	gs.setLifecycle(lifecycleRunning)
+	for step := 1; step <= 2*respawnSteps; step++ {
+		gs.updateSurvival()
+		want := left
+		if step >= respawnSteps {
+			want = full
+		}
+		if gs.getNumPellets() != want {
+			t.Fatalf("step %d: %d pellets left, want %d", step,
+				gs.getNumPellets(), want)
+		}
+		if gs.survivalSteps != uint32(step) {
+			t.Fatalf("step %d: %d steps survived", step, gs.survivalSteps)
+		}
+	}
+	if gs.respawnSteps != respawnSteps {
+		t.Fatalf("%d steps to respawn after respawning twice, want %d",
+			gs.respawnSteps, respawnSteps)
+	}
+}
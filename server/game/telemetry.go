@@ -0,0 +1,63 @@
+package game
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+/*
+Telemetry reports come from the robot itself ('T' commands, see
+webserver/telemetry.go), so that post-match analysis can line up what the
+robot was doing with what happened in the game. Each report holds:
+
+	battery voltage (4 bytes, float32, in volts)
+	IMU heading (4 bytes, float32, in radians - NaN if there is no IMU)
+	wheel encoder counts (4 bytes each, int32, up to maxTelemetryEncoders)
+
+and is written to the event log as a "telemetry" event, with the tick it was
+received on (values that aren't finite numbers are left out). Telemetry
+doesn't affect the game in any way.
+*/
+
+// The most wheel encoder counts in a telemetry report
+const maxTelemetryEncoders = 8
+
+// Check the length of a telemetry report (following the 'T')
+func validTelemetryLen(n int) bool {
+	return n >= 8 && n <= 8+4*maxTelemetryEncoders && n%4 == 0
+}
+
+/*
+Decode a telemetry report (big-endian: battery voltage and heading as
+float32s, then the encoder counts as int32s)
+*/
+func decodeTelemetry(report []byte) (battery, heading float32,
+	encoders []int32) {
+	battery = math.Float32frombits(binary.BigEndian.Uint32(report[0:4]))
+	heading = math.Float32frombits(binary.BigEndian.Uint32(report[4:8]))
+	encoders = make([]int32, 0, (len(report)-8)/4)
+	for i := 8; i+4 <= len(report); i += 4 {
+		encoders = append(encoders, int32(binary.BigEndian.Uint32(report[i:])))
+	}
+	return battery, heading, encoders
+}
+
+// Record a telemetry report from the robot in the event log
+func (gs *gameState) recordTelemetry(battery, heading float32,
+	encoders []int32) {
+
+	// Leave out any values that aren't finite (JSON can't hold them)
+	details := map[string]any{"encoders": encoders}
+	if finite(battery) {
+		details["battery"] = battery
+	}
+	if finite(heading) {
+		details["heading"] = heading
+	}
+	gs.logEvent(eventTelemetry, details)
+}
+
+// Check whether a value is a finite number
+func finite(x float32) bool {
+	return !math.IsNaN(float64(x)) && !math.IsInf(float64(x), 0)
+}
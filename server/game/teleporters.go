@@ -0,0 +1,176 @@
+package game
+
+import (
+	"fmt"
+)
+
+/*
+Teleporters are pairs of cells of a maze that Pacman (and optionally the
+ghosts) can jump between instantly, for variants of the game beyond the
+tunnels around the edges. Each teleporter is drawn in the maze's grid as the
+same lowercase letter at both of its ends, from 'a' (teleporter 0) to 'f'
+(teleporter 5), lettered without gaps, on empty space.
+
+Pacman moving onto either end of a teleporter is sent straight to the other
+end (keeping its direction), after which the teleporter cools down for
+TeleportCooldown steps, during which it can be walked over like any other
+cell (every teleporter is ready again once Pacman is caught or clears the
+level). Only moves that Pacman takes on command teleport it - tracked moves
+follow the physical robot, which can't. With GhostsTeleport set, ghosts in
+play teleport in the same way (eaten ghosts, and ghosts leaving the ghost
+house, never do), coming out of the far end facing a way out of it. Maze
+distances and path queries don't take the teleporters into account (see
+pathfinding.go), so ghosts don't aim through them.
+
+Each jump is logged as a "teleport" event, which the web server relays to
+clients as a message of its own (so that visualizers can animate it), and
+every state carries the teleporters, with their cooldowns, after the
+power-ups (see serTeleporters).
+*/
+
+// The most teleporters a maze can have (lettered 'a' to 'f')
+const maxTeleporters = 6
+
+// A teleporter of a maze, a pair of cells that lead to each other
+type teleporter struct {
+	ends [2][2]int8 // Row and column of each end, in reading order
+}
+
+/*
+Pair up the ends of the teleporters found in a maze's grid (by letter) -
+returns an error unless each teleporter has exactly two ends, lettered
+without gaps
+*/
+func (maze *mazeLayout) pairTeleporters(ends [maxTeleporters][][2]int8) error {
+	for idx, cells := range ends {
+		letter := 'a' + rune(idx)
+		if len(cells) == 0 {
+			continue
+		}
+		if len(cells) != 2 {
+			return fmt.Errorf("teleporter '%c': expected 2 ends, found %d",
+				letter, len(cells))
+		}
+		if idx != len(maze.teleporters) {
+			return fmt.Errorf("missing teleporter '%c'",
+				'a'+rune(len(maze.teleporters)))
+		}
+		maze.teleporters = append(maze.teleporters,
+			teleporter{ends: [2][2]int8{cells[0], cells[1]}})
+	}
+	return nil
+}
+
+/*
+Find the teleporter with an end at a given cell, along with its other end -
+returns -1 for the teleporter if there is none
+*/
+func (maze *mazeLayout) teleporterAt(row, col int8) (int, int8, int8) {
+	for idx, t := range maze.teleporters {
+		for end := range t.ends {
+			if t.ends[end] == [2]int8{row, col} {
+				other := t.ends[1-end]
+				return idx, other[0], other[1]
+			}
+		}
+	}
+	return -1, 0, 0
+}
+
+// Helper function to make every teleporter ready again
+func (gs *gameState) resetTeleporters() {
+	gs.teleportSteps = [maxTeleporters]uint8{}
+}
+
+/*
+Teleport Pacman or a ghost that has just moved onto a teleporter to its other
+end, unless the teleporter is cooling down - returns whether it teleported
+*/
+func (gs *gameState) teleport(loc *locationState, who string) bool {
+
+	// Look for a ready teleporter at the location
+	row, col := loc.getCoords()
+	idx, toRow, toCol := gs.maze.teleporterAt(row, col)
+	if idx < 0 || gs.teleportSteps[idx] != 0 {
+		return false
+	}
+
+	// Jump to the other end, and start the teleporter's cooldown
+	loc.updateCoords(toRow, toCol)
+	gs.teleportSteps[idx] = gs.rules.TeleportCooldown
+
+	// Send a message to the terminal
+	gs.logger().Info("Teleported", "who", who, "teleporter", idx,
+		"tick", gs.getCurrTicks())
+	gs.logEvent(eventTeleport, map[string]any{"teleporter": uint8(idx),
+		"who": who, "fromRow": row, "fromCol": col, "toRow": toRow,
+		"toCol": toCol})
+	return true
+}
+
+/*
+Move a ghost to its planned next location, teleporting it if it moved onto a
+teleporter (only if ghosts teleport, and not for eaten or spawning ghosts)
+*/
+func (g *ghostState) moveToNext() {
+
+	// Copy the next location into the current location
+	moved := !g.loc.collidesWith(g.nextLoc)
+	g.loc.copyFrom(g.nextLoc)
+
+	// Teleport the ghost, if applicable
+	if !moved || !g.game.rules.GhostsTeleport || g.isEaten() ||
+		g.isSpawning() || !g.game.teleport(g.loc, ghostNames[g.color]) {
+		return
+	}
+
+	/*
+		Ghosts plan their moves by carrying on in the direction they face, so
+		face a way out of the far end (keeping the direction if it's open,
+		and otherwise preferring up, left, down, then right)
+	*/
+	row, col := g.loc.getCoords()
+	for _, dir := range [...]uint8{g.loc.getDir(), up, left, down, right} {
+		if dir >= numDirs {
+			continue
+		}
+		nextRow, nextCol := g.game.maze.wrapCoords(row+dRow[dir],
+			col+dCol[dir])
+		if !g.game.wallAt(nextRow, nextCol) {
+			g.loc.updateDir(dir)
+			return
+		}
+	}
+}
+
+// Count down the steps left of each teleporter's cooldown
+func (gs *gameState) updateTeleporters() {
+	for idx := range gs.maze.teleporters {
+		if gs.teleportSteps[idx] != 0 {
+			gs.teleportSteps[idx]--
+		}
+	}
+}
+
+/*
+Serialize the teleporters of the maze, as a count followed by the row and
+column of both ends and the steps left of its cooldown (1 byte each, 0 if it
+is ready) for each (1 + 5 * count bytes)
+*/
+func (gs *gameState) serTeleporters(outputBuf []byte, startIdx int) int {
+
+	// Serialize the count first
+	startIdx = serUint8(uint8(len(gs.maze.teleporters)), outputBuf, startIdx)
+
+	// Serialize each teleporter, in order
+	for idx, t := range gs.maze.teleporters {
+		for _, end := range t.ends {
+			startIdx = serUint8(uint8(end[0]), outputBuf, startIdx)
+			startIdx = serUint8(uint8(end[1]), outputBuf, startIdx)
+		}
+		startIdx = serUint8(gs.teleportSteps[idx], outputBuf, startIdx)
+	}
+
+	// Return the starting index of the next field
+	return startIdx
+}
@@ -1,178 +1,247 @@
 package game
 
-// The number of rows in the pellets and walls states
-const mazeRows int8 = 31
+import "slices"
 
-// The number of columns in the pellets and walls states
-const mazeCols int8 = 28
+/*
+Many of the values below are defaults that can be overridden by the "Game"
+section of the configuration (see game_config.go)
+*/
 
 // The update period that the game starts with by default
-const initUpdatePeriod uint8 = 12
+var initUpdatePeriod uint8 = 12
 
 // The number of steps (update periods) that pass before the level speeds up
-const levelDuration uint16 = 960 // 8 minutes at 24 fps, update period = 12
+var levelDuration uint16 = 960 // 8 minutes at 24 fps, update period = 12
 
 // The number of steps (update periods) before a level speeds up further
-const levelPenaltyDuration uint16 = 240 // 2 min (24fps, update period = 12)
+var levelPenaltyDuration uint16 = 240 // 2 min (24fps, update period = 12)
 
 // The mode that the game starts on by default
 const initMode uint8 = scatter
 
-// The lengths of the game modes, in units of steps (update periods)
-var modeDurations [numModes]uint8 = [...]uint8{
-	255, // paused
-	60,  // scatter - 30 seconds at 24 fps
-	180, // chase   - 90 seconds at 24 fps
+/*
+The scatter/chase schedule (arcade timings, in milliseconds) on each level,
+starting with scatter and alternating - the last row applies to all levels
+beyond the table, and the final chase phase of each schedule lasts forever
+*/
+var modeWaves = [][]uint32{
+	{7000, 20000, 7000, 20000, 5000, 20000, 5000}, // level 1
+	{7000, 20000, 7000, 20000, 5000, 1033000, 17}, // level 2
+	{7000, 20000, 7000, 20000, 5000, 1033000, 17}, // level 3
+	{7000, 20000, 7000, 20000, 5000, 1033000, 17}, // level 4
+	{5000, 20000, 5000, 20000, 5000, 1037000, 17}, // level 5+
 }
 
+// The number of mode steps that represents a phase lasting indefinitely
+const indefiniteModeSteps uint8 = 255
+
 // The level that Pacman starts on by default
 const initLevel uint8 = 1
 
-// The number of lives that Pacman starts with
-const initLives uint8 = 3
-
-// The coordinates where the ghost house exit is located
-const ghostHouseExitRow int8 = 12
-const ghostHouseExitCol int8 = 13
+// The number of lives that Pacman starts with (by default)
+var initLives uint8 = 3
 
-// Spawn position for Pacman
-var pacmanSpawnLoc = newLocationState(23, 13, right)
+/*
+The scores at which Pacman earns an extra life (in increasing order) - this
+can be overridden in the configuration
+*/
+var bonusLifeScores = []uint16{10000}
 
-// Spawn position for the fruit
-var fruitSpawnLoc = newLocationState(17, 13, none)
+// Configure the scores at which Pacman earns an extra life
+func ConfigBonusLifeScores(_bonusLifeScores []uint16) {
+	bonusLifeScores = slices.Clone(_bonusLifeScores)
+	slices.Sort(bonusLifeScores)
+}
 
 // The number of steps that the fruit stays on the maze for
-const fruitDuration uint8 = 30
-
-// The points earned upon collecting a fruit
-const fruitPoints uint16 = 100
-
-// "Invalid" location - serializes to 0x00100000 0x00100000
-var emptyLoc = newLocationState(32, 32, none)
+var fruitDuration uint8 = 30
+
+/*
+The points earned upon collecting a fruit on each level (following the arcade
+fruit values) - the last entry applies to all levels beyond the table
+*/
+var fruitPoints = []uint16{
+	100, 300, 500, 500, 700, 700, 1000, 1000, // levels 1-8
+	2000, 2000, 3000, 3000, 5000, // levels 9-13+
+}
 
-// Spawn positions for the ghosts
-var ghostSpawnLocs [numColors]*locationState = [...]*locationState{
-	newLocationState(11, 13, left), // red
-	newLocationState(13, 13, down), // pink
-	newLocationState(14, 11, up),   // cyan
-	newLocationState(14, 15, up),   // orange
+// Directions that the ghosts face when they spawn
+var ghostSpawnDirs [numColors]uint8 = [...]uint8{
+	left, // red
+	down, // pink
+	up,   // cyan
+	up,   // orange
+	up,   // purple
+	up,   // green
+	up,   // blue
+	up,   // white
 }
 
-// Scatter targets for the ghosts - should remain constant
+// Scatter targets for the ghosts
 var ghostScatterTargets [numColors]*locationState = [...]*locationState{
 	newLocationState(-3, 25, none), // red
 	newLocationState(-3, 2, none),  // pink
 	newLocationState(31, 27, none), // cyan
 	newLocationState(31, 0, none),  // orange
+	newLocationState(-3, 13, none), // purple
+	newLocationState(31, 13, none), // green
+	newLocationState(14, -3, none), // blue
+	newLocationState(14, 30, none), // white
 }
 
-// The number of steps that the ghosts stay in the trapped state for
-var ghostTrappedSteps [numColors]uint8 = [...]uint8{
+/*
+The chase behavior of each ghost, given as the (original) ghost whose chase
+target it uses - the extra ghosts repeat the original four in order
+*/
+var ghostPersonas [numColors]uint8 = [...]uint8{
+	red, pink, cyan, orange, // the original ghosts
+	red, pink, cyan, orange, // purple, green, blue, white
+}
+
+// The number of fright steps left at which frightened ghosts start flashing
+var ghostFlashSteps uint8 = 10
+
+/*
+Frightened ghosts only move once every few steps (2 = half as often as usual),
+skipping their movement updates the rest of the time
+*/
+var ghostFrightMovePeriod uint8 = 2
+
+/*
+Whether ghosts aim for their targets by maze distance (the shortest path
+around the walls, see pathfinding.go) rather than by straight-line distance,
+as in the original game - targets inside walls or out of bounds, such as the
+scatter targets, are still aimed for in a straight line
+*/
+var ghostMazeDistance bool = false
+
+/*
+How ghosts aiming by maze distance choose between moves that are equally far
+from their target: "order" takes the first of up, left, down, and right, as the
+ghosts always have, and "distance" takes the one that is closer in a straight
+line (see pathfinding.go)
+*/
+var ghostTieBreak string = "order"
+
+/*
+Movement speeds on each level, in percent of one cell per step (see speeds.go)
+- the last entry applies to all levels beyond the table
+*/
+var levelSpeeds = []LevelSpeeds{
+	{Pacman: 0, Ghost: 100, Fright: 100, Tunnel: 50, Eyes: 200}, // level 1+
+}
+
+// The number of cells ahead of Pacman that pink aims for when chasing
+var pinkLookahead uint8 = 4
+
+/*
+The number of cells ahead of Pacman that cyan's target pivots around (cyan
+aims for red's location, reflected about this cell)
+*/
+var cyanPivotAhead uint8 = 2
+
+/*
+The distance (in cells) from Pacman within which orange gives up chasing, and
+retreats to its scatter target instead
+*/
+var orangeRetreatRadius uint8 = 8
+
+/*
+The number of pellets that must be eaten (while a ghost is first in line) for
+each ghost to leave the ghost house, on each level - the last row applies to
+all levels beyond the table
+*/
+var ghostDotLimits = [][]uint8{
+	// red, pink, cyan, orange, purple, green, blue, white
+	{0, 0, 30, 60, 90, 120, 150, 180}, // level 1
+	{0, 0, 0, 50, 70, 90, 110, 130},   // level 2
+	{0, 0, 0, 0, 0, 0, 0, 0},          // level 3+
+}
+
+/*
+The number of pellets eaten since Pacman's last death at which each ghost
+leaves the ghost house (this shared counter replaces the per-ghost ones
+until all ghosts are out of the house)
+*/
+var ghostGlobalDotLimits = []uint8{
 	0,  // red
-	5,  // pink
-	16, // cyan
+	7,  // pink
+	17, // cyan
 	32, // orange
+	40, // purple
+	48, // green
+	56, // blue
+	64, // white
 }
 
-// The number of steps that the ghosts stay in the frightened state for
-const ghostFrightSteps uint8 = 40
+/*
+The number of ticks without eating a pellet before the next ghost is released
+from the ghost house anyway, on each level - the last entry applies to all
+levels beyond the table
+*/
+var ghostReleaseTimeouts = []uint16{
+	96, 96, 96, 96, // levels 1-4 - 4 seconds at 24 fps
+	72, // levels 5+ - 3 seconds at 24 fps
+}
 
-// The number of pellets in a typical game of Pacman
-const initPelletCount uint16 = 244
+/*
+The number of steps that the ghosts stay in the frightened state for on each
+level, scaled from the arcade fright times - the last entry applies to all
+levels beyond the table (0 means the ghosts only reverse direction)
+*/
+var ghostFrightSteps = []uint8{
+	40, 34, 28, 22, 16, 34, 16, 16, 10, 34, // levels 1-10
+	16, 10, 10, 22, 10, 10, 0, 10, 0, // levels 11-19+
+}
 
-// The number of pellets at which to spawn the first fruit
-const fruitThreshold1 uint16 = 174
+// The number of pellets eaten at which to spawn the first fruit
+var fruitThreshold1 uint16 = 70
 
-// The number of pellets at which to spawn the second fruit
-const fruitThreshold2 uint16 = 74
+// The number of pellets eaten at which to spawn the second fruit
+var fruitThreshold2 uint16 = 170
 
 // The number of pellets at which to make the ghosts angry
-const angerThreshold1 uint16 = 20
+var angerThreshold1 uint16 = 20
 
 // The number of pellets at which to make the ghosts angrier
-const angerThreshold2 uint16 = 10
+var angerThreshold2 uint16 = 10
 
 // The points earned when collecting a pellet
-const pelletPoints uint16 = 10
+var pelletPoints uint16 = 10
 
-// The points earned when collecting a pellet
-const superPelletPoints uint16 = 50
+// The points earned when collecting a super pellet
+var superPelletPoints uint16 = 50
 
 // The multiplier for the combo from catching successive frightened ghosts
-const comboMultiplier uint16 = 200
-
-// Column-wise, this may look backwards; column 0 is at bit 0 on the right
-// (Tip: Ctrl+F '1' to see the initial pellet locations)
-var initPellets [mazeRows]uint32 = [...]uint32{
-	//                middle
-	// col:             vv    8 6 4 2 0
-	0b0000_0000000000000000000000000000, // row 0
-	0b0000_0111111111111001111111111110, // row 1
-	0b0000_0100001000001001000001000010, // row 2
-	0b0000_0100001000001001000001000010, // row 3
-	0b0000_0100001000001001000001000010, // row 4
-	0b0000_0111111111111111111111111110, // row 5
-	0b0000_0100001001000000001001000010, // row 6
-	0b0000_0100001001000000001001000010, // row 7
-	0b0000_0111111001111001111001111110, // row 8
-	0b0000_0000001000000000000001000000, // row 9
-	0b0000_0000001000000000000001000000, // row 10
-	0b0000_0000001000000000000001000000, // row 11
-	0b0000_0000001000000000000001000000, // row 12
-	0b0000_0000001000000000000001000000, // row 13
-	0b0000_0000001000000000000001000000, // row 14
-	0b0000_0000001000000000000001000000, // row 15
-	0b0000_0000001000000000000001000000, // row 16
-	0b0000_0000001000000000000001000000, // row 17
-	0b0000_0000001000000000000001000000, // row 18
-	0b0000_0000001000000000000001000000, // row 19
-	0b0000_0111111111111001111111111110, // row 20
-	0b0000_0100001000001001000001000010, // row 21
-	0b0000_0100001000001001000001000010, // row 22
-	0b0000_0111001111111001111111001110, // row 23
-	0b0000_0001001001000000001001001000, // row 24
-	0b0000_0001001001000000001001001000, // row 25
-	0b0000_0111111001111001111001111110, // row 26
-	0b0000_0100000000001001000000000010, // row 27
-	0b0000_0100000000001001000000000010, // row 28
-	0b0000_0111111111111111111111111110, // row 29
-	0b0000_0000000000000000000000000000, // row 30
-}
+var comboMultiplier uint16 = 200
 
-// Column-wise, this may look backwards; column 0 is at bit 0 on the right
-// (Tip: Ctrl+F '0' to see the valid Pacman locations)
-var initWalls [mazeRows]uint32 = [...]uint32{
-	//                middle
-	// col:             vv    8 6 4 2 0
-	0b0000_1111111111111111111111111111, // row 0
-	0b0000_1000000000000110000000000001, // row 1
-	0b0000_1011110111110110111110111101, // row 2
-	0b0000_1011110111110110111110111101, // row 3
-	0b0000_1011110111110110111110111101, // row 4
-	0b0000_1000000000000000000000000001, // row 5
-	0b0000_1011110110111111110110111101, // row 6
-	0b0000_1011110110111111110110111101, // row 7
-	0b0000_1000000110000110000110000001, // row 8
-	0b0000_1111110111110110111110111111, // row 9
-	0b0000_1111110111110110111110111111, // row 10
-	0b0000_1111110110000000000110111111, // row 11
-	0b0000_1111110110111111110110111111, // row 12
-	0b0000_1111110110111111110110111111, // row 13
-	0b0000_1111110000111111110000111111, // row 14
-	0b0000_1111110110111111110110111111, // row 15
-	0b0000_1111110110111111110110111111, // row 16
-	0b0000_1111110110000000000110111111, // row 17
-	0b0000_1111110110111111110110111111, // row 18
-	0b0000_1111110110111111110110111111, // row 19
-	0b0000_1000000000000110000000000001, // row 20
-	0b0000_1011110111110110111110111101, // row 21
-	0b0000_1011110111110110111110111101, // row 22
-	0b0000_1000110000000000000000110001, // row 23
-	0b0000_1110110110111111110110110111, // row 24
-	0b0000_1110110110111111110110110111, // row 25
-	0b0000_1000000110000110000110000001, // row 26
-	0b0000_1011111111110110111111111101, // row 27
-	0b0000_1011111111110110111111111101, // row 28
-	0b0000_1000000000000000000000000001, // row 29
-	0b0000_1111111111111111111111111111, // row 30
-}
+// The number of steps that a ghost freeze lasts (see power_ups.go)
+var freezeSteps uint8 = 20
+
+// The number of steps that a speed boost lasts
+var boostSteps uint8 = 30
+
+// Pacman's speed while boosted, in percent of one cell per step (speeds.go)
+var boostSpeed uint8 = 150
+
+// The number of steps that a point multiplier lasts
+var multiplierSteps uint8 = 40
+
+// The multiplier for the points scored while a point multiplier lasts
+var pointMultiplier uint8 = 2
+
+// The number of steps before a teleporter can be used again (teleporters.go)
+var teleportCooldown uint8 = 10
+
+// Whether the ghosts use the teleporters, as well as Pacman
+var ghostsTeleport bool = false
+
+// Whether games run until Pacman is first caught, scoring the steps survived
+// and respawning the pellets (see survival.go)
+var survival bool = false
+
+// The points earned for each step survived, in survival games
+var survivalPoints uint16 = 1
+
+// The number of steps between respawns of the pellets, in survival games
+var pelletRespawnSteps uint16 = 200
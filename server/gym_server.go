@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"pacbot_server/game"
+)
+
+/*
+Gym mode (--gym <address>) serves reinforcement learning environments (see
+game/environment.go) over TCP instead of running the server, so that teams can
+train Pacman policies in any language against the server's rules. Each
+connection gets its own environment, set up like the server's default room,
+and drives it with one request at a time:
+
+	'r', seed (8 bytes, 0 for the configured seed) - start a new game
+	action (1 byte: 0 = up, 1 = left, 2 = down, 3 = right, 4 = stay) - step
+
+Every request is answered with:
+
+	reward (4 bytes, signed), done (1 byte), observation length (2 bytes),
+	then the observation (the serialized game state, as broadcast to clients)
+
+All integers are big-endian, as in the game state. A connection that sends
+anything else is closed
+*/
+
+// The request to start a new game in gym mode (actions are below it)
+const gymReset byte = 'r'
+
+// The largest action in gym mode (staying in place)
+const gymMaxAction byte = 4
+
+/*
+Encode the answer to a request: the reward, whether the game is done, and the
+observation (also sent to bots in tournaments, see tournament.go)
+*/
+func gymFrame(obs []byte, reward float64, done bool) []byte {
+	frame := make([]byte, 0, 7+len(obs))
+	frame = binary.BigEndian.AppendUint32(frame, uint32(int32(reward)))
+	if done {
+		frame = append(frame, 1)
+	} else {
+		frame = append(frame, 0)
+	}
+	frame = binary.BigEndian.AppendUint16(frame, uint16(len(obs)))
+	return append(frame, obs...)
+}
+
+// Serve environments over TCP until the program is stopped
+func runGym(conf Configuration, address string) {
+
+	// Set up the rules of the environments' games
+	configGameRules(conf)
+
+	// Listen for connections, serving each one in its own go-routine
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		slog.Error("Gym server error", "err", err)
+		os.Exit(1)
+	}
+	slog.Info("Gym server running", "address", listener.Addr().String())
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			slog.Error("Gym server error", "err", err)
+			os.Exit(1)
+		}
+		go serveGym(conn, conf.GameFPS)
+	}
+}
+
+// Serve an environment to a single connection, until it closes
+func serveGym(conn net.Conn, fps int32) {
+	defer conn.Close()
+	slog.Info("Gym client connected", "addr", conn.RemoteAddr().String())
+
+	env := game.NewEnvironment(game.RoomSettings{GameFPS: fps})
+	reader := bufio.NewReader(conn)
+	var seedBuf [8]byte
+	for {
+
+		// Read the next request, and act on it
+		request, err := reader.ReadByte()
+		if err != nil {
+			break
+		}
+		var obs []byte
+		var reward float64
+		var done bool
+		switch {
+		case request == gymReset:
+			if _, err := io.ReadFull(reader, seedBuf[:]); err != nil {
+				break
+			}
+			obs = env.Reset(int64(binary.BigEndian.Uint64(seedBuf[:])))
+		case request <= gymMaxAction:
+			obs, reward, done = env.Step(request)
+		default:
+			slog.Warn("Invalid gym request", "request", request)
+		}
+		if obs == nil {
+			break
+		}
+
+		// Answer with the reward, whether the game is done, and the observation
+		if _, err := conn.Write(gymFrame(obs, reward, done)); err != nil {
+			break
+		}
+	}
+
+	slog.Info("Gym client disconnected", "addr", conn.RemoteAddr().String())
+}
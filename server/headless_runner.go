@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"pacbot_server/game"
+	"sync"
+)
+
+/*
+Headless mode (--headless) plays a single game as fast as the CPU allows, with
+no web, TCP, gRPC or UDP servers, and prints a JSON summary of how it went
+(see game/headless.go) - for AI training, and for regression testing rule
+changes: with --replay, a recorded game is played back against the current
+rules, and any keyframes that no longer match are counted as divergences.
+Only warnings and errors are logged, and the game isn't recorded or saved
+*/
+func runHeadless(conf Configuration, replayPath string, maxTicks uint32) {
+
+	// Only log what went wrong, to keep the output clean
+	setupLogging(conf.LogFormat, conf.LogFile, "warn")
+
+	// Set up the rules of the game, but none of its outputs
+	configGameRules(conf)
+
+	// Create the game engine, without channels to a web broker
+	var wgQuit sync.WaitGroup
+	var ge *game.GameEngine
+	if replayPath != "" {
+		var err error
+		ge, err = game.NewReplayEngine(nil, nil, &wgQuit, replayPath, 1)
+		if err != nil {
+			slog.Error("Replay error", "err", err)
+			os.Exit(1)
+		}
+	} else {
+		ge = game.NewGameEngine(nil, nil, &wgQuit, conf.GameFPS)
+	}
+
+	// Run the game to completion, and print the summary
+	result := ge.RunHeadless(maxTicks)
+	summary, err := json.Marshal(result)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(string(summary))
+}
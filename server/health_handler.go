@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"pacbot_server/game"
+	"pacbot_server/webserver"
+	"sync"
+	"time"
+)
+
+/*
+Health checks, for running the server behind supervisors and load balancers:
+
+	/healthz - whether the server is alive (the engine loop is still ticking)
+	/readyz  - whether the server is ready for clients (alive, with the web
+	           broker running and a valid configuration)
+
+Both respond with 200 OK or 503 Service Unavailable, along with a JSON report -
+the engine loop of every room must be ticking (the default room's is reported
+under "engine", and any others under "rooms")
+*/
+
+// The game engines of all rooms, the default room first (set up in main.go)
+var gameEngines []*game.GameEngine
+
+// Mutex to protect the game engines
+var muEngines sync.RWMutex
+
+// Add a room's game engine to the health checks
+func addGameEngine(ge *game.GameEngine) {
+	muEngines.Lock()
+	{
+		gameEngines = append(gameEngines, ge)
+	}
+	muEngines.Unlock()
+}
+
+// The number of missed frames before the engine loop is considered stalled
+const staleFrames = 10
+
+// The shortest time before the engine loop is considered stalled
+const minStaleAge = time.Second
+
+// A JSON report of the health of the server
+type healthReport struct {
+	Status    string                  `json:"status"`
+	Engine    engineHealth            `json:"engine"`
+	Rooms     map[string]engineHealth `json:"rooms,omitempty"`
+	WebSocket hubHealth               `json:"websocket"`
+	Config    configHealth            `json:"config"`
+}
+
+// Health of the game engine loop
+type engineHealth struct {
+	Running       bool    `json:"running"`
+	Live          bool    `json:"live"`
+	LastTickAgeMs float64 `json:"lastTickAgeMs"`
+	TickPeriodMs  float64 `json:"tickPeriodMs"`
+	MeanJitterMs  float64 `json:"meanJitterMs"`
+	MaxJitterMs   float64 `json:"maxJitterMs"`
+	TickOverruns  uint64  `json:"tickOverruns"`
+	ClockResyncs  uint64  `json:"clockResyncs"`
+	Tick          uint16  `json:"tick"`
+	Score         uint16  `json:"score"`
+	Pellets       uint16  `json:"pellets"`
+	Mode          string  `json:"mode"`
+
+	// Planning latency of each ghost (omitted if the engine loop is stopped)
+	Planners []plannerHealth `json:"planners,omitempty"`
+}
+
+// Planning latency of a ghost
+type plannerHealth struct {
+	Ghost  string  `json:"ghost"`
+	LastMs float64 `json:"lastMs"`
+	MeanMs float64 `json:"meanMs"`
+	MaxMs  float64 `json:"maxMs"`
+}
+
+// Health of the websocket hub (web broker)
+type hubHealth struct {
+	Running bool `json:"running"`
+	Clients int  `json:"clients"`
+	Robots  int  `json:"robots"`
+}
+
+// Validity of the configuration
+type configHealth struct {
+	Valid bool   `json:"valid"`
+	Error string `json:"error,omitempty"`
+}
+
+// Check the health of a game engine loop
+func checkEngine(ge *game.GameEngine) engineHealth {
+
+	// Check that the engine loop has had a frame recently
+	age, period, running := ge.Liveness()
+	live := running && age < max(staleFrames*period, minStaleAge)
+
+	// Timing statistics of the engine loop
+	clock := ge.ClockStats()
+
+	// Scalars of the game state, as of the last frame
+	counters := ge.Counters()
+
+	// Planning latency of each ghost
+	var planners []plannerHealth = nil
+	for _, stats := range ge.PlannerStats() {
+		planners = append(planners, plannerHealth{
+			Ghost:  stats.Ghost,
+			LastMs: ms(stats.Last),
+			MeanMs: ms(stats.Mean),
+			MaxMs:  ms(stats.Max),
+		})
+	}
+
+	return engineHealth{
+		Running:       running,
+		Live:          live,
+		LastTickAgeMs: ms(age),
+		TickPeriodMs:  ms(clock.TargetPeriod),
+		MeanJitterMs:  ms(clock.MeanJitter),
+		MaxJitterMs:   ms(clock.MaxJitter),
+		TickOverruns:  clock.Overruns,
+		ClockResyncs:  clock.Resyncs,
+		Tick:          counters.Tick,
+		Score:         counters.Score,
+		Pellets:       counters.Pellets,
+		Mode:          counters.Mode,
+		Planners:      planners,
+	}
+}
+
+// Check the health of each part of the server
+func checkHealth() healthReport {
+
+	// Check the engine loop of each room
+	var engine engineHealth
+	var rooms map[string]engineHealth = nil
+	muEngines.RLock()
+	defer muEngines.RUnlock()
+	for _, ge := range gameEngines {
+		if ge.Room() == "" {
+			engine = checkEngine(ge)
+			continue
+		}
+		if rooms == nil {
+			rooms = make(map[string]engineHealth)
+		}
+		rooms[ge.Room()] = checkEngine(ge)
+	}
+
+	// Check the configuration
+	config := configHealth{Valid: configErr == nil}
+	if configErr != nil {
+		config.Error = configErr.Error()
+	}
+
+	return healthReport{
+		Engine: engine,
+		Rooms:  rooms,
+		WebSocket: hubHealth{
+			Running: webserver.BrokerRunning(),
+			Clients: webserver.NumOpenWebSessions(),
+			Robots:  webserver.NumOpenTCPConns(),
+		},
+		Config: config,
+	}
+}
+
+// Whether the engine loops of all rooms are still ticking
+func (report *healthReport) live() bool {
+	for _, room := range report.Rooms {
+		if !room.Live {
+			return false
+		}
+	}
+	return report.Engine.Live
+}
+
+// Convert a duration to milliseconds, for reporting
+func ms(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// Write a health report, with a status code depending on whether it is ok
+func writeHealth(w http.ResponseWriter, report healthReport, ok bool) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if ok {
+		report.Status = "ok"
+		w.WriteHeader(http.StatusOK)
+	} else {
+		report.Status = "unavailable"
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+// Liveness check - the engine loop must still be ticking
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	report := checkHealth()
+	writeHealth(w, report, report.live())
+}
+
+// Readiness check - the server must be alive and able to serve clients
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	report := checkHealth()
+	writeHealth(w, report, report.live() && report.WebSocket.Running &&
+		report.Config.Valid)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+/*
+A log handler for the console, which prints each record on its own line in the
+same colored format that the server has always used, followed by its fields:
+
+	INFO: Mode changed from=scatter to=chase tick=156
+*/
+type consoleHandler struct {
+	out   io.Writer   // Output stream
+	level slog.Level  // Minimum level to print
+	attrs string      // Pre-formatted fields (from With)
+	mu    *sync.Mutex // Mutex to serialize writes (shared between copies)
+}
+
+// Colors and tags for each level
+var levelColors = map[slog.Level]string{
+	slog.LevelDebug: "\033[2m\033[35m",
+	slog.LevelInfo:  "\033[36m",
+	slog.LevelWarn:  "\033[35m",
+	slog.LevelError: "\033[35m\033[1m",
+}
+var levelTags = map[slog.Level]string{
+	slog.LevelDebug: "DBG: ",
+	slog.LevelInfo:  "INFO:",
+	slog.LevelWarn:  "WARN:",
+	slog.LevelError: "ERR: ",
+}
+
+// Determines if records of a given level should be printed
+func (h *consoleHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+// Print a record to the console
+func (h *consoleHandler) Handle(_ context.Context, r slog.Record) error {
+
+	// Start with the colored tag and message
+	var sb strings.Builder
+	sb.WriteString(levelColors[r.Level])
+	sb.WriteString(levelTags[r.Level])
+	sb.WriteString(" ")
+	sb.WriteString(r.Message)
+
+	// Add the fields
+	sb.WriteString(h.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		sb.WriteString(formatAttr(a))
+		return true
+	})
+	sb.WriteString("\033[0m\n")
+
+	// Write the line all at once
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.out, sb.String())
+	return err
+}
+
+// Return a copy of the handler with some extra fields
+func (h *consoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	for _, a := range attrs {
+		h2.attrs += formatAttr(a)
+	}
+	return &h2
+}
+
+// Return a copy of the handler with a group (printed as a name prefix)
+func (h *consoleHandler) WithGroup(name string) slog.Handler {
+	h2 := *h
+	h2.attrs += " [" + name + "]"
+	return &h2
+}
+
+// Format a field as " key=value"
+func formatAttr(a slog.Attr) string {
+	return fmt.Sprintf(" %s=%v", a.Key, a.Value.Resolve())
+}
+
+/*
+Set up the default logger, according to the configuration - the format can be
+"console" (colored, the default), "text", or "json", and logs go to standard
+error unless a file is given
+*/
+func setupLogging(format string, file string, level string) {
+
+	// Decide the minimum level to log
+	var minLevel slog.Level
+	if err := minLevel.UnmarshalText([]byte(level)); err != nil || level == "" {
+		minLevel = slog.LevelInfo
+	}
+
+	// Decide where to log to
+	var out io.Writer = os.Stderr
+	if file != "" {
+		f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			log.Println("Log file error:", err)
+		} else {
+			out = f
+		}
+	}
+
+	// Decide the format of the logs
+	opts := &slog.HandlerOptions{Level: minLevel}
+	var handler slog.Handler
+	switch format {
+	case "json":
+		handler = slog.NewJSONHandler(out, opts)
+	case "text":
+		handler = slog.NewTextHandler(out, opts)
+	default:
+		handler = &consoleHandler{out: out, level: minLevel, mu: &sync.Mutex{}}
+	}
+
+	// Use the handler for all logs
+	slog.SetDefault(slog.New(handler))
+}
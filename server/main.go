@@ -3,12 +3,19 @@ package main
 import (
 	"context"
 	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
 	"pacbot_server/game"
 	"pacbot_server/webserver"
 	"sync"
+	"syscall"
 	"time"
 )
 
@@ -17,12 +24,149 @@ func main() {
 	// Disable logging timestamps
 	log.SetFlags(0)
 
+	// Command-line flags, to play back a replay instead of a live game
+	replayPath := flag.String("replay", "",
+		"replay file to play back instead of running a live game")
+	replaySpeed := flag.Float64("replay-speed", 1,
+		"speed multiplier for playing back a replay")
+
+	// Command-line flag, to resume a game from a snapshot
+	restorePath := flag.String("restore", "",
+		"snapshot file to resume the game from")
+
+	// Command-line flag, to print the layout of the binary game state
+	dumpSchema := flag.Bool("dump-schema", false,
+		"print a JSON description of the binary game state, and exit")
+
+	// Command-line flag, to check a maze layout instead of running the server
+	validatePath := flag.String("validate", "",
+		"check the maze layout in this file, print any problems, and exit")
+
+	// Command-line flags, to play a game as fast as possible without any
+	// servers, e.g. for AI training or regression tests (headless_runner.go)
+	headless := flag.Bool("headless", false,
+		"play one game (or the replay) as fast as possible, print a summary, "+
+			"and exit")
+	maxTicks := flag.Uint("max-ticks", 0,
+		"in headless mode, stop after this many ticks (0 for no limit)")
+
+	// Command-line flag, to serve reinforcement learning environments instead
+	// of running the server (gym_server.go)
+	gymAddr := flag.String("gym", "",
+		"serve training environments over TCP at this address (e.g. :3005)")
+
+	// Command-line flag, to benchmark bots against each other instead of
+	// running the server (tournament.go)
+	tournamentPath := flag.String("tournament", "",
+		"play the tournament described in this file, print the results, "+
+			"and exit")
+
+	// Command-line flags, to export a replay as an animation instead of
+	// running the server (export_runner.go)
+	exportPath := flag.String("export", "",
+		"export the --replay as an animation to this file (.gif, or e.g. .mp4 "+
+			"through ffmpeg), and exit")
+	exportFPS := flag.Float64("export-fps", 10,
+		"frame rate of the exported animation")
+
+	// Command-line flag, to watch a running server from the terminal instead
+	// of running one (tui.go)
+	tuiAddr := flag.String("tui", "",
+		"watch the server at this websocket address (e.g. ws://localhost:3002) "+
+			"in the terminal")
+	flag.Parse()
+
+	// Print the schema, if asked, instead of running the server (schema.go)
+	if *dumpSchema {
+		schema, err := game.StateSchema()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(schema))
+		return
+	}
+
+	// Check a maze layout, if asked, instead of running the server
+	// (game/maze_validation.go)
+	if *validatePath != "" {
+		if err := game.ValidateMazeFile(*validatePath); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println("Maze is valid")
+		return
+	}
+
 	// Get the configuration info (config.go)
 	conf := GetConfig()
 
+	// Watch a server from the terminal, if asked, instead of running one
+	if *tuiAddr != "" {
+		game.ConfigMazeFile(conf.MazeFile)
+		runTUI(*tuiAddr)
+		return
+	}
+
+	// Export a replay, if asked, instead of running the server
+	if *exportPath != "" {
+		runExport(conf, *replayPath, *exportPath, *exportFPS)
+		return
+	}
+
+	// Play a headless game, if asked, instead of running the server
+	if *headless {
+		runHeadless(conf, *replayPath, uint32(*maxTicks))
+		return
+	}
+
+	// Set up the logger, according to the configuration (log_handler.go)
+	setupLogging(conf.LogFormat, conf.LogFile, conf.LogLevel)
+
+	// Serve training environments, if asked, instead of running the server
+	if *gymAddr != "" {
+		runGym(conf, *gymAddr)
+		return
+	}
+
+	// Play a tournament, if asked, instead of running the server
+	if *tournamentPath != "" {
+		runTournament(conf, *tournamentPath)
+		return
+	}
+
 	// Use this configuration info to set up server subunits
 	webserver.ConfigOneClientPerIP(conf.OneClientPerIP)
 	webserver.ConfigTrustedClientIPs(conf.TrustedClientIPs)
+	webserver.ConfigAuth(conf.AuthTokens, conf.AuthJWTSecret)
+	webserver.ConfigCommandRateLimit(conf.CommandRateLimit, conf.CommandBurst,
+		conf.CommandRatePolicy)
+	webserver.ConfigHeartbeat(conf.PingInterval, conf.PongTimeout)
+	webserver.ConfigMaxControlLatency(conf.MaxControlLatency)
+	webserver.ConfigTelemetryRate(conf.TelemetryRate)
+	webserver.ConfigHumanGhosts(conf.HumanGhosts)
+	webserver.ConfigSessionResume(conf.SessionGrace)
+	webserver.ConfigStateJSONEncoder(game.StateToJSON)
+	webserver.ConfigProtoCodec(game.StateToProto, game.CommandFromProto)
+	webserver.ConfigCommandValidator(game.ValidateCommand)
+	webserver.ConfigFrameRenderers(game.RenderPNG, game.RenderSVG)
+	webserver.ConfigSSERate(conf.SSERate)
+	webserver.ConfigSpectatorRate(conf.SpectatorRate)
+	webserver.ConfigCompression(conf.Compression, conf.CompressionLevel)
+	webserver.ConfigMazes(game.MazeNames())
+	webserver.ConfigWebhooks(conf.Webhooks)
+	webserver.ConfigChaos(conf.Chaos)
+	webserver.ConfigScoreboard(conf.ScoreboardFile)
+	webserver.ConfigResultsDir(conf.ResultsDir)
+
+	/*
+		A context for the lifetime of the server, done once a user types 'q' or
+		the server is interrupted (SIGINT or SIGTERM) - everything below runs
+		until then, so that games end on a whole tick and connections are
+		closed cleanly (a second interrupt kills the server right away)
+	*/
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt,
+		syscall.SIGTERM)
+	defer stop()
 
 	// Make channels for communication between web broker and game engine
 	webBroadcastCh := make(chan []byte, 100)
@@ -31,55 +175,237 @@ func main() {
 
 	// Set up the TCP server
 	tcp := webserver.NewTcpServer(fmt.Sprintf(":%d", conf.TcpPort), tcpSendCh)
-	go tcp.TcpStart()
+	go tcp.Run(ctx)
 	go tcp.Printer()
-	log.Printf("\033[35mLOG:  Tcp server running on %s:%d\033[0m\n", conf.ServerIP, conf.TcpPort)
+	slog.Info("Tcp server running", "ip", conf.ServerIP, "port", conf.TcpPort)
+
+	// Set up the gRPC server, if a port is configured
+	var grpcServer *webserver.GrpcServer = nil
+	if conf.GrpcPort != 0 {
+		grpcServer = webserver.NewGrpcServer(fmt.Sprintf(":%d", conf.GrpcPort))
+		go func() {
+			if err := grpcServer.GrpcStart(); err != nil {
+				slog.Error("gRPC server error", "err", err)
+			}
+		}()
+		slog.Info("gRPC server running", "ip", conf.ServerIP,
+			"port", conf.GrpcPort)
+	}
+
+	// Set up the UDP broadcaster, if any targets are configured
+	var udp *webserver.UdpBroadcaster = nil
+	if len(conf.UdpTargets) > 0 {
+		udp = webserver.NewUdpBroadcaster(conf.UdpTargets)
+		go udp.Run(ctx)
+	}
 
 	// A wait group for quitting synchronously (allowing go-routines to complete)
 	var wgQuit sync.WaitGroup
 
 	// Websocket setup (package webserver)
-	server := http.Server{Addr: fmt.Sprintf(":%d", conf.WebSocketPort)}
-	log.Printf("\033[35mLOG:  Web server running on %s:%d\033[0m\n", conf.ServerIP, conf.WebSocketPort)
+	server := http.Server{Addr: fmt.Sprintf(":%d", conf.WebSocketPort),
+		BaseContext: func(net.Listener) context.Context { return ctx }}
+	certFile, keyFile, useTLS := setupTLS(&server, conf) // (tls_setup.go)
+	slog.Info("Web server running", "ip", conf.ServerIP,
+		"port", conf.WebSocketPort, "tls", useTLS)
 	wb := webserver.NewWebBroker(webBroadcastCh, tcpSendCh, webResponseCh, &wgQuit)
-	go wb.RunLoop() // Run the web broker loop asynchronously
+
+	// Each extra room gets its own web broker and channels
+	roomBrokers := make([]*webserver.WebBroker, len(conf.Rooms))
+	roomBroadcastChs := make([]chan []byte, len(conf.Rooms))
+	roomResponseChs := make([]chan []byte, len(conf.Rooms))
+	for i, room := range conf.Rooms {
+		roomBroadcastChs[i] = make(chan []byte, 100)
+		roomResponseChs[i] = make(chan []byte, 100)
+		roomBrokers[i] = webserver.NewRoomBroker(room.Name, roomBroadcastChs[i],
+			nil, roomResponseChs[i], &wgQuit)
+	}
+
+	// Set up each room's arena calibration (webserver/arena.go)
+	if err := wb.ConfigArena(conf.Arena); err != nil {
+		slog.Error("Arena configuration error", "err", err)
+	}
+	for i, room := range conf.Rooms {
+		arena := conf.Arena
+		if room.Arena != nil {
+			arena = room.Arena
+		}
+		if err := roomBrokers[i].ConfigArena(arena); err != nil {
+			slog.Error("Arena configuration error", "room", room.Name,
+				"err", err)
+		}
+	}
+
+	// Run the web broker loops asynchronously
+	go wb.Run(ctx)
+	for _, roomBroker := range roomBrokers {
+		go roomBroker.Run(ctx)
+	}
 	http.HandleFunc("/", webserver.WebSocketHandler)
+	http.HandleFunc("/healthz", healthzHandler) // Health checks (health_handler.go)
+	http.HandleFunc("/readyz", readyzHandler)
+	http.HandleFunc("/state", webserver.StateHandler) // REST API (rest_handler.go)
+	http.HandleFunc("/score", webserver.ScoreHandler)
+	http.HandleFunc("/frame.png", webserver.FrameHandler) // Images (frame.go)
+	http.HandleFunc("/frame.svg", webserver.FrameHandler)
+	http.HandleFunc("/path", webserver.PathHandler) // Path queries (path_query.go)
+	http.HandleFunc("/predict", webserver.PredictHandler)
+	http.HandleFunc("/arena", webserver.ArenaHandler) // Calibration (arena.go)
+	http.HandleFunc("/arena/transform", webserver.ArenaTransformHandler)
+	http.HandleFunc("/events", webserver.EventsHandler) // SSE (sse_handler.go)
+	http.HandleFunc("/scores", webserver.ScoresHandler) // (scoreboard.go)
+	http.HandleFunc("/scores/teams", webserver.TeamScoresHandler)
+	http.HandleFunc("/admin/pause", webserver.AdminHandler([]byte{'p'}))
+	http.HandleFunc("/admin/play", webserver.AdminHandler([]byte{'P'}))
+	http.HandleFunc("/admin/reset", webserver.AdminHandler([]byte{'r'}))
+	http.HandleFunc("/admin/clients", webserver.ClientsHandler)
+	http.HandleFunc("/admin/dashboard", webserver.DashboardHandler) // (dashboard.go)
+	http.HandleFunc("/admin/kick", webserver.KickHandler)
+	http.HandleFunc("/admin/match", webserver.MatchHandler) // Lobby (lobby.go)
+	http.HandleFunc("/admin/match/start", webserver.MatchStartHandler)
+	http.HandleFunc("/admin/referee", webserver.RefereeHandler) // (referee.go)
+	http.HandleFunc("/admin/estop", webserver.EStopHandler)     // (estop.go)
+	http.HandleFunc("/admin/ghosts", webserver.GhostsHandler)   // (ghost_selection.go)
+	http.HandleFunc("/admin/results", webserver.ResultsHandler) // (results.go)
+	http.HandleFunc("/admin/results/", webserver.ResultsHandler)
 	go func() {
-		if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
-			log.Fatalf("HTTP server error: %e", err)
+		var err error
+		if useTLS {
+			err = server.ListenAndServeTLS(certFile, keyFile)
+		} else {
+			err = server.ListenAndServe()
+		}
+		if !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("HTTP server error", "err", err)
+			os.Exit(1)
 		}
-		log.Println("\033[35mLOG:  HTTP server successfully quit\033[0m")
+		slog.Info("HTTP server successfully quit")
 	}()
 
 	// Game engine setup (package game)
-	game.ConfigNumActiveGhosts(min(conf.NumActiveGhosts, 4))
-	ge := game.NewGameEngine(webBroadcastCh, webResponseCh, &wgQuit, conf.GameFPS)
-	go ge.RunLoop() // Run the game engine loop asynchronously
+	configGameRules(conf)
+	game.ConfigReplayDir(conf.ReplayDir)
+	game.ConfigSnapshotDir(conf.SnapshotDir)
+	game.ConfigRewindSeconds(conf.RewindSeconds)
+	game.ConfigResultsDir(conf.ResultsDir)
+	game.ConfigEventLogFile(conf.EventLogFile)
+	game.ConfigDecisionBudget(conf.DecisionDeadline, conf.DecisionPolicy)
+	game.ConfigEventListener(webserver.NotifyGameEvent) // (countdown.go)
+	game.ConfigCommandErrorListener(webserver.NotifyCommandError)
+	var ge *game.GameEngine
+	if *replayPath != "" {
+		var err error
+		ge, err = game.NewReplayEngine(webBroadcastCh, webResponseCh, &wgQuit,
+			*replayPath, *replaySpeed)
+		if err != nil {
+			slog.Error("Replay error", "err", err)
+			os.Exit(1)
+		}
+	} else {
+		ge = game.NewGameEngine(webBroadcastCh, webResponseCh, &wgQuit,
+			conf.GameFPS)
+		if *restorePath != "" {
+			if err := ge.RestoreSnapshot(*restorePath); err != nil {
+				slog.Error("Snapshot error", "err", err)
+				os.Exit(1)
+			}
+		}
+	}
+	go ge.Run(ctx) // Run the game engine loop asynchronously
+
+	// Keep track of the game engine for health checks (health_handler.go), and
+	// let clients find paths in its maze and predict its ghosts
+	// (webserver/path_query.go, webserver/ghost_prediction.go), and the broker
+	// hand its broadcast buffers back (webserver/state_frames.go)
+	addGameEngine(ge)
+	wb.ConfigPathFinder(ge.FindPath)
+	wb.ConfigGhostPredictor(ge.PredictGhosts)
+	wb.ConfigBufferRecycler(ge.RecycleBuffer)
+
+	// Run a game engine for each extra room (as a live game)
+	for i, room := range conf.Rooms {
+		settings, err := conf.roomSettings(room)
+		if err != nil {
+			slog.Error("Room configuration error", "err", err)
+			os.Exit(1)
+		}
+		roomEngine := game.NewRoomEngine(roomBroadcastChs[i], roomResponseChs[i],
+			&wgQuit, settings)
+		go roomEngine.Run(ctx)
+		addGameEngine(roomEngine)
+		roomBrokers[i].ConfigPathFinder(roomEngine.FindPath)
+		roomBrokers[i].ConfigGhostPredictor(roomEngine.PredictGhosts)
+		roomBrokers[i].ConfigBufferRecycler(roomEngine.RecycleBuffer)
+		slog.Info("Room running", "room", room.Name, "fps", settings.GameFPS)
+	}
 
 	// Set the enable for game command logging to be false by default
 	game.SetCommandLogEnable(false)
 
-	// Keep the game engine alive until a user types 'q'
-	var input string
-	fmt.Println("Ready")
-	for {
-		fmt.Scanf("%s\n", &input) // Blocking I/O to keep the program alive
-		if input == "q" {         // Quit signal
-			break
-		} else {
+	// Keep the game engine alive until a user types 'q' (other commands go to
+	// the default room), or the server is interrupted - without a terminal
+	// (e.g. as a service), only an interrupt stops the server
+	go func() {
+		var input string
+		for {
+			_, err := fmt.Scanf("%s\n", &input)
+			if errors.Is(err, io.EOF) {
+				return
+			} else if err != nil {
+				continue
+			}
+			if input == "q" { // Quit signal
+				stop()
+				return
+			}
 			webResponseCh <- []byte(input)
 		}
-	}
+	}()
+	fmt.Println("Ready")
+	<-ctx.Done()
+	slog.Info("Shutting down")
 
-	// Shutdown HTTP server to prevent new and finish old connections
+	// Shutdown HTTP server to prevent new and finish old connections (the web
+	// brokers, game engines, TCP server, and UDP broadcaster quit on their own
+	// once the context is done)
 	shutdownCtx, shutdownRelease := context.WithTimeout(context.Background(), 2*time.Second)
 	defer shutdownRelease()
 	server.Shutdown(shutdownCtx)
 
-	// Quit the web server and game engine once complete
-	wb.Quit()
-	ge.Quit()
+	// Stop the gRPC server, if it is running
+	if grpcServer != nil {
+		grpcServer.Quit()
+	}
 
 	// Synchronize to allow all processes to end safely
 	wgQuit.Wait()
+
+	// Close the scoreboard and the event log, now that no more games can
+	// finish
+	webserver.CloseScoreboard()
+	game.CloseEventLog()
+}
+
+/*
+Set up the rules of the games (package game), and how their states are
+serialized, from the configuration - shared by the server and the modes that
+play games without it (headless_runner.go, gym_server.go, tournament.go)
+*/
+func configGameRules(conf Configuration) {
+	game.ConfigGame(conf.Game)
+	game.ConfigNumActiveGhosts(conf.NumActiveGhosts)
+	game.ConfigDisabledGhosts(conf.DisabledGhosts)
+	game.ConfigCountdownSeconds(conf.CountdownSeconds)
+	game.ConfigMatchSeconds(conf.MatchSeconds)
+	game.ConfigGateSchedule(conf.GateSchedule)
+	game.ConfigRandomSeed(conf.RandomSeed)
+	game.ConfigStateHash(conf.StateHash)
+	game.ConfigPoseFilter(conf.PoseFilter, conf.PoseFilterWindow,
+		conf.PoseNoise, conf.PoseMaxSpeed)
+	game.ConfigCollisionRadius(conf.CollisionRadius)
+	game.ConfigMaze(conf.Maze)
+	game.ConfigMazeFile(conf.MazeFile)
+	if conf.BonusLifeScores != nil {
+		game.ConfigBonusLifeScores(conf.BonusLifeScores)
+	}
 }
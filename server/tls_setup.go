@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+/*
+Set up TLS for the web server, so that it can serve wss:// (and https://)
+directly - needed when the web visualizer is hosted over HTTPS. Either a
+certificate and key file can be given, or a list of domains to fetch
+certificates for automatically (from Let's Encrypt, which needs the server to
+be reachable on port 80 from the internet). Returns the certificate and key
+files to serve with, and whether TLS is enabled at all
+*/
+func setupTLS(server *http.Server, conf Configuration) (string, string, bool) {
+
+	// Certificate and key files take priority
+	if conf.TLSCertFile != "" && conf.TLSKeyFile != "" {
+		slog.Info("TLS enabled", "cert", conf.TLSCertFile)
+		return conf.TLSCertFile, conf.TLSKeyFile, true
+	}
+
+	// Otherwise, fetch certificates automatically (if any domains are given)
+	if len(conf.AutocertDomains) == 0 {
+		return "", "", false
+	}
+	cacheDir := conf.AutocertCacheDir
+	if cacheDir == "" {
+		cacheDir = "autocert"
+	}
+	manager := autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(conf.AutocertDomains...),
+		Cache:      autocert.DirCache(cacheDir),
+	}
+	server.TLSConfig = manager.TLSConfig()
+
+	// Answer the certificate authority's challenges on port 80
+	go func() {
+		err := http.ListenAndServe(":http", manager.HTTPHandler(nil))
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Autocert challenge server error", "err", err)
+		}
+	}()
+	slog.Info("TLS enabled (autocert)", "domains", conf.AutocertDomains)
+	return "", "", true
+}
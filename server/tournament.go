@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"pacbot_server/game"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+/*
+Tournament mode (--tournament <file>) benchmarks bots against each other
+instead of running the server, e.g. overnight after an algorithm change: each
+bot plays the same series of headless games (see game/environment.go), and the
+results are aggregated into a table, printed when the tournament finishes and
+(optionally) written to a CSV file. The tournament is described in a JSON file:
+
+	{
+	  "Games": 20,             - games for each bot to play
+	  "Seed": 1,               - seed of the first game (each game uses the
+	                             next seed, so every bot plays the same games)
+	  "MaxSteps": 10000,       - steps before a game is cut short (0 for none)
+	  "MoveTimeout": 1,        - seconds a bot endpoint has to answer each step
+	  "Output": "results.csv", - file to write the results table to (optional)
+	  "Bots": [
+	    {"Name": "baseline", "Policy": "greedy"},
+	    {"Name": "team1", "Address": "localhost:4100"}
+	  ]
+	}
+
+Bots are either built-in policies (see game/policies.go), or endpoints that
+the server connects to for each game: an endpoint is sent frames as in gym mode
+(see gym_server.go) - reward, done, observation length, observation - starting
+with the first observation, and answers each frame that isn't done with an
+action byte. The connection is closed after the final frame. Bots play their
+games in parallel with each other, and a bot whose endpoint fails has that game
+counted as an error instead of in its results.
+*/
+
+// A bot taking part in a tournament
+type tournamentBot struct {
+	Name    string
+	Policy  string // Built-in policy to play with
+	Address string // Address of an endpoint to play through instead
+}
+
+// A tournament, as described in its file
+type tournamentConfig struct {
+	Games       int
+	Seed        int64
+	MaxSteps    int
+	MoveTimeout float64
+	Output      string
+	Bots        []tournamentBot
+}
+
+// The aggregated results of a bot in a tournament
+type tournamentResult struct {
+	bot         string
+	games       int // Games finished (without errors)
+	errors      int // Games that failed because of the bot's endpoint
+	totalScore  int
+	bestScore   int
+	worstScore  int
+	totalSteps  int
+	totalLevels int
+	gamesOver   int // Games that ended with Pacman out of lives
+}
+
+// Read a tournament's description from its file, filling in any defaults
+func readTournament(path string) (tournamentConfig, error) {
+	tc := tournamentConfig{Games: 10, Seed: 1, MoveTimeout: 1}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return tc, err
+	}
+	if err := json.Unmarshal(data, &tc); err != nil {
+		return tc, err
+	}
+
+	// Check the tournament
+	if len(tc.Bots) == 0 {
+		return tc, fmt.Errorf("no bots are registered")
+	}
+	for _, bot := range tc.Bots {
+		if bot.Address == "" {
+			if _, ok := game.BuiltinPolicy(bot.Policy); !ok {
+				return tc, fmt.Errorf("bot \"%s\" needs an address or one of "+
+					"the policies %v", bot.Name, game.PolicyNames())
+			}
+		}
+	}
+
+	// Seed 0 would give each bot different games
+	if tc.Seed == 0 {
+		tc.Seed = 1
+	}
+	return tc, nil
+}
+
+/*
+Play one game of a tournament with a bot, returning the number of steps
+played (the game stays in the environment, for its results)
+*/
+func (tc *tournamentConfig) playGame(bot tournamentBot, env *game.Environment,
+	seed int64) (int, error) {
+
+	// Connect to the bot's endpoint, if it has one
+	policy, _ := game.BuiltinPolicy(bot.Policy)
+	timeout := time.Duration(tc.MoveTimeout * float64(time.Second))
+	var conn net.Conn
+	if bot.Address != "" {
+		var err error
+		conn, err = net.DialTimeout("tcp", bot.Address, timeout)
+		if err != nil {
+			return 0, err
+		}
+		defer conn.Close()
+	}
+
+	// Play until the game is done (or cut short)
+	obs, reward, done := env.Reset(seed), 0.0, false
+	steps := 0
+	for !done && (tc.MaxSteps <= 0 || steps < tc.MaxSteps) {
+
+		// Ask the bot for its move
+		var action uint8
+		if conn == nil {
+			action = policy(env.Simulation())
+		} else {
+			conn.SetDeadline(time.Now().Add(timeout))
+			if _, err := conn.Write(gymFrame(obs, reward, false)); err != nil {
+				return steps, err
+			}
+			var buf [1]byte
+			if _, err := conn.Read(buf[:]); err != nil {
+				return steps, err
+			}
+			action = buf[0]
+		}
+
+		// Make it
+		obs, reward, done = env.Step(action)
+		steps++
+	}
+
+	// Let the bot's endpoint know that the game is done
+	if conn != nil {
+		conn.SetDeadline(time.Now().Add(timeout))
+		conn.Write(gymFrame(obs, reward, true))
+	}
+	return steps, nil
+}
+
+// Play all of a bot's games in a tournament, aggregating the results
+func (tc *tournamentConfig) playBot(bot tournamentBot,
+	settings game.RoomSettings) tournamentResult {
+	result := tournamentResult{bot: bot.Name}
+	env := game.NewEnvironment(settings)
+	for i := 0; i < tc.Games; i++ {
+
+		// Play the game
+		seed := tc.Seed + int64(i)
+		steps, err := tc.playGame(bot, env, seed)
+		if err != nil {
+			slog.Warn("Tournament game failed", "bot", bot.Name, "seed", seed,
+				"err", err)
+			result.errors++
+			continue
+		}
+
+		// Add it to the results
+		sim := env.Simulation()
+		score := int(sim.Score())
+		slog.Info("Tournament game finished", "bot", bot.Name, "seed", seed,
+			"score", score, "steps", steps)
+		if result.games == 0 || score > result.bestScore {
+			result.bestScore = score
+		}
+		if result.games == 0 || score < result.worstScore {
+			result.worstScore = score
+		}
+		result.games++
+		result.totalScore += score
+		result.totalSteps += steps
+		result.totalLevels += int(sim.Level())
+		if sim.Lives() == 0 {
+			result.gamesOver++
+		}
+	}
+	return result
+}
+
+// Get the average of a total over a bot's finished games
+func (result *tournamentResult) mean(total int) string {
+	if result.games == 0 {
+		return "-"
+	}
+	return strconv.FormatFloat(float64(total)/float64(result.games), 'f', 1, 64)
+}
+
+// Get a score of a bot's finished games
+func (result *tournamentResult) score(score int) string {
+	if result.games == 0 {
+		return "-"
+	}
+	return strconv.Itoa(score)
+}
+
+// The rows of the results table (with the header first)
+func resultsTable(results []tournamentResult) [][]string {
+	rows := [][]string{{"bot", "games", "errors", "mean score", "best score",
+		"worst score", "mean steps", "mean level", "games over"}}
+	for _, result := range results {
+		rows = append(rows, []string{
+			result.bot,
+			strconv.Itoa(result.games),
+			strconv.Itoa(result.errors),
+			result.mean(result.totalScore),
+			result.score(result.bestScore),
+			result.score(result.worstScore),
+			result.mean(result.totalSteps),
+			result.mean(result.totalLevels),
+			strconv.Itoa(result.gamesOver),
+		})
+	}
+	return rows
+}
+
+// Run a tournament from its file, printing (and writing) the results table
+func runTournament(conf Configuration, path string) {
+
+	// Read the tournament, and set up its games like the default room's
+	tc, err := readTournament(path)
+	if err != nil {
+		slog.Error("Tournament error", "path", path, "err", err)
+		os.Exit(1)
+	}
+	configGameRules(conf)
+	settings := game.RoomSettings{GameFPS: conf.GameFPS}
+
+	// Play each bot's games in parallel
+	slog.Info("Tournament started", "bots", len(tc.Bots), "games", tc.Games)
+	start := time.Now()
+	results := make([]tournamentResult, len(tc.Bots))
+	var wg sync.WaitGroup
+	for i, bot := range tc.Bots {
+		wg.Add(1)
+		go func(i int, bot tournamentBot) {
+			defer wg.Done()
+			results[i] = tc.playBot(bot, settings)
+		}(i, bot)
+	}
+	wg.Wait()
+	slog.Info("Tournament finished", "elapsed", time.Since(start))
+
+	// Print the results table
+	rows := resultsTable(results)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, row := range rows {
+		for _, cell := range row {
+			fmt.Fprintf(tw, "%s\t", cell)
+		}
+		fmt.Fprintln(tw)
+	}
+	tw.Flush()
+
+	// Write it to a file, if asked
+	if tc.Output != "" {
+		f, err := os.Create(tc.Output)
+		if err != nil {
+			slog.Error("Tournament output error", "err", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		w := csv.NewWriter(f)
+		w.WriteAll(rows)
+		if err := w.Error(); err != nil {
+			slog.Error("Tournament output error", "err", err)
+			os.Exit(1)
+		}
+		slog.Info("Tournament results written", "path", tc.Output)
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"pacbot_server/client"
+	"pacbot_server/game"
+	"strings"
+	"time"
+)
+
+/*
+TUI mode (--tui <address>) watches a running server from the terminal instead
+of running one, e.g. on a laptop at the field with no browser: it connects to
+the server's websocket as a spectator (see the client package), and redraws
+the maze, pellets, ghosts, Pacman, score, and mode for every state that the
+server broadcasts. The address is the server's websocket address, such as
+ws://localhost:3002, with "?room=<name>" to watch another room. Walls come
+from the maze named in the state, so custom mazes need the same MazeFile as
+the server. If the connection drops, the viewer reconnects every second,
+until it is stopped with Ctrl-C.
+*/
+
+// ANSI escape sequences for drawing the frames
+const (
+	ansiHome       = "\x1b[H"     // Move the cursor to the top left
+	ansiClear      = "\x1b[2J"    // Clear the screen
+	ansiClearBelow = "\x1b[J"     // Clear the rest of the screen
+	ansiHide       = "\x1b[?25l"  // Hide the cursor
+	ansiShow       = "\x1b[?25h"  // Show the cursor
+	ansiReset      = "\x1b[0m"    // Reset the colors
+	ansiWall       = "\x1b[34m"   // Blue
+	ansiPellet     = "\x1b[37m"   // White
+	ansiPacman     = "\x1b[1;33m" // Bright yellow
+	ansiFruit      = "\x1b[1;31m" // Bright red
+	ansiPowerUp    = "\x1b[1;32m" // Bright green
+	ansiTeleporter = "\x1b[1;36m" // Bright cyan
+	ansiFrightened = "\x1b[1;34m" // Bright blue
+	ansiFlashing   = "\x1b[1;37m" // Bright white
+	ansiDoor       = "\x1b[35m"   // Magenta
+	ansiEyes       = "\x1b[1;37m" // Bright white
+	ansiDim        = "\x1b[2m"    // Dim, for the status lines
+	ansiClearLine  = "\x1b[K"     // Clear the rest of the line
+)
+
+// Colors of the ghosts, in the order of client.Color
+var ghostColors = [client.NumColors]string{
+	"\x1b[1;31m",     // Red
+	"\x1b[1;95m",     // Pink
+	"\x1b[1;36m",     // Cyan
+	"\x1b[38;5;208m", // Orange
+	"\x1b[1;35m",     // Purple
+	"\x1b[1;32m",     // Green
+	"\x1b[1;94m",     // Blue
+	"\x1b[1;97m",     // White
+}
+
+// Watch a server from the terminal, until interrupted
+func runTUI(addr string) {
+
+	// Restore the terminal when interrupted
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		<-interrupts
+		fmt.Print(ansiReset + ansiShow + "\n")
+		os.Exit(0)
+	}()
+	fmt.Print(ansiClear + ansiHide)
+
+	// Reconnect whenever the connection drops
+	for {
+		err := watchServer(addr)
+		fmt.Print(ansiHome + ansiClearBelow)
+		fmt.Printf("%s: %v (reconnecting)\n", addr, err)
+		time.Sleep(time.Second)
+	}
+}
+
+// Draw every state broadcast by a server, until the connection drops
+func watchServer(addr string) error {
+	c, err := client.Dial(addr, nil)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	for {
+		state, err := c.Next()
+		var serverErr *client.ServerError
+		if errors.As(err, &serverErr) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Print(ansiHome + renderFrame(addr, state) + ansiClearBelow)
+	}
+}
+
+// Draw a game state as text, with a status line above and below the maze
+func renderFrame(addr string, state *client.GameState) string {
+	var b strings.Builder
+
+	// Status line: the score, level, lives, and mode
+	fmt.Fprintf(&b, "%sscore%s %-6d %slevel%s %-3d %slives%s %-3d %s%s%s",
+		ansiDim, ansiReset, state.Score, ansiDim, ansiReset, state.Level,
+		ansiDim, ansiReset, state.Lives, ansiPacman, state.Mode, ansiReset)
+	if state.Lifecycle != client.LifecycleRunning {
+		fmt.Fprintf(&b, " (%s)", state.Lifecycle)
+	}
+	b.WriteString(ansiClearLine + "\n")
+
+	// Pick out what is drawn on each cell, over the walls and pellets
+	sprites := make(map[[2]int8]string)
+	for _, t := range state.Teleporters {
+		color := ansiTeleporter
+		if t.CooldownSteps > 0 {
+			color = ansiWall
+		}
+		for _, end := range t.Ends {
+			sprites[[2]int8{end.Row, end.Col}] = color + "()"
+		}
+	}
+	for _, p := range state.PowerUps {
+		sprites[[2]int8{p.Row, p.Col}] = ansiPowerUp + powerUpSprite(p.Type)
+	}
+	if state.Fruit != nil {
+		sprites[[2]int8{state.Fruit.Row, state.Fruit.Col}] = ansiFruit + "%%"
+	}
+	sprites[[2]int8{state.Pacman.Row, state.Pacman.Col}] = ansiPacman +
+		pacmanSprite(state.Pacman.Dir)
+	for _, ghost := range state.AllGhosts() {
+		sprites[[2]int8{ghost.Row, ghost.Col}] = ghostSprite(&ghost)
+	}
+
+	// The maze, two characters per cell (so that cells are roughly square)
+	grid, _ := game.MazeGrid(state.Maze)
+	for row := int8(0); row < state.Rows; row++ {
+		for col := int8(0); col < state.Cols; col++ {
+			if sprite, ok := sprites[[2]int8{row, col}]; ok {
+				b.WriteString(sprite)
+			} else {
+				b.WriteString(cellSprite(state, grid, row, col))
+			}
+		}
+		b.WriteString(ansiReset + ansiClearLine + "\n")
+	}
+
+	// Status line: the clock, the maze, and the steps survived (in survival games)
+	fmt.Fprintf(&b, "%s%s  %s  tick %d", ansiDim, addr, state.Maze, state.Ticks)
+	if state.MatchLeft > 0 && state.GameFPS > 0 {
+		fmt.Fprintf(&b, "  %ds left", state.MatchLeft/state.GameFPS)
+	}
+	if state.Survival {
+		fmt.Fprintf(&b, "  survived %d steps", state.SurvivalSteps)
+	}
+	b.WriteString(ansiReset + ansiClearLine + "\n")
+	return b.String()
+}
+
+// Draw Pacman, facing its direction
+func pacmanSprite(dir client.Direction) string {
+	switch dir {
+	case client.Up:
+		return "\\/"
+	case client.Left:
+		return "C>"
+	case client.Down:
+		return "/\\"
+	}
+	return "<C"
+}
+
+// Draw a power-up, depending on its type
+func powerUpSprite(kind client.PowerUpType) string {
+	switch kind {
+	case client.Freeze:
+		return "**"
+	case client.Boost:
+		return ">>"
+	}
+	return "x2"
+}
+
+// Draw a ghost, depending on its state
+func ghostSprite(ghost *client.Ghost) string {
+	switch {
+	case ghost.Eaten:
+		return ansiEyes + "\"\""
+	case ghost.Flashing:
+		return ansiFlashing + "MM"
+	case ghost.Frightened():
+		return ansiFrightened + "MM"
+	}
+	return ghostColors[ghost.Color%client.NumColors] + "MM"
+}
+
+// Draw an empty cell: a wall, a pellet, or nothing
+func cellSprite(state *client.GameState, grid []string, row, col int8) string {
+	tile := byte(' ')
+	if int(row) < len(grid) && int(col) < len(grid[row]) {
+		tile = grid[row][col]
+	}
+	switch {
+	case tile == '#':
+		return ansiWall + "██"
+	case tile == '-':
+		return ansiDoor + "--"
+	case state.GateClosed(row, col):
+		return ansiDoor + "=="
+	case !state.Pellet(row, col):
+		return "  "
+	}
+	for _, super := range state.SuperPellets {
+		if super.Row == row && super.Col == col {
+			return ansiPellet + "()"
+		}
+	}
+	return ansiPellet + " ."
+}
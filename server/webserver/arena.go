@@ -0,0 +1,251 @@
+package webserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+/*
+The vision system, the robot, and the game each have their own coordinates:
+the camera's image (in pixels), the arena's floor (in world units, e.g.
+meters), and the maze's grid (in cells, with the center of the cell at (row,
+col) at exactly (row, col)). Rather than each team hardcoding the constants
+between them, the arena's calibration is set once in config.json (under
+Arena, or per room):
+
+	CellSize   - the length of a cell, in world units
+	Origin     - the world position (x, y) of the center of cell (0, 0)
+	Rotation   - the angle from the world's x-axis to the direction of
+	             increasing columns, in degrees (counterclockwise)
+	FlipRows   - whether increasing rows point clockwise from the columns
+	             (e.g. with the world's y-axis pointing up, and row 0 at the top)
+	Homography - optionally, 9 numbers (row-major) of the homography from the
+	             grid (col, row, 1) to the camera's image (u, v, w)
+
+so that, with s = CellSize, a = Rotation, and r = -row if FlipRows (or row):
+
+	x = Origin[0] + s * (col * cos(a) - r * sin(a))
+	y = Origin[1] + s * (col * sin(a) + r * cos(a))
+
+The calibration is served as JSON (GET /arena), and sent to clients speaking
+pacbot.v1 as a message of type 'w' when they connect, so that they can convert
+coordinates themselves. The server also converts any point for them:
+
+	GET /arena/transform?grid=<row>,<col>
+	GET /arena/transform?world=<x>,<y>
+	GET /arena/transform?image=<u>,<v>     (with a homography)
+
+which answers with the point in each of the coordinates: {"grid": [row, col],
+"world": [x, y], "image": [u, v]} (the image is left out without a homography).
+*/
+
+// Message type for the arena calibration (server -> client)
+const msgArena byte = 'w'
+
+// The arena's calibration, as configured in config.json
+type ArenaCalibration struct {
+	CellSize   float64    `json:"cellSize"`
+	Origin     [2]float64 `json:"origin"`
+	Rotation   float64    `json:"rotation"`
+	FlipRows   bool       `json:"flipRows"`
+	Homography []float64  `json:"homography,omitempty"`
+}
+
+// The arena's calibration, ready to convert coordinates with
+type arenaTransform struct {
+	calibration   ArenaCalibration
+	cos, sin      float64    // Of the rotation
+	homography    [9]float64 // Grid to image (if hasHomography)
+	inverse       [9]float64 // Image to grid
+	hasHomography bool
+	encoded       []byte // Calibration, as JSON
+}
+
+// Check an arena calibration, and prepare it for converting coordinates
+func newArenaTransform(calibration ArenaCalibration) (*arenaTransform, error) {
+	if !(calibration.CellSize > 0) {
+		return nil, fmt.Errorf("cell size must be positive")
+	}
+	angle := calibration.Rotation * math.Pi / 180
+	at := arenaTransform{calibration: calibration,
+		cos: math.Cos(angle), sin: math.Sin(angle)}
+
+	// Invert the homography, if there is one
+	switch len(calibration.Homography) {
+	case 0:
+	case 9:
+		copy(at.homography[:], calibration.Homography)
+		inverse, ok := invert3x3(at.homography)
+		if !ok {
+			return nil, fmt.Errorf("homography must be invertible")
+		}
+		at.inverse, at.hasHomography = inverse, true
+	default:
+		return nil, fmt.Errorf("homography must have 9 numbers, not %d",
+			len(calibration.Homography))
+	}
+	at.encoded, _ = json.Marshal(calibration)
+	return &at, nil
+}
+
+/*
+Set the arena's calibration for the room (nil for none) - returns an error,
+leaving the room without a calibration, if it doesn't check out
+*/
+func (wb *WebBroker) ConfigArena(calibration *ArenaCalibration) error {
+	if calibration == nil {
+		wb.arena.Store(nil)
+		return nil
+	}
+	at, err := newArenaTransform(*calibration)
+	if err != nil {
+		wb.arena.Store(nil)
+		return fmt.Errorf("invalid arena calibration: %v", err)
+	}
+	wb.arena.Store(at)
+	return nil
+}
+
+// Convert a point from the grid to the world
+func (at *arenaTransform) gridToWorld(row, col float64) (float64, float64) {
+	if at.calibration.FlipRows {
+		row = -row
+	}
+	s, o := at.calibration.CellSize, at.calibration.Origin
+	return o[0] + s*(col*at.cos-row*at.sin), o[1] + s*(col*at.sin+row*at.cos)
+}
+
+// Convert a point from the world to the grid
+func (at *arenaTransform) worldToGrid(x, y float64) (float64, float64) {
+	s, o := at.calibration.CellSize, at.calibration.Origin
+	dx, dy := (x-o[0])/s, (y-o[1])/s
+	row, col := -dx*at.sin+dy*at.cos, dx*at.cos+dy*at.sin
+	if at.calibration.FlipRows {
+		row = -row
+	}
+	return row, col
+}
+
+// Apply a homography to a point
+func applyHomography(h [9]float64, a, b float64) (float64, float64) {
+	w := h[6]*a + h[7]*b + h[8]
+	return (h[0]*a + h[1]*b + h[2]) / w, (h[3]*a + h[4]*b + h[5]) / w
+}
+
+// Invert a 3x3 matrix (row-major) - returns false if it is singular
+func invert3x3(m [9]float64) ([9]float64, bool) {
+	det := m[0]*(m[4]*m[8]-m[5]*m[7]) - m[1]*(m[3]*m[8]-m[5]*m[6]) +
+		m[2]*(m[3]*m[7]-m[4]*m[6])
+	if math.Abs(det) < 1e-12 {
+		return [9]float64{}, false
+	}
+	return [9]float64{
+		(m[4]*m[8] - m[5]*m[7]) / det, (m[2]*m[7] - m[1]*m[8]) / det,
+		(m[1]*m[5] - m[2]*m[4]) / det, (m[5]*m[6] - m[3]*m[8]) / det,
+		(m[0]*m[8] - m[2]*m[6]) / det, (m[2]*m[3] - m[0]*m[5]) / det,
+		(m[3]*m[7] - m[4]*m[6]) / det, (m[1]*m[6] - m[0]*m[7]) / det,
+		(m[0]*m[4] - m[1]*m[3]) / det,
+	}, true
+}
+
+// Send the room's arena calibration to a new pacbot.v1 client, if there is one
+func (ws *webSession) sendArena() {
+	if at := ws.broker.arena.Load(); at != nil && ws.taggedMessages() {
+		ws.writeMessage(msgArena, at.encoded)
+	}
+}
+
+// Find the room's arena calibration, writing an error response if it has none
+func requestArenaOrError(w http.ResponseWriter, r *http.Request) *arenaTransform {
+	wb := requestRoomOrError(w, r)
+	if wb == nil {
+		return nil
+	}
+	at := wb.arena.Load()
+	if at == nil {
+		writeJSONError(w, http.StatusNotFound, "the arena isn't calibrated")
+	}
+	return at
+}
+
+// Serve the room's arena calibration (GET /arena)
+func ArenaHandler(w http.ResponseWriter, r *http.Request) {
+
+	// Only reads are allowed
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+	at := requestArenaOrError(w, r)
+	if at == nil {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(at.encoded)
+}
+
+// Parse a point given as "<a>,<b>" in a query parameter
+func parsePoint(value string) (float64, float64, error) {
+	aStr, bStr, ok := strings.Cut(value, ",")
+	a, errA := strconv.ParseFloat(strings.TrimSpace(aStr), 64)
+	b, errB := strconv.ParseFloat(strings.TrimSpace(bStr), 64)
+	if !ok || errA != nil || errB != nil || math.IsNaN(a) || math.IsNaN(b) ||
+		math.IsInf(a, 0) || math.IsInf(b, 0) {
+		return 0, 0, fmt.Errorf("invalid point %q (use <a>,<b>)", value)
+	}
+	return a, b, nil
+}
+
+// Convert a point between the arena's coordinates (GET /arena/transform)
+func ArenaTransformHandler(w http.ResponseWriter, r *http.Request) {
+
+	// Only reads are allowed
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+	at := requestArenaOrError(w, r)
+	if at == nil {
+		return
+	}
+
+	// Read the point, converting it to the grid first
+	var row, col float64
+	query := r.URL.Query()
+	var err error
+	switch {
+	case query.Has("grid"):
+		row, col, err = parsePoint(query.Get("grid"))
+	case query.Has("world"):
+		var x, y float64
+		x, y, err = parsePoint(query.Get("world"))
+		row, col = at.worldToGrid(x, y)
+	case query.Has("image") && at.hasHomography:
+		var u, v float64
+		u, v, err = parsePoint(query.Get("image"))
+		col, row = applyHomography(at.inverse, u, v)
+	case query.Has("image"):
+		err = fmt.Errorf("the arena has no homography for the image")
+	default:
+		err = fmt.Errorf("give a point as grid, world, or image")
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Convert it to each of the coordinates
+	out := map[string]any{"grid": [2]float64{row, col}}
+	x, y := at.gridToWorld(row, col)
+	out["world"] = [2]float64{x, y}
+	if at.hasHomography {
+		u, v := applyHomography(at.homography, col, row)
+		out["image"] = [2]float64{u, v}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
@@ -0,0 +1,195 @@
+package webserver
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+/*
+Clients can authenticate when connecting, so that only registered team bots
+and referee consoles can send commands (the game state stays open to
+spectators). A client presents a token, either as a bearer token in the
+Authorization header or as a "token" query parameter (for browsers, which
+can't set headers on websockets):
+
+	ws://localhost:3002/?token=<token>
+
+The token can be either:
+
+	- a pre-shared token, listed (with the client's name and role) under
+	  AuthTokens in config.json
+	- a JSON Web Token signed with HS256 using AuthJWTSecret, whose "sub"
+	  claim names the client and whose "role" claim gives its role (its "exp"
+	  and "nbf" claims are checked, if set)
+
+Once any tokens or a JWT secret are configured, only authenticated clients can
+send commands (as allowed by their roles - see roles.go); otherwise, commands
+are accepted from the trusted client IPs as before. Clients presenting an
+invalid token are rejected outright, so that misconfigured bots don't silently
+connect as spectators.
+*/
+
+// A pre-shared token for a registered client
+type ClientToken struct {
+	Name  string // Client name (for logging)
+	Token string
+	Role  string // Client role (roles.go), bot by default
+}
+
+// An authenticated client
+type authClient struct {
+	name string
+	role clientRole
+}
+
+// Pre-shared tokens, mapped to their clients
+var authTokens = make(map[string]authClient)
+
+// Secret for verifying JSON Web Tokens (nil if JWTs are not accepted)
+var authJWTSecret []byte = nil
+
+// Set the tokens and JWT secret used to authenticate clients
+func ConfigAuth(tokens []ClientToken, jwtSecret string) {
+	for _, token := range tokens {
+		if token.Token == "" {
+			continue
+		}
+		role, err := parseRole(token.Role)
+		if err != nil {
+			slog.Warn("Ignoring token with an invalid role", "client", token.Name,
+				"err", err)
+			continue
+		}
+		authTokens[token.Token] = authClient{name: token.Name, role: role}
+	}
+	if jwtSecret != "" {
+		authJWTSecret = []byte(jwtSecret)
+	}
+}
+
+// Whether clients need to authenticate to send commands
+func authEnabled() bool {
+	return len(authTokens) > 0 || authJWTSecret != nil
+}
+
+// Get the token presented with an HTTP request ("" if there is none)
+func requestToken(r *http.Request) string {
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"),
+		"Bearer "); ok {
+		return strings.TrimSpace(bearer)
+	}
+	return r.URL.Query().Get("token")
+}
+
+/*
+Decide which commands a client can send, given its IP address and the token it
+presented - returns the client's name (its IP address, if it was trusted by
+IP) and role, and an error if the token is invalid
+*/
+func authorizeClient(ip string, token string) (string, clientRole, error) {
+
+	// Without authentication, fall back to the trusted client IPs
+	if !authEnabled() {
+		if _, trusted := trustedClientIPs[ip]; trusted {
+			return ip, roleReferee, nil
+		}
+		return "", roleSpectator, nil
+	}
+
+	// Otherwise, clients without a token can only watch
+	if token == "" {
+		return "", roleSpectator, nil
+	}
+	client, err := authenticate(token)
+	if err != nil {
+		return "", roleSpectator, err
+	}
+	return client.name, client.role, nil
+}
+
+// Check a token, returning the client it belongs to
+func authenticate(token string) (authClient, error) {
+
+	// Look for a matching pre-shared token (comparing in constant time, so
+	// that tokens can't be guessed from the response time)
+	for known, client := range authTokens {
+		if subtle.ConstantTimeCompare([]byte(known), []byte(token)) == 1 {
+			return client, nil
+		}
+	}
+
+	// Otherwise, try the token as a JWT
+	if authJWTSecret != nil && strings.Count(token, ".") == 2 {
+		return verifyJWT(token)
+	}
+	return authClient{}, fmt.Errorf("invalid token")
+}
+
+// The claims read from a JSON Web Token
+type jwtClaims struct {
+	Subject   string `json:"sub"`
+	ExpiresAt int64  `json:"exp"`
+	NotBefore int64  `json:"nbf"`
+	Role      string `json:"role"`
+}
+
+// Verify a JSON Web Token (HS256 only), returning the client it names
+func verifyJWT(token string) (authClient, error) {
+	parts := strings.Split(token, ".")
+
+	// Check the algorithm in the header
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return authClient{}, fmt.Errorf("invalid token header")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if json.Unmarshal(headerJSON, &header) != nil || header.Alg != "HS256" {
+		return authClient{}, fmt.Errorf("unsupported token algorithm")
+	}
+
+	// Check the signature
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return authClient{}, fmt.Errorf("invalid token signature")
+	}
+	mac := hmac.New(sha256.New, authJWTSecret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return authClient{}, fmt.Errorf("invalid token signature")
+	}
+
+	// Check the claims
+	claimsJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return authClient{}, fmt.Errorf("invalid token claims")
+	}
+	var claims jwtClaims
+	if err := json.Unmarshal(claimsJSON, &claims); err != nil {
+		return authClient{}, fmt.Errorf("invalid token claims")
+	}
+	now := time.Now().Unix()
+	if claims.ExpiresAt != 0 && now >= claims.ExpiresAt {
+		return authClient{}, fmt.Errorf("token expired")
+	}
+	if claims.NotBefore != 0 && now < claims.NotBefore {
+		return authClient{}, fmt.Errorf("token not yet valid")
+	}
+	if claims.Subject == "" {
+		return authClient{}, fmt.Errorf("token has no subject")
+	}
+	role, err := parseRole(claims.Role)
+	if err != nil {
+		return authClient{}, err
+	}
+	return authClient{name: claims.Subject, role: role}, nil
+}
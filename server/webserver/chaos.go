@@ -0,0 +1,127 @@
+package webserver
+
+import (
+	"log/slog"
+	"math/rand"
+	"time"
+)
+
+/*
+Chaos mode injects the faults of flaky venue wifi into the connections of bots
+and trackers, so that teams can check that their bots (and the server's session
+resumption, see session_resume.go) cope with them before a competition. It is
+set up under "Chaos" in config.json, and is off unless some fault is:
+
+	"Chaos": {
+		"DelayMs": 80,         - delay each message by up to this long (each way)
+		"DropRate": 0.05,      - drop this fraction of the client's messages
+		"DuplicateRate": 0.02, - receive this fraction of them twice
+		"DisconnectRate": 0.01 - chance per second of dropping the connection
+	}
+
+Referees and spectators are never affected, so that the match itself can still
+be run. The faults happen where they would on the network: messages from the
+client are delayed, dropped, or duplicated before the server reads them (so a
+duplicated sequenced command is caught like any other, see command_seq.go),
+messages to the client are delayed before they are written, and a dropped
+connection is closed without a close frame. Each injected fault is logged at
+the debug level.
+*/
+
+// Faults to inject into the connections of bots and trackers
+type ChaosConfig struct {
+	DelayMs        float64 // Longest delay of each message, in milliseconds
+	DropRate       float64 // Fraction of the client's messages dropped
+	DuplicateRate  float64 // Fraction of the client's messages received twice
+	DisconnectRate float64 // Chance per second of dropping the connection
+}
+
+// The faults injected in chaos mode (all zero when it is off)
+var chaos ChaosConfig
+
+// Set the faults to inject in chaos mode
+func ConfigChaos(conf ChaosConfig) {
+	chaos = ChaosConfig{
+		DelayMs:        max(conf.DelayMs, 0),
+		DropRate:       min(max(conf.DropRate, 0), 1),
+		DuplicateRate:  min(max(conf.DuplicateRate, 0), 1),
+		DisconnectRate: min(max(conf.DisconnectRate, 0), 1),
+	}
+	if chaos != (ChaosConfig{}) {
+		slog.Warn("Chaos mode enabled", "delayMs", chaos.DelayMs,
+			"dropRate", chaos.DropRate, "duplicateRate", chaos.DuplicateRate,
+			"disconnectRate", chaos.DisconnectRate)
+	}
+}
+
+// Check whether chaos mode injects faults into a session's connection
+func (ws *webSession) chaotic() bool {
+	return chaos != (ChaosConfig{}) &&
+		ws.role != roleReferee && ws.role != roleSpectator
+}
+
+// Wait out a random delay for a message, in chaos mode
+func (ws *webSession) chaosDelay() {
+	if chaos.DelayMs == 0 || !ws.chaotic() {
+		return
+	}
+	time.Sleep(time.Duration(rand.Float64() * chaos.DelayMs *
+		float64(time.Millisecond)))
+}
+
+/*
+Read the next message from the client - in chaos mode, messages may be
+dropped, delayed, or read twice (only called from the read loop)
+*/
+func (ws *webSession) readMessage() ([]byte, error) {
+
+	// Read the duplicate of the last message again, if there is one
+	if msg := ws.chaosDup; msg != nil {
+		ws.chaosDup = nil
+		slog.Debug("Chaos: duplicated a message", "client", ws.client)
+		return msg, nil
+	}
+
+	for {
+		_, msg, err := ws.conn.ReadMessage()
+		if err != nil || !ws.chaotic() {
+			return msg, err
+		}
+		if rand.Float64() < chaos.DropRate {
+			slog.Debug("Chaos: dropped a message", "client", ws.client)
+			continue
+		}
+		ws.chaosDelay()
+		if rand.Float64() < chaos.DuplicateRate {
+			ws.chaosDup = msg
+		}
+		return msg, nil
+	}
+}
+
+/*
+Drop the connection at random, in chaos mode, until a channel is closed (once
+the session is over)
+*/
+func (ws *webSession) startChaos(done <-chan struct{}) {
+	if chaos.DisconnectRate == 0 || !ws.chaotic() {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if rand.Float64() < chaos.DisconnectRate {
+					slog.Debug("Chaos: dropped the connection",
+						"client", ws.client)
+					ws.conn.Close()
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+}
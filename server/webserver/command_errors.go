@@ -0,0 +1,100 @@
+package webserver
+
+import (
+	"log/slog"
+)
+
+/*
+Commands that pass the web server's checks can still be turned down by the
+game engine (e.g. a move into a wall, or while the game is paused - see
+game/errors.go). The engine reports each one, and the error is relayed to the
+pacbot.v1 sessions of the room that sent a command of the same type which the
+engine could have been applying, as a message of type 'e' (as for the web
+server's own errors):
+
+	wall collision: (5, 0)
+	game is not running
+
+Malformed commands (e.g. the wrong length, or a direction out of range) are
+turned down by the web server itself as they arrive, with the game's own
+checks (see game/command_decoder.go), so that the client hears about them
+right away (as an error message of the same type):
+
+	invalid command 'G': direction 7 out of range (0-4)
+
+A command is applied by the time two more states have been broadcast (see
+command_seq.go), so only sessions that sent one within that window hear about
+the error. Legacy sessions can't receive errors, so they aren't told.
+*/
+
+// Function to check byte commands against their formats (set by main)
+var commandValidator func([]byte) error = nil
+
+// Set the function to check byte commands against their formats
+func ConfigCommandValidator(validator func([]byte) error) {
+	commandValidator = validator
+}
+
+// Check that a byte command is well-formed, before sending it to a game engine
+func validateCommand(cmd []byte) error {
+	if commandValidator == nil {
+		return nil
+	}
+	return commandValidator(cmd)
+}
+
+/*
+Record that a command was sent to the game engine, so that any error it causes
+is relayed back to this session (only called from the read loop)
+*/
+func (ws *webSession) recordCommand(cmd []byte) {
+	ws.muCmdDue.Lock()
+	{
+		if ws.cmdDue == nil {
+			ws.cmdDue = make(map[byte]uint64)
+		}
+		ws.cmdDue[cmd[0]] = ws.broker.broadcasts.Load() + ackDelay
+	}
+	ws.muCmdDue.Unlock()
+}
+
+/*
+Check whether the session sent a command of a given type that the game engine
+may be applying, as of a given broadcast count
+*/
+func (ws *webSession) sentCommand(cmdType byte, broadcast uint64) bool {
+	ws.muCmdDue.Lock()
+	defer ws.muCmdDue.Unlock()
+	due, ok := ws.cmdDue[cmdType]
+	return ok && due >= broadcast
+}
+
+/*
+Relay an error from the game engine, for a command it couldn't carry out, to
+the sessions which may have sent it (registered with the game engine as its
+command error listener)
+*/
+func NotifyCommandError(room string, cmd []byte, err error) {
+	wb := getRoom(room)
+	if wb == nil || len(cmd) == 0 {
+		return
+	}
+	msg := tagMessage(msgError, []byte(err.Error()))
+	broadcast := wb.broadcasts.Load()
+	muOWS.RLock()
+	{
+		for ws := range openWebSessions {
+			if ws.broker != wb || !ws.taggedMessages() ||
+				!ws.sentCommand(cmd[0], broadcast) {
+				continue
+			}
+			select {
+			case ws.sendCh <- outgoing{msg: msg}:
+			default:
+				slog.Warn("A web-session send channel was full",
+					"client", getIP(ws.conn))
+			}
+		}
+	}
+	muOWS.RUnlock()
+}
@@ -0,0 +1,105 @@
+package webserver
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+/*
+Clients speaking pacbot.v1 can number their commands, to detect commands that
+were dropped or reordered on the way to the server. A sequenced command is
+sent as a message of type 'q', whose payload is a (big-endian) 4-byte sequence
+number followed by the command itself:
+
+	sequence number (4 bytes) - must increase with each command
+	command                   - as in a message of type 'c'
+
+Commands whose sequence number is not greater than the last accepted one are
+rejected with an error (they arrived late, or were sent twice). Once a command
+has been applied by the game engine, the server acknowledges it with a message
+of type 'a', holding the sequence number of the latest applied command, sent
+right before the first game state that reflects it. Commands that were
+rejected (e.g. by the client's role or rate limit) are never acknowledged, so
+a sequence number skipped by the acknowledgments marks a lost command.
+*/
+
+// Message types for sequenced commands and their acknowledgments
+const (
+	msgSeqCommand byte = 'q' // Sequenced game command (client -> server)
+	msgAck        byte = 'a' // Latest applied sequence number (server -> client)
+)
+
+// The length of a sequence number
+const seqNumLen = 4
+
+/*
+The number of broadcasts after which a command is sure to have been applied -
+a command sent to the game engine is applied by the time two more states have
+been broadcast (counted by the room's web broker), since the engine reads its
+commands right after each broadcast
+*/
+const ackDelay = 2
+
+// A sequenced command sent to the game engine, waiting to be acknowledged
+type pendingAck struct {
+	seq uint32 // Sequence number of the command
+	due uint64 // Broadcast count at which the command has been applied
+}
+
+// Read the sequence number off a sequenced command
+func splitSeqCommand(payload []byte) (uint32, []byte, error) {
+	if len(payload) < seqNumLen {
+		return 0, nil, fmt.Errorf("sequenced command too short")
+	}
+	return binary.BigEndian.Uint32(payload), payload[seqNumLen:], nil
+}
+
+// Check that a sequence number is newer than the last accepted one
+func (ws *webSession) checkSeq(seq uint32) error {
+	if ws.seqStarted && seq <= ws.lastSeq {
+		return fmt.Errorf("stale sequence number %d (last accepted %d)",
+			seq, ws.lastSeq)
+	}
+	return nil
+}
+
+/*
+Record that a sequenced command was sent to the game engine, so that it is
+acknowledged once applied (only called from the read loop)
+*/
+func (ws *webSession) recordSeq(seq uint32) {
+	ws.seqStarted = true
+	ws.lastSeq = seq
+	ws.muAck.Lock()
+	{
+		ws.pendingAcks = append(ws.pendingAcks, pendingAck{
+			seq: seq,
+			due: ws.broker.broadcasts.Load() + ackDelay,
+		})
+	}
+	ws.muAck.Unlock()
+}
+
+/*
+Get the acknowledgment to send before a given broadcast (as a tagged message),
+or nil if no new commands have been applied since the last one
+*/
+func (ws *webSession) dueAck(broadcast uint64) []byte {
+	var seq uint32
+	acked := 0
+	ws.muAck.Lock()
+	{
+		// The pending commands are in order, so acknowledge the latest that
+		// has been applied
+		for acked < len(ws.pendingAcks) && ws.pendingAcks[acked].due <= broadcast {
+			seq = ws.pendingAcks[acked].seq
+			acked++
+		}
+		ws.pendingAcks = ws.pendingAcks[acked:]
+	}
+	ws.muAck.Unlock()
+	if acked == 0 {
+		return nil
+	}
+	return tagMessage(msgAck, binary.BigEndian.AppendUint32(nil, seq))
+}
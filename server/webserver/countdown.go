@@ -0,0 +1,84 @@
+package webserver
+
+import (
+	"encoding/json"
+	"log/slog"
+)
+
+/*
+When a game counts down before it starts (see CountdownSeconds), the game
+engine announces each second as a "countdown" event (game/game_lifecycle.go),
+right as the countdown reaches it. The room's pacbot.v1 sessions are told
+about each one at once, with a JSON message of type 'k' (queued ahead of the
+next state):
+
+	{"count": 3, "tick": 0}
+	{"count": 2, "tick": 0}
+	{"count": 1, "tick": 0}
+	{"count": 0, "tick": 0}  - go: the ghosts are released, and moves count
+
+so that drive teams can start their robots on the same frame as the engine.
+The countdown events also go to any webhooks that subscribe to them.
+*/
+
+// Message type for the countdown to the start of a game (server -> client)
+const msgCountdown byte = 'k'
+
+// A second of the countdown, as announced
+type countdownInfo struct {
+	Count uint16 `json:"count"` // Seconds left (0 = go)
+	Tick  uint16 `json:"tick"`
+}
+
+/*
+Pass on an event from the game engine (as its event listener) - countdown
+events, changes to the gates, and jumps through teleporters are announced to
+the room's clients, the end of a match's game is recorded on the scoreboard,
+the room's dashboard keeps the recent events, and every event is sent to the
+webhooks that subscribe to it (webhooks.go), without blocking
+*/
+func NotifyGameEvent(details map[string]any) {
+	event, _ := details["event"].(string)
+	room, _ := details["room"].(string)
+	if wb := getRoom(room); wb != nil {
+		wb.recordDashboardEvent(details) // (dashboard.go)
+		switch event {
+		case "countdown":
+			count, _ := details["count"].(uint16)
+			tick, _ := details["tick"].(uint16)
+			wb.announceCountdown(countdownInfo{Count: count, Tick: tick})
+		case "game_over":
+			wb.recordResult(details) // (scoreboard.go)
+		case "gate":
+			wb.announceGate(gateInfoOf(details)) // (gates.go)
+		case "teleport":
+			wb.announceTeleport(teleportInfoOf(details)) // (teleporters.go)
+		}
+	}
+	NotifyWebhooks(details)
+}
+
+/*
+Announce a second of the countdown to the room's pacbot.v1 sessions, all
+within one pass so that they hear about it at the same time (skipping sessions
+that aren't keeping up)
+*/
+func (wb *WebBroker) announceCountdown(info countdownInfo) {
+	payload, _ := json.Marshal(info)
+	msg := tagMessage(msgCountdown, payload)
+	muOWS.RLock()
+	{
+		for ws := range openWebSessions {
+			if ws.broker != wb || !ws.taggedMessages() {
+				continue
+			}
+			select {
+			case ws.sendCh <- outgoing{msg: msg}:
+			default:
+				slog.Warn("A web-session send channel was full",
+					"client", getIP(ws.conn))
+			}
+		}
+	}
+	muOWS.RUnlock()
+}
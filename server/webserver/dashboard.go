@@ -0,0 +1,328 @@
+package webserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+/*
+The admin dashboard API gathers everything a referee's web dashboard shows
+about a room into one document, and takes the dashboard's controls (referees
+only):
+
+	GET  /admin/dashboard - the room's dashboard, as JSON:
+	                        {"room", "game": {"ticks", "score", "level",
+	                        "lives", "mode", "lifecycle", "maze",
+	                        "matchLeft"}, "match", "clients", "events"}
+	POST /admin/kick      - disconnect a client in the room, given the JSON
+	                        body {"id": <client ID>} (or {"client": "<name>"}
+	                        to disconnect every session of a named client)
+
+The clients are the room's websocket clients, as in /admin/clients, along
+with the time and type of the last message each one sent. The events are the
+room's most recent game events (leaving out pellets and telemetry, which
+would crowd out the rest), oldest first, each with the time it arrived.
+
+Opening /admin/dashboard as a websocket instead streams the dashboard, as a
+JSON text message every second (and straight after each control), and takes
+the controls as JSON text messages: {"action": "pause"}, {"action": "play"},
+{"action": "reset"}, or {"action": "kick", "id": <client ID>}. A control
+that fails is answered with {"error": "..."}. Either way, the room is the
+default room, or the one given by "?room=<name>" (rooms.go), and browsers
+can pass their token as "?token=<token>" (auth.go).
+*/
+
+// The number of recent events kept for each room's dashboard
+const dashboardEvents = 50
+
+// The time between dashboards pushed to a dashboard websocket
+const dashboardPeriod = time.Second
+
+// The events left out of the dashboard, since they are too frequent
+var dashboardSkippedEvents = map[string]bool{
+	"pellet":    true,
+	"telemetry": true,
+}
+
+// Counter for the IDs that tell websocket clients apart (from 1)
+var lastClientID atomic.Uint64
+
+// Upgrader for dashboard websockets, which speak JSON rather than a protocol
+var dashboardUpgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool {
+		return true // Allow dashboards served from anywhere
+	},
+}
+
+// The recent events of a room, for its dashboard
+type dashboard struct {
+	events   []map[string]any
+	muEvents sync.Mutex
+}
+
+// The game status shown on a dashboard (picked out of the latest state)
+type dashboardGame struct {
+	Ticks     uint16 `json:"ticks"`
+	Score     uint16 `json:"score"`
+	Level     uint8  `json:"level"`
+	Lives     uint8  `json:"lives"`
+	Mode      string `json:"mode"`
+	Lifecycle string `json:"lifecycle,omitempty"`
+	Maze      string `json:"maze,omitempty"`
+	MatchLeft uint16 `json:"matchLeft,omitempty"`
+}
+
+// A room's dashboard
+type dashboardInfo struct {
+	Room    string           `json:"room"`
+	Game    *dashboardGame   `json:"game"`  // nil if there is no state yet
+	Match   *matchInfo       `json:"match"` // nil if there is none (lobby.go)
+	Clients []webClientInfo  `json:"clients"`
+	Events  []map[string]any `json:"events"`
+}
+
+// A control sent over a dashboard websocket
+type dashboardControl struct {
+	Action string `json:"action"`
+	ID     uint64 `json:"id"`
+}
+
+// A request to disconnect a client (POST /admin/kick)
+type kickRequest struct {
+	ID     uint64 `json:"id"`
+	Client string `json:"client"`
+}
+
+// Keep a game event for the room's dashboard, dropping the oldest if full
+func (wb *WebBroker) recordDashboardEvent(details map[string]any) {
+	if event, _ := details["event"].(string); dashboardSkippedEvents[event] {
+		return
+	}
+	entry := make(map[string]any, len(details)+1)
+	for key, value := range details {
+		entry[key] = value
+	}
+	entry["time"] = time.Now().UTC()
+	wb.dashboard.muEvents.Lock()
+	{
+		if len(wb.dashboard.events) == dashboardEvents {
+			wb.dashboard.events = wb.dashboard.events[1:]
+		}
+		wb.dashboard.events = append(wb.dashboard.events, entry)
+	}
+	wb.dashboard.muEvents.Unlock()
+}
+
+// Gather the room's dashboard
+func (wb *WebBroker) dashboardInfo() dashboardInfo {
+	info := dashboardInfo{
+		Room:    wb.room,
+		Match:   wb.getMatch(),
+		Clients: []webClientInfo{},
+	}
+
+	// Pick the game status out of the latest state, if there is one
+	state := wb.latestState()
+	if state != nil && stateEncoders[formatJSON] != nil {
+		if out, err := stateEncoders[formatJSON](state); err == nil {
+			info.Game = &dashboardGame{}
+			json.Unmarshal(out, info.Game)
+		}
+	}
+
+	// Only the room's own clients are shown
+	for _, client := range webClientsInfo() {
+		if client.Room == wb.room {
+			info.Clients = append(info.Clients, client)
+		}
+	}
+
+	wb.dashboard.muEvents.Lock()
+	{
+		info.Events = append([]map[string]any{}, wb.dashboard.events...)
+	}
+	wb.dashboard.muEvents.Unlock()
+	return info
+}
+
+/*
+Disconnect the room's clients that match a request (by ID, or else by name) -
+returns the number of sessions disconnected
+*/
+func (wb *WebBroker) kick(req kickRequest) int {
+	kicked := 0
+
+	/*
+		Close the connections while the sessions are still registered, so
+		that none of them can close its send channel in the meantime (each
+		unregisters itself as it ends)
+	*/
+	muOWS.RLock()
+	{
+		for ws := range openWebSessions {
+			if ws.broker != wb {
+				continue
+			}
+			if (req.ID != 0 && ws.id == req.ID) ||
+				(req.ID == 0 && req.Client != "" && ws.client == req.Client) {
+				slog.Info("Client kicked", "ip", getIP(ws.conn), "id", ws.id,
+					"client", ws.client, "room", wb.room)
+				ws.quit()
+				kicked++
+			}
+		}
+	}
+	muOWS.RUnlock()
+	return kicked
+}
+
+// Apply a control sent over a dashboard websocket
+func (wb *WebBroker) applyDashboardControl(ctrl dashboardControl) error {
+	var cmd []byte
+	switch ctrl.Action {
+	case "pause":
+		cmd = []byte{'p'}
+	case "play":
+		cmd = []byte{'P'}
+	case "reset":
+		cmd = []byte{'r'}
+	case "kick":
+		if ctrl.ID == 0 || wb.kick(kickRequest{ID: ctrl.ID}) == 0 {
+			return fmt.Errorf("no client with ID %d", ctrl.ID)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown action \"%s\"", ctrl.Action)
+	}
+	if !wb.sendCommand(cmd) {
+		return fmt.Errorf("game engine not running")
+	}
+	return nil
+}
+
+// Serve a room's dashboard, or stream it over a websocket (/admin/dashboard)
+func DashboardHandler(w http.ResponseWriter, r *http.Request) {
+
+	// Only reads (or websocket upgrades) are allowed
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+
+	// Only referees can see the dashboard
+	ip, client, wb, ok := authorizeLobbyRequest(w, r)
+	if !ok {
+		return
+	}
+	if websocket.IsWebSocketUpgrade(r) {
+		wb.streamDashboard(w, r, ip, client)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(wb.dashboardInfo())
+}
+
+// Stream the room's dashboard over a websocket, taking controls from it
+func (wb *WebBroker) streamDashboard(w http.ResponseWriter, r *http.Request,
+	ip string, client string) {
+	conn, err := dashboardUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // The upgrader already responded with an error
+	}
+	defer conn.Close()
+	slog.Info("Dashboard connected", "ip", ip, "client", client,
+		"room", wb.room)
+	defer slog.Info("Dashboard disconnected", "ip", ip)
+
+	// Only one write can happen on the connection at a time
+	var muWrite sync.Mutex
+	write := func(msg any) error {
+		muWrite.Lock()
+		defer muWrite.Unlock()
+		return conn.WriteJSON(msg)
+	}
+
+	// Read the controls, asking for a fresh dashboard after each one
+	refreshCh := make(chan struct{}, 1)
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var ctrl dashboardControl
+			if err = json.Unmarshal(msg, &ctrl); err == nil {
+				err = wb.applyDashboardControl(ctrl)
+			}
+			if err != nil {
+				write(map[string]string{"error": err.Error()})
+				continue
+			}
+			slog.Info("Dashboard control", "ip", ip, "client", client,
+				"room", wb.room, "action", ctrl.Action)
+			select {
+			case refreshCh <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	// Push the dashboard every so often, until the dashboard disconnects
+	ticker := time.NewTicker(dashboardPeriod)
+	defer ticker.Stop()
+	for {
+		if err := write(wb.dashboardInfo()); err != nil {
+			return
+		}
+		select {
+		case <-ticker.C:
+		case <-refreshCh:
+		case <-doneCh:
+			return
+		}
+	}
+}
+
+// Disconnect a client in a room (POST /admin/kick)
+func KickHandler(w http.ResponseWriter, r *http.Request) {
+
+	// Only commands are allowed
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+
+	// Only referees can kick clients
+	ip, client, wb, ok := authorizeLobbyRequest(w, r)
+	if !ok {
+		return
+	}
+	var req kickRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid request: "+err.Error())
+		return
+	}
+	if req.ID == 0 && req.Client == "" {
+		writeJSONError(w, http.StatusBadRequest, "id or client is required")
+		return
+	}
+	kicked := wb.kick(req)
+	if kicked == 0 {
+		writeJSONError(w, http.StatusNotFound, "no such client in this room")
+		return
+	}
+	slog.Info("Admin kick", "ip", ip, "client", client, "room", wb.room,
+		"kicked", kicked)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"kicked": kicked})
+}
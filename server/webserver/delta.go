@@ -0,0 +1,99 @@
+package webserver
+
+/*
+Delta updates let clients skip most of each broadcast: since the serialized
+game state has a fixed layout (apart from its variable-length extensions),
+most of its bytes - especially the pellet bitmap - are the same from one frame
+to the next. Clients connecting with "?format=delta" (which requires the
+pacbot.v1 protocol, to tell the messages apart) receive:
+
+	- keyframes, as messages of type 's' - the full serialized state, sent
+	  when the client first connects, every so often, whenever the layout of
+	  the state changes, and whenever the client missed an update
+	- deltas, as messages of type 'd' - only the bytes that changed since the
+	  previous update, in the following (big-endian) format:
+
+		run count (2 bytes)
+		for each run:
+			offset (2 bytes) - index of the first changed byte
+			length (1 byte)  - number of bytes in the run
+			bytes            - the new values of those bytes
+
+To reconstruct the state, clients copy each run into their copy of the
+previous state, at the given offset
+*/
+
+// Message type for delta updates
+const msgDelta byte = 'd'
+
+// The number of broadcasts between keyframes sent to delta clients
+const deltaKeyframePeriod = 48 // 2 seconds at 24 fps
+
+/*
+The largest gap of unchanged bytes that is merged into a run (since starting a
+new run costs 3 bytes, it is cheaper to resend a few unchanged bytes)
+*/
+const deltaMaxGap = 3
+
+/*
+Encode the changes from one serialized state to the next as a delta - returns
+nil if the layouts differ, so that a keyframe has to be sent instead
+*/
+func encodeDelta(prev []byte, curr []byte) []byte {
+
+	// Deltas can only be taken between states of the same length
+	if prev == nil || len(prev) != len(curr) || len(curr) > 0xffff {
+		return nil
+	}
+
+	// Leave space for the run count, and fill it in once we are done
+	delta := make([]byte, 2, 64)
+	count := 0
+
+	// Find each run of changed bytes
+	for idx := 0; idx < len(curr); {
+
+		// Skip past unchanged bytes
+		if prev[idx] == curr[idx] {
+			idx++
+			continue
+		}
+
+		// Extend the run until there is a long enough gap of unchanged bytes
+		// (or the run is as long as it can be)
+		start, end := idx, idx+1
+		for end < len(curr) && end-start < 0xff {
+			if prev[end] != curr[end] {
+				end++
+				continue
+			}
+			gap := end
+			for gap < len(curr) && gap-end < deltaMaxGap && prev[gap] == curr[gap] {
+				gap++
+			}
+			if gap == len(curr) || gap-end >= deltaMaxGap ||
+				gap-start >= 0xff {
+				break
+			}
+			end = gap
+		}
+
+		// Write the run
+		delta = append(delta, byte(start>>8), byte(start), byte(end-start))
+		delta = append(delta, curr[start:end]...)
+		count++
+		idx = end
+	}
+
+	// Fill in the run count
+	delta[0], delta[1] = byte(count>>8), byte(count)
+	return delta
+}
+
+/*
+Tag a message with its type, for sessions whose messages can be of different
+types (the send loop strips the tag off again)
+*/
+func tagMessage(msgType byte, msg []byte) []byte {
+	return append([]byte{msgType}, msg...)
+}
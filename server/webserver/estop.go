@@ -0,0 +1,157 @@
+package webserver
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+/*
+An emergency stop (E-stop) halts every robot in a room at once, e.g. when a
+person walks into the arena. Referees trigger it through the REST API, or with
+a message of type 'X' over pacbot.v1 (holding the reason as text):
+
+	POST /admin/estop - with an optional JSON body: {"reason": "<reason>"}
+
+The stop is broadcast before the room's game is paused, on every channel that
+robots listen to, as the same JSON document ({"room": ..., "reason": ...,
+"by": <referee>, "time": ...}):
+
+	websocket - a message of type 'X' to each pacbot.v1 session, written
+	            straight to the connection (ahead of any queued states)
+	UDP       - a packet with the reserved sequence number 0xffffffff instead
+	            of a state, sent several times in a row (as packets may be lost)
+	SSE       - an "estop" event
+
+Legacy websocket and gRPC clients only see the game pause in the next state.
+The game stays paused until a referee resumes it.
+*/
+
+// Message type for emergency stops (client -> server, and server -> client)
+const msgEStop byte = 'X'
+
+// The sequence number of UDP packets holding an emergency stop
+const udpEStopSeq uint32 = 0xffffffff
+
+// The number of times each emergency stop is sent over UDP
+const udpEStopRepeats = 3
+
+// An emergency stop, as broadcast
+type estopInfo struct {
+	Room   string    `json:"room"`
+	Reason string    `json:"reason,omitempty"`
+	By     string    `json:"by,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+// A request for an emergency stop (POST /admin/estop)
+type estopRequest struct {
+	Reason string `json:"reason"`
+}
+
+/*
+Subscribe to the room's emergency stops - returns a channel of stops (as
+JSON), and a function to cancel the subscription (which must be called when
+done)
+*/
+func (wb *WebBroker) subscribeStop() (<-chan []byte, func()) {
+	ch := make(chan []byte, 1)
+	wb.muSubs.Lock()
+	{
+		wb.stopSubscribers[ch] = struct{}{}
+	}
+	wb.muSubs.Unlock()
+	return ch, func() {
+		wb.muSubs.Lock()
+		{
+			delete(wb.stopSubscribers, ch)
+		}
+		wb.muSubs.Unlock()
+	}
+}
+
+// Stop every robot in the room, then pause its game
+func (wb *WebBroker) emergencyStop(client string, reason string) {
+	msg, _ := json.Marshal(estopInfo{Room: wb.room, Reason: reason,
+		By: client, Time: time.Now()})
+	slog.Warn("Emergency stop", "room", wb.room, "client", client,
+		"reason", reason)
+
+	// Write the stop straight to each pacbot.v1 session, without waiting for
+	// any of them
+	muOWS.RLock()
+	{
+		for ws := range openWebSessions {
+			if ws.broker == wb && ws.taggedMessages() {
+				go ws.writeMessage(msgEStop, msg)
+			}
+		}
+	}
+	muOWS.RUnlock()
+
+	// Pass it on to the other channels
+	wb.muSubs.RLock()
+	{
+		for ch := range wb.stopSubscribers {
+			select {
+			case ch <- msg:
+			default:
+			}
+		}
+	}
+	wb.muSubs.RUnlock()
+
+	// Pause the game, and let the webhooks know
+	if !wb.sendCommand([]byte{'p'}) {
+		slog.Error("Emergency stop couldn't pause the game (game engine "+
+			"not running)", "room", wb.room)
+	}
+	details := map[string]any{"event": "estop", "reason": reason}
+	if wb.room != "" {
+		details["room"] = wb.room
+	}
+	NotifyWebhooks(details)
+}
+
+// Trigger an emergency stop from a pacbot.v1 session (referees only)
+func (ws *webSession) requestEStop(reason []byte) {
+	if ws.role != roleReferee {
+		slog.Warn("Unauthorized emergency stop from client",
+			"ip", getIP(ws.conn), "client", ws.client)
+		ws.writeMessage(msgError, []byte("referees only"))
+		return
+	}
+	ws.broker.emergencyStop(ws.client, string(reason))
+}
+
+// Trigger an emergency stop in a room (POST /admin/estop)
+func EStopHandler(w http.ResponseWriter, r *http.Request) {
+
+	// Only commands are allowed
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+
+	// Only referees can stop the robots
+	_, client, wb, ok := authorizeLobbyRequest(w, r)
+	if !ok {
+		return
+	}
+
+	// Read the reason, if there is one
+	var req estopRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil &&
+		!errors.Is(err, io.EOF) {
+		writeJSONError(w, http.StatusBadRequest,
+			"invalid emergency stop: "+err.Error())
+		return
+	}
+
+	wb.emergencyStop(client, req.Reason)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
@@ -0,0 +1,76 @@
+package webserver
+
+import (
+	"log/slog"
+	"net/http"
+	"path"
+)
+
+/*
+Stream overlays and match reports can fetch a snapshot of the board as an
+image, without running the visualizer:
+
+	GET /frame.png - the latest game state, rendered as a PNG image
+	GET /frame.svg - the latest game state, rendered as an SVG image
+
+Like /state, each request applies to the default room, or the one given by
+"?room=<name>" (rooms.go), and needs no token. The images are rendered by the
+game engine's renderers (see game/render.go)
+*/
+
+/*
+Functions to render the binary game state as an image, by file extension (set
+by the main package, nil if a format is unavailable)
+*/
+var frameRenderers = map[string]func([]byte) ([]byte, error){}
+
+// Content types of the frame formats, by file extension
+var frameContentTypes = map[string]string{
+	".png": "image/png",
+	".svg": "image/svg+xml",
+}
+
+// Set the functions to render the binary game state as PNG and SVG images
+func ConfigFrameRenderers(pngRenderer func([]byte) ([]byte, error),
+	svgRenderer func([]byte) ([]byte, error)) {
+	frameRenderers[".png"] = pngRenderer
+	frameRenderers[".svg"] = svgRenderer
+}
+
+// Serve the latest game state as an image (GET /frame.png or /frame.svg)
+func FrameHandler(w http.ResponseWriter, r *http.Request) {
+
+	// Only reads are allowed
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	wb := requestRoomOrError(w, r)
+	if wb == nil {
+		return
+	}
+
+	// The format is picked by the extension
+	ext := path.Ext(r.URL.Path)
+	render := frameRenderers[ext]
+	if render == nil {
+		writeJSONError(w, http.StatusNotImplemented, "format is unavailable")
+		return
+	}
+	state := wb.latestState()
+	if state == nil {
+		writeJSONError(w, http.StatusServiceUnavailable, "no game state yet")
+		return
+	}
+
+	// Render the state
+	out, err := render(state)
+	if err != nil {
+		slog.Error("Frame rendering error", "format", ext, "err", err)
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", frameContentTypes[ext])
+	w.Write(out)
+}
@@ -0,0 +1,67 @@
+package webserver
+
+import (
+	"encoding/json"
+	"log/slog"
+)
+
+/*
+When a gate of the maze opens or closes (see game/gates.go), the game engine
+logs a "gate" event, and the room's pacbot.v1 sessions are told about the
+change at once, with a JSON message of type 'W' (queued ahead of the next
+state):
+
+	{"gate": 0, "row": 1, "col": 13, "open": true, "tick": 1200}
+
+so that robots can re-plan around the wall without diffing each state. The
+state of every gate is also sent with each state (after the extra ghosts), for
+clients that join mid-game, or that use the legacy protocol.
+*/
+
+// Message type for a change to a gate of the maze (server -> client)
+const msgGate byte = 'W'
+
+// A change to a gate, as announced
+type gateInfo struct {
+	Gate uint8  `json:"gate"`
+	Row  int8   `json:"row"`
+	Col  int8   `json:"col"`
+	Open bool   `json:"open"`
+	Tick uint16 `json:"tick"`
+}
+
+// Get the change to a gate from its event's details
+func gateInfoOf(details map[string]any) gateInfo {
+	var info gateInfo
+	info.Gate, _ = details["gate"].(uint8)
+	info.Row, _ = details["row"].(int8)
+	info.Col, _ = details["col"].(int8)
+	info.Open, _ = details["open"].(bool)
+	info.Tick, _ = details["tick"].(uint16)
+	return info
+}
+
+/*
+Announce a change to a gate to the room's pacbot.v1 sessions, all within one
+pass so that they hear about it at the same time (skipping sessions that
+aren't keeping up)
+*/
+func (wb *WebBroker) announceGate(info gateInfo) {
+	payload, _ := json.Marshal(info)
+	msg := tagMessage(msgGate, payload)
+	muOWS.RLock()
+	{
+		for ws := range openWebSessions {
+			if ws.broker != wb || !ws.taggedMessages() {
+				continue
+			}
+			select {
+			case ws.sendCh <- outgoing{msg: msg}:
+			default:
+				slog.Warn("A web-session send channel was full",
+					"client", getIP(ws.conn))
+			}
+		}
+	}
+	muOWS.RUnlock()
+}
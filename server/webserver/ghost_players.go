@@ -0,0 +1,131 @@
+package webserver
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+)
+
+/*
+In PvP mode (HumanGhosts in config.json), clients can each play one of the
+ghosts against Pacman: a bot (or referee) claims a ghost by connecting with
+"?ghost=<color>" (e.g. "?ghost=red"), and its moves ('w', 'a', 's', 'd')
+then steer its ghost instead of Pacman. The moves are passed on to the game
+engine as 'G' commands (see game/ghost_control.go), which turn the ghost at
+its next move where that move is legal - the AI still moves the ghosts that no
+one has claimed, and any ghost whose steered move isn't allowed. Each ghost can
+only be claimed by one client at a time (the connection is refused otherwise),
+and it goes back to the AI when its client disconnects. The ghost each client
+plays is listed in /admin/clients.
+*/
+
+// Marks a session that doesn't play a ghost
+const noGhost = -1
+
+// Steering that hands a ghost back to the AI (the game's "none" direction)
+const ghostSteerNone byte = 4
+
+// Whether clients can claim the ghosts
+var humanGhosts bool = false
+
+// Set whether clients can claim the ghosts (PvP mode)
+func ConfigHumanGhosts(enabled bool) {
+	humanGhosts = enabled
+}
+
+// The clients playing the room's ghosts, by ghost color
+type ghostPlayers struct {
+	sessions [len(ghostColorNames)]*webSession
+	mu       sync.Mutex
+}
+
+// Directions steered by each of the moves (in the game's direction order)
+var ghostMoveDirs = map[byte]byte{'w': 0, 'a': 1, 's': 2, 'd': 3}
+
+/*
+Find the ghost a client asked to play (noGhost if none), along with the HTTP
+status to refuse the connection with if it can't play it
+*/
+func (wb *WebBroker) requestGhost(r *http.Request, role clientRole) (int,
+	int, error) {
+	name := r.URL.Query().Get("ghost")
+	if name == "" {
+		return noGhost, http.StatusOK, nil
+	}
+	if !humanGhosts {
+		return noGhost, http.StatusForbidden,
+			fmt.Errorf("human ghosts aren't enabled")
+	}
+	if role != roleBot && role != roleReferee {
+		return noGhost, http.StatusForbidden,
+			fmt.Errorf("only bots and referees can play a ghost")
+	}
+	for color, ghostName := range ghostColorNames {
+		if ghostName != name {
+			continue
+		}
+		wb.ghostPlayers.mu.Lock()
+		defer wb.ghostPlayers.mu.Unlock()
+		if wb.ghostPlayers.sessions[color] != nil {
+			return noGhost, http.StatusConflict,
+				fmt.Errorf("the %s ghost is already claimed", name)
+		}
+		return color, http.StatusOK, nil
+	}
+	return noGhost, http.StatusBadRequest, fmt.Errorf("unknown ghost %q", name)
+}
+
+// Claim a ghost for a session - returns false if another client claimed it
+func (ws *webSession) claimGhost(color int) bool {
+	players := &ws.broker.ghostPlayers
+	players.mu.Lock()
+	defer players.mu.Unlock()
+	if players.sessions[color] != nil {
+		return false
+	}
+	players.sessions[color] = ws
+	ws.ghost = color
+	slog.Info("Client claimed a ghost", "ip", getIP(ws.conn),
+		"client", ws.client, "ghost", ghostColorNames[color])
+	return true
+}
+
+// Release the session's ghost (if it plays one), handing it back to the AI
+func (ws *webSession) releaseGhost() {
+	if ws.ghost == noGhost {
+		return
+	}
+	players := &ws.broker.ghostPlayers
+	players.mu.Lock()
+	players.sessions[ws.ghost] = nil
+	players.mu.Unlock()
+	ws.broker.sendCommand([]byte{'G', byte(ws.ghost), ghostSteerNone})
+	slog.Info("Client released a ghost", "ip", getIP(ws.conn),
+		"client", ws.client, "ghost", ghostColorNames[ws.ghost])
+}
+
+/*
+Turn a move from a ghost's player into a command steering its ghost (other
+commands are passed on as they are)
+*/
+func (ws *webSession) ghostCommand(cmd []byte) ([]byte, error) {
+	if ws.ghost == noGhost {
+		return cmd, nil
+	}
+	if dir, ok := ghostMoveDirs[cmd[0]]; ok {
+		return []byte{'G', byte(ws.ghost), dir}, nil
+	}
+	if cmd[0] == 'x' {
+		return nil, fmt.Errorf("ghost players can only steer their ghost")
+	}
+	return cmd, nil
+}
+
+// Get the name of the ghost a session plays ("" if none)
+func (ws *webSession) ghostName() string {
+	if ws.ghost == noGhost {
+		return ""
+	}
+	return ghostColorNames[ws.ghost]
+}
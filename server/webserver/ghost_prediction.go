@@ -0,0 +1,141 @@
+package webserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+/*
+Bots can ask the server where the ghosts will be over the next few steps
+(update periods), predicted by playing the ghosts' own logic forward (see
+game/ghost_prediction.go), instead of re-implementing the ghost AI. Clients
+speaking pacbot.v1 send a prediction query as a message of type 'g', holding
+the number of steps to predict (1 byte, up to 64), and the server answers with
+a message of type 'G':
+
+	steps (1 byte), ghosts (1 byte),
+	then for each step, for each ghost (red, pink, cyan, orange, then any
+	extra ghosts the maze has): row (1 byte), column (1 byte)
+
+or with an error message. Ghosts out of play are at row and column 32 (63 on
+mazes of more than 32 rows or columns). The prediction assumes that Pacman
+stays where it is and that the mode doesn't change. Prediction queries count
+towards the command rate limit. The same query is available over the REST API:
+
+	GET /predict?steps=<steps>
+
+which answers with {"steps": <steps>, "ghosts": {"red": [[row, col], ...],
+...}}
+*/
+
+// Message types for prediction queries and their answers
+const (
+	msgPredictQuery byte = 'g' // Ghost prediction query (client -> server)
+	msgPrediction   byte = 'G' // Predicted ghost cells (server -> client)
+)
+
+// Names of the ghosts, in the order of their colors (for the REST API)
+var ghostColorNames = [...]string{"red", "pink", "cyan", "orange",
+	"purple", "green", "blue", "white"}
+
+/*
+A function predicting the cells of the ghosts (in the order of their colors)
+after each of the next few steps of a room's game - set by the main package
+for each room, as the room's game engine runs its game
+*/
+type GhostPredictor func(steps int) ([][][2]int8, error)
+
+// Set the function to predict the ghosts of a room's game with
+func (wb *WebBroker) ConfigGhostPredictor(predictor GhostPredictor) {
+	wb.predictor.Store(&predictor)
+}
+
+// Predict the cells of the ghosts after each of the next few steps
+func (wb *WebBroker) predictGhosts(steps int) ([][][2]int8, error) {
+	predictor := wb.predictor.Load()
+	if predictor == nil {
+		return nil, fmt.Errorf("ghost predictions are unavailable")
+	}
+	return (*predictor)(steps)
+}
+
+// Answer a prediction query from a web session (only called from the read loop)
+func (ws *webSession) answerPredictQuery(query []byte) {
+
+	// Check the query
+	if len(query) != 1 {
+		ws.writeMessage(msgError, []byte("prediction query must be 1 byte long"))
+		return
+	}
+
+	// Prediction queries count as commands, for the rate limit (rate_limit.go)
+	if !ws.limitCommand() {
+		return
+	}
+
+	// Predict the ghosts, and answer with their cells after each step
+	cells, err := ws.broker.predictGhosts(int(query[0]))
+	if err != nil {
+		ws.writeMessage(msgError, []byte(err.Error()))
+		return
+	}
+	numGhosts := 0
+	if len(cells) > 0 {
+		numGhosts = len(cells[0])
+	}
+	payload := make([]byte, 0, 2+2*len(cells)*numGhosts)
+	payload = append(payload, byte(len(cells)), byte(numGhosts))
+	for _, step := range cells {
+		for _, cell := range step {
+			payload = append(payload, byte(cell[0]), byte(cell[1]))
+		}
+	}
+	ws.writeMessage(msgPrediction, payload)
+}
+
+// Predict the ghosts of a room's game (GET /predict)
+func PredictHandler(w http.ResponseWriter, r *http.Request) {
+
+	// Only reads are allowed
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+
+	wb := requestRoomOrError(w, r)
+	if wb == nil {
+		return
+	}
+
+	// Read the number of steps from the query
+	steps, err := strconv.Atoi(r.URL.Query().Get("steps"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid number of steps")
+		return
+	}
+
+	// Predict the ghosts, listing the cells of each ghost in turn
+	cells, err := wb.predictGhosts(steps)
+	if err != nil {
+		writeJSONError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	ghosts := make(map[string][][2]int8)
+	for _, step := range cells {
+		for color, cell := range step {
+			if color < len(ghostColorNames) {
+				name := ghostColorNames[color]
+				ghosts[name] = append(ghosts[name], cell)
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"steps":  len(cells),
+		"ghosts": ghosts,
+	})
+}
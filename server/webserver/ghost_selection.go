@@ -0,0 +1,83 @@
+package webserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+)
+
+/*
+For practice, referees choose which ghosts are in play (see
+game/ghost_selection.go) through the REST API:
+
+	POST /admin/ghosts - with a JSON body listing the ghosts to keep in play,
+	    e.g. {"ghosts": ["red", "cyan"]}, or {"ghosts": []} for no ghosts
+
+which restarts the room's game to apply it (the selection holds for every new
+game, until it is changed again). Over a websocket, referees send the same
+selection as the command 'g' followed by the set of ghosts (1 byte, a bit per
+color, with red as the lowest bit).
+*/
+
+// A selection of the ghosts in play (POST /admin/ghosts)
+type ghostSelectRequest struct {
+	Ghosts *[]string `json:"ghosts"`
+}
+
+// Convert a selection of the ghosts in play into a command for the game engine
+func (req *ghostSelectRequest) command() ([]byte, error) {
+	if req.Ghosts == nil {
+		return nil, fmt.Errorf("list the ghosts to keep in play")
+	}
+	set := byte(0)
+	for _, name := range *req.Ghosts {
+		color := slices.Index(ghostColorNames[:], name)
+		if color < 0 {
+			return nil, fmt.Errorf("unknown ghost \"%s\"", name)
+		}
+		set |= 1 << color
+	}
+	return []byte{'g', set}, nil
+}
+
+// Select the ghosts in play in a room's games (POST /admin/ghosts)
+func GhostsHandler(w http.ResponseWriter, r *http.Request) {
+
+	// Only commands are allowed
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+
+	// Only referees can select the ghosts
+	ip, client, wb, ok := authorizeLobbyRequest(w, r)
+	if !ok {
+		return
+	}
+
+	// Read the selection
+	var req ghostSelectRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest,
+			"invalid ghost selection: "+err.Error())
+		return
+	}
+	cmd, err := req.command()
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Send it to the room's game engine
+	if !wb.sendCommand(cmd) {
+		writeJSONError(w, http.StatusServiceUnavailable,
+			"game engine not running")
+		return
+	}
+	slog.Info("Ghost selection", "ip", ip, "client", client, "room", wb.room,
+		"ghosts", *req.Ghosts)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
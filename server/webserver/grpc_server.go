@@ -0,0 +1,219 @@
+package webserver
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+/*
+The gRPC service (pacbot.PacbotService in proto/pacbot.proto) is a strongly
+typed alternative to the websocket, for teams with existing gRPC tooling:
+
+	WatchState  - streams every game state broadcast, as GameState messages
+	SendCommand - sends a Command to the game engine (trusted clients only)
+
+Both apply to the default room, or the one given by the "room" metadata
+(rooms.go)
+
+The messages are the same protobuf messages offered over the websocket with
+"?format=proto", so the service is registered by hand with a codec that passes
+the (already encoded) messages through, instead of through generated code
+*/
+
+// A gRPC codec which passes encoded protobuf messages through as bytes
+type rawCodec struct{}
+
+// Encode a message (which is already encoded)
+func (rawCodec) Marshal(v any) ([]byte, error) {
+	msg, ok := v.(*[]byte)
+	if !ok {
+		return nil, fmt.Errorf("unexpected message type %T", v)
+	}
+	return *msg, nil
+}
+
+// Decode a message (leaving it encoded, to be decoded by the handler)
+func (rawCodec) Unmarshal(data []byte, v any) error {
+	msg, ok := v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("unexpected message type %T", v)
+	}
+	*msg = append([]byte{}, data...)
+	return nil
+}
+
+// Name of the codec (standing in for the default protobuf codec)
+func (rawCodec) Name() string {
+	return "proto"
+}
+
+// A CommandReply message, accepting a command (accepted = true)
+var commandAccepted = []byte{0x08, 0x01}
+
+// Look up the room that a gRPC call asked for, in the "room" metadata
+func grpcRoom(ctx context.Context) (*WebBroker, error) {
+	room := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("room"); len(values) > 0 {
+			room = values[0]
+		}
+	}
+	wb, err := findRoom(room)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	return wb, nil
+}
+
+// Handle a SendCommand call
+func sendCommandHandler(_ any, ctx context.Context, dec func(any) error,
+	_ grpc.UnaryServerInterceptor) (any, error) {
+
+	// Read the command
+	var req []byte
+	if err := dec(&req); err != nil {
+		return nil, err
+	}
+
+	// Only authorized clients can send commands (auth.go), with their token
+	// in the "authorization" metadata
+	ip, token := "", ""
+	if p, ok := peer.FromContext(ctx); ok {
+		ip = ipFromAddr(p.Addr.String())
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			token = strings.TrimSpace(strings.TrimPrefix(values[0], "Bearer "))
+		}
+	}
+	client, role, err := authorizeClient(ip, token)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if role == roleSpectator {
+		return nil, status.Error(codes.PermissionDenied, "untrusted client")
+	}
+
+	// Convert the command into a byte command
+	if commandProtoDecoder == nil {
+		return nil, status.Error(codes.Unimplemented, "protobuf is unavailable")
+	}
+	cmd, err := commandProtoDecoder(req)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := validateCommand(cmd); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	// Only send commands that the client's role allows (roles.go)
+	if err := role.canSend(cmd); err != nil {
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+
+	// Send the command to the room's game engine, as if it came from a
+	// websocket client (the game engine must be running to receive it)
+	wb, err := grpcRoom(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := wb.checkController(client, role, cmd); err != nil {
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	if !wb.sendCommand(cmd) {
+		return nil, status.Error(codes.Unavailable, "game engine not running")
+	}
+	reply := commandAccepted
+	return &reply, nil
+}
+
+// Handle a WatchState call
+func watchStateHandler(_ any, stream grpc.ServerStream) error {
+
+	// Read the (empty) request
+	var req []byte
+	if err := stream.RecvMsg(&req); err != nil {
+		return err
+	}
+	if stateEncoders[formatProto] == nil {
+		return status.Error(codes.Unimplemented, "protobuf is unavailable")
+	}
+
+	// Subscribe to the room's game state broadcasts
+	wb, err := grpcRoom(stream.Context())
+	if err != nil {
+		return err
+	}
+	states, cancel := wb.subscribeState()
+	defer cancel()
+
+	// Stream each broadcast until the client leaves
+	for {
+		select {
+		case state := <-states:
+			msg, err := stateEncoders[formatProto](state)
+			if err != nil {
+				slog.Error("Game state encoding error", "format", "proto",
+					"err", err)
+				continue
+			}
+			if err := stream.SendMsg(&msg); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+// Description of the gRPC service, registered by hand (see above)
+var pacbotServiceDesc = grpc.ServiceDesc{
+	ServiceName: "pacbot.PacbotService",
+	HandlerType: (*any)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "SendCommand", Handler: sendCommandHandler},
+	},
+	Streams: []grpc.StreamDesc{
+		{StreamName: "WatchState", Handler: watchStateHandler, ServerStreams: true},
+	},
+	Metadata: "pacbot.proto",
+}
+
+// gRPC server, exposing the game engine
+type GrpcServer struct {
+	listenAddr string
+	server     *grpc.Server
+}
+
+// Create a new gRPC server
+func NewGrpcServer(listenAddr string) *GrpcServer {
+	server := grpc.NewServer(grpc.ForceServerCodec(rawCodec{}))
+	server.RegisterService(&pacbotServiceDesc, struct{}{})
+	return &GrpcServer{
+		listenAddr: listenAddr,
+		server:     server,
+	}
+}
+
+// Start serving gRPC calls (blocks until the server stops)
+func (s *GrpcServer) GrpcStart() error {
+	listener, err := net.Listen("tcp", s.listenAddr)
+	if err != nil {
+		return err
+	}
+	return s.server.Serve(listener)
+}
+
+// Stop the gRPC server, ending any open streams
+func (s *GrpcServer) Quit() {
+	s.server.Stop()
+}
@@ -0,0 +1,145 @@
+package webserver
+
+import (
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+/*
+To detect dead connections quickly (e.g. a robot that lost power or wandered
+out of wifi range), the server pings each websocket client every PingInterval
+seconds. Any message or pong from the client counts as a sign of life; if
+nothing is heard from a client for PongTimeout seconds, its connection is
+closed and cleaned up. Browsers and websocket libraries answer pings
+automatically, so clients don't need to do anything. Each ping also measures
+the round-trip time to the client (latency.go).
+*/
+
+// Time between pings sent to each websocket client
+var pingInterval time.Duration = 5 * time.Second
+
+// Time without hearing from a client before it is considered dead
+var pongTimeout time.Duration = 15 * time.Second
+
+// Set the ping interval and pong timeout, in seconds (0 keeps the default)
+func ConfigHeartbeat(interval float64, timeout float64) {
+	if interval > 0 {
+		pingInterval = time.Duration(interval * float64(time.Second))
+	}
+	if timeout > 0 {
+		pongTimeout = time.Duration(timeout * float64(time.Second))
+	}
+
+	// The timeout must leave time for at least one ping to be answered
+	pongTimeout = max(pongTimeout, pingInterval+time.Second)
+}
+
+/*
+Start the heartbeat for a web session: set up the pong handler, and ping the
+client until the connection is closed
+*/
+func (ws *webSession) startHeartbeat() {
+
+	// Any pong counts as a sign of life (and measures the RTT)
+	ws.seen()
+	ws.conn.SetPongHandler(func(data string) error {
+		ws.seen()
+		ws.recordPong(data)
+		return nil
+	})
+
+	// Ping the client periodically (with a timestamp to echo), until the
+	// connection is closed
+	go func() {
+		ticker := time.NewTicker(pingInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			stamp := newTimestamp()
+			err := ws.conn.WriteControl(websocket.PingMessage, stamp,
+				time.Now().Add(pingInterval))
+			if err != nil {
+				return
+			}
+			if ws.taggedMessages() {
+				ws.writeMessage(msgRoundTrip, stamp)
+			}
+		}
+	}()
+}
+
+/*
+Record that the client was heard from, extending the deadline for the next
+message or pong (only called from the read loop)
+*/
+func (ws *webSession) seen() {
+	now := time.Now()
+	ws.lastSeen.Store(now.UnixNano())
+	ws.conn.SetReadDeadline(now.Add(pongTimeout))
+}
+
+// Log a client being evicted for not answering pings
+func (ws *webSession) logEviction() {
+	slog.Warn("Evicted stale client", "ip", getIP(ws.conn),
+		"client", ws.client,
+		"lastSeen", time.Unix(0, ws.lastSeen.Load()).Format(time.RFC3339Nano))
+}
+
+// Information about a connected websocket client, for the admin API
+type webClientInfo struct {
+	ID          uint64     `json:"id"`
+	IP          string     `json:"ip"`
+	Room        string     `json:"room"`
+	Client      string     `json:"client,omitempty"`
+	Role        string     `json:"role"`
+	Version     uint8      `json:"version"`
+	Format      string     `json:"format"`
+	ConnectedAt time.Time  `json:"connectedAt"`
+	LastSeen    time.Time  `json:"lastSeen"`
+	IdleSeconds float64    `json:"idleSeconds"`
+	RTTMs       float64    `json:"rttMs,omitempty"`       // (latency.go)
+	EchoRTTMs   float64    `json:"echoRttMs,omitempty"`   // Control latency
+	Ghost       string     `json:"ghost,omitempty"`       // (ghost_players.go)
+	LastMessage *time.Time `json:"lastMessage,omitempty"` // (dashboard.go)
+	LastMsgType string     `json:"lastMessageType,omitempty"`
+}
+
+// Get information about each connected websocket client (oldest first)
+func webClientsInfo() []webClientInfo {
+	now := time.Now()
+	clients := []webClientInfo{}
+	muOWS.RLock()
+	{
+		for ws := range openWebSessions {
+			lastSeen := time.Unix(0, ws.lastSeen.Load())
+			info := webClientInfo{
+				ID:          ws.id,
+				IP:          getIP(ws.conn),
+				Room:        ws.broker.room,
+				Client:      ws.client,
+				Role:        ws.role.String(),
+				Version:     ws.version,
+				Format:      formatNames[ws.format],
+				ConnectedAt: ws.connectedAt,
+				LastSeen:    lastSeen,
+				IdleSeconds: now.Sub(lastSeen).Seconds(),
+				RTTMs:       ws.pingRTT.milliseconds(),
+				EchoRTTMs:   ws.echoRTT.milliseconds(),
+				Ghost:       ws.ghostName(),
+			}
+			if at := ws.lastMessage.Load(); at != 0 {
+				lastMessage := time.Unix(0, at)
+				info.LastMessage = &lastMessage
+				info.LastMsgType = string(rune(ws.lastMsgType.Load()))
+			}
+			clients = append(clients, info)
+		}
+	}
+	muOWS.RUnlock()
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].ConnectedAt.Before(clients[j].ConnectedAt)
+	})
+	return clients
+}
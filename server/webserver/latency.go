@@ -0,0 +1,140 @@
+package webserver
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+/*
+A robot that hears about the game late, or whose moves reach the server late,
+is at a disadvantage, so the server measures the round-trip time (RTT) to each
+websocket client with timestamp echoes. Every ping (heartbeat.go) carries the
+time it was sent (8 bytes: nanoseconds since the Unix epoch, big-endian),
+which websocket libraries echo back in the pong, measuring the network's RTT
+to every client. Clients speaking pacbot.v1 are also sent the same timestamp
+in a message of type 'r', which they can echo back unchanged (as a message of
+type 'r'): as the echo passes through the client's own message loop, it
+measures the client's control latency - how long it takes for a state to
+reach the robot's code and a command to come back.
+
+The RTTs (smoothed, like TCP's) are listed for each client by the admin API
+(GET /admin/clients). With MaxControlLatency set (in milliseconds), the server
+warns when the control latency of a client that can send commands (the echo
+RTT, or the ping RTT for clients that don't echo) rises above it, and again
+when it recovers.
+*/
+
+// Message type for timestamp echoes (server -> client, and client -> server)
+const msgRoundTrip byte = 'r'
+
+// The weight of each new RTT sample in the smoothed RTT (1 / rttSmoothing)
+const rttSmoothing = 8
+
+// The control latency above which clients are warned about (0 for never)
+var maxControlLatency time.Duration = 0
+
+// Set the control latency above which clients are warned about, in ms
+func ConfigMaxControlLatency(ms float64) {
+	maxControlLatency = time.Duration(ms * float64(time.Millisecond))
+}
+
+// Round-trip times measured to a client (written from the read loop only)
+type rttTracker struct {
+	latest   atomic.Int64 // Latest RTT, in nanoseconds (0 if not measured)
+	smoothed atomic.Int64 // Smoothed RTT, in nanoseconds
+}
+
+// Record an RTT sample
+func (rt *rttTracker) record(rtt time.Duration) {
+	rt.latest.Store(int64(rtt))
+	smoothed := rt.smoothed.Load()
+	if smoothed == 0 {
+		smoothed = int64(rtt)
+	} else {
+		smoothed += (int64(rtt) - smoothed) / rttSmoothing
+	}
+	rt.smoothed.Store(smoothed)
+}
+
+// Get the smoothed RTT (0 if not measured)
+func (rt *rttTracker) get() time.Duration {
+	return time.Duration(rt.smoothed.Load())
+}
+
+// Get the smoothed RTT in milliseconds, for the admin API
+func (rt *rttTracker) milliseconds() float64 {
+	return float64(rt.get()) / float64(time.Millisecond)
+}
+
+// Encode the current time as a timestamp to be echoed
+func newTimestamp() []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(time.Now().UnixNano()))
+}
+
+// Get the time since an echoed timestamp was sent
+func timestampAge(stamp []byte) (time.Duration, error) {
+	if len(stamp) != 8 {
+		return 0, fmt.Errorf("timestamp must be 8 bytes, not %d", len(stamp))
+	}
+	sent := time.Unix(0, int64(binary.BigEndian.Uint64(stamp)))
+	rtt := time.Since(sent)
+	if rtt < 0 || rtt > pongTimeout {
+		return 0, fmt.Errorf("timestamp wasn't sent recently")
+	}
+	return rtt, nil
+}
+
+// Record the RTT of a pong (ignoring pongs without a timestamp)
+func (ws *webSession) recordPong(data string) {
+	if rtt, err := timestampAge([]byte(data)); err == nil {
+		ws.pingRTT.record(rtt)
+		ws.checkLatency()
+	}
+}
+
+// Record the RTT of a timestamp echo from a pacbot.v1 client
+func (ws *webSession) recordEcho(stamp []byte) {
+	rtt, err := timestampAge(stamp)
+	if err != nil {
+		slog.Warn("Invalid message from client", "ip", getIP(ws.conn),
+			"err", err)
+		ws.writeMessage(msgError, []byte(err.Error()))
+		return
+	}
+	ws.echoRTT.record(rtt)
+	ws.checkLatency()
+}
+
+/*
+Warn if a client's control latency rises above the maximum, or once it
+recovers (only called from the read loop)
+*/
+func (ws *webSession) checkLatency() {
+
+	// Only clients that control the game matter
+	if maxControlLatency == 0 || ws.role == roleSpectator {
+		return
+	}
+
+	// Use the echo RTT, if the client echoes timestamps
+	latency := ws.echoRTT.get()
+	if latency == 0 {
+		latency = ws.pingRTT.get()
+	}
+
+	// Warn when the latency crosses the maximum
+	if slow := latency > maxControlLatency; slow != ws.latencyWarned {
+		ws.latencyWarned = slow
+		if slow {
+			slog.Warn("Client control latency too high", "ip", getIP(ws.conn),
+				"client", ws.client, "latency", latency,
+				"max", maxControlLatency)
+		} else {
+			slog.Info("Client control latency recovered", "ip",
+				getIP(ws.conn), "client", ws.client, "latency", latency)
+		}
+	}
+}
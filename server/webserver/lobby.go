@@ -0,0 +1,371 @@
+package webserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+/*
+The lobby lets a referee set up a match in a room before it starts: which
+connected client controls Pacman, and which maze and settings the game is
+played with. Once everyone is ready, the referee starts the match, which
+starts the game's countdown for all participants at once. The lobby is run
+through the REST API (referees only):
+
+	GET    /admin/match       - the current match (null if there is none)
+	POST   /admin/match       - set up a new match, resetting the game, with a
+	                            JSON body: {"pacman": "<client name>",
+	                            "team": "<team name>", "maze": "<maze
+	                            profile>", "updatePeriod": <ticks per step>,
+	                            "gameFPS": <ticks per second>} (only "pacman"
+	                            is required - the team, as shown on the
+	                            scoreboard, defaults to the client's name)
+	POST   /admin/match/start - start the match (counting down, if configured)
+	DELETE /admin/match       - end the match
+
+While a match is set up, only the client controlling Pacman (and referees) can
+move Pacman in its room, and the game engine runs in competition mode, where
+the controller's move for each step must arrive within a deadline (if one is
+configured, see game/decision_budget.go). Clients speaking pacbot.v1 are told about the match
+with a message of type 'm', holding the match as JSON, whenever it changes
+(and when they connect, if there is one)
+*/
+
+// Message type for match announcements (server -> client)
+const msgMatch byte = 'm'
+
+// Match statuses
+const (
+	matchSetup   = "setup"   // Set up, waiting for the referee to start it
+	matchStarted = "started" // Started by the referee
+	matchEnded   = "ended"   // Ended by the referee (only announced)
+)
+
+// A match set up in the lobby of a room
+type matchInfo struct {
+	Room         string     `json:"room"`
+	Pacman       string     `json:"pacman"`
+	Team         string     `json:"team,omitempty"`
+	Maze         string     `json:"maze,omitempty"`
+	UpdatePeriod uint8      `json:"updatePeriod,omitempty"`
+	GameFPS      uint16     `json:"gameFPS,omitempty"`
+	Status       string     `json:"status"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	StartedAt    *time.Time `json:"startedAt,omitempty"`
+}
+
+// A request to set up a new match (POST /admin/match)
+type matchRequest struct {
+	Pacman       string `json:"pacman"`
+	Team         string `json:"team"`
+	Maze         string `json:"maze"`
+	UpdatePeriod uint8  `json:"updatePeriod"`
+	GameFPS      uint16 `json:"gameFPS"`
+}
+
+// The names of the maze profiles that matches can be played on
+var mazeNames []string = nil
+
+// Set the names of the maze profiles that matches can be played on
+func ConfigMazes(_mazeNames []string) {
+	mazeNames = _mazeNames
+}
+
+// The lobby of a room: the match that is set up in it (nil if there is none)
+type lobby struct {
+	match   *matchInfo
+	muMatch sync.RWMutex
+}
+
+// Get a copy of the room's current match (nil if there is none)
+func (wb *WebBroker) getMatch() *matchInfo {
+	wb.lobby.muMatch.RLock()
+	defer wb.lobby.muMatch.RUnlock()
+	if wb.lobby.match == nil {
+		return nil
+	}
+	match := *wb.lobby.match
+	return &match
+}
+
+// Check whether a client is connected to the room, and can move Pacman
+func (wb *WebBroker) hasClient(client string) bool {
+	muOWS.RLock()
+	defer muOWS.RUnlock()
+	for ws := range openWebSessions {
+		if ws.broker == wb && ws.client == client && ws.role != roleSpectator {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Check whether a client can send a command in the room's current match - while
+a match is set up, only the client controlling Pacman (and referees) can move
+Pacman
+*/
+func (wb *WebBroker) checkController(client string, role clientRole,
+	cmd []byte) error {
+	if len(cmd) == 0 || role == roleReferee {
+		return nil
+	}
+	if _, ok := botCommands[cmd[0]]; !ok {
+		return nil
+	}
+	wb.lobby.muMatch.RLock()
+	defer wb.lobby.muMatch.RUnlock()
+	if wb.lobby.match == nil || wb.lobby.match.Pacman == client {
+		return nil
+	}
+	return fmt.Errorf("client \"%s\" does not control Pacman in this match",
+		client)
+}
+
+// Encode a match as JSON, for announcements
+func (match *matchInfo) encode() []byte {
+	out, _ := json.Marshal(match)
+	return out
+}
+
+// Send a match event to the webhooks that subscribe to it (webhooks.go)
+func (match *matchInfo) notifyWebhooks(event string) {
+	details := map[string]any{"event": event, "pacman": match.Pacman}
+	if match.Room != "" {
+		details["room"] = match.Room
+	}
+	if match.Team != "" {
+		details["team"] = match.Team
+	}
+	if match.Maze != "" {
+		details["maze"] = match.Maze
+	}
+	NotifyWebhooks(details)
+}
+
+/*
+Announce a match to the room's pacbot.v1 sessions, all within one pass so that
+they hear about it at the same time (skipping sessions that aren't keeping up)
+*/
+func (wb *WebBroker) announceMatch(match *matchInfo) {
+	msg := tagMessage(msgMatch, match.encode())
+	muOWS.RLock()
+	{
+		for ws := range openWebSessions {
+			if ws.broker != wb || !ws.taggedMessages() {
+				continue
+			}
+			select {
+			case ws.sendCh <- outgoing{msg: msg}:
+			default:
+				slog.Warn("A web-session send channel was full",
+					"client", getIP(ws.conn))
+			}
+		}
+	}
+	muOWS.RUnlock()
+}
+
+// Set up a new match in a room, from a referee's request
+func (wb *WebBroker) setupMatch(req matchRequest) (*matchInfo, int, error) {
+
+	// Check the request
+	if req.Pacman == "" {
+		return nil, http.StatusBadRequest, fmt.Errorf("pacman is required")
+	}
+	if !wb.hasClient(req.Pacman) {
+		return nil, http.StatusNotFound,
+			fmt.Errorf("client \"%s\" is not connected", req.Pacman)
+	}
+	if req.Maze != "" && !slices.Contains(mazeNames, req.Maze) {
+		return nil, http.StatusBadRequest,
+			fmt.Errorf("unknown maze \"%s\"", req.Maze)
+	}
+	if current := wb.getMatch(); current != nil &&
+		current.Status == matchStarted {
+		return nil, http.StatusConflict,
+			fmt.Errorf("a match is in progress (end it first)")
+	}
+
+	// Reset the game, selecting the maze (which also resets it) if asked
+	reset := []byte{'r'}
+	if req.Maze != "" {
+		reset = append([]byte{'m'}, req.Maze...)
+	}
+	if !wb.sendCommand(reset) {
+		return nil, http.StatusServiceUnavailable,
+			fmt.Errorf("game engine not running")
+	}
+
+	// Apply the game settings
+	if req.UpdatePeriod != 0 {
+		wb.sendCommand([]byte{'u', req.UpdatePeriod})
+	}
+	if req.GameFPS != 0 {
+		wb.sendCommand([]byte{'f', byte(req.GameFPS >> 8), byte(req.GameFPS)})
+	}
+
+	// Enforce the deadline for each step's move, until the match ends
+	wb.sendCommand([]byte{'b', 1})
+
+	// Keep track of the match, and announce it
+	match := matchInfo{
+		Room:         wb.room,
+		Pacman:       req.Pacman,
+		Team:         req.Team,
+		Maze:         req.Maze,
+		UpdatePeriod: req.UpdatePeriod,
+		GameFPS:      req.GameFPS,
+		Status:       matchSetup,
+		CreatedAt:    time.Now(),
+	}
+	wb.lobby.muMatch.Lock()
+	{
+		wb.lobby.match = &match
+	}
+	wb.lobby.muMatch.Unlock()
+	wb.announceMatch(&match)
+	return &match, http.StatusOK, nil
+}
+
+// Start the room's match, counting down for all participants at once
+func (wb *WebBroker) startMatch() (*matchInfo, int, error) {
+
+	// Mark the match as started
+	var match matchInfo
+	now := time.Now()
+	wb.lobby.muMatch.Lock()
+	{
+		if wb.lobby.match != nil && wb.lobby.match.Status == matchSetup {
+			wb.lobby.match.Status = matchStarted
+			wb.lobby.match.StartedAt = &now
+			match = *wb.lobby.match
+		}
+	}
+	wb.lobby.muMatch.Unlock()
+	if match.Status != matchStarted {
+		return nil, http.StatusConflict, fmt.Errorf("no match is set up")
+	}
+
+	// Start the game (counting down, if configured), and announce it
+	if !wb.sendCommand([]byte{'P'}) {
+		return nil, http.StatusServiceUnavailable,
+			fmt.Errorf("game engine not running")
+	}
+	wb.announceMatch(&match)
+	match.notifyWebhooks("match_start")
+	return &match, http.StatusOK, nil
+}
+
+// End the room's match, so that any bot can move Pacman again
+func (wb *WebBroker) endMatch() (*matchInfo, int, error) {
+	var match *matchInfo
+	wb.lobby.muMatch.Lock()
+	{
+		match, wb.lobby.match = wb.lobby.match, nil
+	}
+	wb.lobby.muMatch.Unlock()
+	if match == nil {
+		return nil, http.StatusConflict, fmt.Errorf("no match is set up")
+	}
+	wb.sendCommand([]byte{'b', 0})
+	match.Status = matchEnded
+	wb.announceMatch(match)
+	match.notifyWebhooks("match_end")
+	return match, http.StatusOK, nil
+}
+
+// Set up, inspect, or end the match of a room (/admin/match)
+func MatchHandler(w http.ResponseWriter, r *http.Request) {
+
+	// Only referees can run the lobby
+	ip, client, wb, ok := authorizeLobbyRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var match *matchInfo
+	var status int
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(wb.getMatch())
+		return
+	case http.MethodPost:
+		var req matchRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid match: "+err.Error())
+			return
+		}
+		match, status, err = wb.setupMatch(req)
+	case http.MethodDelete:
+		match, status, err = wb.endMatch()
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "use GET, POST, or DELETE")
+		return
+	}
+	writeMatch(w, match, status, err)
+	if err == nil {
+		slog.Info("Match "+match.Status, "ip", ip, "client", client,
+			"room", wb.room, "pacman", match.Pacman)
+	}
+}
+
+// Start the match of a room (POST /admin/match/start)
+func MatchStartHandler(w http.ResponseWriter, r *http.Request) {
+
+	// Only commands are allowed
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+
+	// Only referees can run the lobby
+	ip, client, wb, ok := authorizeLobbyRequest(w, r)
+	if !ok {
+		return
+	}
+
+	match, status, err := wb.startMatch()
+	writeMatch(w, match, status, err)
+	if err == nil {
+		slog.Info("Match started", "ip", ip, "client", client,
+			"room", wb.room, "pacman", match.Pacman)
+	}
+}
+
+/*
+Authorize a lobby request, writing an error response if the client is not a
+referee - returns the client's IP address and name, and the room's web broker
+*/
+func authorizeLobbyRequest(w http.ResponseWriter,
+	r *http.Request) (string, string, *WebBroker, bool) {
+	ip, client, role, ok := authorizeAdminRequest(w, r)
+	if !ok {
+		return ip, client, nil, false
+	}
+	if role != roleReferee {
+		writeJSONError(w, http.StatusForbidden, "referees only")
+		return ip, client, nil, false
+	}
+	wb := requestRoomOrError(w, r)
+	return ip, client, wb, wb != nil
+}
+
+// Write a match (or an error) as the response to a lobby request
+func writeMatch(w http.ResponseWriter, match *matchInfo, status int, err error) {
+	if err != nil {
+		writeJSONError(w, status, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(match)
+}
@@ -0,0 +1,46 @@
+package webserver
+
+import (
+	"fmt"
+)
+
+/*
+The overhead-camera tracking system reports the physical robot's position
+through its own channel, instead of synthesizing moves: clients with the
+tracker role (or referees) speaking pacbot.v1 send localization reports as
+messages of type 'l', holding the position in cell units:
+
+	row (4 bytes, float32), column (4 bytes, float32),
+	heading (4 bytes, float32, optional)
+
+where the center of the cell at (row, col) is at exactly (row, col), and the
+heading is in radians (right = 0, down = pi/2). Reports are passed on to the
+game engine as a command ('l' followed by the same 8 or 12 bytes, which legacy
+clients can send directly), which checks them and moves Pacman to the reported
+cell (see game/localization.go). Trackers report at the camera's frame rate,
+so their reports aren't rate limited, and they can be sent during matches
+regardless of which client controls Pacman.
+*/
+
+// Message type for localization reports (client -> server)
+const msgLocalization byte = 'l'
+
+// The lengths of a localization report, without and with the heading
+const (
+	localizationLen        = 8
+	localizationHeadingLen = 12
+)
+
+// The commands that trackers can send, by their first byte
+var trackerCommands = map[byte]struct{}{
+	'l': {}, // Localization report
+}
+
+// Convert a localization report into a command for the game engine
+func localizationCommand(report []byte) ([]byte, error) {
+	if len(report) != localizationLen && len(report) != localizationHeadingLen {
+		return nil, fmt.Errorf("localization report must be %d or %d bytes "+
+			"long", localizationLen, localizationHeadingLen)
+	}
+	return append([]byte{'l'}, report...), nil
+}
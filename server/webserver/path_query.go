@@ -0,0 +1,145 @@
+package webserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+/*
+Bots and referee tools can ask the server for a shortest path between two
+cells of their room's maze (moving around the walls), instead of implementing
+their own pathfinding. Clients speaking pacbot.v1 send a path query as a
+message of type 'f', holding the two cells:
+
+	from row (1 byte), from column (1 byte), to row (1 byte), to column (1 byte)
+
+and the server answers with a message of type 'p', holding the same four bytes
+followed by the moves to make, one byte each (0 = up, 1 = left, 2 = down,
+3 = right, as in the game state) - or with an error message, if either cell is
+a wall or there is no path. Path queries count towards the command rate limit.
+The same query is available over the REST API:
+
+	GET /path?from=<row>,<col>&to=<row>,<col>
+
+which answers with {"from": [row, col], "to": [row, col], "length": <moves>,
+"moves": ["up", "left", ...]}
+*/
+
+// Message types for path queries and their answers
+const (
+	msgPathQuery byte = 'f' // Path query (client -> server)
+	msgPath      byte = 'p' // Path found (server -> client)
+)
+
+// The length of a path query
+const pathQueryLen = 4
+
+// Names of the moves in a path (for the REST API)
+var moveNames = [...]string{"up", "left", "down", "right"}
+
+/*
+A function finding a shortest path between two cells of a room's maze,
+returning the moves (directions) to make - set by the main package for each
+room, as the room's game engine knows its maze
+*/
+type PathFinder func(fromRow, fromCol, toRow, toCol int8) ([]uint8, error)
+
+// Set the function to find paths in a room's maze with
+func (wb *WebBroker) ConfigPathFinder(finder PathFinder) {
+	wb.pathFinder.Store(&finder)
+}
+
+// Find a shortest path between two cells of the room's maze
+func (wb *WebBroker) findPath(fromRow, fromCol, toRow,
+	toCol int8) ([]uint8, error) {
+	finder := wb.pathFinder.Load()
+	if finder == nil {
+		return nil, fmt.Errorf("path queries are unavailable")
+	}
+	return (*finder)(fromRow, fromCol, toRow, toCol)
+}
+
+// Answer a path query from a web session (only called from the read loop)
+func (ws *webSession) answerPathQuery(query []byte) {
+
+	// Check the query
+	if len(query) != pathQueryLen {
+		ws.writeMessage(msgError, []byte(fmt.Sprintf(
+			"path query must be %d bytes long", pathQueryLen)))
+		return
+	}
+
+	// Path queries count as commands, for the rate limit (rate_limit.go)
+	if !ws.limitCommand() {
+		return
+	}
+
+	// Find the path, and answer with the query followed by the moves
+	path, err := ws.broker.findPath(int8(query[0]), int8(query[1]),
+		int8(query[2]), int8(query[3]))
+	if err != nil {
+		ws.writeMessage(msgError, []byte(err.Error()))
+		return
+	}
+	ws.writeMessage(msgPath, append(query[:pathQueryLen:pathQueryLen], path...))
+}
+
+// Parse a cell given as "<row>,<col>" in a query parameter
+func parseCell(value string) (int8, int8, error) {
+	rowStr, colStr, ok := strings.Cut(value, ",")
+	row, errRow := strconv.ParseInt(strings.TrimSpace(rowStr), 10, 8)
+	col, errCol := strconv.ParseInt(strings.TrimSpace(colStr), 10, 8)
+	if !ok || errRow != nil || errCol != nil {
+		return 0, 0, fmt.Errorf("invalid cell %q (use <row>,<col>)", value)
+	}
+	return int8(row), int8(col), nil
+}
+
+// Find a shortest path between two cells of a room's maze (GET /path)
+func PathHandler(w http.ResponseWriter, r *http.Request) {
+
+	// Only reads are allowed
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+
+	wb := requestRoomOrError(w, r)
+	if wb == nil {
+		return
+	}
+
+	// Read the cells from the query
+	fromRow, fromCol, err := parseCell(r.URL.Query().Get("from"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	toRow, toCol, err := parseCell(r.URL.Query().Get("to"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Find the path
+	path, err := wb.findPath(fromRow, fromCol, toRow, toCol)
+	if err != nil {
+		writeJSONError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	moves := make([]string, len(path))
+	for i, dir := range path {
+		moves[i] = moveNames[dir]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"from":   [2]int8{fromRow, fromCol},
+		"to":     [2]int8{toRow, toCol},
+		"length": len(path),
+		"moves":  moves,
+	})
+}
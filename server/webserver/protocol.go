@@ -0,0 +1,193 @@
+package webserver
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+/*
+Clients negotiate the protocol version when connecting, by offering websocket
+subprotocols named "pacbot.v<version>" (e.g. "pacbot.v1"). Clients that offer
+a supported version have every message wrapped in an envelope, in the
+following (big-endian) format:
+
+	version (1 byte) - protocol version
+	type (1 byte)    - message type (see below)
+	length (4 bytes) - length of the payload
+	payload          - the message itself
+
+Clients that don't offer any "pacbot.v" subprotocol are downgraded to the
+legacy protocol (version 0), and receive the bare serialized game state as
+before. Clients that only offer unsupported versions are rejected.
+
+Independently of the protocol version, clients can ask for the game state in
+JSON instead of the compact binary format by connecting with "?format=json" -
+they then receive it as a JSON document (a text message for legacy clients,
+or a message of type 'j' in an envelope). Similarly, clients connecting with
+"?format=proto" receive the game state as a protobuf message (type 'b' in an
+envelope), and are expected to send their commands as protobuf messages too
+(see proto/pacbot.proto at the top of the repository). Clients speaking
+pacbot.v1 can also ask for delta updates with "?format=delta" (see delta.go).
+*/
+
+// The newest protocol version that the server speaks
+const protocolVersion uint8 = 1
+
+// The legacy protocol version (no envelopes)
+const legacyProtocolVersion uint8 = 0
+
+// Prefix of the websocket subprotocols naming a protocol version
+const protocolPrefix = "pacbot.v"
+
+// The length of an envelope header
+const envelopeHeaderLen = 6
+
+// Enum-like declaration to hold the message types
+const (
+	msgState   byte = 's' // Serialized game state (server -> client)
+	msgCommand byte = 'c' // Game command (client -> server)
+	msgError   byte = 'e' // Error description, as text (server -> client)
+	msgJSON    byte = 'j' // Game state as JSON (server -> client)
+	msgProto   byte = 'b' // Game state as protobuf (server -> client)
+)
+
+// Enum-like declaration to hold the game state formats
+const (
+	formatBinary uint8 = 0 // Compact binary serialization
+	formatJSON   uint8 = 1 // JSON document
+	formatProto  uint8 = 2 // Protobuf message
+	formatDelta  uint8 = 3 // Keyframes and deltas of the binary serialization
+	numFormats   uint8 = 4
+)
+
+// Names of the game state formats (as given in the "format" query parameter)
+var formatNames [numFormats]string = [...]string{
+	"binary",
+	"json",
+	"proto",
+	"delta",
+}
+
+// Message types that each game state format is sent as (0 if it varies)
+var formatMsgTypes [numFormats]byte = [...]byte{
+	msgState,
+	msgJSON,
+	msgProto,
+	0,
+}
+
+/*
+Functions to convert the binary game state to each other format (set by the
+main package, nil if a format is unavailable)
+*/
+var stateEncoders [numFormats]func([]byte) ([]byte, error)
+
+// Function to convert protobuf commands to byte commands (set by main)
+var commandProtoDecoder func([]byte) ([]byte, error) = nil
+
+// Set the function to convert the binary game state to JSON
+func ConfigStateJSONEncoder(encoder func([]byte) ([]byte, error)) {
+	stateEncoders[formatJSON] = encoder
+}
+
+/*
+Set the functions to convert the binary game state to protobuf, and protobuf
+commands back to byte commands
+*/
+func ConfigProtoCodec(stateEncoder func([]byte) ([]byte, error),
+	commandDecoder func([]byte) ([]byte, error)) {
+	stateEncoders[formatProto] = stateEncoder
+	commandProtoDecoder = commandDecoder
+}
+
+/*
+Decide which game state format a connecting client asked for (falling back to
+the binary format if the client can't use it)
+*/
+func negotiateFormat(r *http.Request, version uint8) uint8 {
+	name := r.URL.Query().Get("format")
+
+	// Delta updates need envelopes, to tell keyframes and deltas apart
+	if name == formatNames[formatDelta] {
+		if version == legacyProtocolVersion {
+			return formatBinary
+		}
+		return formatDelta
+	}
+
+	// Other formats need a function to convert the state
+	for format := formatJSON; format < numFormats; format++ {
+		if name == formatNames[format] && stateEncoders[format] != nil {
+			return format
+		}
+	}
+	return formatBinary
+}
+
+// Websocket subprotocols for the supported protocol versions, newest first
+var supportedProtocols = []string{
+	protocolPrefix + strconv.Itoa(int(protocolVersion)),
+}
+
+/*
+Decide which protocol version to speak with a connecting client, based on the
+subprotocols it offered - returns false if the client should be rejected
+*/
+func negotiateProtocol(r *http.Request) (uint8, bool) {
+
+	// Look for any offered protocol versions
+	offered := false
+	for _, proto := range websocket.Subprotocols(r) {
+		if !strings.HasPrefix(proto, protocolPrefix) {
+			continue
+		}
+		offered = true
+
+		// Pick the first supported version that was offered
+		for _, supported := range supportedProtocols {
+			if proto == supported {
+				version, _ := strconv.Atoi(strings.TrimPrefix(proto, protocolPrefix))
+				return uint8(version), true
+			}
+		}
+	}
+
+	// If no versions were offered at all, fall back to the legacy protocol
+	return legacyProtocolVersion, !offered
+}
+
+// Write an envelope header for a message of a given type and length
+func putEnvelopeHeader(buf []byte, msgType byte, length int) {
+	buf[0] = protocolVersion
+	buf[1] = msgType
+	buf[2] = byte(length >> 24)
+	buf[3] = byte(length >> 16)
+	buf[4] = byte(length >> 8)
+	buf[5] = byte(length)
+}
+
+// Open an envelope received from a client, checking its header
+func openEnvelope(msg []byte) (byte, []byte, error) {
+
+	// Check the header
+	if len(msg) < envelopeHeaderLen {
+		return 0, nil, fmt.Errorf("message too short for an envelope")
+	}
+	if msg[0] != protocolVersion {
+		return 0, nil, fmt.Errorf("unsupported protocol version %d", msg[0])
+	}
+
+	// Check the length of the payload
+	length := int(msg[2])<<24 | int(msg[3])<<16 | int(msg[4])<<8 | int(msg[5])
+	if length != len(msg)-envelopeHeaderLen {
+		return 0, nil, fmt.Errorf("envelope length %d does not match the "+
+			"payload length %d", length, len(msg)-envelopeHeaderLen)
+	}
+
+	// Return the message type and payload
+	return msg[1], msg[envelopeHeaderLen:], nil
+}
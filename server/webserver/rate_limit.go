@@ -0,0 +1,137 @@
+package webserver
+
+import (
+	"log/slog"
+	"time"
+)
+
+/*
+Command messages from each websocket connection are rate limited, to protect
+the game engine from misbehaving bots flooding it with moves. Each connection
+gets a token bucket: it can send up to CommandBurst commands at once, refilled
+at CommandRateLimit commands per second. What happens to commands past the
+limit is decided by the policy:
+
+	drop       - discard the command (and tell the client, with an error)
+	queue      - hold the command until the limit allows it (the client's
+	             later messages wait behind it)
+	disconnect - close the connection
+*/
+
+// Enum-like declaration to hold the rate limit policies
+const (
+	ratePolicyDrop       uint8 = 0
+	ratePolicyQueue      uint8 = 1
+	ratePolicyDisconnect uint8 = 2
+	numRatePolicies      uint8 = 3
+)
+
+// Names of the rate limit policies (as given in the configuration)
+var ratePolicyNames [numRatePolicies]string = [...]string{
+	"drop",
+	"queue",
+	"disconnect",
+}
+
+// Commands per second allowed from each connection (0 = unlimited)
+var commandRate float64 = 0
+
+// Commands that can be sent at once, before the rate limit applies
+var commandBurst float64 = 1
+
+// What to do with commands past the rate limit
+var commandRatePolicy uint8 = ratePolicyDrop
+
+// Set the rate limit on commands from each connection
+func ConfigCommandRateLimit(rate float64, burst int, policy string) {
+	commandRate = max(rate, 0)
+	commandBurst = float64(max(burst, 1))
+	for p := uint8(0); p < numRatePolicies; p++ {
+		if policy == ratePolicyNames[p] {
+			commandRatePolicy = p
+		}
+	}
+}
+
+// A token bucket limiting the commands from a connection (not thread-safe)
+type commandLimiter struct {
+	tokens float64   // Commands that can currently be sent
+	last   time.Time // Time the bucket was last refilled
+	warned bool      // Whether the client was warned since it went over
+}
+
+// Create a new command limiter, with a full bucket
+func newCommandLimiter() *commandLimiter {
+	return &commandLimiter{
+		tokens: commandBurst,
+		last:   time.Now(),
+	}
+}
+
+// Refill the bucket for the time since the last refill
+func (cl *commandLimiter) refill() {
+	now := time.Now()
+	cl.tokens = min(commandBurst,
+		cl.tokens+now.Sub(cl.last).Seconds()*commandRate)
+	cl.last = now
+}
+
+/*
+Decide whether a command can be sent now, waiting for the limit to allow it
+under the queue policy - returns false if the command is over the limit
+*/
+func (cl *commandLimiter) allow() bool {
+
+	// Without a rate limit, all commands are allowed
+	if commandRate <= 0 {
+		return true
+	}
+
+	// Take a token, if there is one
+	cl.refill()
+	if cl.tokens >= 1 {
+		cl.tokens--
+		return true
+	}
+
+	// Otherwise, queue the command (if allowed) until a token is available
+	if commandRatePolicy != ratePolicyQueue {
+		return false
+	}
+	wait := time.Duration((1 - cl.tokens) / commandRate * float64(time.Second))
+	time.Sleep(wait)
+	cl.refill()
+	cl.tokens = max(cl.tokens-1, 0)
+	return true
+}
+
+/*
+Apply the rate limit to a command from a web session - returns false if the
+command should not be sent (closing the session, under the disconnect policy)
+*/
+func (ws *webSession) limitCommand() bool {
+
+	// Trackers report at the camera's frame rate, so they aren't limited
+	if ws.role == roleTracker {
+		return true
+	}
+	if ws.limiter.allow() {
+		ws.limiter.warned = false
+		return true
+	}
+
+	// Log only the first command over the limit, to avoid flooding the logs
+	if !ws.limiter.warned {
+		slog.Warn("Client exceeded the command rate limit",
+			"ip", getIP(ws.conn), "client", ws.client,
+			"policy", ratePolicyNames[commandRatePolicy])
+		ws.limiter.warned = true
+	}
+	if commandRatePolicy == ratePolicyDisconnect {
+		ws.writeMessage(msgError, []byte("command rate limit exceeded"))
+		ws.quit()
+		return false
+	}
+	ws.writeMessage(msgError, []byte("command rate limit exceeded, dropped"))
+	return false
+}
@@ -0,0 +1,121 @@
+package webserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+)
+
+/*
+Referees settle disputes over the physical robot during a match by correcting
+the game directly (see game/referee.go), through the REST API:
+
+	POST /admin/referee - with a JSON body, one of:
+	    {"action": "score", "change": <points, positive or negative>}
+	    {"action": "lives", "change": <lives, positive or negative>}
+	    {"action": "respawn", "ghost": "<ghost color, e.g. red>"}
+	    {"action": "teleport", "row": <row>, "col": <col>}
+	    {"action": "end"}
+	    {"action": "gate", "gate": <gate, or -1 for all>, "open": <true/false>}
+	    {"action": "note", "text": "<annotation for the event log>"}
+
+or by sending the equivalent commands ('e' followed by the action) over a
+websocket. Only referees can send them. Ending the game through the REST API
+also ends the room's match in the lobby, if one is set up.
+*/
+
+// A referee command (POST /admin/referee)
+type refereeRequest struct {
+	Action string `json:"action"`
+	Change int    `json:"change"` // Points or lives to add (negative to take)
+	Ghost  string `json:"ghost"`  // Ghost to respawn
+	Row    int8   `json:"row"`    // Cell to teleport Pacman to
+	Col    int8   `json:"col"`
+	Text   string `json:"text"` // Annotation
+	Gate   int    `json:"gate"` // Gate to open or close (-1 for all)
+	Open   bool   `json:"open"`
+}
+
+// Convert a referee command into a command for the game engine
+func (req *refereeRequest) command() ([]byte, error) {
+	switch req.Action {
+	case "score":
+		change := int16(min(max(req.Change, -32768), 32767))
+		return []byte{'e', 's', byte(change >> 8), byte(change)}, nil
+	case "lives":
+		change := int8(min(max(req.Change, -128), 127))
+		return []byte{'e', 'l', byte(change)}, nil
+	case "respawn":
+		color := slices.Index(ghostColorNames[:], req.Ghost)
+		if color < 0 {
+			return nil, fmt.Errorf("unknown ghost \"%s\"", req.Ghost)
+		}
+		return []byte{'e', 'g', byte(color)}, nil
+	case "teleport":
+		return []byte{'e', 't', byte(req.Row), byte(req.Col)}, nil
+	case "end":
+		return []byte{'e', 'e'}, nil
+	case "gate":
+		if req.Gate < -1 || req.Gate > 254 {
+			return nil, fmt.Errorf("invalid gate %d", req.Gate)
+		}
+		var open byte = 0
+		if req.Open {
+			open = 1
+		}
+		return []byte{'e', 'w', byte(req.Gate), open}, nil
+	case "note":
+		if req.Text == "" {
+			return nil, fmt.Errorf("empty note")
+		}
+		return append([]byte{'e', 'n'}, req.Text...), nil
+	}
+	return nil, fmt.Errorf("unknown action \"%s\"", req.Action)
+}
+
+// Send a referee command to the game of a room (POST /admin/referee)
+func RefereeHandler(w http.ResponseWriter, r *http.Request) {
+
+	// Only commands are allowed
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+
+	// Only referees can correct the game
+	ip, client, wb, ok := authorizeLobbyRequest(w, r)
+	if !ok {
+		return
+	}
+
+	// Read the command
+	var req refereeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest,
+			"invalid referee command: "+err.Error())
+		return
+	}
+	cmd, err := req.command()
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Send it to the room's game engine
+	if !wb.sendCommand(cmd) {
+		writeJSONError(w, http.StatusServiceUnavailable,
+			"game engine not running")
+		return
+	}
+	slog.Info("Referee request", "ip", ip, "client", client, "room", wb.room,
+		"action", req.Action)
+
+	// Ending the game ends the match, if there is one
+	if req.Action == "end" && wb.getMatch() != nil {
+		wb.endMatch()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
@@ -0,0 +1,253 @@
+package webserver
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+/*
+The REST API mirrors a subset of the websocket protocol over plain HTTP, for
+scoreboards and simple tools that would rather not maintain a socket:
+
+	GET  /state         - the latest game state (as JSON by default, or in
+	                      another format with "?format=binary" or "?format=proto")
+	GET  /score         - the score, level, lives, and lifecycle, as JSON
+	GET  /frame.png     - the latest game state as an image (or as SVG, with
+	                      /frame.svg, frame.go)
+	GET  /path          - a shortest path between two cells (path_query.go)
+	GET  /predict       - the ghosts' cells over the next few steps
+	                      (ghost_prediction.go)
+	POST /admin/pause   - pause the game (trusted clients only)
+	POST /admin/play    - resume the game (trusted clients only)
+	POST /admin/reset   - restart the game (trusted clients only)
+	GET  /admin/clients - the connected websocket clients, with the time each
+	                      was last seen (referees only)
+	/admin/dashboard    - everything a referee's dashboard shows, with its
+	                      controls (referees only, dashboard.go)
+	/admin/match        - set up and start matches (referees only, lobby.go)
+	GET  /admin/results - list and download the result files of past games
+	                      (referees only, results.go)
+	GET  /scores        - the best match results, across all rooms
+	                      (scoreboard.go)
+
+Each request applies to the default room, or the one given by "?room=<name>"
+(rooms.go)
+*/
+
+// Write an error response as JSON
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+/*
+Look up the room that a request asked for, writing an error response if it
+doesn't exist
+*/
+func requestRoomOrError(w http.ResponseWriter, r *http.Request) *WebBroker {
+	wb, err := requestRoom(r)
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, err.Error())
+	}
+	return wb
+}
+
+// Get the latest game state as JSON, writing an error response if it fails
+func latestStateJSON(w http.ResponseWriter, wb *WebBroker) []byte {
+
+	// Check that there is a state to serve
+	state := wb.latestState()
+	if state == nil {
+		writeJSONError(w, http.StatusServiceUnavailable, "no game state yet")
+		return nil
+	}
+	if stateEncoders[formatJSON] == nil {
+		writeJSONError(w, http.StatusNotImplemented, "JSON is unavailable")
+		return nil
+	}
+
+	// Convert it to JSON
+	out, err := stateEncoders[formatJSON](state)
+	if err != nil {
+		slog.Error("Game state encoding error", "format", "json", "err", err)
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return nil
+	}
+	return out
+}
+
+// Serve the latest game state (GET /state)
+func StateHandler(w http.ResponseWriter, r *http.Request) {
+
+	// Only reads are allowed
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	wb := requestRoomOrError(w, r)
+	if wb == nil {
+		return
+	}
+
+	// JSON is served by default
+	format := r.URL.Query().Get("format")
+	if format == "" || format == formatNames[formatJSON] {
+		if out := latestStateJSON(w, wb); out != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(out)
+		}
+		return
+	}
+
+	// Otherwise, only the binary and protobuf formats are available
+	if format != formatNames[formatBinary] && format != formatNames[formatProto] {
+		writeJSONError(w, http.StatusBadRequest, "unknown format")
+		return
+	}
+	state := wb.latestState()
+	if state == nil {
+		writeJSONError(w, http.StatusServiceUnavailable, "no game state yet")
+		return
+	}
+
+	// Convert the state to protobuf, if requested
+	if format == formatNames[formatProto] {
+		if stateEncoders[formatProto] == nil {
+			writeJSONError(w, http.StatusNotImplemented, "protobuf is unavailable")
+			return
+		}
+		var err error
+		if state, err = stateEncoders[formatProto](state); err != nil {
+			slog.Error("Game state encoding error", "format", "proto", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(state)
+}
+
+// Serve the score and related fields of the latest game state (GET /score)
+func ScoreHandler(w http.ResponseWriter, r *http.Request) {
+
+	// Only reads are allowed
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+
+	wb := requestRoomOrError(w, r)
+	if wb == nil {
+		return
+	}
+
+	// Pick out the fields from the full state
+	out := latestStateJSON(w, wb)
+	if out == nil {
+		return
+	}
+	var score struct {
+		Ticks     uint16 `json:"ticks"`
+		Score     uint16 `json:"score"`
+		Level     uint8  `json:"level"`
+		Lives     uint8  `json:"lives"`
+		Lifecycle string `json:"lifecycle,omitempty"`
+	}
+	json.Unmarshal(out, &score)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(score)
+}
+
+/*
+Authorize an admin request, writing an error response if the client is not
+trusted - returns the client's IP address, name, and role
+*/
+func authorizeAdminRequest(w http.ResponseWriter,
+	r *http.Request) (string, string, clientRole, bool) {
+	ip := ipFromAddr(r.RemoteAddr)
+	client, role, err := authorizeClient(ip, requestToken(r))
+	if err != nil {
+		slog.Warn("Rejected admin request with an invalid token",
+			"ip", ip, "path", r.URL.Path, "err", err)
+		writeJSONError(w, http.StatusUnauthorized, err.Error())
+		return ip, client, role, false
+	}
+	if role == roleSpectator {
+		slog.Warn("Rejected admin request from an untrusted client",
+			"ip", ip, "path", r.URL.Path)
+		writeJSONError(w, http.StatusForbidden, "untrusted client")
+		return ip, client, role, false
+	}
+	return ip, client, role, true
+}
+
+/*
+Create a handler which sends a command to the game engine, on behalf of a
+trusted client (POST /admin/...)
+*/
+func AdminHandler(cmd []byte) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		// Only commands are allowed
+		if r.Method != http.MethodPost {
+			writeJSONError(w, http.StatusMethodNotAllowed, "use POST")
+			return
+		}
+
+		// Only authorized clients can send commands (auth.go)
+		ip, client, role, ok := authorizeAdminRequest(w, r)
+		if !ok {
+			return
+		}
+		if err := role.canSend(cmd); err != nil {
+			slog.Warn("Rejected admin request from an unauthorized client",
+				"ip", ip, "client", client, "path", r.URL.Path, "err", err)
+			writeJSONError(w, http.StatusForbidden, err.Error())
+			return
+		}
+
+		// Send the command to the room's game engine, as if it came from a
+		// websocket client (the game engine must be running to receive it)
+		wb := requestRoomOrError(w, r)
+		if wb == nil {
+			return
+		}
+		if !wb.sendCommand(cmd) {
+			writeJSONError(w, http.StatusServiceUnavailable,
+				"game engine not running")
+			return
+		}
+		slog.Info("Admin command", "ip", ip, "client", client,
+			"room", wb.room, "path", r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	}
+}
+
+// List the connected websocket clients (GET /admin/clients)
+func ClientsHandler(w http.ResponseWriter, r *http.Request) {
+
+	// Only reads are allowed
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+
+	// Only referees can see the clients
+	_, _, role, ok := authorizeAdminRequest(w, r)
+	if !ok {
+		return
+	}
+	if role != roleReferee {
+		writeJSONError(w, http.StatusForbidden, "referees only")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"clients": webClientsInfo()})
+}
@@ -0,0 +1,126 @@
+package webserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+/*
+The game engine writes a result file for the judges after each game, as JSON
+and as CSV (see game/results.go). Judges (referees) can list and download them
+through the REST API:
+
+	GET /admin/results        - the room's result files, newest first, as a
+	                            JSON array of {"name", "size", "modified"}
+	GET /admin/results/<name> - download a result file
+
+Each request applies to the default room, or the one given by "?room=<name>"
+(rooms.go)
+*/
+
+// The directory that the game engine writes results to (empty if disabled)
+var resultsDir string = ""
+
+// Configure the directory that the game engine writes results to
+func ConfigResultsDir(_resultsDir string) {
+	resultsDir = _resultsDir
+}
+
+// A result file, as listed
+type resultFile struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// Content types of the result file formats, by extension
+var resultContentTypes = map[string]string{
+	".json": "application/json",
+	".csv":  "text/csv",
+}
+
+/*
+Get the directory that a room's results are written to - the default room uses
+the results directory itself, as in the game engine (game/game_rules.go)
+*/
+func (wb *WebBroker) resultsDir() string {
+	if wb.room == "" {
+		return resultsDir
+	}
+	return filepath.Join(resultsDir, filepath.Base(wb.room))
+}
+
+// Check whether a file name is one of the game engine's result files
+func isResultFile(name string) bool {
+	_, ok := resultContentTypes[filepath.Ext(name)]
+	return ok && strings.HasPrefix(name, "result_") &&
+		filepath.Base(name) == name
+}
+
+// List or download the result files of a room (/admin/results)
+func ResultsHandler(w http.ResponseWriter, r *http.Request) {
+
+	// Only reads are allowed
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+
+	// Only referees can read the results
+	_, _, wb, ok := authorizeLobbyRequest(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	if resultsDir == "" {
+		writeJSONError(w, http.StatusNotImplemented, "results are disabled")
+		return
+	}
+	dir := wb.resultsDir()
+
+	// A name after the path downloads that file
+	name := strings.TrimPrefix(r.URL.Path, "/admin/results")
+	if name = strings.TrimPrefix(name, "/"); name != "" {
+		if !isResultFile(name) {
+			writeJSONError(w, http.StatusNotFound, "no such result")
+			return
+		}
+		out, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			writeJSONError(w, http.StatusNotFound, "no such result")
+			return
+		}
+		w.Header().Set("Content-Type", resultContentTypes[filepath.Ext(name)])
+		w.Header().Set("Content-Disposition",
+			"attachment; filename=\""+name+"\"")
+		w.Write(out)
+		return
+	}
+
+	// Otherwise, list the room's result files (none until a game ends)
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	files := make([]resultFile, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !entry.Type().IsRegular() ||
+			!isResultFile(entry.Name()) {
+			continue
+		}
+		files = append(files, resultFile{Name: entry.Name(),
+			Size: info.Size(), Modified: info.ModTime()})
+	}
+	slices.SortFunc(files, func(a, b resultFile) int {
+		return strings.Compare(b.Name, a.Name) // Named after the time
+	})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(files)
+}
@@ -0,0 +1,95 @@
+package webserver
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+Each client is given a role, which decides which commands it can send:
+
+	spectator - can only watch the game (clients without a token)
+	bot       - can move Pacman (directions and absolute positions), and
+	            report telemetry (telemetry.go)
+	referee   - can send any command (pause, play, reset, etc.)
+	tracker   - can report the robot's position (localization.go)
+
+The role of a pre-shared token is set next to it under AuthTokens in
+config.json, and the role of a JWT by its "role" claim - either defaults to
+bot. When authentication is disabled, trusted client IPs act as referees.
+Commands that a client's role does not allow are rejected with an error.
+*/
+
+// Enum-like declaration to hold the client roles
+type clientRole uint8
+
+const (
+	roleSpectator clientRole = 0
+	roleBot       clientRole = 1
+	roleReferee   clientRole = 2
+	roleTracker   clientRole = 3
+	numRoles      clientRole = 4
+)
+
+// Names of the client roles (as given in the configuration and tokens)
+var roleNames [numRoles]string = [...]string{
+	"spectator",
+	"bot",
+	"referee",
+	"tracker",
+}
+
+// Get the name of a role
+func (role clientRole) String() string {
+	if role < numRoles {
+		return roleNames[role]
+	}
+	return fmt.Sprint(uint8(role))
+}
+
+// Look up a role by its name (with "admin" as an alias of referee)
+func parseRole(name string) (clientRole, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	switch name {
+	case "":
+		return roleBot, nil
+	case "admin":
+		return roleReferee, nil
+	}
+	for role := roleSpectator; role < numRoles; role++ {
+		if name == roleNames[role] {
+			return role, nil
+		}
+	}
+	return roleSpectator, fmt.Errorf("unknown role \"%s\"", name)
+}
+
+// The commands that bots can send (moving Pacman), by their first byte
+var botCommands = map[byte]struct{}{
+	'w': {}, // Move up
+	'a': {}, // Move left
+	's': {}, // Move down
+	'd': {}, // Move right
+	'x': {}, // Absolute position (from tracking)
+	'T': {}, // Telemetry (telemetry.go)
+}
+
+// Check whether a role is allowed to send a given byte command
+func (role clientRole) canSend(cmd []byte) error {
+	if len(cmd) == 0 {
+		return nil
+	}
+	switch role {
+	case roleReferee:
+		return nil
+	case roleBot:
+		if _, ok := botCommands[cmd[0]]; ok {
+			return nil
+		}
+	case roleTracker:
+		if _, ok := trackerCommands[cmd[0]]; ok {
+			return nil
+		}
+	}
+	return fmt.Errorf("command '%c' is not allowed for role %s", cmd[0], role)
+}
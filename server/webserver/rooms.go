@@ -0,0 +1,64 @@
+package webserver
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+/*
+A server can host several independent games at once, each in its own room
+with its own game engine and web broker. Clients pick a room by name with
+"?room=<name>" (websocket, REST, and SSE) or the "room" metadata (gRPC) - the
+default room ("") is used if none is given. The TCP and UDP outputs always
+follow the default room.
+*/
+
+// The web brokers of the rooms, by room name
+var rooms = make(map[string]*WebBroker)
+
+// Mutex to protect the rooms
+var muRooms sync.RWMutex
+
+// Register a room's web broker, so that clients can join the room
+func registerRoom(wb *WebBroker) {
+	muRooms.Lock()
+	{
+		rooms[wb.room] = wb
+	}
+	muRooms.Unlock()
+}
+
+// Look up a room's web broker by name (nil if there is no such room)
+func getRoom(room string) *WebBroker {
+	muRooms.RLock()
+	defer muRooms.RUnlock()
+	return rooms[room]
+}
+
+// Get the names of all rooms, in order (the default room first)
+func RoomNames() []string {
+	muRooms.RLock()
+	names := make([]string, 0, len(rooms))
+	for room := range rooms {
+		names = append(names, room)
+	}
+	muRooms.RUnlock()
+	sort.Strings(names)
+	return names
+}
+
+// Look up the room that a client asked for, returning an error if it is unknown
+func findRoom(room string) (*WebBroker, error) {
+	wb := getRoom(room)
+	if wb == nil {
+		return nil, fmt.Errorf("unknown room %q", room)
+	}
+	return wb, nil
+}
+
+// Look up the room that an HTTP request asked for ("?room=<name>")
+func requestRoom(r *http.Request) (*WebBroker, error) {
+	return findRoom(r.URL.Query().Get("room"))
+}
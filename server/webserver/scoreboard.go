@@ -0,0 +1,278 @@
+package webserver
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/*
+The scoreboard keeps the results of every match in an embedded database (a
+BoltDB file, see ScoreboardFile), so that they outlive the server and can be
+shown on the venue's scoreboard. Whenever a game finishes while a match is
+started in its room (lobby.go), the match's result is recorded: the team name,
+final score, level reached, pellets eaten, and how long the match took (from
+the referee starting it to the game ending). The results are served through
+the REST API, to anyone:
+
+	GET /scores       - the best results, highest score first (ties go to
+	                    whoever reached the score first), optionally only
+	                    those played in a room or on a maze, with
+	                    "?room=<name>" or "?maze=<maze profile>"
+	GET /scores/teams - each team's best result, highest score first
+
+Each takes "?limit=<count>" (10 by default, at most 100). The results are kept
+in one bucket, keyed by the order they were recorded in, and indexed by score
+in another, so that the best ones can be read off in order.
+*/
+
+// A match result, as recorded on the scoreboard
+type matchResult struct {
+	ID       uint64    `json:"id"`
+	Team     string    `json:"team"`
+	Room     string    `json:"room,omitempty"`
+	Maze     string    `json:"maze,omitempty"`
+	Score    uint16    `json:"score"`
+	Level    uint8     `json:"level"`
+	Pellets  uint16    `json:"pellets"`
+	Duration float64   `json:"duration"` // Seconds, from the start of the match
+	PlayedAt time.Time `json:"playedAt"`
+}
+
+// Names of the buckets holding the results, and the index by score
+var (
+	resultsBucket = []byte("results")
+	scoresBucket  = []byte("scores")
+)
+
+// The number of results served by default, and at most
+const (
+	defaultScoresLimit = 10
+	maxScoresLimit     = 100
+)
+
+// The scoreboard database (nil if the scoreboard is disabled)
+var scoreboardDB *bolt.DB = nil
+
+// Mutex accompanying the above variable
+var muScoreboard sync.RWMutex
+
+/*
+Configure the file to keep the scoreboard in (empty to disable the scoreboard)
+- the file is created if it doesn't exist yet
+*/
+func ConfigScoreboard(path string) {
+	if path == "" {
+		return
+	}
+
+	// Open the database, without waiting on another server that has it open
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		slog.Error("Scoreboard error", "path", path, "err", err)
+		return
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{resultsBucket, scoresBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		slog.Error("Scoreboard error", "path", path, "err", err)
+		return
+	}
+
+	muScoreboard.Lock()
+	{
+		scoreboardDB = db
+	}
+	muScoreboard.Unlock()
+	slog.Info("Keeping the scoreboard", "path", path)
+}
+
+// Close the scoreboard database, if it is open (when the server quits)
+func CloseScoreboard() {
+	muScoreboard.Lock()
+	defer muScoreboard.Unlock()
+	if scoreboardDB != nil {
+		scoreboardDB.Close()
+		scoreboardDB = nil
+	}
+}
+
+// Getter method for the scoreboard database (nil if it is disabled)
+func getScoreboard() *bolt.DB {
+	muScoreboard.RLock()
+	defer muScoreboard.RUnlock()
+	return scoreboardDB
+}
+
+/*
+Get the index key of a result: its score, inverted so that the highest score
+comes first, followed by its ID
+*/
+func scoreKey(score uint16, id uint64) []byte {
+	key := make([]byte, 10)
+	binary.BigEndian.PutUint16(key, math.MaxUint16-score)
+	binary.BigEndian.PutUint64(key[2:], id)
+	return key
+}
+
+/*
+Record the result of the room's match, if one is started, from the details of
+its game's "game_over" event (writing it to disk in the background)
+*/
+func (wb *WebBroker) recordResult(details map[string]any) {
+
+	// Only games played in a started match are recorded
+	db := getScoreboard()
+	match := wb.getMatch()
+	if db == nil || match == nil || match.Status != matchStarted {
+		return
+	}
+	result := matchResult{
+		Team:     match.Team,
+		Room:     match.Room,
+		Maze:     match.Maze,
+		PlayedAt: time.Now().UTC(),
+	}
+	if result.Team == "" {
+		result.Team = match.Pacman
+	}
+	if match.StartedAt != nil {
+		duration := time.Since(*match.StartedAt).Seconds()
+		result.Duration = math.Round(duration*10) / 10
+	}
+	result.Score, _ = details["score"].(uint16)
+	result.Level, _ = details["level"].(uint8)
+	result.Pellets, _ = details["pellets"].(uint16)
+	go storeResult(db, result)
+}
+
+// Store a match result, indexing it by score - should be launched as a go-routine
+func storeResult(db *bolt.DB, result matchResult) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		results := tx.Bucket(resultsBucket)
+		id, err := results.NextSequence()
+		if err != nil {
+			return err
+		}
+		result.ID = id
+		value, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		idKey := binary.BigEndian.AppendUint64(nil, id)
+		if err := results.Put(idKey, value); err != nil {
+			return err
+		}
+		return tx.Bucket(scoresBucket).Put(scoreKey(result.Score, id), idKey)
+	})
+	if err != nil {
+		slog.Error("Scoreboard error", "err", err)
+		return
+	}
+	slog.Info("Match result recorded", "room", result.Room, "team", result.Team,
+		"score", result.Score, "level", result.Level)
+}
+
+/*
+Get the best results, highest score first, keeping only those that a filter
+accepts (up to the limit)
+*/
+func bestResults(db *bolt.DB, limit int,
+	keep func(result *matchResult) bool) ([]matchResult, error) {
+	out := make([]matchResult, 0, limit)
+	err := db.View(func(tx *bolt.Tx) error {
+		results := tx.Bucket(resultsBucket)
+		cursor := tx.Bucket(scoresBucket).Cursor()
+		for key, idKey := cursor.First(); key != nil; key, idKey = cursor.Next() {
+			if len(out) == limit {
+				break
+			}
+			var result matchResult
+			if err := json.Unmarshal(results.Get(idKey), &result); err != nil {
+				return fmt.Errorf("result %x: %v", idKey, err)
+			}
+			if keep(&result) {
+				out = append(out, result)
+			}
+		}
+		return nil
+	})
+	return out, err
+}
+
+// Serve the best match results (GET /scores)
+func ScoresHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	room, maze := query.Get("room"), query.Get("maze")
+	serveResults(w, r, func(result *matchResult) bool {
+		return (room == "" || result.Room == room) &&
+			(maze == "" || result.Maze == maze)
+	})
+}
+
+// Serve each team's best match result (GET /scores/teams)
+func TeamScoresHandler(w http.ResponseWriter, r *http.Request) {
+
+	// Results are read best first, so only each team's first one is kept
+	seen := make(map[string]bool)
+	serveResults(w, r, func(result *matchResult) bool {
+		if seen[result.Team] {
+			return false
+		}
+		seen[result.Team] = true
+		return true
+	})
+}
+
+// Serve the best match results that a filter accepts, as JSON
+func serveResults(w http.ResponseWriter, r *http.Request,
+	keep func(result *matchResult) bool) {
+
+	// Only reads are allowed
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	db := getScoreboard()
+	if db == nil {
+		writeJSONError(w, http.StatusNotImplemented, "scoreboard is disabled")
+		return
+	}
+
+	// Read the limit, if one is given
+	limit := defaultScoresLimit
+	if param := r.URL.Query().Get("limit"); param != "" {
+		var err error
+		if limit, err = strconv.Atoi(param); err != nil || limit < 1 ||
+			limit > maxScoresLimit {
+			writeJSONError(w, http.StatusBadRequest,
+				fmt.Sprintf("limit must be between 1 and %d", maxScoresLimit))
+			return
+		}
+	}
+
+	results, err := bestResults(db, limit, keep)
+	if err != nil {
+		slog.Error("Scoreboard error", "err", err)
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
@@ -0,0 +1,119 @@
+package webserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+/*
+Clients that can send commands are given a session token when they connect,
+so that a robot whose wifi blips can reconnect and resume control without
+being re-authorized (e.g. if its IP address changed, or its token was only
+entered once by hand). The token is sent in the "Pacbot-Session" header of the
+websocket upgrade response, and to pacbot.v1 clients as a message of type 't'.
+To resume, a client reconnects with the token within the grace window:
+
+	ws://localhost:3002/?session=<session token>
+
+The resumed connection keeps the client's name and role (and receives a fresh
+keyframe, like any new connection). If the old connection is still open, it
+is closed. Session tokens expire once their client has been disconnected for
+longer than the grace window.
+*/
+
+// Message type for session tokens (server -> client)
+const msgSession byte = 't'
+
+// Time that a disconnected client's session can still be resumed (0 = never)
+var sessionGrace time.Duration = 0
+
+// Set the grace window for resuming sessions, in seconds
+func ConfigSessionResume(grace float64) {
+	sessionGrace = time.Duration(max(grace, 0) * float64(time.Second))
+}
+
+// A session that a client can resume
+type resumableSession struct {
+	client  string
+	role    clientRole
+	ws      *webSession // Current connection (nil while disconnected)
+	expires time.Time   // Time the session expires (while disconnected)
+}
+
+// Resumable sessions, by their tokens
+var resumableSessions = make(map[string]*resumableSession)
+
+// Protects the "resumableSessions" map from race conditions
+var muRS sync.Mutex
+
+// Generate a new random session token
+func newSessionToken() string {
+	var buf [16]byte
+	rand.Read(buf[:])
+	return hex.EncodeToString(buf[:])
+}
+
+/*
+Look up a session that a client wants to resume - returns the client's name
+and role, and false if the session can't be resumed
+*/
+func findSession(token string) (string, clientRole, bool) {
+	if token == "" || sessionGrace <= 0 {
+		return "", roleSpectator, false
+	}
+	muRS.Lock()
+	defer muRS.Unlock()
+	session, ok := resumableSessions[token]
+	if !ok || (session.ws == nil && time.Now().After(session.expires)) {
+		return "", roleSpectator, false
+	}
+	return session.client, session.role, true
+}
+
+/*
+Attach a web session to its session token (closing any older connection with
+the same token), so that the session can be resumed later
+*/
+func (ws *webSession) claimSession() {
+	if ws.sessionToken == "" {
+		return
+	}
+	muRS.Lock()
+	defer muRS.Unlock()
+
+	// Forget any sessions that have expired
+	now := time.Now()
+	for token, session := range resumableSessions {
+		if session.ws == nil && now.After(session.expires) {
+			delete(resumableSessions, token)
+		}
+	}
+
+	// Take over the session, closing the old connection if it is still open
+	session, ok := resumableSessions[ws.sessionToken]
+	if !ok {
+		session = &resumableSession{client: ws.client, role: ws.role}
+		resumableSessions[ws.sessionToken] = session
+	} else if session.ws != nil && session.ws != ws {
+		slog.Info("Closing the previous connection of a resumed session",
+			"ip", getIP(session.ws.conn), "client", session.client)
+		session.ws.quit()
+	}
+	session.ws = ws
+}
+
+// Detach a web session from its session token, starting the grace window
+func (ws *webSession) releaseSession() {
+	if ws.sessionToken == "" {
+		return
+	}
+	muRS.Lock()
+	defer muRS.Unlock()
+	if session, ok := resumableSessions[ws.sessionToken]; ok && session.ws == ws {
+		session.ws = nil
+		session.expires = time.Now().Add(sessionGrace)
+	}
+}
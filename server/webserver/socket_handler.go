@@ -1,8 +1,9 @@
 package webserver
 
 import (
-	"log"
+	"log/slog"
 	"net/http"
+	"strings"
 	"sync"
 
 	"github.com/gorilla/websocket"
@@ -27,6 +28,27 @@ var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool {
 		return true // Allow all clients to connect
 	},
+	Subprotocols: supportedProtocols, // Protocol versions (protocol.go)
+}
+
+// The compression level for clients that negotiate permessage-deflate
+var compressionLevel int = 1
+
+/*
+Messages shorter than this aren't worth compressing (e.g. deltas), since the
+deflate framing would outweigh the savings
+*/
+const compressionThreshold = 128
+
+/*
+Set whether websocket messages can be compressed (permessage-deflate), for
+clients that ask for it, and at which level (1 = fastest, 9 = smallest)
+*/
+func ConfigCompression(enabled bool, level int) {
+	upgrader.EnableCompression = enabled
+	if level >= 1 && level <= 9 {
+		compressionLevel = level
+	}
 }
 
 /*
@@ -35,16 +57,90 @@ all communication goes smoothly.
 */
 func WebSocketHandler(w http.ResponseWriter, r *http.Request) {
 
+	// Decide which protocol version to speak, rejecting unsupported clients
+	version, ok := negotiateProtocol(r)
+	if !ok {
+		slog.Warn("Rejected client with an unsupported protocol version",
+			"offered", websocket.Subprotocols(r))
+		http.Error(w, "Unsupported protocol version (supported: "+
+			strings.Join(supportedProtocols, ", ")+")",
+			http.StatusUpgradeRequired)
+		return
+	}
+
+	// Find the room the client asked for (rooms.go)
+	broker, err := requestRoom(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	// Decide whether the client can send commands, rejecting invalid tokens
+	// (auth.go)
+	ip := ipFromAddr(r.RemoteAddr)
+	client, role, err := authorizeClient(ip, requestToken(r))
+	if err != nil {
+		slog.Warn("Rejected client with an invalid token", "ip", ip, "err", err)
+		http.Error(w, "Authentication failed: "+err.Error(),
+			http.StatusUnauthorized)
+		return
+	}
+
+	// Check that the client can play the ghost it asked for, if any
+	// (ghost_players.go)
+	ghost, status, err := broker.requestGhost(r, role)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	// Resume a previous session, if the client has a valid session token, or
+	// start a new one if the client can send commands (session_resume.go)
+	sessionToken := r.URL.Query().Get("session")
+	if resumedClient, resumedRole, ok := findSession(sessionToken); ok {
+		client, role = resumedClient, resumedRole
+		slog.Info("Client resumed its session", "ip", ip, "client", client)
+	} else if role != roleSpectator && sessionGrace > 0 {
+		sessionToken = newSessionToken()
+	} else {
+		sessionToken = ""
+	}
+	header := http.Header{}
+	if sessionToken != "" {
+		header.Set("Pacbot-Session", sessionToken)
+	}
+
 	// Upgrades the connection, and quits if it didn't work out.
-	conn, err := upgrader.Upgrade(w, r, nil)
+	conn, err := upgrader.Upgrade(w, r, header)
 	if err != nil {
-		log.Println("websocket err:", err)
+		slog.Error("Websocket upgrade error", "err", err)
 		return
 	}
 
+	// Set the compression level (only used if the client negotiated it)
+	conn.SetCompressionLevel(compressionLevel)
+
 	// Create a websocket session object
-	ws := newWebSession(conn)
-	
+	ws := newWebSession(conn, broker, version, negotiateFormat(r, version))
+	ws.readEn = role != roleSpectator
+	ws.client = client
+	ws.role = role
+	ws.sessionToken = sessionToken
+	if role == roleSpectator {
+		_, ws.stateInterval = requestRate(r, spectatorRate) // (spectator.go)
+	}
+
+	// Claim the ghost, in case another client claimed it in the meantime
+	if ghost != noGhost {
+		if !ws.claimGhost(ghost) {
+			ws.writeMessage(msgError, []byte("the ghost was claimed by "+
+				"another client"))
+			conn.Close()
+			return
+		}
+		defer ws.releaseGhost()
+	}
+
 	// Ensure we wait for clients to finish
 	wgQuit.Add(1)
 	defer wgQuit.Done()
@@ -58,5 +154,20 @@ func WebSocketHandler(w http.ResponseWriter, r *http.Request) {
 	*/
 	defer ws.unregister()
 
+	// Let the client know its session token, so it can resume the session
+	if sessionToken != "" {
+		ws.claimSession()
+		defer ws.releaseSession()
+		ws.writeMessage(msgSession, []byte(sessionToken))
+	}
+
+	// Let the client know the arena's calibration, if there is one (arena.go)
+	ws.sendArena()
+
+	// Let the client know about the room's match, if one is set up (lobby.go)
+	if match := broker.getMatch(); match != nil && ws.taggedMessages() {
+		ws.writeMessage(msgMatch, match.encode())
+	}
+
 	ws.loop()
 }
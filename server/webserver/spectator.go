@@ -0,0 +1,64 @@
+package webserver
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+/*
+Spectators (clients that can't send commands) rarely need every state, so to
+scale to many viewers, the web broker downsamples their broadcasts to at most
+SpectatorRate states per second - bots and referees still receive every state.
+Spectators can ask for an even lower rate with "?rate=<states per second>".
+Spectators receiving deltas are sent a keyframe after each skipped state, since
+their last state is no longer the one the delta is taken against.
+*/
+
+// The highest rate of states sent to each spectator (states per second)
+var spectatorRate float64 = 0
+
+// Set the highest rate of states sent to each spectator (0 for every state)
+func ConfigSpectatorRate(_spectatorRate float64) {
+	spectatorRate = _spectatorRate
+}
+
+/*
+Decide the rate of states for a client, given the highest rate allowed (0 for
+every state) - clients can only lower the rate with "?rate=<states per
+second>". Returns the rate, and the shortest time between states
+*/
+func requestRate(r *http.Request, maxRate float64) (float64, time.Duration) {
+	rate := maxRate
+	if reqRate, err := strconv.ParseFloat(r.URL.Query().Get("rate"), 64); err == nil &&
+		reqRate > 0 && (rate <= 0 || reqRate < rate) {
+		rate = reqRate
+	}
+	var interval time.Duration = 0
+	if rate > 0 {
+		interval = time.Duration(float64(time.Second) / rate)
+	}
+	return rate, interval
+}
+
+/*
+Check whether a state is due to be sent to this session, scheduling the next
+one if so (web broker only) - states are scheduled a fixed interval apart,
+rather than an interval after the last one sent, so that the rate isn't
+lowered by the states falling between the frames of the game engine
+*/
+func (ws *webSession) stateDue(now time.Time) bool {
+	if ws.stateInterval == 0 {
+		return true
+	}
+	if now.Sub(ws.lastState) < ws.stateInterval {
+		return false
+	}
+
+	// Schedule the next state, unless the session has fallen behind
+	ws.lastState = ws.lastState.Add(ws.stateInterval)
+	if now.Sub(ws.lastState) >= ws.stateInterval {
+		ws.lastState = now
+	}
+	return true
+}
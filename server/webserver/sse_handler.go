@@ -0,0 +1,114 @@
+package webserver
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+/*
+The Server-Sent Events stream (GET /events) sends the game state as JSON to
+scoreboard overlays and web dashboards that only need to watch the game, as
+"state" events at a reduced rate:
+
+	event: state
+	data: {"ticks": 1234, "score": 560, ...}
+
+Clients can ask for an even lower rate with "?rate=<states per second>", and
+watch another room with "?room=<name>" (rooms.go). Emergency stops are sent
+straight away, as "estop" events (estop.go).
+*/
+
+// The highest rate of states sent to each SSE client (states per second)
+var sseRate float64 = 10
+
+// Set the highest rate of states sent to each SSE client (0 for every state)
+func ConfigSSERate(_sseRate float64) {
+	sseRate = _sseRate
+}
+
+// Stream the game state as Server-Sent Events (GET /events)
+func EventsHandler(w http.ResponseWriter, r *http.Request) {
+
+	// Only reads are allowed
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+
+	// The stream needs to be flushed after every event, and sent as JSON
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSONError(w, http.StatusInternalServerError,
+			"streaming is unsupported")
+		return
+	}
+	if stateEncoders[formatJSON] == nil {
+		writeJSONError(w, http.StatusNotImplemented, "JSON is unavailable")
+		return
+	}
+
+	// Find the room to watch
+	wb := requestRoomOrError(w, r)
+	if wb == nil {
+		return
+	}
+
+	// Decide the time between states (clients can only lower the rate)
+	rate, interval := requestRate(r, sseRate) // (spectator.go)
+
+	// Subscribe to the game state broadcasts
+	states, cancel := wb.subscribeState()
+	defer cancel()
+	stops, cancelStops := wb.subscribeStop()
+	defer cancelStops()
+
+	// Start the stream
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	ip := ipFromAddr(r.RemoteAddr)
+	slog.Info("SSE client connected", "ip", ip, "room", wb.room,
+		"rate", rate)
+	defer slog.Info("SSE client disconnected", "ip", ip)
+
+	// Send states until the client leaves
+	var lastSent time.Time
+	for {
+		select {
+		case state := <-states:
+
+			// Skip states that come too soon after the last one
+			now := time.Now()
+			if now.Sub(lastSent) < interval {
+				continue
+			}
+
+			// Send the state as JSON
+			out, err := stateEncoders[formatJSON](state)
+			if err != nil {
+				slog.Error("Game state encoding error", "format", "json",
+					"err", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: state\ndata: %s\n\n", out); err != nil {
+				return
+			}
+			flusher.Flush()
+			lastSent = now
+
+		// Send emergency stops straight away (estop.go)
+		case stop := <-stops:
+			if _, err := fmt.Fprintf(w, "event: estop\ndata: %s\n\n", stop); err != nil {
+				return
+			}
+			flusher.Flush()
+
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package webserver
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+/*
+Each broadcast is copied once into a pooled frame, tagged as a state message
+('s'), and the same immutable frame is queued for every session that receives
+the binary state - untagged for legacy sessions, and tagged for pacbot.v1
+sessions and delta keyframes. Each queued copy holds a reference to the frame,
+which the session's send loop drops once the message is written, and the last
+reference returns the frame to the pool, so that busy rooms don't allocate a
+new state message per client per tick.
+
+The game engine's own buffer is handed back to it for re-use once the broker
+is done with it (see game/broadcast_buffers.go). Frames that are never released
+(e.g. queued for a session that disconnects) are left to the garbage collector.
+*/
+
+// A broadcast state, shared between the sessions it is queued for
+type stateFrame struct {
+	data []byte       // The state, tagged as a state message
+	refs atomic.Int32 // References held to the frame
+}
+
+// Pool of frames, re-used between broadcasts
+var framePool = sync.Pool{
+	New: func() any { return &stateFrame{} },
+}
+
+// Copy a broadcast state into a frame from the pool (held by the caller)
+func newStateFrame(state []byte) *stateFrame {
+	frame := framePool.Get().(*stateFrame)
+	frame.data = append(append(frame.data[:0], msgState), state...)
+	frame.refs.Store(1)
+	return frame
+}
+
+// Get the state held by a frame (untagged)
+func (frame *stateFrame) state() []byte {
+	return frame.data[1:]
+}
+
+// Get the state held by a frame, tagged as a state message
+func (frame *stateFrame) tagged() []byte {
+	return frame.data
+}
+
+// Drop a reference to a frame, returning it to the pool if it was the last
+func (frame *stateFrame) release() {
+	if frame.refs.Add(-1) == 0 {
+		framePool.Put(frame)
+	}
+}
+
+/*
+A message queued for a web session, along with the frame it is part of (nil if
+it doesn't belong to one)
+*/
+type outgoing struct {
+	msg   []byte
+	frame *stateFrame
+}
+
+// Take a reference to the frame of a queued message (if it has one)
+func (out outgoing) retain() {
+	if out.frame != nil {
+		out.frame.refs.Add(1)
+	}
+}
+
+// Drop the reference to the frame of a queued message (if it has one)
+func (out outgoing) release() {
+	if out.frame != nil {
+		out.frame.release()
+	}
+}
+
+/*
+A function to hand a broadcast buffer back to the room's game engine for
+re-use - set by the main package for each room
+*/
+type BufferRecycler func(buf []byte)
+
+// Set the function to hand broadcast buffers back to the room's game engine
+func (wb *WebBroker) ConfigBufferRecycler(recycler BufferRecycler) {
+	wb.recycler.Store(&recycler)
+}
+
+// Hand a broadcast buffer back to the room's game engine (if it takes them)
+func (wb *WebBroker) recycleBuffer(buf []byte) {
+	if recycler := wb.recycler.Load(); recycler != nil {
+		(*recycler)(buf)
+	}
+}
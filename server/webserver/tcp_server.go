@@ -2,8 +2,10 @@ package webserver
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"io"
-	"log"
+	"log/slog"
 	"net"
 	"strings"
 	"sync"
@@ -21,6 +23,13 @@ Protect important TCP variables from race conditions with a mutex
 */
 var muTcp sync.Mutex
 
+// Get the number of open TCP connections, for health checks
+func NumOpenTCPConns() int {
+	muTcp.Lock()
+	defer muTcp.Unlock()
+	return NumOpenTCPClients
+}
+
 // Keep track of who sent the message, and the content
 type Message struct {
 	from    string
@@ -35,6 +44,7 @@ type TcpServer struct {
 	readCh     chan Message
 	tcpSendCh  <-chan []byte
 	conns      map[net.Conn]struct{}
+	readers    sync.WaitGroup // Read loops that may still send to readCh
 }
 
 // Create a new TCP server, buffering up to 10 messages
@@ -70,20 +80,46 @@ func (s *TcpServer) TcpStart() error {
 	// Block on the quit channel as long as we haven't quit yet
 	<-s.quitCh
 
-	// Close the read channel once we have quit
+	// Stop accepting connections, then close the robots' connections, and the
+	// read channel once their read loops have finished
+	listener.Close()
+	muTcp.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	muTcp.Unlock()
+	s.readers.Wait()
 	close(s.readCh)
 
 	// No errors
 	return nil
 }
 
+// Quit function exported to other packages
+func (s *TcpServer) Quit() {
+	close(s.quitCh)
+}
+
+/*
+Run the TCP server until a context is done (e.g. when the server is
+interrupted), then close the robots' connections
+*/
+func (s *TcpServer) Run(ctx context.Context) error {
+	stop := context.AfterFunc(ctx, s.Quit)
+	defer stop()
+	return s.TcpStart()
+}
+
 // Accept incoming TCP connections
 func (s *TcpServer) tcpAcceptLoop() {
 	for {
 		// Accept an incoming connection request
 		conn, err := s.listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return // The server quit
+		}
 		if err != nil {
-			log.Println("accept error:", err)
+			slog.Error("TCP accept error", "err", err)
 			continue
 		}
 
@@ -91,7 +127,8 @@ func (s *TcpServer) tcpAcceptLoop() {
 		muTcp.Lock()
 		NumOpenTCPClients++
 		s.conns[conn] = struct{}{}
-		log.Printf("\033[32m[%d -> %d] robot connected at %s\033[0m\n", NumOpenTCPClients-1, NumOpenTCPClients, conn.RemoteAddr().String())
+		s.readers.Add(1)
+		slog.Info("Robot connected", "addr", conn.RemoteAddr().String(), "from", NumOpenTCPClients-1, "to", NumOpenTCPClients)
 		muTcp.Unlock()
 		go s.tcpReadLoop(conn)
 	}
@@ -101,12 +138,13 @@ func (s *TcpServer) tcpAcceptLoop() {
 func (s *TcpServer) tcpReadLoop(conn net.Conn) {
 
 	// Close the connection when necessary
+	defer s.readers.Done()
 	defer func() {
 		conn.Close()
 		muTcp.Lock()
 		NumOpenTCPClients--
 		delete(s.conns, conn)
-		log.Printf("\033[31m[%d -> %d] robot quit at %s\033[0m\n", NumOpenTCPClients+1, NumOpenTCPClients, conn.RemoteAddr().String())
+		slog.Info("Robot quit", "addr", conn.RemoteAddr().String(), "from", NumOpenTCPClients+1, "to", NumOpenTCPClients)
 		muTcp.Unlock()
 	}()
 
@@ -121,17 +159,22 @@ func (s *TcpServer) tcpReadLoop(conn net.Conn) {
 				muTcp.Lock()
 				NumOpenTCPClients--
 				delete(s.conns, conn)
-				log.Printf("\033[31m[%d -> %d] robot disconnected at %s\033[0m\n", NumOpenTCPClients+1, NumOpenTCPClients, conn.RemoteAddr().String())
+				slog.Info("Robot disconnected", "addr", conn.RemoteAddr().String(), "from", NumOpenTCPClients+1, "to", NumOpenTCPClients)
 				muTcp.Unlock()
 				return
 			}
 
+			// Stop reading once the connection is closed (by the server quitting)
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+
 			// Handle network operational errors, such as timeouts or connection failures
 			if opErr, ok := err.(*net.OpError); ok {
 				// If it's a timeout, retry a few times (backoff strategy or a simple retry)
 				if opErr.Op == "read" && opErr.Err.Error() == "i/o timeout" {
 					// Timeout error - retry reading a few more times
-					log.Printf("\033[33m[%d] Timeout error with robot at %s. Retrying...\033[0m\n", NumOpenTCPClients, conn.RemoteAddr().String())
+					slog.Warn("Timeout error with robot. Retrying...", "addr", conn.RemoteAddr().String(), "robots", NumOpenTCPClients)
 					continue // Retry the read operation
 				}
 
@@ -142,12 +185,12 @@ func (s *TcpServer) tcpReadLoop(conn net.Conn) {
 				}
 
 				// For other types of net.OpErrors (like connection reset), log the error and keep the connection open
-				log.Printf("\033[31m[%d] Network operation error with robot at %s: %v. Continuing...\033[0m\n", NumOpenTCPClients, conn.RemoteAddr().String(), opErr)
+				slog.Warn("Network operation error with robot. Continuing...", "addr", conn.RemoteAddr().String(), "robots", NumOpenTCPClients, "err", opErr)
 				continue // Keep trying to read
 			}
 
 			// Log any other read errors that aren't EOF or network operation errors
-			log.Println("\tRead error: ", err)
+			slog.Error("TCP read error", "err", err)
 			continue
 		}
 
@@ -177,6 +220,6 @@ func (s *TcpServer) tcpSendLoop() {
 // Print out messages that are received
 func (s *TcpServer) Printer() {
 	for msg := range s.readCh {
-		log.Printf("\033[2m\033[32m| TCP from %s: %s`\033[0m\n", msg.from, string(msg.payload))
+		slog.Debug("TCP message", "from", msg.from, "payload", string(msg.payload))
 	}
 }
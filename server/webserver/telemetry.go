@@ -0,0 +1,66 @@
+package webserver
+
+import (
+	"fmt"
+	"time"
+)
+
+/*
+Robots report their own state for post-match analysis as telemetry: bots (or
+referees) speaking pacbot.v1 send telemetry reports as messages of type 'T':
+
+	battery voltage (4 bytes, float32, in volts)
+	IMU heading (4 bytes, float32, in radians - NaN if there is no IMU)
+	wheel encoder counts (4 bytes each, int32, up to 8)
+
+Reports are passed on to the game engine as a command ('T' followed by the
+same bytes, which legacy clients can send directly), which writes them to the
+event log with the tick they arrived on (see game/telemetry.go). Telemetry
+doesn't count towards the command rate limit, so that it can't crowd out
+moves, but each client's reports are limited to TelemetryRate per second
+(reports that come too soon after the last one are dropped).
+*/
+
+// Message type for telemetry reports (client -> server)
+const msgTelemetry byte = 'T'
+
+// The lengths of a telemetry report, without and with every encoder count
+const (
+	telemetryMinLen = 8
+	telemetryMaxLen = 8 + 4*8
+)
+
+// The highest rate of telemetry reports from each client (reports per second)
+var telemetryRate float64 = 20
+
+// Set the highest rate of telemetry reports from each client (0 for no limit)
+func ConfigTelemetryRate(_telemetryRate float64) {
+	telemetryRate = max(_telemetryRate, 0)
+}
+
+// Convert a telemetry report into a command for the game engine
+func telemetryCommand(report []byte) ([]byte, error) {
+	if len(report) < telemetryMinLen || len(report) > telemetryMaxLen ||
+		len(report)%4 != 0 {
+		return nil, fmt.Errorf("telemetry report must be %d to %d bytes "+
+			"long, in steps of 4", telemetryMinLen, telemetryMaxLen)
+	}
+	return append([]byte{'T'}, report...), nil
+}
+
+/*
+Apply the telemetry rate limit to a report - returns whether to pass it on
+(only called from the read loop)
+*/
+func (ws *webSession) limitTelemetry() bool {
+	if telemetryRate == 0 {
+		return true
+	}
+	now := time.Now()
+	interval := time.Duration(float64(time.Second) / telemetryRate)
+	if now.Sub(ws.lastTelemetry) < interval {
+		return false
+	}
+	ws.lastTelemetry = now
+	return true
+}
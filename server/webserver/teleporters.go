@@ -0,0 +1,72 @@
+package webserver
+
+import (
+	"encoding/json"
+	"log/slog"
+)
+
+/*
+When Pacman or a ghost jumps through a teleporter of the maze (see
+game/teleporters.go), the game engine logs a "teleport" event, and the room's
+pacbot.v1 sessions are told about the jump at once, with a JSON message of
+type 'J' (queued ahead of the next state):
+
+	{"teleporter": 0, "who": "pacman", "fromRow": 5, "fromCol": 1,
+	 "toRow": 26, "toCol": 26, "tick": 1200}
+
+so that visualizers can animate the jump rather than drawing a leap across
+the maze. The teleporters, with their cooldowns, are also sent with each state
+(after the power-ups).
+*/
+
+// Message type for a jump through a teleporter (server -> client)
+const msgTeleport byte = 'J'
+
+// A jump through a teleporter, as announced
+type teleportInfo struct {
+	Teleporter uint8  `json:"teleporter"`
+	Who        string `json:"who"` // "pacman", or the ghost's color
+	FromRow    int8   `json:"fromRow"`
+	FromCol    int8   `json:"fromCol"`
+	ToRow      int8   `json:"toRow"`
+	ToCol      int8   `json:"toCol"`
+	Tick       uint16 `json:"tick"`
+}
+
+// Get a jump through a teleporter from its event's details
+func teleportInfoOf(details map[string]any) teleportInfo {
+	var info teleportInfo
+	info.Teleporter, _ = details["teleporter"].(uint8)
+	info.Who, _ = details["who"].(string)
+	info.FromRow, _ = details["fromRow"].(int8)
+	info.FromCol, _ = details["fromCol"].(int8)
+	info.ToRow, _ = details["toRow"].(int8)
+	info.ToCol, _ = details["toCol"].(int8)
+	info.Tick, _ = details["tick"].(uint16)
+	return info
+}
+
+/*
+Announce a jump through a teleporter to the room's pacbot.v1 sessions, all
+within one pass so that they hear about it at the same time (skipping sessions
+that aren't keeping up)
+*/
+func (wb *WebBroker) announceTeleport(info teleportInfo) {
+	payload, _ := json.Marshal(info)
+	msg := tagMessage(msgTeleport, payload)
+	muOWS.RLock()
+	{
+		for ws := range openWebSessions {
+			if ws.broker != wb || !ws.taggedMessages() {
+				continue
+			}
+			select {
+			case ws.sendCh <- outgoing{msg: msg}:
+			default:
+				slog.Warn("A web-session send channel was full",
+					"client", getIP(ws.conn))
+			}
+		}
+	}
+	muOWS.RUnlock()
+}
@@ -0,0 +1,129 @@
+package webserver
+
+import (
+	"context"
+	"log/slog"
+	"net"
+)
+
+/*
+The UDP broadcaster sends the compact binary state every frame to a list of
+peers (or multicast groups, e.g. "239.0.0.1:5005"), for minimum-latency robot
+control on a LAN. It runs alongside the websocket, which remains the reliable
+channel - packets may be lost or arrive out of order, so each one is prefixed
+with a sequence number (4 bytes, big-endian) that receivers can use to drop
+stale states:
+
+	sequence (4 bytes) - increases by one with every state sent
+	state              - the serialized game state, as sent over the websocket
+*/
+
+// UDP broadcaster, with a connection to each target and a quit channel
+type UdpBroadcaster struct {
+	conns  []*net.UDPConn
+	quitCh chan struct{}
+	seq    uint32
+}
+
+/*
+Create a new UDP broadcaster for a list of targets ("host:port") - targets that
+can't be resolved are skipped, with an error logged
+*/
+func NewUdpBroadcaster(targets []string) *UdpBroadcaster {
+	ub := UdpBroadcaster{
+		quitCh: make(chan struct{}),
+	}
+	for _, target := range targets {
+		addr, err := net.ResolveUDPAddr("udp", target)
+		if err != nil {
+			slog.Error("UDP target error", "target", target, "err", err)
+			continue
+		}
+		conn, err := net.DialUDP("udp", nil, addr)
+		if err != nil {
+			slog.Error("UDP target error", "target", target, "err", err)
+			continue
+		}
+		ub.conns = append(ub.conns, conn)
+		slog.Info("UDP broadcast target added", "target", addr.String(),
+			"multicast", addr.IP.IsMulticast())
+	}
+	return &ub
+}
+
+// Send states to the targets until quitting - should be launched as a go-routine
+func (ub *UdpBroadcaster) RunLoop() {
+
+	// Subscribe to the game state broadcasts of the default room (rooms.go)
+	wb := getRoom("")
+	if wb == nil {
+		slog.Error("UDP broadcaster error", "err", "no default room")
+		return
+	}
+	states, cancel := wb.subscribeState()
+	defer cancel()
+	stops, cancelStops := wb.subscribeStop()
+	defer cancelStops()
+
+	// Close the connections when done
+	defer func() {
+		for _, conn := range ub.conns {
+			conn.Close()
+		}
+	}()
+
+	// Packet buffer, with space for the sequence number
+	packet := make([]byte, 4, 1024)
+
+	for {
+		select {
+		case state := <-states:
+
+			// Prefix the state with the sequence number
+			ub.seq++
+			packet[0], packet[1] = byte(ub.seq>>24), byte(ub.seq>>16)
+			packet[2], packet[3] = byte(ub.seq>>8), byte(ub.seq)
+			packet = append(packet[:4], state...)
+
+			// Send the packet to every target (dropping it if a send fails)
+			for _, conn := range ub.conns {
+				if _, err := conn.Write(packet); err != nil {
+					slog.Debug("UDP send error", "target",
+						conn.RemoteAddr().String(), "err", err)
+				}
+			}
+
+		// Send emergency stops several times, with the reserved sequence
+		// number (estop.go)
+		case stop := <-stops:
+			seq := udpEStopSeq
+			packet[0], packet[1] = byte(seq>>24), byte(seq>>16)
+			packet[2], packet[3] = byte(seq>>8), byte(seq)
+			packet = append(packet[:4], stop...)
+			for i := 0; i < udpEStopRepeats; i++ {
+				for _, conn := range ub.conns {
+					if _, err := conn.Write(packet); err != nil {
+						slog.Debug("UDP send error", "target",
+							conn.RemoteAddr().String(), "err", err)
+					}
+				}
+			}
+
+		// If we get a quit signal, quit this broadcaster
+		case <-ub.quitCh:
+			return
+		}
+	}
+}
+
+// Quit function exported to other packages
+func (ub *UdpBroadcaster) Quit() {
+	close(ub.quitCh)
+}
+
+// Run the UDP broadcaster until a context is done (e.g. when interrupted)
+func (ub *UdpBroadcaster) Run(ctx context.Context) {
+	stop := context.AfterFunc(ctx, ub.Quit)
+	defer stop()
+	ub.RunLoop()
+}
@@ -1,53 +1,198 @@
 package webserver
 
 import (
-	"log"
+	"context"
+	"log/slog"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Wait group to safely close all open clients when quitting
 var wgQuit *sync.WaitGroup
 
+// How long clients are given to receive the close frame, when shutting down
+const shutdownWriteWait = time.Second
+
+// Whether a room's web broker loop is currently running
+func (wb *WebBroker) isRunning() bool {
+	return wb.running.Load()
+}
+
+// Setter method for whether a room's web broker loop is running
+func (wb *WebBroker) setRunning(running bool) {
+	wb.running.Store(running)
+}
+
+// Whether the web brokers of all rooms are running, exported for health checks
+func BrokerRunning() bool {
+	muRooms.RLock()
+	defer muRooms.RUnlock()
+	for _, wb := range rooms {
+		if !wb.isRunning() {
+			return false
+		}
+	}
+	return len(rooms) > 0
+}
+
+// Get a copy of the room's last broadcast game state (nil if there hasn't been one)
+func (wb *WebBroker) latestState() []byte {
+	wb.muLatest.RLock()
+	defer wb.muLatest.RUnlock()
+	if len(wb.prevState) == 0 {
+		return nil
+	}
+	return append([]byte{}, wb.prevState...)
+}
+
+/*
+Subscribe to the room's game state broadcasts - returns a channel of
+broadcasts, and a function to cancel the subscription (which must be called
+when done)
+*/
+func (wb *WebBroker) subscribeState() (<-chan []byte, func()) {
+	ch := make(chan []byte, 4)
+	wb.muSubs.Lock()
+	{
+		wb.subscribers[ch] = struct{}{}
+	}
+	wb.muSubs.Unlock()
+	return ch, func() {
+		wb.muSubs.Lock()
+		{
+			delete(wb.subscribers, ch)
+		}
+		wb.muSubs.Unlock()
+	}
+}
+
+// Send a broadcast to all subscribers (skipping any that aren't keeping up)
+func (wb *WebBroker) publishState(msg []byte) {
+	wb.muSubs.RLock()
+	defer wb.muSubs.RUnlock()
+
+	// If there are subscribers, each gets its own copy of the broadcast
+	var state []byte = nil
+	for ch := range wb.subscribers {
+		if state == nil {
+			state = append([]byte{}, msg...)
+		}
+		select {
+		case ch <- state:
+		default:
+		}
+	}
+}
+
+/*
+Send a command to the room's game engine, as if it came from a websocket
+client - returns false if the room's web broker isn't running
+*/
+func (wb *WebBroker) sendCommand(cmd []byte) bool {
+	if !wb.isRunning() {
+		return false
+	}
+	wb.responseCh <- cmd
+	if cap(wb.responseCh) == len(wb.responseCh) {
+		slog.Warn("Incoming messages full, server not keeping up",
+			"room", wb.room)
+	}
+	return true
+}
+
+// Get the number of open websocket sessions (in all rooms), for health checks
+func NumOpenWebSessions() int {
+	muOWS.RLock()
+	defer muOWS.RUnlock()
+	return len(openWebSessions)
+}
+
 /*
 A web-broker object, to act as an intermediary between web sessions
 and messages from the game engine - its responsibility is to forward byte
-messages from the game engine to the clients and vice versa
+messages from the game engine to the clients and vice versa (one per room,
+see rooms.go)
 */
 type WebBroker struct {
+	room        string // room name ("" for the default room)
 	quitCh      chan struct{}
 	broadcastCh <-chan []byte
-	tcpSendCh   chan<- []byte
+	tcpSendCh   chan<- []byte // nil, except for the default room
 	responseCh  chan<- []byte
+	running     atomic.Bool // whether the loop is running
+	prevState   []byte      // copy of the last broadcast (for deltas and the API)
+	muLatest    sync.RWMutex
+	broadcasts  atomic.Uint64 // number of broadcasts so far (command_seq.go)
+	/*
+		Subscribers to the game state broadcasts, outside of the websocket
+		sessions (each receives its own copy of every broadcast, and misses
+		broadcasts if it doesn't keep up)
+	*/
+	subscribers map[chan []byte]struct{}
+	/*
+		Subscribers to the room's emergency stops (estop.go), guarded by the
+		same mutex as the state subscribers
+	*/
+	stopSubscribers map[chan []byte]struct{}
+	muSubs          sync.RWMutex
+	lobby           lobby                          // match set up in the room (lobby.go)
+	pathFinder      atomic.Pointer[PathFinder]     // finds paths (path_query.go)
+	predictor       atomic.Pointer[GhostPredictor] // predicts ghosts (ghost_prediction.go)
+	arena           atomic.Pointer[arenaTransform] // arena calibration (arena.go)
+	recycler        atomic.Pointer[BufferRecycler] // re-uses buffers (state_frames.go)
+	ghostPlayers    ghostPlayers                   // players of the ghosts (ghost_players.go)
+	dashboard       dashboard                      // recent events (dashboard.go)
 }
 
-// Create a new web broker, casting input and output channels to be uni-directional
+// Create a new web broker for the default room
 func NewWebBroker(_broadcastCh <-chan []byte, _tcpSendCh chan<- []byte, _responseCh chan<- []byte, _wgQuit *sync.WaitGroup) *WebBroker {
+	return NewRoomBroker("", _broadcastCh, _tcpSendCh, _responseCh, _wgQuit)
+}
+
+/*
+Create a new web broker for a room, casting input and output channels to be
+uni-directional (the TCP send channel may be nil)
+*/
+func NewRoomBroker(room string, _broadcastCh <-chan []byte, _tcpSendCh chan<- []byte, _responseCh chan<- []byte, _wgQuit *sync.WaitGroup) *WebBroker {
 	wb := WebBroker{
-		quitCh:      make(chan struct{}, 0),
-		broadcastCh: _broadcastCh,
-		tcpSendCh:   _tcpSendCh,
-		responseCh:  _responseCh,
+		room:            room,
+		quitCh:          make(chan struct{}, 0),
+		broadcastCh:     _broadcastCh,
+		tcpSendCh:       _tcpSendCh,
+		responseCh:      _responseCh,
+		subscribers:     make(map[chan []byte]struct{}),
+		stopSubscribers: make(map[chan []byte]struct{}),
 	}
 	wgQuit = _wgQuit
+	registerRoom(&wb)
 	return &wb
 }
 
-// Quit by closing all web sessions, in case the loop ends
+// Quit by closing all of the room's web sessions, in case the loop ends
 func (wb *WebBroker) quit() {
 
+	// The web broker is no longer running
+	wb.setRunning(false)
+
 	// Log that all websocket connections are closed upon broker exit, then close them individually
-	log.Println("\033[35m\033[1mLOG:  Web broker exit: killing all websocket connections")
+	slog.Info("Web broker exit: killing all websocket connections",
+		"room", wb.room)
+	deadline := time.Now().Add(shutdownWriteWait)
 	muOWS.RLock()
 	{
-		// Individually quit each of the open web sessions
+		// Individually tell each of the room's open web sessions that the
+		// server is shutting down, and quit them
 		for ws := range openWebSessions {
-			ws.quit()
+			if ws.broker == wb {
+				ws.shutdown(deadline)
+			}
 		}
 	}
 	muOWS.RUnlock()
 
 	// Log that the web broker has quit (if this message doesn't get sent, we are blocked by some mutex)
-	log.Println("\033[35mLOG:  Web broker successfully quit\033[0m")
+	slog.Info("Web broker successfully quit", "room", wb.room)
 
 	wgQuit.Done()
 }
@@ -57,6 +202,33 @@ func (wb *WebBroker) Quit() {
 	close(wb.quitCh)
 }
 
+/*
+Run the web broker loop until a context is done (e.g. when the server is
+interrupted), then close the room's websocket connections
+*/
+func (wb *WebBroker) Run(ctx context.Context) {
+	stop := context.AfterFunc(ctx, wb.Quit)
+	defer stop()
+	wb.RunLoop()
+}
+
+/*
+Convert the game state to another format for clients that asked for it - on
+failure, an empty message is sent instead, so that the clients stay connected
+*/
+func (wb *WebBroker) encodeState(format uint8, msg []byte) []byte {
+	out, err := stateEncoders[format](msg)
+	if err != nil {
+		slog.Error("Game state encoding error", "format", formatNames[format],
+			"err", err)
+		if format == formatJSON {
+			return []byte("{}")
+		}
+		return []byte{}
+	}
+	return out
+}
+
 // Start the web-broker - should be launched as a go-routine
 func (wb *WebBroker) RunLoop() {
 	// Make sure we wait for web broker to complete before exit
@@ -65,8 +237,8 @@ func (wb *WebBroker) RunLoop() {
 	// Quit if we ever run into an error or the program ends
 	defer wb.quit()
 
-	// Copy (by reference) the response channel to match the broker's
-	responseCh = wb.responseCh
+	// The web broker is now running
+	wb.setRunning(true)
 
 	// "While" loop, keep running until we quit the web broker
 	for {
@@ -74,33 +246,129 @@ func (wb *WebBroker) RunLoop() {
 
 		// If we get a message, broadcast it to all web sessions
 		case msg := <-wb.broadcastCh:
+
+			// The number of this broadcast (for delta keyframes and acks)
+			broadcast := wb.broadcasts.Load()
+
+			// The state, shared by every session that receives it in binary
+			// (state_frames.go)
+			frame := newStateFrame(msg)
+
+			// The state in each other format, converted once if needed
+			var encoded [numFormats][]byte
+
+			// The state in each format, tagged with its message type once if
+			// needed (for sessions receiving messages of different types)
+			var tagged [numFormats][]byte
+
+			// Deltas for delta clients, made once if needed (their keyframes
+			// are the tagged binary state)
+			var delta []byte
+			keyframeDue := broadcast%deltaKeyframePeriod == 0
+			if !keyframeDue {
+				if d := encodeDelta(wb.prevState, msg); d != nil {
+					delta = tagMessage(msgDelta, d)
+				}
+			}
+
+			// The time of this broadcast (for downsampling spectators)
+			now := time.Now()
+
 			muOWS.RLock()
 			{
 				for ws := range openWebSessions {
 
+					// Only send the state to the room's sessions
+					if ws.broker != wb {
+						continue
+					}
+
+					// Skip states that come too soon after the last one sent
+					// to a spectator (spectator.go)
+					if !ws.stateDue(now) {
+						ws.synced = false
+						continue
+					}
+
+					// Send the state in the format the client asked for
+					out := outgoing{msg: frame.state(), frame: frame}
+					if ws.format == formatDelta {
+						if delta != nil && ws.synced {
+							out = outgoing{msg: delta}
+						} else {
+							out.msg = frame.tagged()
+						}
+					} else if ws.format != formatBinary {
+						if encoded[ws.format] == nil {
+							encoded[ws.format] = wb.encodeState(ws.format, msg)
+						}
+						out = outgoing{msg: encoded[ws.format]}
+					}
+					if ws.taggedMessages() {
+						if ws.format == formatBinary {
+							out.msg = frame.tagged()
+						} else if ws.format != formatDelta {
+							if tagged[ws.format] == nil {
+								tagged[ws.format] = tagMessage(
+									formatMsgTypes[ws.format], out.msg)
+							}
+							out.msg = tagged[ws.format]
+						}
+
+						// Acknowledge any applied commands first, if there is
+						// room for both messages (command_seq.go)
+						if len(ws.sendCh)+2 <= cap(ws.sendCh) {
+							if ack := ws.dueAck(broadcast); ack != nil {
+								ws.sendCh <- outgoing{msg: ack}
+							}
+						}
+					}
+
 					// Issue update to client if they are keeping up
+					out.retain()
 					select {
-					case ws.sendCh <- msg:
+					case ws.sendCh <- out:
 						// Don't wait, we won't hold everything up for a slow client
+						ws.synced = true
 					default:
+						// The client missed an update, so it needs a keyframe
+						ws.synced = false
+						out.release()
+
 						/*
 							What this means: a web session channel was full,
 							preventing this write
 						*/
-						log.Printf("\033[35mWARN: A web-session send channel was full"+
-							" (client = %s)\033[0m\n", getIP(ws.conn))
+						slog.Warn("A web-session send channel was full",
+							"client", getIP(ws.conn))
 					}
 				}
 			}
 			muOWS.RUnlock()
 
-			if NumOpenTCPClients > 0 {
+			// Keep a copy of the state, to take the next delta against
+			wb.muLatest.Lock()
+			{
+				wb.prevState = append(wb.prevState[:0], msg...)
+			}
+			wb.muLatest.Unlock()
+			wb.broadcasts.Add(1)
+
+			// Send the state to any other subscribers
+			wb.publishState(msg)
+
+			// The TCP server takes the engine's buffer, otherwise it can be
+			// re-used (the sessions are sent the frame instead)
+			if wb.tcpSendCh != nil && NumOpenTCPClients > 0 {
 				select {
 				case wb.tcpSendCh <- msg:
 				default:
-					log.Println("\033[35mWARN: TCP send channel full!\033[0m")
+					slog.Warn("TCP send channel full!")
 				}
+			} else {
+				wb.recycleBuffer(msg)
 			}
+			frame.release()
 
 		// If we get a quit signal, quit this broker
 		case <-wb.quitCh:
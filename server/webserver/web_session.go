@@ -1,10 +1,13 @@
 package webserver
 
 import (
-	"log"
+	"fmt"
+	"log/slog"
 	"net"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
@@ -27,9 +30,6 @@ func ConfigTrustedClientIPs(_trustedClientIPs []string) {
 	}
 }
 
-// Store the responses from trusted clients in a (send-only) channel
-var responseCh chan<- []byte
-
 /*
 Map to keep track of websocket client IPs; if only
 one client connection is allowed per IP, kick the oldest
@@ -42,28 +42,128 @@ Get the IP address by taking the part of the remote address
 {ip}:{port} before the last colon separating the address and port
 */
 func getIP(conn *websocket.Conn) string {
-	addr := conn.RemoteAddr().String()
+	return ipFromAddr(conn.RemoteAddr().String())
+}
+
+// Get the IP address from a remote address of the form {ip}:{port}
+func ipFromAddr(addr string) string {
 	sepIdx := strings.LastIndex(addr, ":")
+	if sepIdx < 0 {
+		return addr
+	}
 	return addr[:sepIdx]
 }
 
 // Web session object, for keeping track of individual websocket sessions
 type webSession struct {
-	sendCh chan []byte
-	readEn bool // read enabled (allowed by IP whitelist)
-	conn   *websocket.Conn
+	broker  *WebBroker      // web broker of the client's room (rooms.go)
+	sendCh  chan outgoing   // messages to send (state_frames.go)
+	quitCh  chan struct{}   // closed (once) when the session should end
+	readEn  bool            // read enabled (authenticated, or allowed by IP whitelist)
+	client  string          // client name, if authorized to send commands (auth.go)
+	role    clientRole      // client role, deciding which commands it can send
+	version uint8           // protocol version (protocol.go)
+	format  uint8           // game state format (protocol.go)
+	synced  bool            // whether the last delta update was sent (web broker only)
+	limiter *commandLimiter // command rate limit (rate_limit.go)
+	// Shortest time between states, and the time of the last state sent (web
+	// broker only), for downsampling spectators (spectator.go)
+	stateInterval time.Duration
+	lastState     time.Time
+	// Token for resuming the session after reconnecting (session_resume.go)
+	sessionToken string
+	// Time the client connected, and was last heard from (heartbeat.go)
+	connectedAt time.Time
+	lastSeen    atomic.Int64
+	// ID of the session, and the time and type of the last message the
+	// client sent, for the admin dashboard (dashboard.go)
+	id          uint64
+	lastMessage atomic.Int64
+	lastMsgType atomic.Uint32
+	// Round-trip times to the client, and whether its control latency was
+	// too high when last checked (latency.go)
+	pingRTT       rttTracker
+	echoRTT       rttTracker
+	latencyWarned bool
+	// Time of the last telemetry report passed on (telemetry.go)
+	lastTelemetry time.Time
+	// Color of the ghost the client plays, or noGhost (ghost_players.go)
+	ghost int
+	// Sequenced commands (command_seq.go): the last accepted sequence number
+	// (read loop only), and the commands waiting to be acknowledged
+	seqStarted  bool
+	lastSeq     uint32
+	pendingAcks []pendingAck
+	muAck       sync.Mutex
+	// The broadcast count by which the last command of each type sent to the
+	// game engine was applied (command_errors.go)
+	cmdDue   map[byte]uint64
+	muCmdDue sync.Mutex
+	// A duplicate of the last message, to be read again (chaos.go)
+	chaosDup []byte
+	conn     *websocket.Conn
+	// Makes sure the quit channel is only closed once
+	quitOnce sync.Once
+	// Mutex to serialize writes to the connection
 	sync.Mutex
 }
 
 // Create a new web session object
-func newWebSession(conn *websocket.Conn) *webSession {
+func newWebSession(conn *websocket.Conn, broker *WebBroker, version uint8,
+	format uint8) *webSession {
 	return &webSession{
-		sendCh: make(chan []byte, 10),
-		readEn: true,
-		conn:   conn,
+		broker:      broker,
+		sendCh:      make(chan outgoing, 10),
+		quitCh:      make(chan struct{}),
+		readEn:      false,
+		version:     version,
+		format:      format,
+		limiter:     newCommandLimiter(),
+		connectedAt: time.Now(),
+		id:          lastClientID.Add(1),
+		ghost:       noGhost,
+		conn:        conn,
 	}
 }
 
+/*
+Write a message of a given type to the client, wrapped in an envelope unless
+the client speaks the legacy protocol (which only receives the game state)
+*/
+func (ws *webSession) writeMessage(msgType byte, payload []byte) error {
+
+	// Only one write can happen on a connection at a time
+	ws.Lock()
+	defer ws.Unlock()
+
+	// Only compress messages that are long enough to benefit from it
+	ws.conn.EnableWriteCompression(len(payload) >= compressionThreshold)
+
+	// Legacy clients receive the bare game state (as text, if it is JSON)
+	if ws.version == legacyProtocolVersion {
+		switch msgType {
+		case msgState:
+			return ws.conn.WriteMessage(websocket.BinaryMessage, payload)
+		case msgJSON:
+			return ws.conn.WriteMessage(websocket.TextMessage, payload)
+		case msgProto:
+			return ws.conn.WriteMessage(websocket.BinaryMessage, payload)
+		}
+		return nil
+	}
+
+	// Otherwise, write the envelope header followed by the payload
+	w, err := ws.conn.NextWriter(websocket.BinaryMessage)
+	if err != nil {
+		return err
+	}
+	var header [envelopeHeaderLen]byte
+	putEnvelopeHeader(header[:], msgType, len(payload))
+	w.Write(header[:])
+	w.Write(payload)
+	return w.Close()
+}
+
 // Register this web session in the active connections
 func (ws *webSession) register() {
 	muISM.Lock()
@@ -77,14 +177,8 @@ func (ws *webSession) register() {
 	ipSessionMap[ip] = ws
 	muISM.Unlock()
 
-	/*
-		Determine if we trust this new connection, by checking against configured
-		trusted connections
-	*/
-	_, trusted := trustedClientIPs[ip]
-	if !trusted {
-		ws.readEn = false
-	}
+	// Whether we trust this new connection (decided when it connected)
+	trusted := ws.readEn
 
 	// Lock the mutex so we can keep track of the number of open clients
 	muOWS.Lock()
@@ -92,11 +186,12 @@ func (ws *webSession) register() {
 		// Add this web session to the web sessions set
 		openWebSessions[ws] = struct{}{}
 		if trusted {
-			log.Printf("\033[34m[%d -> %d] trusted client connected (%s)\033[0m\n",
-				len(openWebSessions)-1, len(openWebSessions), ip)
+			slog.Info("Trusted client connected", "ip", ip, "client", ws.client,
+				"role", ws.role, "room", ws.broker.room,
+				"from", len(openWebSessions)-1, "to", len(openWebSessions))
 		} else {
-			log.Printf("\033[34m[%d -> %d] client connected (%s)\033[0m\n",
-				len(openWebSessions)-1, len(openWebSessions), ip)
+			slog.Info("Client connected", "ip", ip, "room", ws.broker.room,
+				"from", len(openWebSessions)-1, "to", len(openWebSessions))
 		}
 	}
 	muOWS.Unlock()
@@ -106,7 +201,7 @@ func (ws *webSession) register() {
 func (ws *webSession) unregister() {
 	// Record the IP address of the disconnecting client
 	ip := getIP(ws.conn)
-	_, trusted := trustedClientIPs[ip]
+	trusted := ws.readEn
 
 	/*
 		Lock the mutex so that other channels will not read the open web
@@ -117,14 +212,15 @@ func (ws *webSession) unregister() {
 		// Print information regarding the disconnect
 		if len(openWebSessions) > 0 {
 			if trusted {
-				log.Printf("\033[33m[%d -> %d] trusted client disconnected (%s)\033[0m\n",
-					len(openWebSessions), len(openWebSessions)-1, ip)
+				slog.Info("Trusted client disconnected", "ip", ip,
+					"client", ws.client,
+					"from", len(openWebSessions), "to", len(openWebSessions)-1)
 			} else {
-				log.Printf("\033[33m[%d -> %d] client disconnected (%s)\033[0m\n",
-					len(openWebSessions), len(openWebSessions)-1, ip)
+				slog.Info("Client disconnected", "ip", ip,
+					"from", len(openWebSessions), "to", len(openWebSessions)-1)
 			}
 		} else {
-			log.Printf("\033[33m[X -> X] client(s) blocked\033[0m\n")
+			slog.Info("Client(s) blocked", "ip", ip)
 		}
 
 		// Remove this websession from the open web sessions set
@@ -138,39 +234,51 @@ func (ws *webSession) unregister() {
 		delete(ipSessionMap, ip)
 	}
 	muISM.Unlock()
-
-	// Wait until deregister to prevent write to closed channel
-	close(ws.sendCh)
 }
 
-// Close the websocket client (causes loop to unblock)
+/*
+Close the websocket client (causes loop to unblock) - safe to call from any
+go-routine, any number of times, even after the session is unregistered
+*/
 func (ws *webSession) quit() {
 	ws.conn.Close()
 	// Wake the send loop, if it needs to be reminded to exit
-	// Any message will cause readLoop to exit as the socket is closed
-	select {
-	case ws.sendCh <- nil:
-	default:
-	}
+	// (readLoop exits on its own, as the socket is closed)
+	ws.quitOnce.Do(func() { close(ws.quitCh) })
+}
+
+/*
+Tell the client that the server is shutting down (with a close frame, sent by
+a deadline), then close the websocket client
+*/
+func (ws *webSession) shutdown(deadline time.Time) {
+	msg := websocket.FormatCloseMessage(websocket.CloseGoingAway,
+		"server shutting down")
+	ws.conn.WriteControl(websocket.CloseMessage, msg, deadline)
+	ws.quit()
 }
 
 // Runs all loops to service the connection and blocks until complete
 func (ws *webSession) loop() {
-	if !ws.readEn {
-		defer ws.quit()
-		ws.sendLoop()
-		return
-	}
+	/*
+		Read from every client (even those that can't send commands), so that
+		pongs are received and dead connections are detected (heartbeat.go)
+	*/
+	ws.startHeartbeat()
+
+	// Drop the connection at random, in chaos mode (chaos.go)
+	done := make(chan struct{})
+	defer close(done)
+	ws.startChaos(done)
+
 	var wg sync.WaitGroup
 	wg.Add(2)
 	go func() {
-		defer ws.quit()
-		defer wg.Done()
+		defer func() { ws.quit(); wg.Done() }()
 		ws.readLoop()
 	}()
 	go func() {
-		defer ws.quit()
-		defer wg.Done()
+		defer func() { ws.quit(); wg.Done() }()
 		ws.sendLoop()
 	}()
 	wg.Wait()
@@ -184,9 +292,15 @@ func (ws *webSession) readLoop() {
 	// "While" loop, keep reading until the connection closes
 	for {
 		// Read a message (discard the type since we don't need it)
-		_, msg, err := ws.conn.ReadMessage()
+		msg, err := ws.readMessage()
 		if err != nil {
 
+			// If the client stopped answering pings, evict it
+			if timeoutErr, ok := err.(net.Error); ok && timeoutErr.Timeout() {
+				ws.logEviction()
+				return
+			}
+
 			// Types of errors which we intentionally catch and return from
 			clientCloseErr := websocket.IsCloseError(
 				err,
@@ -206,38 +320,197 @@ func (ws *webSession) readLoop() {
 			}
 
 			// For all other unspecified errors, log them and quit
-			log.Println("read error:", err)
+			slog.Error("Websocket read error", "err", err)
 			return
 		}
 
+		// Any message counts as a sign of life (heartbeat.go)
+		ws.seen()
+
 		// Skip this message if it is empty
 		if len(msg) == 0 {
 			continue
 		}
 
-		responseCh <- msg
-		if cap(responseCh) == len(responseCh) {
-			log.Println("\033[35mWARN: Incoming messages " +
-				"full, server not keeping up \033[0m")
+		// Note the message for the admin dashboard (dashboard.go)
+		ws.lastMessage.Store(time.Now().UnixNano())
+		ws.lastMsgType.Store(uint32(msg[0]))
+
+		// Open the envelope, unless the client speaks the legacy protocol
+		sequenced, seq := false, uint32(0)
+		report := false // Whether this is a report, already a byte command
+		if ws.version != legacyProtocolVersion {
+			msgType, payload, err := openEnvelope(msg)
+
+			// Answer path and prediction queries, which aren't commands
+			// (path_query.go, ghost_prediction.go)
+			if err == nil && msgType == msgPathQuery {
+				ws.answerPathQuery(payload)
+				continue
+			}
+			if err == nil && msgType == msgPredictQuery {
+				ws.answerPredictQuery(payload)
+				continue
+			}
+
+			// Record the RTT of timestamp echoes (latency.go)
+			if err == nil && msgType == msgRoundTrip {
+				ws.recordEcho(payload)
+				continue
+			}
+
+			// Stop the robots on a referee's request (estop.go)
+			if err == nil && msgType == msgEStop {
+				ws.requestEStop(payload)
+				continue
+			}
+			if err == nil && msgType != msgCommand && msgType != msgSeqCommand &&
+				msgType != msgLocalization && msgType != msgTelemetry {
+				err = fmt.Errorf("unexpected message type '%c'", msgType)
+			}
+
+			// Turn localization reports into commands (localization.go)
+			report = (msgType == msgLocalization || msgType == msgTelemetry)
+			if err == nil && msgType == msgLocalization {
+				payload, err = localizationCommand(payload)
+			}
+
+			// Turn telemetry reports into commands (telemetry.go)
+			if err == nil && msgType == msgTelemetry {
+				payload, err = telemetryCommand(payload)
+			}
+
+			// Check the sequence number of sequenced commands (command_seq.go)
+			if err == nil && msgType == msgSeqCommand {
+				sequenced = true
+				seq, payload, err = splitSeqCommand(payload)
+				if err == nil {
+					err = ws.checkSeq(seq)
+				}
+			}
+			if err != nil {
+				slog.Warn("Invalid message from client", "ip", getIP(ws.conn),
+					"err", err)
+				ws.writeMessage(msgError, []byte(err.Error()))
+				continue
+			}
+			msg = payload
+
+			// Skip this message if it is empty
+			if len(msg) == 0 {
+				continue
+			}
+		}
+
+		// Convert protobuf commands into byte commands
+		if ws.format == formatProto && !report {
+			cmd, err := commandProtoDecoder(msg)
+			if err != nil {
+				slog.Warn("Invalid protobuf command from client",
+					"ip", getIP(ws.conn), "err", err)
+				ws.writeMessage(msgError, []byte(err.Error()))
+				continue
+			}
+			msg = cmd
+		}
+
+		// Only send well-formed commands (command_errors.go)
+		if err := validateCommand(msg); err != nil {
+			slog.Warn("Invalid command from client", "ip", getIP(ws.conn),
+				"client", ws.client, "err", err)
+			ws.writeMessage(msgError, []byte(err.Error()))
+			continue
+		}
+
+		// Only send commands that the client's role allows (roles.go)
+		if err := ws.role.canSend(msg); err != nil {
+			slog.Warn("Unauthorized command from client", "ip", getIP(ws.conn),
+				"client", ws.client, "err", err)
+			ws.writeMessage(msgError, []byte(err.Error()))
+			continue
+		}
+
+		// Moves from a ghost's player steer its ghost (ghost_players.go)
+		cmd, err := ws.ghostCommand(msg)
+		if err != nil {
+			slog.Warn("Invalid command from a ghost's player", "ip",
+				getIP(ws.conn), "client", ws.client, "err", err)
+			ws.writeMessage(msgError, []byte(err.Error()))
+			continue
+		}
+		msg = cmd
+
+		// While a match is set up, only its controller can move Pacman
+		// (lobby.go)
+		if err := ws.broker.checkController(ws.client, ws.role, msg); err != nil {
+			slog.Warn("Command from a client not in control", "ip", getIP(ws.conn),
+				"client", ws.client, "err", err)
+			ws.writeMessage(msgError, []byte(err.Error()))
+			continue
+		}
+
+		// Telemetry has its own rate limit (telemetry.go)
+		if msg[0] == msgTelemetry {
+			if !ws.limitTelemetry() {
+				continue
+			}
+		} else if !ws.limitCommand() { // Command rate limit (rate_limit.go)
+			if commandRatePolicy == ratePolicyDisconnect {
+				return
+			}
+			continue
+		}
+
+		// Send the command to the game engine of the client's room
+		if !ws.broker.sendCommand(msg) {
+			continue
+		}
+
+		// Relay any error the game engine finds with it (command_errors.go)
+		ws.recordCommand(msg)
+
+		// Acknowledge the command once it has been applied (command_seq.go)
+		if sequenced {
+			ws.recordSeq(seq)
 		}
 	}
 }
 
+/*
+Whether the messages queued for this session are tagged with their types -
+sessions speaking pacbot.v1 receive messages of different types (e.g.
+acknowledgments, or deltas), while legacy sessions only receive the game state
+*/
+func (ws *webSession) taggedMessages() bool {
+	return ws.version != legacyProtocolVersion
+}
+
 // Sending websocket data (binary)
 func (ws *webSession) sendLoop() {
 	// "While" loop, keep sending until the connection closes
 	for {
 
-		// Block until the next message is ready
-		msg := <-ws.sendCh
-
-		// nil means we are told to exit
-		if msg == nil {
+		// Block until the next message is ready, or we are told to exit
+		var out outgoing
+		select {
+		case out = <-ws.sendCh:
+		case <-ws.quitCh:
 			return
 		}
+		msg := out.msg
 
 		// Try writing the message
-		if err := ws.conn.WriteMessage(websocket.BinaryMessage, msg); err != nil {
+		// Find the message type (stripping the tag, if the message has one)
+		msgType := formatMsgTypes[ws.format]
+		if ws.taggedMessages() {
+			msgType, msg = msg[0], msg[1:]
+		}
+		ws.chaosDelay() // Delay the message, in chaos mode (chaos.go)
+		err := ws.writeMessage(msgType, msg)
+
+		// The message was written, so the frame it belongs to can be re-used
+		out.release()
+		if err != nil {
 
 			// Types of errors which we intentionally catch and return from
 			clientCloseErr := websocket.IsCloseError(
@@ -253,7 +526,7 @@ func (ws *webSession) sendLoop() {
 			}
 
 			// For all other unspecified errors, log them and quit
-			log.Println("write error:", err)
+			slog.Error("Websocket write error", "err", err)
 			return
 		}
 	}
@@ -0,0 +1,162 @@
+package webserver
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+)
+
+/*
+Webhooks let tournament software and stream overlays react to the game without
+maintaining a socket: for each configured event, the server sends an HTTP POST
+to each webhook that subscribes to it, with the event as a JSON body:
+
+	{"event": "level_complete", "level": 2, "score": 4560, "tick": 1234,
+	 "room": "field2", "time": "2024-04-13T15:04:05Z"}
+
+The events are the game events from the event log (game/events.go), such as
+"game_start", "game_over", "level_complete", and "high_score", along with
+"match_start" and "match_end" for matches run through the lobby (lobby.go).
+The room is left out for the default room. Webhooks with a secret are signed:
+the "Pacbot-Signature" header holds "sha256=" followed by the hex-encoded
+HMAC-SHA256 of the body, keyed by the secret. Deliveries are sent one at a
+time in the background, and dropped (with a warning) if they fall too far
+behind or fail.
+*/
+
+// A webhook, as configured in config.json
+type Webhook struct {
+	URL    string   // Address to send the events to
+	Events []string // Events to send (the default events if empty)
+	Secret string   // Key to sign the events with (unsigned if empty)
+}
+
+// The events sent to webhooks that don't list any
+var defaultWebhookEvents = []string{
+	"match_start",
+	"match_end",
+	"game_start",
+	"game_over",
+	"level_complete",
+	"high_score",
+}
+
+// A webhook delivery, waiting to be sent
+type webhookDelivery struct {
+	hook *Webhook
+	body []byte
+}
+
+// The configured webhooks
+var webhooks []*Webhook = nil
+
+// Queue of deliveries, for the webhook go-routine to send
+var webhookCh = make(chan webhookDelivery, 64)
+
+// HTTP client for sending webhooks (which shouldn't hold up other deliveries)
+var webhookClient = &http.Client{Timeout: 5 * time.Second}
+
+/*
+Configure the webhooks to send game events to, starting a go-routine to send
+them (if there are any)
+*/
+func ConfigWebhooks(_webhooks []Webhook) {
+	for i := range _webhooks {
+		hook := _webhooks[i]
+		if hook.URL == "" {
+			continue
+		}
+		if len(hook.Events) == 0 {
+			hook.Events = defaultWebhookEvents
+		}
+		webhooks = append(webhooks, &hook)
+		slog.Info("Webhook added", "url", hook.URL, "events", hook.Events)
+	}
+	if len(webhooks) > 0 {
+		go sendWebhooks()
+	}
+}
+
+/*
+Queue an event (with its details, including the event type) for the webhooks
+that subscribe to it - the game engine's events are passed on by
+NotifyGameEvent (countdown.go), and this never blocks
+*/
+func NotifyWebhooks(details map[string]any) {
+
+	// Find the webhooks that subscribe to the event
+	event, _ := details["event"].(string)
+	var body []byte = nil
+	for _, hook := range webhooks {
+		if !slices.Contains(hook.Events, event) {
+			continue
+		}
+
+		// Encode the event once, with the time it happened
+		if body == nil {
+			payload := make(map[string]any, len(details)+1)
+			for key, value := range details {
+				payload[key] = value
+			}
+			payload["time"] = time.Now().UTC()
+			var err error
+			if body, err = json.Marshal(payload); err != nil {
+				slog.Error("Webhook encoding error", "event", event, "err", err)
+				return
+			}
+		}
+
+		// Queue the delivery, unless the webhooks are too far behind
+		select {
+		case webhookCh <- webhookDelivery{hook: hook, body: body}:
+		default:
+			slog.Warn("Webhook queue full, dropping event", "url", hook.URL,
+				"event", event)
+		}
+	}
+}
+
+// Send the queued webhook deliveries - should be launched as a go-routine
+func sendWebhooks() {
+	for delivery := range webhookCh {
+		delivery.send()
+	}
+}
+
+// Send a webhook delivery, logging any failure
+func (delivery webhookDelivery) send() {
+
+	// Prepare the request, signing it if the webhook has a secret
+	req, err := http.NewRequest(http.MethodPost, delivery.hook.URL,
+		bytes.NewReader(delivery.body))
+	if err != nil {
+		slog.Error("Webhook error", "url", delivery.hook.URL, "err", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if delivery.hook.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(delivery.hook.Secret))
+		mac.Write(delivery.body)
+		req.Header.Set("Pacbot-Signature",
+			"sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	// Send it, checking that the webhook accepted it
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		slog.Warn("Webhook delivery failed", "url", delivery.hook.URL,
+			"err", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		slog.Warn("Webhook delivery rejected", "url", delivery.hook.URL,
+			"status", resp.StatusCode)
+	}
+}